@@ -4,15 +4,24 @@ go 1.14
 
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/go-resty/resty/v2 v2.3.0
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/golang/mock v1.4.4
+	github.com/jackc/pgconn v1.6.4
 	github.com/labstack/echo/v4 v4.1.17
-	github.com/mattn/go-sqlite3 v1.14.2 // indirect
+	github.com/mattn/go-sqlite3 v1.14.2
+	github.com/miekg/dns v1.1.31
 	github.com/ovh/go-ovh v1.1.0
 	github.com/pelletier/go-toml v1.8.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/rs/zerolog v1.19.0
 	github.com/urfave/cli/v2 v2.2.0
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	gorm.io/driver/mysql v1.0.1
+	gorm.io/driver/postgres v1.0.0
 	gorm.io/driver/sqlite v1.1.1
 	gorm.io/gorm v1.20.0
+	rsc.io/qr v0.2.0
 )
@@ -21,11 +21,18 @@ func LoadToml(path string, value interface{}) error {
 }
 
 // SaveToml save given structure in toml format into file located at given path
+// the file may hold secrets (tokens, passwords): it is only readable by its owner
 func SaveToml(path string, value interface{}) error {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0640)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
+
+	// the permissions of an existing file are not changed by OpenFile
+	if err := file.Chmod(0600); err != nil {
+		return err
+	}
 
 	return toml.NewEncoder(file).Encode(value)
 }
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type tomlTestConfig struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func TestSaveToml(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(path, []byte("Token = \"a-much-longer-previous-token-value\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := SaveToml(path, &tomlTestConfig{Token: "short", ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the previous content is truncated
+	var conf tomlTestConfig
+	if err := LoadToml(path, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Token != "short" || !conf.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("wrong config loaded: %+v", conf)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("wrong file permissions: %s", info.Mode().Perm())
+	}
+
+	// the zero time is kept
+	if err := SaveToml(path, &tomlTestConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadToml(path, &conf); err != nil || conf.Token != "" || !conf.ExpiresAt.IsZero() {
+		t.Errorf("wrong config loaded: %+v %v", conf, err)
+	}
+}
@@ -0,0 +1,168 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// defaultPollInterval is used when the provider does not specify the polling interval
+	defaultPollInterval = 5 * time.Second
+)
+
+// ErrAccessDenied is returned when the user denied the device authorization
+var ErrAccessDenied = errors.New("authorization denied")
+
+// ErrExpiredDeviceCode is returned when the user did not complete the device authorization in time
+var ErrExpiredDeviceCode = errors.New("device code expired")
+
+// DeviceAuthorization is a pending device authorization request (RFC 8628)
+// the user must open VerificationURI and enter UserCode, see WaitIDToken
+type DeviceAuthorization struct {
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string        // VerificationURI including the user code, may be empty
+	ExpiresAt               time.Time     // the request is expired after this date
+	Interval                time.Duration // interval between two token requests
+
+	deviceCode    string
+	clientID      string
+	tokenEndpoint string
+}
+
+// StartDeviceAuthorization request a device authorization to given issuer for given (public) client
+func StartDeviceAuthorization(ctx context.Context, issuer, clientID string, scopes []string) (DeviceAuthorization, error) {
+	metadata, err := Discover(ctx, issuer)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	if metadata.DeviceAuthorizationEndpoint == "" {
+		return DeviceAuthorization{}, fmt.Errorf("the provider does not support the device authorization grant")
+	}
+
+	form := url.Values{}
+	form.Set("client_id", clientID)
+	form.Set("scope", strings.Join(scopes, " "))
+
+	req, err := newFormRequest(ctx, metadata.DeviceAuthorizationEndpoint, form)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+
+	var res struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}
+	if err := doJSON(req, &res); err != nil {
+		return DeviceAuthorization{}, fmt.Errorf("error while requesting the device authorization: %s", err)
+	}
+
+	interval := defaultPollInterval
+	if res.Interval > 0 {
+		interval = time.Duration(res.Interval) * time.Second
+	}
+
+	return DeviceAuthorization{
+		UserCode:                res.UserCode,
+		VerificationURI:         res.VerificationURI,
+		VerificationURIComplete: res.VerificationURIComplete,
+		ExpiresAt:               time.Now().Add(time.Duration(res.ExpiresIn) * time.Second),
+		Interval:                interval,
+		deviceCode:              res.DeviceCode,
+		clientID:                clientID,
+		tokenEndpoint:           metadata.TokenEndpoint,
+	}, nil
+}
+
+// WaitIDToken poll the provider until the user completed the device authorization
+// and return the issued ID token
+func (da DeviceAuthorization) WaitIDToken(ctx context.Context) (string, error) {
+	interval := da.Interval
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+
+		idToken, err := da.requestIDToken(ctx)
+		if err == nil {
+			return idToken, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		switch err.Error() {
+		case "authorization_pending":
+		case "slow_down":
+			interval += defaultPollInterval
+		case "access_denied":
+			return "", ErrAccessDenied
+		case "expired_token":
+			return "", ErrExpiredDeviceCode
+		default:
+			return "", fmt.Errorf("error while requesting the ID token: %s", err)
+		}
+	}
+}
+
+// requestIDToken exchange the device code against an ID token
+// the OAuth 2.0 error codes are returned as error
+func (da DeviceAuthorization) requestIDToken(ctx context.Context) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", deviceCodeGrantType)
+	form.Set("device_code", da.deviceCode)
+	form.Set("client_id", da.clientID)
+
+	req, err := newFormRequest(ctx, da.tokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := jsonDecode(res, &body); err != nil {
+		return "", err
+	}
+
+	if body.Error != "" {
+		return "", errors.New(body.Error)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("no ID token returned (is the openid scope allowed?)")
+	}
+
+	return body.IDToken, nil
+}
+
+func newFormRequest(ctx context.Context, endpoint string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	return req, nil
+}
@@ -0,0 +1,76 @@
+// Package oidc implement the subset of OpenID Connect used by OpenDyDNS:
+// the ID tokens validation (daemon) and the device authorization grant (opendydnsctl)
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpClient is used to reach the OpenID Providers
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Metadata is the subset of the OpenID Provider metadata used by OpenDyDNS
+// see https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type Metadata struct {
+	Issuer                      string `json:"issuer"`
+	JWKSURI                     string `json:"jwks_uri"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// Discover fetch the metadata of given issuer
+// using its /.well-known/openid-configuration document
+func Discover(ctx context.Context, issuer string) (Metadata, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var metadata Metadata
+	if err := doJSON(req, &metadata); err != nil {
+		return Metadata{}, fmt.Errorf("error while fetching the provider metadata: %s", err)
+	}
+
+	// prevent a provider from impersonating another one
+	if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+		return Metadata{}, fmt.Errorf("issuer mismatch: got %s want %s", metadata.Issuer, issuer)
+	}
+
+	return metadata, nil
+}
+
+// doJSON execute given request and decode the JSON response body into v
+// the responses other than 2XX are returned as error
+func doJSON(req *http.Request, v interface{}) error {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// jsonDecode decode the JSON response body into v whatever the status code
+// used for the OAuth 2.0 endpoints, whose errors are JSON documents
+func jsonDecode(res *http.Response, v interface{}) error {
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("unexpected response (status code %d): %s", res.StatusCode, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package oidc
+
+import (
+	"context"
+	"github.com/creekorful/open-dydns/internal/oidc/oidctest"
+	"github.com/dgrijalva/jwt-go"
+	"testing"
+	"time"
+)
+
+func TestDiscover(t *testing.T) {
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	metadata, err := Discover(context.Background(), issuer.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.JWKSURI != issuer.URL+"/jwks" || metadata.DeviceAuthorizationEndpoint != issuer.URL+"/device" {
+		t.Errorf("wrong metadata: %+v", metadata)
+	}
+
+	// the issuer must match the requested one
+	if _, err := Discover(context.Background(), issuer.URL+"/realms/foo"); err == nil {
+		t.Error("Discover() should have failed")
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	v := NewVerifier(issuer.URL, "opendydnsctl", "groups")
+
+	claims, err := v.Verify(context.Background(), issuer.IDToken(jwt.MapClaims{
+		"sub":            "248289761001",
+		"email":          "luna@example.org",
+		"email_verified": true,
+		"groups":         []string{"dyndns", "staff"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "248289761001" || claims.Email != "luna@example.org" || !claims.EmailVerified ||
+		len(claims.Groups) != 2 || claims.Groups[1] != "staff" {
+		t.Errorf("wrong claims: %+v", claims)
+	}
+
+	// the audience may be a list
+	if _, err := v.Verify(context.Background(), issuer.IDToken(jwt.MapClaims{
+		"sub": "248289761001",
+		"aud": []string{"other", "opendydnsctl"},
+	})); err != nil {
+		t.Error(err)
+	}
+
+	invalidClaims := []jwt.MapClaims{
+		{"sub": "248289761001", "aud": "other"},
+		{"sub": "248289761001", "aud": []string{"other", "opendydnsctl"}, "azp": "other"},
+		{"sub": "248289761001", "iss": "https://accounts.example.org"},
+		{"sub": "248289761001", "exp": time.Now().Add(-time.Hour).Unix()},
+		{"sub": "248289761001", "nbf": time.Now().Add(time.Hour).Unix()},
+		{"sub": ""},
+	}
+	for _, c := range invalidClaims {
+		if _, err := v.Verify(context.Background(), issuer.IDToken(c)); err == nil {
+			t.Errorf("Verify() should have failed: %+v", c)
+		}
+	}
+
+	// the tokens signed by another issuer are rejected
+	other := oidctest.NewIssuer("opendydnsctl")
+	defer other.Close()
+
+	if _, err := v.Verify(context.Background(), other.IDToken(jwt.MapClaims{"sub": "248289761001", "iss": issuer.URL})); err == nil {
+		t.Error("Verify() should have failed")
+	}
+
+	if _, err := v.Verify(context.Background(), "foo.bar.baz"); err == nil {
+		t.Error("Verify() should have failed")
+	}
+}
+
+func TestVerifier_Verify_KeyRotation(t *testing.T) {
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	now := time.Now()
+	v := NewVerifier(issuer.URL, "opendydnsctl", "groups")
+	v.now = func() time.Time { return now }
+
+	if _, err := v.Verify(context.Background(), issuer.IDToken(jwt.MapClaims{"sub": "248289761001"})); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.RotateKey()
+	token := issuer.IDToken(jwt.MapClaims{"sub": "248289761001"})
+
+	// the keys are not fetched again too often
+	if _, err := v.Verify(context.Background(), token); err == nil {
+		t.Error("Verify() should have failed")
+	}
+
+	now = now.Add(keysRefreshInterval)
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifier_Verify_KeysMaxAge(t *testing.T) {
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	now := time.Now()
+	v := NewVerifier(issuer.URL, "opendydnsctl", "groups")
+	v.now = func() time.Time { return now }
+
+	claims := jwt.MapClaims{"sub": "248289761001", "exp": now.Add(2 * keysMaxAge).Unix()}
+	token := issuer.IDToken(claims)
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	// the key withdrawn by the issuer is trusted until the keys are fetched again
+	issuer.RotateKey()
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Error(err)
+	}
+
+	now = now.Add(keysMaxAge)
+	if _, err := v.Verify(context.Background(), token); err == nil {
+		t.Error("Verify() should have failed")
+	}
+	if _, err := v.Verify(context.Background(), issuer.IDToken(claims)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	if _, err := StartDeviceAuthorization(context.Background(), issuer.URL, "other", []string{"openid"}); err == nil {
+		t.Error("StartDeviceAuthorization() should have failed")
+	}
+
+	da, err := StartDeviceAuthorization(context.Background(), issuer.URL, "opendydnsctl", []string{"openid", "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if da.UserCode == "" || da.VerificationURI != issuer.URL+"/activate" || da.Interval != time.Second ||
+		da.ExpiresAt.Before(time.Now()) {
+		t.Errorf("wrong device authorization: %+v", da)
+	}
+	da.Interval = 10 * time.Millisecond
+
+	// still pending
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := da.WaitIDToken(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitIDToken() should have returned DeadlineExceeded: %v", err)
+	}
+
+	issuer.Approve(da.UserCode, jwt.MapClaims{"sub": "248289761001", "email": "luna@example.org"})
+
+	idToken, err := da.WaitIDToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := NewVerifier(issuer.URL, "opendydnsctl", "groups").Verify(context.Background(), idToken)
+	if err != nil || claims.Subject != "248289761001" {
+		t.Errorf("wrong ID token: %+v %v", claims, err)
+	}
+}
+
+func TestDeviceAuthorization_Denied(t *testing.T) {
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	da, err := StartDeviceAuthorization(context.Background(), issuer.URL, "opendydnsctl", []string{"openid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	da.Interval = 10 * time.Millisecond
+
+	issuer.Deny(da.UserCode)
+
+	if _, err := da.WaitIDToken(context.Background()); err != ErrAccessDenied {
+		t.Errorf("WaitIDToken() should have returned ErrAccessDenied: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+// Package oidctest provide a local stand-in OpenID Provider for the tests
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Issuer is a minimal OpenID Provider serving the discovery document, the signing keys
+// and the device authorization grant. The device authorizations are completed using Approve / Deny
+type Issuer struct {
+	*httptest.Server
+	ClientID string
+
+	lock    sync.Mutex
+	key     *rsa.PrivateKey
+	keyID   int
+	devices map[string]*device // by device code
+}
+
+type device struct {
+	userCode string
+	claims   jwt.MapClaims // nil while pending
+	denied   bool
+}
+
+// NewIssuer start a new issuer accepting given (public) client
+// the issuer must be closed once done
+func NewIssuer(clientID string) *Issuer {
+	i := &Issuer{
+		ClientID: clientID,
+		devices:  map[string]*device{},
+	}
+	i.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("/jwks", i.jwks)
+	mux.HandleFunc("/device", i.deviceAuthorization)
+	mux.HandleFunc("/token", i.token)
+	i.Server = httptest.NewServer(mux)
+
+	return i
+}
+
+// RotateKey replace the signing key
+func (i *Issuer) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.key = key
+	i.keyID++
+}
+
+// IDToken return an ID token signed by the issuer, for its client
+// the iss, aud, iat and exp claims are set unless provided
+func (i *Issuer) IDToken(claims jwt.MapClaims) string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.sign(claims)
+}
+
+// Approve complete the device authorization identified by given user code
+// the issued ID token will carry given claims
+func (i *Issuer) Approve(userCode string, claims jwt.MapClaims) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, d := range i.devices {
+		if d.userCode == userCode {
+			d.claims = claims
+		}
+	}
+}
+
+// Deny reject the device authorization identified by given user code
+func (i *Issuer) Deny(userCode string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, d := range i.devices {
+		if d.userCode == userCode {
+			d.denied = true
+		}
+	}
+}
+
+func (i *Issuer) sign(claims jwt.MapClaims) string {
+	token := jwt.MapClaims{
+		"iss": i.URL,
+		"aud": i.ClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		token[name] = value
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, token)
+	t.Header["kid"] = i.kid()
+
+	signed, err := t.SignedString(i.key)
+	if err != nil {
+		panic(err)
+	}
+
+	return signed
+}
+
+func (i *Issuer) kid() string {
+	return fmt.Sprintf("key-%d", i.keyID)
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                        i.URL,
+		"jwks_uri":                      i.URL + "/jwks",
+		"token_endpoint":                i.URL + "/token",
+		"device_authorization_endpoint": i.URL + "/device",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": i.kid(),
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (i *Issuer) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("client_id") != i.ClientID {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	deviceCode := fmt.Sprintf("device-%d", len(i.devices)+1)
+	userCode := fmt.Sprintf("USER-%04d", len(i.devices)+1)
+	i.devices[deviceCode] = &device{userCode: userCode}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          i.URL + "/activate",
+		"verification_uri_complete": i.URL + "/activate?user_code=" + userCode,
+		"expires_in":                600,
+		"interval":                  1,
+	})
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("grant_type") != "urn:ietf:params:oauth:grant-type:device_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	if r.PostFormValue("client_id") != i.ClientID {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	d, exist := i.devices[r.PostFormValue("device_code")]
+	switch {
+	case !exist:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	case d.denied:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "access_denied"})
+	case d.claims == nil:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     i.sign(d.claims),
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,309 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clockSkew is the tolerated clock difference with the OpenID Provider
+	clockSkew = time.Minute
+
+	// keysRefreshInterval is the minimal interval between two JWKS fetches
+	// the keys are fetched again when a token is signed using an unknown key
+	keysRefreshInterval = 10 * time.Second
+
+	// keysMaxAge is the maximal age of the cached keys: the keys withdrawn by the issuer
+	// (compromised or retired) are no longer trusted once the keys are fetched again
+	keysMaxAge = 6 * time.Hour
+)
+
+// ErrUnknownKey is returned when the ID token is signed using a key not published by the issuer
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Claims are the ID token claims used by OpenDyDNS
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+}
+
+// Verifier validate the ID tokens issued by an OpenID Provider for a given client
+// the provider signing keys (JWKS) are discovered and cached
+type Verifier struct {
+	issuer      string
+	clientID    string
+	groupsClaim string
+	now         func() time.Time
+
+	lock      sync.Mutex
+	keys      map[string]interface{} // by key ID
+	fetchedAt time.Time
+}
+
+// NewVerifier return a verifier accepting the ID tokens issued by given issuer for given client
+// the groups of the user are read from the groupsClaim claim
+func NewVerifier(issuer, clientID, groupsClaim string) *Verifier {
+	return &Verifier{
+		issuer:      strings.TrimSuffix(issuer, "/"),
+		clientID:    clientID,
+		groupsClaim: groupsClaim,
+		now:         time.Now,
+	}
+}
+
+// Verify validate given raw ID token: signature, issuer, audience and expiration
+// and return its claims
+func (v *Verifier) Verify(ctx context.Context, rawIDToken string) (Claims, error) {
+	parser := jwt.Parser{
+		ValidMethods:         []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"},
+		SkipClaimsValidation: true, // see validateClaims
+	}
+
+	token, err := parser.Parse(rawIDToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.getKey(ctx, kid)
+	})
+	if err != nil {
+		return Claims{}, err
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if err := v.validateClaims(claims); err != nil {
+		return Claims{}, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+
+	return Claims{
+		Subject:       sub,
+		Email:         email,
+		EmailVerified: isTrue(claims["email_verified"]),
+		Groups:        getStrings(claims[v.groupsClaim]),
+	}, nil
+}
+
+func (v *Verifier) validateClaims(claims jwt.MapClaims) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.issuer {
+		return fmt.Errorf("invalid issuer: %s", iss)
+	}
+
+	if !contains(getStrings(claims["aud"]), v.clientID) {
+		return fmt.Errorf("invalid audience: %v", claims["aud"])
+	}
+	if azp, exist := claims["azp"].(string); exist && azp != v.clientID {
+		return fmt.Errorf("invalid authorized party: %s", azp)
+	}
+
+	now := v.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("missing expiration")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return errors.New("missing subject")
+	}
+
+	return nil
+}
+
+// getKey return the issuer signing key identified by given key ID
+// the keys are fetched again if the key is unknown (key rotation) or if they are too old
+func (v *Verifier) getKey(ctx context.Context, kid string) (interface{}, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if age := v.now().Sub(v.fetchedAt); v.keys != nil && age < keysMaxAge {
+		if key, exist := v.findKey(kid); exist {
+			return key, nil
+		}
+
+		if age < keysRefreshInterval {
+			return nil, ErrUnknownKey
+		}
+	}
+
+	keys, err := fetchKeys(ctx, v.issuer)
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = v.now()
+
+	if key, exist := v.findKey(kid); exist {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// findKey return the key identified by given key ID
+// the tokens without key ID are accepted if the issuer publish a single key
+func (v *Verifier) findKey(kid string) (interface{}, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+
+	key, exist := v.keys[kid]
+	return key, exist
+}
+
+// jsonWebKey is a JSON Web Key (RFC 7517) holding a RSA or EC public key
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys fetch the signing keys published by given issuer
+// the unsupported keys are ignored
+func fetchKeys(ctx context.Context, issuer string) (map[string]interface{}, error) {
+	metadata, err := Discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := doJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("error while fetching the provider keys: %s", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// getStrings return given claim value as a list
+// a single string value is returned as a list of one element
+func getStrings(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// isTrue determinate if given claim value is true
+// some providers send the booleans as string
+func isTrue(value interface{}) bool {
+	switch value := value.(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	default:
+		return false
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
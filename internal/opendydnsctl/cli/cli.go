@@ -1,19 +1,30 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/creekorful/open-dydns/internal/oidc"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/client"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/rs/zerolog"
+	"net/http"
+	"time"
 )
 
+// tokenRefreshMargin is the time before expiration from which the JWT token is renewed
+const tokenRefreshMargin = 30 * time.Second
+
 // ErrBadRequest is returned when function is calling with missing parameters
 var ErrBadRequest = fmt.Errorf("missing parameters")
 
 // ErrAlreadyLoggedIn is returned when trying to log-in but already logged in
 var ErrAlreadyLoggedIn = fmt.Errorf("already logged in")
 
+// ErrNotLoggedIn is returned when trying to log-out but not logged in
+var ErrNotLoggedIn = fmt.Errorf("not logged in")
+
 // AliasStatus represent an alias as viewed by the CLI app
 type AliasStatus struct {
 	proto.AliasDto
@@ -23,13 +34,37 @@ type AliasStatus struct {
 // CLI represent a instance of the cli application
 type CLI interface {
 	Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error)
+	VerifyTwoFactor(twoFactorToken, code string) error
+	StartOIDCLogin() (oidc.DeviceAuthorization, error)
+	CompleteOIDCLogin(auth oidc.DeviceAuthorization) (proto.TokenDto, error)
+	Logout() error
+	GetSessions() ([]proto.SessionDto, error)
+	RevokeSession(sessionID uint) error
+	SignUp(cred proto.CredentialsDto) error
+	VerifyEmail(token string) error
+	ChangePassword(currentPassword, newPassword string) error
+	RequestPasswordReset(email string) error
+	CompletePasswordReset(token, password string) error
+	SetupTwoFactor() (proto.TwoFactorSetupDto, error)
+	EnableTwoFactor(code string) ([]string, error)
+	DisableTwoFactor(code string) error
+	RegenerateRecoveryCodes(code string) ([]string, error)
 	GetAliases() ([]AliasStatus, error)
 	RegisterAlias(alias proto.AliasDto) (proto.AliasDto, error)
 	UpdateAlias(alias proto.AliasDto) (proto.AliasDto, error)
 	DeleteAlias(aliasName string) error
 	GetDomains() ([]proto.DomainDto, error)
+	CreateUpdateToken(aliasName string) (proto.UpdateTokenDto, error)
+	GetUpdateTokens(aliasName string) ([]proto.UpdateTokenDto, error)
+	RevokeUpdateToken(aliasName string, tokenID uint) error
 	SetSynchronize(aliasName string, status bool) error
-	Synchronize(IP string) error
+	Synchronize(IPs []string) error
+	GetRemoteIPs() ([]string, error)
+	GetUsers() ([]proto.UserDto, error)
+	CreateUser(user proto.CreateUserDto) (proto.UserDto, error)
+	SetUserDisabled(userID uint, disabled bool) error
+	ResetPassword(userID uint, password string) error
+	DeleteUser(userID uint) error
 }
 
 type cli struct {
@@ -38,6 +73,7 @@ type cli struct {
 	conf         config.Config
 	confProvider config.Provider
 	apiClient    proto.APIContract
+	ipClients    []proto.APIContract // one per IP family
 }
 
 // NewCLI instantiate a new CLI instance
@@ -54,13 +90,19 @@ func NewCLI(confPath string, logger *zerolog.Logger) (CLI, error) {
 		return nil, fmt.Errorf("invalid config file")
 	}
 
-	return &cli{
+	c := &cli{
 		tok:          proto.TokenDto{Token: conf.Token},
 		logger:       logger,
 		conf:         conf,
 		confProvider: provider,
 		apiClient:    client.NewClient(conf.APIAddr),
-	}, nil
+		ipClients: []proto.APIContract{
+			client.NewClientWithNetwork(conf.APIAddr, "tcp4"),
+			client.NewClientWithNetwork(conf.APIAddr, "tcp6"),
+		},
+	}
+
+	return c, nil
 }
 
 func (c *cli) Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error) {
@@ -78,18 +120,219 @@ func (c *cli) Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error) {
 		return proto.TokenDto{}, err
 	}
 
-	// save token
-	c.conf.Token = token.Token
-	if err := c.saveConfig(); err != nil {
+	// the second step is required: see VerifyTwoFactor
+	if token.TwoFactorToken != "" {
+		return proto.TokenDto{TwoFactorToken: token.TwoFactorToken}, nil
+	}
+
+	if err := c.setToken(token); err != nil {
 		return proto.TokenDto{}, err
 	}
 
 	return proto.TokenDto{Token: c.conf.Token}, nil
 }
 
-func (c *cli) GetAliases() ([]AliasStatus, error) {
-	aliases, err := c.apiClient.GetAliases(c.tok)
+func (c *cli) VerifyTwoFactor(twoFactorToken, code string) error {
+	if twoFactorToken == "" || code == "" {
+		return ErrBadRequest
+	}
+
+	token, err := c.apiClient.VerifyTwoFactor(proto.TwoFactorDto{Token: twoFactorToken, Code: code})
+	if err != nil {
+		return err
+	}
+
+	return c.setToken(token)
+}
+
+// StartOIDCLogin start the OpenID Connect login (device authorization grant)
+// the user must complete the returned authorization before calling CompleteOIDCLogin
+func (c *cli) StartOIDCLogin() (oidc.DeviceAuthorization, error) {
+	// check if not already logged in
+	if c.conf.Token != "" {
+		return oidc.DeviceAuthorization{}, ErrAlreadyLoggedIn
+	}
+
+	conf, err := c.apiClient.GetOIDCConfig()
+	if err != nil {
+		return oidc.DeviceAuthorization{}, err
+	}
+
+	return oidc.StartDeviceAuthorization(context.Background(), conf.Issuer, conf.ClientID, conf.Scopes)
+}
+
+// CompleteOIDCLogin wait for the user to complete given authorization
+// and authenticate against the daemon using the issued ID token
+func (c *cli) CompleteOIDCLogin(auth oidc.DeviceAuthorization) (proto.TokenDto, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), auth.ExpiresAt)
+	defer cancel()
+
+	idToken, err := auth.WaitIDToken(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return proto.TokenDto{}, oidc.ErrExpiredDeviceCode
+	}
+	if err != nil {
+		return proto.TokenDto{}, err
+	}
+
+	token, err := c.apiClient.AuthenticateOIDC(proto.OIDCTokenDto{IDToken: idToken})
 	if err != nil {
+		return proto.TokenDto{}, err
+	}
+
+	// the second step is required: see VerifyTwoFactor
+	if token.TwoFactorToken != "" {
+		return proto.TokenDto{TwoFactorToken: token.TwoFactorToken}, nil
+	}
+
+	if err := c.setToken(token); err != nil {
+		return proto.TokenDto{}, err
+	}
+
+	return proto.TokenDto{Token: c.conf.Token}, nil
+}
+
+func (c *cli) Logout() error {
+	if c.conf.Token == "" {
+		return ErrNotLoggedIn
+	}
+
+	// the local credentials are dropped even if the daemon cannot be reached
+	if err := c.revokeCurrentSession(); err != nil {
+		c.logger.Warn().Err(err).Msg("unable to revoke the session.")
+	}
+
+	return c.setToken(proto.TokenDto{})
+}
+
+func (c *cli) GetSessions() ([]proto.SessionDto, error) {
+	var sessions []proto.SessionDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		sessions, err = c.apiClient.GetSessions(token)
+		return err
+	})
+	return sessions, err
+}
+
+func (c *cli) RevokeSession(sessionID uint) error {
+	if sessionID == 0 {
+		return ErrBadRequest
+	}
+
+	return c.authenticated(func(token proto.TokenDto) error {
+		return c.apiClient.RevokeSession(token, sessionID)
+	})
+}
+
+func (c *cli) SignUp(cred proto.CredentialsDto) error {
+	if cred.Email == "" || cred.Password == "" {
+		return ErrBadRequest
+	}
+
+	return c.apiClient.SignUp(cred)
+}
+
+func (c *cli) VerifyEmail(token string) error {
+	if token == "" {
+		return ErrBadRequest
+	}
+
+	return c.apiClient.VerifyEmail(token)
+}
+
+func (c *cli) ChangePassword(currentPassword, newPassword string) error {
+	if currentPassword == "" || newPassword == "" {
+		return ErrBadRequest
+	}
+
+	var token proto.TokenDto
+	if err := c.authenticated(func(current proto.TokenDto) (err error) {
+		token, err = c.apiClient.ChangePassword(current, proto.ChangePasswordDto{
+			CurrentPassword: currentPassword,
+			NewPassword:     newPassword,
+		})
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// the previous session has been revoked
+	return c.setToken(token)
+}
+
+func (c *cli) RequestPasswordReset(email string) error {
+	if email == "" {
+		return ErrBadRequest
+	}
+
+	return c.apiClient.RequestPasswordReset(proto.PasswordResetRequestDto{Email: email})
+}
+
+func (c *cli) CompletePasswordReset(token, password string) error {
+	if token == "" || password == "" {
+		return ErrBadRequest
+	}
+
+	return c.apiClient.CompletePasswordReset(proto.PasswordResetDto{Token: token, Password: password})
+}
+
+func (c *cli) SetupTwoFactor() (proto.TwoFactorSetupDto, error) {
+	var setup proto.TwoFactorSetupDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		setup, err = c.apiClient.SetupTwoFactor(token)
+		return err
+	})
+	return setup, err
+}
+
+func (c *cli) EnableTwoFactor(code string) ([]string, error) {
+	if code == "" {
+		return nil, ErrBadRequest
+	}
+
+	var codes proto.RecoveryCodesDto
+	if err := c.authenticated(func(token proto.TokenDto) (err error) {
+		codes, err = c.apiClient.EnableTwoFactor(token, proto.TwoFactorCodeDto{Code: code})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return codes.Codes, nil
+}
+
+func (c *cli) DisableTwoFactor(code string) error {
+	if code == "" {
+		return ErrBadRequest
+	}
+
+	return c.authenticated(func(token proto.TokenDto) error {
+		return c.apiClient.DisableTwoFactor(token, proto.TwoFactorCodeDto{Code: code})
+	})
+}
+
+func (c *cli) RegenerateRecoveryCodes(code string) ([]string, error) {
+	if code == "" {
+		return nil, ErrBadRequest
+	}
+
+	var codes proto.RecoveryCodesDto
+	if err := c.authenticated(func(token proto.TokenDto) (err error) {
+		codes, err = c.apiClient.RegenerateRecoveryCodes(token, proto.TwoFactorCodeDto{Code: code})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return codes.Codes, nil
+}
+
+func (c *cli) GetAliases() ([]AliasStatus, error) {
+	var aliases []proto.AliasDto
+	if err := c.authenticated(func(token proto.TokenDto) (err error) {
+		aliases, err = c.apiClient.GetAliases(token)
+		return err
+	}); err != nil {
 		return nil, err
 	}
 
@@ -116,7 +359,12 @@ func (c *cli) RegisterAlias(alias proto.AliasDto) (proto.AliasDto, error) {
 		return proto.AliasDto{}, ErrBadRequest
 	}
 
-	return c.apiClient.RegisterAlias(c.tok, alias)
+	var result proto.AliasDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		result, err = c.apiClient.RegisterAlias(token, alias)
+		return err
+	})
+	return result, err
 }
 
 func (c *cli) UpdateAlias(alias proto.AliasDto) (proto.AliasDto, error) {
@@ -124,7 +372,12 @@ func (c *cli) UpdateAlias(alias proto.AliasDto) (proto.AliasDto, error) {
 		return proto.AliasDto{}, ErrBadRequest
 	}
 
-	return c.apiClient.UpdateAlias(c.tok, alias)
+	var result proto.AliasDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		result, err = c.apiClient.UpdateAlias(token, alias)
+		return err
+	})
+	return result, err
 }
 
 func (c *cli) DeleteAlias(aliasName string) error {
@@ -132,11 +385,54 @@ func (c *cli) DeleteAlias(aliasName string) error {
 		return ErrBadRequest
 	}
 
-	return c.apiClient.DeleteAlias(c.tok, aliasName)
+	return c.authenticated(func(token proto.TokenDto) error {
+		return c.apiClient.DeleteAlias(token, aliasName)
+	})
 }
 
 func (c *cli) GetDomains() ([]proto.DomainDto, error) {
-	return c.apiClient.GetDomains(c.tok)
+	var domains []proto.DomainDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		domains, err = c.apiClient.GetDomains(token)
+		return err
+	})
+	return domains, err
+}
+
+func (c *cli) CreateUpdateToken(aliasName string) (proto.UpdateTokenDto, error) {
+	if aliasName == "" {
+		return proto.UpdateTokenDto{}, ErrBadRequest
+	}
+
+	var updateToken proto.UpdateTokenDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		updateToken, err = c.apiClient.CreateUpdateToken(token, aliasName)
+		return err
+	})
+	return updateToken, err
+}
+
+func (c *cli) GetUpdateTokens(aliasName string) ([]proto.UpdateTokenDto, error) {
+	if aliasName == "" {
+		return nil, ErrBadRequest
+	}
+
+	var updateTokens []proto.UpdateTokenDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		updateTokens, err = c.apiClient.GetUpdateTokens(token, aliasName)
+		return err
+	})
+	return updateTokens, err
+}
+
+func (c *cli) RevokeUpdateToken(aliasName string, tokenID uint) error {
+	if aliasName == "" || tokenID == 0 {
+		return ErrBadRequest
+	}
+
+	return c.authenticated(func(token proto.TokenDto) error {
+		return c.apiClient.RevokeUpdateToken(token, aliasName, tokenID)
+	})
 }
 
 func (c *cli) SetSynchronize(aliasName string, status bool) error {
@@ -161,25 +457,175 @@ func (c *cli) SetSynchronize(aliasName string, status bool) error {
 	return nil
 }
 
-func (c *cli) Synchronize(ip string) error {
+func (c *cli) Synchronize(ips []string) error {
 	for name, conf := range c.conf.Aliases {
 		if !conf.Synchronize {
 			continue
 		}
 
-		if _, err := c.UpdateAlias(proto.AliasDto{
-			Domain: name,
-			Value:  ip,
-		}); err != nil {
-			c.logger.Err(err).Str("Domain", name).Str("Value", ip).Msg("error while updating alias.")
-		} else {
-			c.logger.Info().Str("Domain", name).Str("Value", ip).Msg("successfully updated alias.")
+		for _, ip := range ips {
+			if _, err := c.UpdateAlias(proto.AliasDto{
+				Domain: name,
+				Value:  ip,
+			}); err != nil {
+				c.logger.Err(err).Str("Domain", name).Str("Value", ip).Msg("error while updating alias.")
+			} else {
+				c.logger.Info().Str("Domain", name).Str("Value", ip).Msg("successfully updated alias.")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *cli) GetRemoteIPs() ([]string, error) {
+	var ips []string
+	for _, ipClient := range c.ipClients {
+		ip, err := ipClient.GetIP()
+		if err != nil || ip.IP == "" {
+			c.logger.Debug().Err(err).Msg("unable to get remote IP.")
+			continue
+		}
+		ips = append(ips, ip.IP)
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("unable to determinate remote IP")
+	}
+
+	return ips, nil
+}
+
+func (c *cli) GetUsers() ([]proto.UserDto, error) {
+	var users []proto.UserDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		users, err = c.apiClient.GetUsers(token)
+		return err
+	})
+	return users, err
+}
+
+func (c *cli) CreateUser(user proto.CreateUserDto) (proto.UserDto, error) {
+	if user.Email == "" || user.Password == "" {
+		return proto.UserDto{}, ErrBadRequest
+	}
+
+	var created proto.UserDto
+	err := c.authenticated(func(token proto.TokenDto) (err error) {
+		created, err = c.apiClient.CreateUser(token, user)
+		return err
+	})
+	return created, err
+}
+
+func (c *cli) SetUserDisabled(userID uint, disabled bool) error {
+	if userID == 0 {
+		return ErrBadRequest
+	}
+
+	return c.authenticated(func(token proto.TokenDto) error {
+		return c.apiClient.SetUserDisabled(token, userID, disabled)
+	})
+}
+
+func (c *cli) ResetPassword(userID uint, password string) error {
+	if userID == 0 || password == "" {
+		return ErrBadRequest
+	}
+
+	return c.authenticated(func(token proto.TokenDto) error {
+		return c.apiClient.ResetPassword(token, userID, proto.PasswordDto{Password: password})
+	})
+}
+
+func (c *cli) DeleteUser(userID uint) error {
+	if userID == 0 {
+		return ErrBadRequest
+	}
+
+	return c.authenticated(func(token proto.TokenDto) error {
+		return c.apiClient.DeleteUser(token, userID)
+	})
+}
+
+// authenticated run given call using the JWT token, renewed first if (almost) expired
+// the call is retried once with a renewed token if the daemon rejected the token (i.e clock skew)
+func (c *cli) authenticated(call func(token proto.TokenDto) error) error {
+	if err := c.refreshSession(false); err != nil {
+		return err
+	}
+
+	err := call(c.tok)
+
+	var apiErr *proto.ErrorDto
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusUnauthorized || c.conf.RefreshToken == "" {
+		return err
+	}
+
+	if err := c.refreshSession(true); err != nil {
+		return err
+	}
+	if c.conf.Token == "" {
+		return err // the session is no longer valid
+	}
+
+	return call(c.tok)
+}
+
+// refreshSession renew the JWT token using the refresh token once (almost) expired, or if forced
+// the credentials are dropped if the session is no longer valid
+func (c *cli) refreshSession(force bool) error {
+	if c.conf.RefreshToken == "" {
+		return nil
+	}
+	if !force && time.Now().Add(tokenRefreshMargin).Before(c.conf.TokenExpiresAt) {
+		return nil
+	}
+
+	token, err := c.apiClient.RefreshSession(proto.RefreshTokenDto{RefreshToken: c.conf.RefreshToken})
+	if err != nil {
+		var apiErr *proto.ErrorDto
+		if !errors.As(err, &apiErr) {
+			// the daemon cannot be reached: keep the credentials
+			c.logger.Warn().Err(err).Msg("unable to refresh the session.")
+			return nil
+		}
+
+		c.logger.Warn().Err(err).Msg("session expired, please login again.")
+		return c.setToken(proto.TokenDto{})
+	}
+
+	c.logger.Debug().Time("ExpiresAt", token.ExpiresAt).Msg("session refreshed.")
+
+	return c.setToken(token)
+}
+
+// revokeCurrentSession revoke the session of the current token
+func (c *cli) revokeCurrentSession() error {
+	sessions, err := c.GetSessions()
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.Current {
+			return c.apiClient.RevokeSession(c.tok, session.ID)
 		}
 	}
 
 	return nil
 }
 
+// setToken save the session tokens, an empty token drop the credentials
+func (c *cli) setToken(token proto.TokenDto) error {
+	c.tok = proto.TokenDto{Token: token.Token}
+	c.conf.Token = token.Token
+	c.conf.TokenExpiresAt = token.ExpiresAt
+	c.conf.RefreshToken = token.RefreshToken
+
+	return c.saveConfig()
+}
+
 func (c *cli) saveConfig() error {
 	return c.confProvider.Save(c.conf)
 }
@@ -1,15 +1,21 @@
 package cli
 
 import (
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/oidc"
+	"github.com/creekorful/open-dydns/internal/oidc/oidctest"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/config_mock"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/creekorful/open-dydns/proto_mock"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/golang/mock/gomock"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"io/ioutil"
+	"net/http"
 	"testing"
+	"time"
 )
 
 func TestCli_Authenticate_InvalidRequest(t *testing.T) {
@@ -35,6 +41,105 @@ func TestCli_Authenticate_AlreadyLoggedIn(t *testing.T) {
 	}
 }
 
+func TestCli_SignUp(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		apiClient: clientMock,
+	}
+
+	if err := c.SignUp(proto.CredentialsDto{Email: "test@example.org"}); err != ErrBadRequest {
+		t.Error("SignUp() should return ErrBadRequest")
+	}
+
+	clientMock.EXPECT().SignUp(proto.CredentialsDto{Email: "test@example.org", Password: "test"}).Return(nil)
+	if err := c.SignUp(proto.CredentialsDto{Email: "test@example.org", Password: "test"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCli_VerifyEmail(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		apiClient: clientMock,
+	}
+
+	if err := c.VerifyEmail(""); err != ErrBadRequest {
+		t.Error("VerifyEmail() should return ErrBadRequest")
+	}
+
+	clientMock.EXPECT().VerifyEmail("token").Return(proto.ErrInvalidVerificationToken)
+	if err := c.VerifyEmail("token"); err != proto.ErrInvalidVerificationToken {
+		t.Error("VerifyEmail() should return ErrInvalidVerificationToken")
+	}
+}
+
+func TestCli_ChangePassword(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	c := cli{
+		tok:          proto.TokenDto{Token: "old-token"},
+		conf:         config.Config{Token: "old-token"},
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+
+	if err := c.ChangePassword("current", ""); err != ErrBadRequest {
+		t.Error("ChangePassword() should return ErrBadRequest")
+	}
+
+	clientMock.EXPECT().
+		ChangePassword(proto.TokenDto{Token: "old-token"}, proto.ChangePasswordDto{CurrentPassword: "current", NewPassword: "new"}).
+		Return(proto.TokenDto{Token: "new-token"}, nil)
+	configMock.EXPECT().Save(config.Config{Token: "new-token"}).Return(nil)
+
+	if err := c.ChangePassword("current", "new"); err != nil {
+		t.Error(err)
+	}
+	if c.tok.Token != "new-token" {
+		t.Error("the new token should be used")
+	}
+}
+
+func TestCli_PasswordReset(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		apiClient: clientMock,
+	}
+
+	if err := c.RequestPasswordReset(""); err != ErrBadRequest {
+		t.Error("RequestPasswordReset() should return ErrBadRequest")
+	}
+	if err := c.CompletePasswordReset("token", ""); err != ErrBadRequest {
+		t.Error("CompletePasswordReset() should return ErrBadRequest")
+	}
+
+	clientMock.EXPECT().RequestPasswordReset(proto.PasswordResetRequestDto{Email: "test@example.org"}).Return(nil)
+	if err := c.RequestPasswordReset("test@example.org"); err != nil {
+		t.Error(err)
+	}
+
+	clientMock.EXPECT().CompletePasswordReset(proto.PasswordResetDto{Token: "token", Password: "new"}).Return(nil)
+	if err := c.CompletePasswordReset("token", "new"); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestCli_Authenticate(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -64,6 +169,424 @@ func TestCli_Authenticate(t *testing.T) {
 	}
 }
 
+func TestCli_Authenticate_TwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	c := cli{
+		logger:       &l,
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+
+	// nothing is saved until the second step
+	clientMock.EXPECT().
+		Authenticate(proto.CredentialsDto{Email: "root", Password: "toor"}).
+		Return(proto.TokenDto{TwoFactorToken: "challenge"}, nil)
+
+	tok, err := c.Authenticate(proto.CredentialsDto{Email: "root", Password: "toor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.TwoFactorToken != "challenge" || c.conf.Token != "" {
+		t.Errorf("wrong token returned: %+v", tok)
+	}
+
+	if err := c.VerifyTwoFactor("challenge", ""); err != ErrBadRequest {
+		t.Error("VerifyTwoFactor() should return ErrBadRequest")
+	}
+
+	clientMock.EXPECT().
+		VerifyTwoFactor(proto.TwoFactorDto{Token: "challenge", Code: "123456"}).
+		Return(proto.TokenDto{Token: "test-token", RefreshToken: "odr_test"}, nil)
+	configMock.EXPECT().Save(config.Config{Token: "test-token", RefreshToken: "odr_test"}).Return(nil)
+
+	if err := c.VerifyTwoFactor("challenge", "123456"); err != nil {
+		t.Error(err)
+	}
+	if c.tok.Token != "test-token" {
+		t.Error("the new token should be used")
+	}
+}
+
+func TestCli_OIDCLogin(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	c := cli{
+		logger:       &l,
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+
+	clientMock.EXPECT().GetOIDCConfig().
+		Return(proto.OIDCConfigDto{Issuer: issuer.URL, ClientID: "opendydnsctl", Scopes: []string{"openid", "email"}}, nil)
+
+	auth, err := c.StartOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.UserCode == "" || auth.VerificationURI == "" {
+		t.Errorf("wrong device authorization: %+v", auth)
+	}
+	auth.Interval = 10 * time.Millisecond
+
+	idToken := ""
+	clientMock.EXPECT().AuthenticateOIDC(gomock.Any()).DoAndReturn(func(token proto.OIDCTokenDto) (proto.TokenDto, error) {
+		idToken = token.IDToken
+		return proto.TokenDto{Token: "test-token", RefreshToken: "odr_test"}, nil
+	})
+	configMock.EXPECT().Save(config.Config{Token: "test-token", RefreshToken: "odr_test"}).Return(nil)
+
+	issuer.Approve(auth.UserCode, jwt.MapClaims{"sub": "248289761001", "email": "luna@example.org"})
+
+	tok, err := c.CompleteOIDCLogin(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Token != "test-token" {
+		t.Errorf("wrong token returned: %+v", tok)
+	}
+	if idToken == "" || c.tok.Token != "test-token" {
+		t.Errorf("wrong token used: %s %s", idToken, c.tok.Token)
+	}
+
+	// already logged in
+	if _, err := c.StartOIDCLogin(); err != ErrAlreadyLoggedIn {
+		t.Errorf("StartOIDCLogin() should have returned ErrAlreadyLoggedIn")
+	}
+}
+
+func TestCli_OIDCLogin_TwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	c := cli{apiClient: clientMock}
+
+	clientMock.EXPECT().GetOIDCConfig().
+		Return(proto.OIDCConfigDto{Issuer: issuer.URL, ClientID: "opendydnsctl", Scopes: []string{"openid"}}, nil)
+
+	auth, err := c.StartOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Interval = 10 * time.Millisecond
+
+	// nothing is saved until the second step is completed
+	clientMock.EXPECT().AuthenticateOIDC(gomock.Any()).Return(proto.TokenDto{TwoFactorToken: "challenge"}, nil)
+
+	issuer.Approve(auth.UserCode, jwt.MapClaims{"sub": "248289761001", "email": "luna@example.org"})
+
+	tok, err := c.CompleteOIDCLogin(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.TwoFactorToken != "challenge" || c.conf.Token != "" {
+		t.Errorf("wrong token returned: %+v", tok)
+	}
+}
+
+func TestCli_OIDCLogin_Expired(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	c := cli{apiClient: clientMock}
+
+	clientMock.EXPECT().GetOIDCConfig().
+		Return(proto.OIDCConfigDto{Issuer: issuer.URL, ClientID: "opendydnsctl", Scopes: []string{"openid"}}, nil)
+
+	auth, err := c.StartOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Interval = 10 * time.Millisecond
+	auth.ExpiresAt = time.Now().Add(50 * time.Millisecond)
+
+	// the daemon is not called
+	if _, err := c.CompleteOIDCLogin(auth); err != oidc.ErrExpiredDeviceCode {
+		t.Errorf("CompleteOIDCLogin() should have returned ErrExpiredDeviceCode: %v", err)
+	}
+}
+
+func TestCli_TwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		tok:       proto.TokenDto{Token: "test-token"},
+		apiClient: clientMock,
+	}
+
+	if _, err := c.EnableTwoFactor(""); err != ErrBadRequest {
+		t.Error("EnableTwoFactor() should return ErrBadRequest")
+	}
+	if err := c.DisableTwoFactor(""); err != ErrBadRequest {
+		t.Error("DisableTwoFactor() should return ErrBadRequest")
+	}
+	if _, err := c.RegenerateRecoveryCodes(""); err != ErrBadRequest {
+		t.Error("RegenerateRecoveryCodes() should return ErrBadRequest")
+	}
+
+	clientMock.EXPECT().SetupTwoFactor(proto.TokenDto{Token: "test-token"}).
+		Return(proto.TwoFactorSetupDto{Secret: "JBSWY3DPEHPK3PXP", URI: "otpauth://totp/test"}, nil)
+	if setup, err := c.SetupTwoFactor(); err != nil || setup.Secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("wrong setup returned: %+v %v", setup, err)
+	}
+
+	clientMock.EXPECT().EnableTwoFactor(proto.TokenDto{Token: "test-token"}, proto.TwoFactorCodeDto{Code: "123456"}).
+		Return(proto.RecoveryCodesDto{Codes: []string{"abcde-fghij"}}, nil)
+	if codes, err := c.EnableTwoFactor("123456"); err != nil || len(codes) != 1 || codes[0] != "abcde-fghij" {
+		t.Errorf("wrong recovery codes returned: %+v %v", codes, err)
+	}
+
+	clientMock.EXPECT().RegenerateRecoveryCodes(proto.TokenDto{Token: "test-token"}, proto.TwoFactorCodeDto{Code: "654321"}).
+		Return(proto.RecoveryCodesDto{}, proto.ErrInvalidTwoFactorCode)
+	if _, err := c.RegenerateRecoveryCodes("654321"); err != proto.ErrInvalidTwoFactorCode {
+		t.Error("RegenerateRecoveryCodes() should have returned ErrInvalidTwoFactorCode")
+	}
+
+	clientMock.EXPECT().DisableTwoFactor(proto.TokenDto{Token: "test-token"}, proto.TwoFactorCodeDto{Code: "abcde-fghij"}).
+		Return(nil)
+	if err := c.DisableTwoFactor("abcde-fghij"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCli_Logout(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	c := cli{
+		logger:       &l,
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+
+	if err := c.Logout(); err != ErrNotLoggedIn {
+		t.Error("Logout() should have returned ErrNotLoggedIn")
+	}
+
+	c.tok = proto.TokenDto{Token: "test-token"}
+	c.conf = config.Config{Token: "test-token", TokenExpiresAt: time.Now().Add(time.Hour), RefreshToken: "odr_test"}
+
+	clientMock.EXPECT().GetSessions(proto.TokenDto{Token: "test-token"}).
+		Return([]proto.SessionDto{{ID: 3}, {ID: 4, Current: true}}, nil)
+	clientMock.EXPECT().RevokeSession(proto.TokenDto{Token: "test-token"}, uint(4)).Return(nil)
+	configMock.EXPECT().Save(config.Config{}).Return(nil)
+
+	if err := c.Logout(); err != nil {
+		t.Error(err)
+	}
+	if c.tok.Token != "" {
+		t.Error("the token should have been dropped")
+	}
+}
+
+func TestCli_Logout_DaemonUnreachable(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	c := cli{
+		logger:       &l,
+		tok:          proto.TokenDto{Token: "test-token"},
+		conf:         config.Config{Token: "test-token", TokenExpiresAt: time.Now().Add(time.Hour), RefreshToken: "odr_test"},
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+
+	// the local credentials are dropped anyway
+	clientMock.EXPECT().GetSessions(proto.TokenDto{Token: "test-token"}).Return(nil, fmt.Errorf("connection refused"))
+	configMock.EXPECT().Save(config.Config{}).Return(nil)
+
+	if err := c.Logout(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCli_RefreshSession(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	expiresAt := time.Now().Add(time.Hour)
+
+	// the token is still valid: nothing to do
+	c := cli{
+		logger:       &l,
+		conf:         config.Config{Token: "test-token", TokenExpiresAt: expiresAt, RefreshToken: "odr_test"},
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+	if err := c.refreshSession(false); err != nil {
+		t.Error(err)
+	}
+
+	// the token is expired: refresh it
+	c.conf.TokenExpiresAt = time.Now().Add(-time.Minute)
+
+	newExpiresAt := time.Now().Add(15 * time.Minute)
+	clientMock.EXPECT().RefreshSession(proto.RefreshTokenDto{RefreshToken: "odr_test"}).
+		Return(proto.TokenDto{Token: "new-token", ExpiresAt: newExpiresAt, RefreshToken: "odr_new"}, nil)
+	configMock.EXPECT().
+		Save(config.Config{Token: "new-token", TokenExpiresAt: newExpiresAt, RefreshToken: "odr_new"}).
+		Return(nil)
+
+	if err := c.refreshSession(false); err != nil {
+		t.Error(err)
+	}
+	if c.tok.Token != "new-token" {
+		t.Error("the new token should be used")
+	}
+
+	// the daemon cannot be reached: keep the credentials
+	c.conf.TokenExpiresAt = time.Now().Add(-time.Minute)
+	clientMock.EXPECT().RefreshSession(proto.RefreshTokenDto{RefreshToken: "odr_new"}).
+		Return(proto.TokenDto{}, fmt.Errorf("connection refused"))
+
+	if err := c.refreshSession(false); err != nil {
+		t.Error(err)
+	}
+	if c.conf.RefreshToken != "odr_new" {
+		t.Error("the credentials should have been kept")
+	}
+
+	// the session is revoked: drop the credentials
+	clientMock.EXPECT().RefreshSession(proto.RefreshTokenDto{RefreshToken: "odr_new"}).
+		Return(proto.TokenDto{}, &proto.ErrorDto{Message: "invalid or expired refresh token"})
+	configMock.EXPECT().Save(config.Config{}).Return(nil)
+
+	if err := c.refreshSession(false); err != nil {
+		t.Error(err)
+	}
+	if c.tok.Token != "" {
+		t.Error("the token should have been dropped")
+	}
+}
+
+func TestCli_Authenticated(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	configMock := config_mock.NewMockProvider(mockCtrl)
+
+	// the expired token is renewed before the call
+	c := cli{
+		logger:       &l,
+		tok:          proto.TokenDto{Token: "test-token"},
+		conf:         config.Config{Token: "test-token", TokenExpiresAt: time.Now().Add(-time.Minute), RefreshToken: "odr_test"},
+		apiClient:    clientMock,
+		confProvider: configMock,
+	}
+
+	expiresAt := time.Now().Add(15 * time.Minute)
+	clientMock.EXPECT().RefreshSession(proto.RefreshTokenDto{RefreshToken: "odr_test"}).
+		Return(proto.TokenDto{Token: "new-token", ExpiresAt: expiresAt, RefreshToken: "odr_new"}, nil)
+	configMock.EXPECT().Save(gomock.Any()).Return(nil).AnyTimes()
+	clientMock.EXPECT().GetDomains(proto.TokenDto{Token: "new-token"}).Return([]proto.DomainDto{{Domain: "bar.baz"}}, nil)
+
+	if domains, err := c.GetDomains(); err != nil || len(domains) != 1 {
+		t.Errorf("wrong domains returned: %+v %v", domains, err)
+	}
+
+	// the token rejected by the daemon is renewed and the call retried once
+	clientMock.EXPECT().GetDomains(proto.TokenDto{Token: "new-token"}).
+		Return(nil, &proto.ErrorDto{Message: "invalid session", Code: http.StatusUnauthorized})
+	clientMock.EXPECT().RefreshSession(proto.RefreshTokenDto{RefreshToken: "odr_new"}).
+		Return(proto.TokenDto{Token: "other-token", ExpiresAt: expiresAt, RefreshToken: "odr_other"}, nil)
+	clientMock.EXPECT().GetDomains(proto.TokenDto{Token: "other-token"}).Return([]proto.DomainDto{{Domain: "bar.baz"}}, nil)
+
+	if domains, err := c.GetDomains(); err != nil || len(domains) != 1 {
+		t.Errorf("wrong domains returned: %+v %v", domains, err)
+	}
+
+	// the other errors are not retried
+	clientMock.EXPECT().DeleteAlias(proto.TokenDto{Token: "other-token"}, "foo.bar.baz").
+		Return(&proto.ErrorDto{Message: "alias not found", Code: http.StatusNotFound})
+	if err := c.DeleteAlias("foo.bar.baz"); err == nil {
+		t.Error("DeleteAlias() should have failed")
+	}
+
+	// the session is no longer valid: the credentials are dropped and the call is not retried
+	clientMock.EXPECT().GetDomains(proto.TokenDto{Token: "other-token"}).
+		Return(nil, &proto.ErrorDto{Message: "invalid session", Code: http.StatusUnauthorized})
+	clientMock.EXPECT().RefreshSession(proto.RefreshTokenDto{RefreshToken: "odr_other"}).
+		Return(proto.TokenDto{}, &proto.ErrorDto{Message: "invalid or expired refresh token", Code: http.StatusUnauthorized})
+
+	if _, err := c.GetDomains(); err == nil || err.Error() != "invalid session" {
+		t.Errorf("GetDomains() should have returned the session error: %v", err)
+	}
+	if c.conf.RefreshToken != "" {
+		t.Error("the credentials should have been dropped")
+	}
+}
+
+func TestCli_Sessions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		tok:       proto.TokenDto{Token: "test-token"},
+		apiClient: clientMock,
+	}
+
+	if err := c.RevokeSession(0); err != ErrBadRequest {
+		t.Error("RevokeSession() should return ErrBadRequest")
+	}
+
+	clientMock.EXPECT().GetSessions(proto.TokenDto{Token: "test-token"}).
+		Return([]proto.SessionDto{{ID: 4, Current: true}}, nil)
+	sessions, err := c.GetSessions()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != 4 {
+		t.Errorf("wrong sessions returned: %+v", sessions)
+	}
+
+	clientMock.EXPECT().RevokeSession(proto.TokenDto{Token: "test-token"}, uint(3)).Return(proto.ErrSessionNotFound)
+	if err := c.RevokeSession(3); err != proto.ErrSessionNotFound {
+		t.Error("RevokeSession() should have returned ErrSessionNotFound")
+	}
+}
+
 func TestCli_GetAliases(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -296,6 +819,115 @@ func TestCli_GetDomains(t *testing.T) {
 	}
 }
 
+func TestCli_UpdateTokens_InvalidRequest(t *testing.T) {
+	c := cli{}
+
+	if _, err := c.CreateUpdateToken(""); err != ErrBadRequest {
+		t.Error("CreateUpdateToken() should return ErrBadRequest")
+	}
+	if _, err := c.GetUpdateTokens(""); err != ErrBadRequest {
+		t.Error("GetUpdateTokens() should return ErrBadRequest")
+	}
+	if err := c.RevokeUpdateToken("foo.bar.baz", 0); err != ErrBadRequest {
+		t.Error("RevokeUpdateToken() should return ErrBadRequest")
+	}
+}
+
+func TestCli_UpdateTokens(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		tok:       proto.TokenDto{Token: "test-token"},
+	}
+
+	clientMock.EXPECT().
+		CreateUpdateToken(c.tok, "foo.bar.baz").
+		Return(proto.UpdateTokenDto{ID: 3, Token: "odt_test"}, nil)
+	clientMock.EXPECT().
+		GetUpdateTokens(c.tok, "foo.bar.baz").
+		Return([]proto.UpdateTokenDto{{ID: 3}}, nil)
+	clientMock.EXPECT().RevokeUpdateToken(c.tok, "foo.bar.baz", uint(3)).Return(nil)
+
+	token, err := c.CreateUpdateToken("foo.bar.baz")
+	if err != nil || token.Token != "odt_test" {
+		t.Errorf("wrong token created: %v %v", token, err)
+	}
+
+	tokens, err := c.GetUpdateTokens("foo.bar.baz")
+	if err != nil || len(tokens) != 1 {
+		t.Errorf("wrong tokens returned: %v %v", tokens, err)
+	}
+
+	if err := c.RevokeUpdateToken("foo.bar.baz", 3); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCli_Users_InvalidRequest(t *testing.T) {
+	c := cli{}
+
+	if _, err := c.CreateUser(proto.CreateUserDto{Email: "lunamicard@gmail.com"}); err != ErrBadRequest {
+		t.Error("CreateUser() should return ErrBadRequest")
+	}
+	if err := c.SetUserDisabled(0, true); err != ErrBadRequest {
+		t.Error("SetUserDisabled() should return ErrBadRequest")
+	}
+	if err := c.ResetPassword(2, ""); err != ErrBadRequest {
+		t.Error("ResetPassword() should return ErrBadRequest")
+	}
+	if err := c.DeleteUser(0); err != ErrBadRequest {
+		t.Error("DeleteUser() should return ErrBadRequest")
+	}
+}
+
+func TestCli_Users(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	clientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		apiClient: clientMock,
+		tok:       proto.TokenDto{Token: "test-token"},
+	}
+
+	clientMock.EXPECT().
+		CreateUser(c.tok, proto.CreateUserDto{Email: "lunamicard@gmail.com", Password: "secret"}).
+		Return(proto.UserDto{ID: 2, Email: "lunamicard@gmail.com"}, nil)
+	clientMock.EXPECT().GetUsers(c.tok).Return([]proto.UserDto{{ID: 1}, {ID: 2}}, nil)
+	clientMock.EXPECT().SetUserDisabled(c.tok, uint(2), true).Return(nil)
+	clientMock.EXPECT().ResetPassword(c.tok, uint(2), proto.PasswordDto{Password: "other"}).Return(nil)
+	clientMock.EXPECT().DeleteUser(c.tok, uint(2)).Return(nil)
+
+	user, err := c.CreateUser(proto.CreateUserDto{Email: "lunamicard@gmail.com", Password: "secret"})
+	if err != nil || user.ID != 2 {
+		t.Errorf("wrong user created: %v %v", user, err)
+	}
+
+	users, err := c.GetUsers()
+	if err != nil || len(users) != 2 {
+		t.Errorf("wrong users returned: %v %v", users, err)
+	}
+
+	if err := c.SetUserDisabled(2, true); err != nil {
+		t.Error(err)
+	}
+	if err := c.ResetPassword(2, "other"); err != nil {
+		t.Error(err)
+	}
+	if err := c.DeleteUser(2); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestCli_Synchronize(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -327,7 +959,17 @@ func TestCli_Synchronize(t *testing.T) {
 		UpdateAlias(c.tok, proto.AliasDto{Domain: "dummy.notexist.org", Value: "127.0.0.1"}).
 		Return(proto.AliasDto{}, proto.ErrAliasNotFound)
 
-	if err := c.Synchronize("127.0.0.1"); err != nil {
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "local.example.org", Value: "::1"}).
+		Return(proto.AliasDto{Domain: "local.example.org", Type: "AAAA", Value: "::1"}, nil)
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "foo.example.org", Value: "::1"}).
+		Return(proto.AliasDto{Domain: "foo.example.org", Type: "AAAA", Value: "::1"}, nil)
+	clientMock.EXPECT().
+		UpdateAlias(c.tok, proto.AliasDto{Domain: "dummy.notexist.org", Value: "::1"}).
+		Return(proto.AliasDto{}, proto.ErrAliasNotFound)
+
+	if err := c.Synchronize([]string{"127.0.0.1", "::1"}); err != nil {
 		t.Error(err)
 	}
 }
@@ -388,3 +1030,36 @@ func TestCli_SetSynchronize(t *testing.T) {
 		t.Error("alias foo.example.org is not updated")
 	}
 }
+
+func TestCli_GetRemoteIPs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	l := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	ipv4ClientMock := proto_mock.NewMockAPIContract(mockCtrl)
+	ipv6ClientMock := proto_mock.NewMockAPIContract(mockCtrl)
+
+	c := cli{
+		logger:    &l,
+		ipClients: []proto.APIContract{ipv4ClientMock, ipv6ClientMock},
+	}
+
+	ipv4ClientMock.EXPECT().GetIP().Return(proto.IPDto{IP: "8.8.8.8"}, nil)
+	ipv6ClientMock.EXPECT().GetIP().Return(proto.IPDto{}, fmt.Errorf("network is unreachable"))
+
+	ips, err := c.GetRemoteIPs()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(ips) != 1 || ips[0] != "8.8.8.8" {
+		t.Error("wrong IPs returned")
+	}
+
+	ipv4ClientMock.EXPECT().GetIP().Return(proto.IPDto{}, fmt.Errorf("network is unreachable"))
+	ipv6ClientMock.EXPECT().GetIP().Return(proto.IPDto{}, fmt.Errorf("network is unreachable"))
+
+	if _, err := c.GetRemoteIPs(); err == nil {
+		t.Error("GetRemoteIPs() should have failed")
+	}
+}
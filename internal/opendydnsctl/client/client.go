@@ -1,9 +1,13 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/go-resty/resty/v2"
+	"net"
+	"net/http"
+	"time"
 )
 
 // Client is an HTTP REST client to interface with a OpenDyDNS daemon
@@ -16,12 +20,36 @@ func NewClient(baseURL string) proto.APIContract {
 	httpClient := resty.New()
 	httpClient.SetHostURL(baseURL)
 	httpClient.SetAuthScheme("Bearer")
+	httpClient.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		// the callers may need the status code of the error (i.e to renew the session)
+		if apiErr, ok := resp.Error().(*proto.ErrorDto); ok && resp.IsError() {
+			apiErr.Code = resp.StatusCode()
+		}
+		return nil
+	})
 
 	return &Client{
 		httpClient: httpClient,
 	}
 }
 
+// NewClientWithNetwork return a new configured Client using given baseURL
+// which only dial using given network (tcp4, tcp6)
+// this is used to determinate the client IPv4 / IPv6 address
+func NewClientWithNetwork(baseURL, network string) proto.APIContract {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	c := NewClient(baseURL).(*Client)
+	c.httpClient.SetTransport(&http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	})
+
+	return c
+}
+
 // Authenticate see proto.APIContract
 func (c *Client) Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error) {
 	var result proto.TokenDto
@@ -32,6 +60,69 @@ func (c *Client) Authenticate(cred proto.CredentialsDto) (proto.TokenDto, error)
 	return result, nonNilError(err)
 }
 
+// VerifyTwoFactor see proto.APIContract
+func (c *Client) VerifyTwoFactor(challenge proto.TwoFactorDto) (proto.TokenDto, error) {
+	var result proto.TokenDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetBody(challenge).SetResult(&result).SetError(&err).Post("/sessions/2fa")
+
+	return result, nonNilError(err)
+}
+
+// GetOIDCConfig see proto.APIContract
+func (c *Client) GetOIDCConfig() (proto.OIDCConfigDto, error) {
+	var result proto.OIDCConfigDto
+	var err proto.ErrorDto
+
+	if _, err := c.httpClient.R().SetResult(&result).SetError(&err).Get("/sessions/oidc"); err != nil {
+		return proto.OIDCConfigDto{}, err
+	}
+
+	return result, nonNilError(err)
+}
+
+// AuthenticateOIDC see proto.APIContract
+func (c *Client) AuthenticateOIDC(token proto.OIDCTokenDto) (proto.TokenDto, error) {
+	var result proto.TokenDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetBody(token).SetResult(&result).SetError(&err).Post("/sessions/oidc")
+
+	return result, nonNilError(err)
+}
+
+// RefreshSession see proto.APIContract
+func (c *Client) RefreshSession(refresh proto.RefreshTokenDto) (proto.TokenDto, error) {
+	var result proto.TokenDto
+	var err proto.ErrorDto
+
+	if _, err := c.httpClient.R().SetBody(refresh).SetResult(&result).SetError(&err).Post("/sessions/refresh"); err != nil {
+		return proto.TokenDto{}, err
+	}
+
+	return result, nonNilError(err)
+}
+
+// GetSessions see proto.APIContract
+func (c *Client) GetSessions(token proto.TokenDto) ([]proto.SessionDto, error) {
+	var result []proto.SessionDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/sessions")
+
+	return result, nonNilError(err)
+}
+
+// RevokeSession see proto.APIContract
+func (c *Client) RevokeSession(token proto.TokenDto, id uint) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetError(&err).Delete(fmt.Sprintf("/sessions/%d", id))
+
+	return nonNilError(err)
+}
+
 // GetAliases see proto.APIContract
 func (c *Client) GetAliases(token proto.TokenDto) ([]proto.AliasDto, error) {
 	var result []proto.AliasDto
@@ -71,6 +162,49 @@ func (c *Client) DeleteAlias(token proto.TokenDto, name string) error {
 	return nonNilError(err)
 }
 
+// CreateUpdateToken see proto.APIContract
+func (c *Client) CreateUpdateToken(token proto.TokenDto, name string) (proto.UpdateTokenDto, error) {
+	var result proto.UpdateTokenDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetResult(&result).SetError(&err).
+		Post(fmt.Sprintf("/aliases/%s/tokens", name))
+
+	return result, nonNilError(err)
+}
+
+// GetUpdateTokens see proto.APIContract
+func (c *Client) GetUpdateTokens(token proto.TokenDto, name string) ([]proto.UpdateTokenDto, error) {
+	var result []proto.UpdateTokenDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetResult(&result).SetError(&err).
+		Get(fmt.Sprintf("/aliases/%s/tokens", name))
+
+	return result, nonNilError(err)
+}
+
+// RevokeUpdateToken see proto.APIContract
+func (c *Client) RevokeUpdateToken(token proto.TokenDto, name string, id uint) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetError(&err).
+		Delete(fmt.Sprintf("/aliases/%s/tokens/%d", name, id))
+
+	return nonNilError(err)
+}
+
+// UpdateAliasWithToken see proto.APIContract
+func (c *Client) UpdateAliasWithToken(updateToken string, alias proto.AliasDto) (proto.AliasDto, error) {
+	var result proto.AliasDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(updateToken).SetBody(alias).SetResult(&result).SetError(&err).
+		Put(fmt.Sprintf("/aliases/%s", alias.Domain))
+
+	return result, nonNilError(err)
+}
+
 // GetDomains see proto.APIContract
 func (c *Client) GetDomains(token proto.TokenDto) ([]proto.DomainDto, error) {
 	var result []proto.DomainDto
@@ -81,6 +215,161 @@ func (c *Client) GetDomains(token proto.TokenDto) ([]proto.DomainDto, error) {
 	return result, nonNilError(err)
 }
 
+// GetIP see proto.APIContract
+func (c *Client) GetIP() (proto.IPDto, error) {
+	var result proto.IPDto
+	var err proto.ErrorDto
+
+	if _, err := c.httpClient.R().SetResult(&result).SetError(&err).Get("/ip"); err != nil {
+		return proto.IPDto{}, err
+	}
+
+	return result, nonNilError(err)
+}
+
+// SignUp see proto.APIContract
+func (c *Client) SignUp(cred proto.CredentialsDto) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetBody(cred).SetError(&err).Post("/users")
+
+	return nonNilError(err)
+}
+
+// VerifyEmail see proto.APIContract
+func (c *Client) VerifyEmail(token string) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetQueryParam("token", token).SetError(&err).Get("/users/verify")
+
+	return nonNilError(err)
+}
+
+// ChangePassword see proto.APIContract
+func (c *Client) ChangePassword(token proto.TokenDto, password proto.ChangePasswordDto) (proto.TokenDto, error) {
+	var result proto.TokenDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetBody(password).SetResult(&result).SetError(&err).
+		Put("/users/me/password")
+
+	return result, nonNilError(err)
+}
+
+// RequestPasswordReset see proto.APIContract
+func (c *Client) RequestPasswordReset(request proto.PasswordResetRequestDto) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetBody(request).SetError(&err).Post("/users/password-reset")
+
+	return nonNilError(err)
+}
+
+// CompletePasswordReset see proto.APIContract
+func (c *Client) CompletePasswordReset(reset proto.PasswordResetDto) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetBody(reset).SetError(&err).Put("/users/password-reset")
+
+	return nonNilError(err)
+}
+
+// SetupTwoFactor see proto.APIContract
+func (c *Client) SetupTwoFactor(token proto.TokenDto) (proto.TwoFactorSetupDto, error) {
+	var result proto.TwoFactorSetupDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetResult(&result).SetError(&err).Post("/users/me/2fa")
+
+	return result, nonNilError(err)
+}
+
+// EnableTwoFactor see proto.APIContract
+func (c *Client) EnableTwoFactor(token proto.TokenDto, code proto.TwoFactorCodeDto) (proto.RecoveryCodesDto, error) {
+	var result proto.RecoveryCodesDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetBody(code).SetResult(&result).SetError(&err).
+		Put("/users/me/2fa")
+
+	return result, nonNilError(err)
+}
+
+// DisableTwoFactor see proto.APIContract
+func (c *Client) DisableTwoFactor(token proto.TokenDto, code proto.TwoFactorCodeDto) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetBody(code).SetError(&err).Post("/users/me/2fa/disable")
+
+	return nonNilError(err)
+}
+
+// RegenerateRecoveryCodes see proto.APIContract
+func (c *Client) RegenerateRecoveryCodes(token proto.TokenDto, code proto.TwoFactorCodeDto) (proto.RecoveryCodesDto, error) {
+	var result proto.RecoveryCodesDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetBody(code).SetResult(&result).SetError(&err).
+		Post("/users/me/2fa/recovery-codes")
+
+	return result, nonNilError(err)
+}
+
+// GetUsers see proto.APIContract
+func (c *Client) GetUsers(token proto.TokenDto) ([]proto.UserDto, error) {
+	var result []proto.UserDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetResult(&result).SetError(&err).Get("/admin/users")
+
+	return result, nonNilError(err)
+}
+
+// CreateUser see proto.APIContract
+func (c *Client) CreateUser(token proto.TokenDto, user proto.CreateUserDto) (proto.UserDto, error) {
+	var result proto.UserDto
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetBody(user).SetResult(&result).SetError(&err).Post("/admin/users")
+
+	return result, nonNilError(err)
+}
+
+// SetUserDisabled see proto.APIContract
+func (c *Client) SetUserDisabled(token proto.TokenDto, id uint, disabled bool) error {
+	var err proto.ErrorDto
+
+	action := "enable"
+	if disabled {
+		action = "disable"
+	}
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetError(&err).
+		Post(fmt.Sprintf("/admin/users/%d/%s", id, action))
+
+	return nonNilError(err)
+}
+
+// ResetPassword see proto.APIContract
+func (c *Client) ResetPassword(token proto.TokenDto, id uint, password proto.PasswordDto) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetBody(password).SetError(&err).
+		Put(fmt.Sprintf("/admin/users/%d/password", id))
+
+	return nonNilError(err)
+}
+
+// DeleteUser see proto.APIContract
+func (c *Client) DeleteUser(token proto.TokenDto, id uint) error {
+	var err proto.ErrorDto
+
+	_, _ = c.httpClient.R().SetAuthToken(token.Token).SetError(&err).
+		Delete(fmt.Sprintf("/admin/users/%d", id))
+
+	return nonNilError(err)
+}
+
 func nonNilError(err proto.ErrorDto) error {
 	if err.Message == "" {
 		return nil
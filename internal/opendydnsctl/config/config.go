@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/common"
+	"time"
 )
 
 //go:generate mockgen -source config.go -destination=../config_mock/config_mock.go -package=config_mock
@@ -48,10 +49,13 @@ func NewFileProvider(filepath string) Provider {
 }
 
 // Config represent the OpenDyDNS-CLI configuration
+// the file holds the session tokens: it is only readable by its owner
 type Config struct {
-	APIAddr string
-	Token   string
-	Aliases map[string]AliasConfig
+	APIAddr        string
+	Token          string
+	TokenExpiresAt time.Time // the token is renewed using the refresh token once expired
+	RefreshToken   string
+	Aliases        map[string]AliasConfig
 }
 
 // AliasConfig represent the aliases part of the configuration file
@@ -6,12 +6,12 @@ import (
 	cli2 "github.com/creekorful/open-dydns/internal/opendydnsctl/cli"
 	"github.com/creekorful/open-dydns/internal/opendydnsctl/config"
 	"github.com/creekorful/open-dydns/proto"
-	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/crypto/ssh/terminal"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // CLIApp represent the opendydnsctl running context
@@ -42,6 +42,82 @@ func (odc *CLIApp) App() *cli.App {
 				ArgsUsage: "<EMAIL>",
 				Usage:     "Authenticate against an OpenDyDNS daemon",
 				Action:    odc.login,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "oidc",
+						Usage: "Authenticate using the OpenID Connect provider of the daemon (no EMAIL required)",
+					},
+				},
+			},
+			{
+				Name:   "logout",
+				Usage:  "Revoke the current session and forget its tokens",
+				Action: odc.logout,
+			},
+			{
+				Name:   "sessions",
+				Usage:  "List the active sessions",
+				Action: odc.sessions,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "revoke",
+						ArgsUsage: "<ID>",
+						Usage:     "Revoke given session",
+						Action:    odc.sessionsRevoke,
+					},
+				},
+			},
+			{
+				Name:      "signup",
+				ArgsUsage: "<EMAIL>",
+				Usage:     "Create an account on an OpenDyDNS daemon (if allowed)",
+				Action:    odc.signup,
+			},
+			{
+				Name:      "verify",
+				ArgsUsage: "<TOKEN>",
+				Usage:     "Verify the email address using the token received by email",
+				Action:    odc.verify,
+			},
+			{
+				Name:   "passwd",
+				Usage:  "Change the password of the authenticated user",
+				Action: odc.passwd,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "forgot",
+						ArgsUsage: "<EMAIL>",
+						Usage:     "Receive a password reset token by email",
+						Action:    odc.passwdForgot,
+					},
+					{
+						Name:      "reset",
+						ArgsUsage: "<TOKEN>",
+						Usage:     "Choose a new password using the token received by email",
+						Action:    odc.passwdReset,
+					},
+				},
+			},
+			{
+				Name:  "2fa",
+				Usage: "Manage the two-factor authentication (TOTP) of the authenticated user",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "enable",
+						Usage:  "Enable the two-factor authentication using an authenticator app",
+						Action: odc.twoFactorEnable,
+					},
+					{
+						Name:   "disable",
+						Usage:  "Disable the two-factor authentication",
+						Action: odc.twoFactorDisable,
+					},
+					{
+						Name:   "recovery-codes",
+						Usage:  "Replace the recovery codes",
+						Action: odc.twoFactorRecoveryCodes,
+					},
+				},
 			},
 			{
 				Name:      "ls",
@@ -73,6 +149,77 @@ func (odc *CLIApp) App() *cli.App {
 				Usage:     "Enable synchronization for given alias",
 				Action:    odc.setSynchronize,
 			},
+			{
+				Name:  "token",
+				Usage: "Manage the update tokens of an alias (for headless devices)",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "create",
+						ArgsUsage: "<ALIAS>",
+						Usage:     "Create an update token for given alias",
+						Action:    odc.tokenCreate,
+					},
+					{
+						Name:      "ls",
+						ArgsUsage: "<ALIAS>",
+						Usage:     "List the update tokens of given alias",
+						Action:    odc.tokenLs,
+					},
+					{
+						Name:      "revoke",
+						ArgsUsage: "<ALIAS> <ID>",
+						Usage:     "Revoke given update token",
+						Action:    odc.tokenRevoke,
+					},
+				},
+			},
+			{
+				Name:  "admin",
+				Usage: "Manage the users (admin only)",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "ls",
+						Usage:  "List the users",
+						Action: odc.adminLs,
+					},
+					{
+						Name:      "create",
+						ArgsUsage: "<EMAIL>",
+						Usage:     "Create an user account",
+						Action:    odc.adminCreate,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "admin",
+								Usage: "Allow the user to use the admin commands",
+							},
+						},
+					},
+					{
+						Name:      "disable",
+						ArgsUsage: "<ID>",
+						Usage:     "Disable given user: he can no longer authenticate",
+						Action:    odc.adminDisable,
+					},
+					{
+						Name:      "enable",
+						ArgsUsage: "<ID>",
+						Usage:     "Enable given (disabled) user",
+						Action:    odc.adminEnable,
+					},
+					{
+						Name:      "reset-password",
+						ArgsUsage: "<ID>",
+						Usage:     "Replace the password of given user",
+						Action:    odc.adminResetPassword,
+					},
+					{
+						Name:      "rm",
+						ArgsUsage: "<ID>",
+						Usage:     "Delete given user together with his aliases",
+						Action:    odc.adminRm,
+					},
+				},
+			},
 			{
 				Name:    "synchronize",
 				Aliases: []string{"sync"},
@@ -95,6 +242,10 @@ func (odc *CLIApp) login(c *cli.Context) error {
 		return err
 	}
 
+	if c.Bool("oidc") {
+		return loginOIDC(app, logger)
+	}
+
 	if !c.Args().Present() {
 		err := fmt.Errorf("missing EMAIL")
 		logger.Err(err).Msg("missing EMAIL.")
@@ -108,19 +259,362 @@ func (odc *CLIApp) login(c *cli.Context) error {
 	password, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
 	// TODO clear screen after that
 
-	if _, err := app.Authenticate(proto.CredentialsDto{
+	token, err := app.Authenticate(proto.CredentialsDto{
 		Email:    c.Args().First(),
 		Password: string(password),
-	}); err != nil {
+	})
+	if err != nil {
 		logger.Err(err).Msg("error while authenticating.")
 		return err
 	}
 
+	if token.TwoFactorToken != "" {
+		fmt.Println()
+		if err := app.VerifyTwoFactor(token.TwoFactorToken, readTwoFactorCode()); err != nil {
+			logger.Err(err).Msg("error while authenticating.")
+			return err
+		}
+	}
+
 	logger.Info().Str("Email", c.Args().First()).Msg("successfully authenticated.")
 
 	return nil
 }
 
+// loginOIDC authenticate using the device authorization grant:
+// the user complete the login in a browser, possibly on another device
+func loginOIDC(app cli2.CLI, logger *zerolog.Logger) error {
+	auth, err := app.StartOIDCLogin()
+	if err != nil {
+		logger.Err(err).Msg("error while starting OpenID Connect login.")
+		return err
+	}
+
+	fmt.Printf("Open %s and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	if auth.VerificationURIComplete != "" {
+		fmt.Println("or scan this QR code:")
+		fmt.Println()
+		if err := printQRCode(os.Stdout, auth.VerificationURIComplete); err != nil {
+			logger.Err(err).Msg("error while generating QR code.")
+		}
+	}
+	fmt.Println()
+
+	token, err := app.CompleteOIDCLogin(auth)
+	if err != nil {
+		logger.Err(err).Msg("error while authenticating.")
+		return err
+	}
+
+	if token.TwoFactorToken != "" {
+		if err := app.VerifyTwoFactor(token.TwoFactorToken, readTwoFactorCode()); err != nil {
+			logger.Err(err).Msg("error while authenticating.")
+			return err
+		}
+	}
+
+	logger.Info().Msg("successfully authenticated.")
+
+	return nil
+}
+
+func (odc *CLIApp) logout(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if err := app.Logout(); err != nil {
+		logger.Err(err).Msg("error while logging out.")
+		return err
+	}
+
+	logger.Info().Msg("successfully logged out.")
+
+	return nil
+}
+
+func (odc *CLIApp) sessions(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := app.GetSessions()
+	if err != nil {
+		logger.Err(err).Msg("error while listing sessions.")
+		return err
+	}
+
+	for _, session := range sessions {
+		logger.Info().
+			Uint("ID", session.ID).
+			Str("UserAgent", session.UserAgent).
+			Str("IP", session.IP).
+			Time("CreatedAt", session.CreatedAt).
+			Time("LastUsedAt", session.LastUsedAt).
+			Bool("Current", session.Current).
+			Msg("")
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) sessionsRevoke(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ID")
+		logger.Err(err).Msg("missing ID.")
+		return err
+	}
+
+	id, err := strconv.ParseUint(c.Args().First(), 10, 64)
+	if err != nil {
+		logger.Err(err).Msg("invalid ID.")
+		return err
+	}
+
+	if err := app.RevokeSession(uint(id)); err != nil {
+		logger.Err(err).Uint64("ID", id).Msg("error while revoking session.")
+		return err
+	}
+
+	logger.Info().Uint64("ID", id).Msg("successfully revoked session.")
+	return nil
+}
+
+func (odc *CLIApp) signup(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing EMAIL")
+		logger.Err(err).Msg("missing EMAIL.")
+		return err
+	}
+
+	// Ask for user password
+	fmt.Printf("Password: ")
+	password, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+
+	if err := app.SignUp(proto.CredentialsDto{
+		Email:    c.Args().First(),
+		Password: string(password),
+	}); err != nil {
+		logger.Err(err).Msg("error while signing up.")
+		return err
+	}
+
+	logger.Info().Str("Email", c.Args().First()).Msg("check your emails to activate your account.")
+
+	return nil
+}
+
+func (odc *CLIApp) verify(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing TOKEN")
+		logger.Err(err).Msg("missing TOKEN.")
+		return err
+	}
+
+	if err := app.VerifyEmail(c.Args().First()); err != nil {
+		logger.Err(err).Msg("error while verifying email address.")
+		return err
+	}
+
+	logger.Info().Msg("email address verified: you can now login.")
+
+	return nil
+}
+
+func (odc *CLIApp) passwd(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Current password: ")
+	currentPassword, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+
+	newPassword, err := readNewPassword()
+	if err != nil {
+		logger.Err(err).Msg("error while reading password.")
+		return err
+	}
+
+	if err := app.ChangePassword(string(currentPassword), newPassword); err != nil {
+		logger.Err(err).Msg("error while changing password.")
+		return err
+	}
+
+	logger.Info().Msg("successfully changed password: the other sessions have been logged out.")
+
+	return nil
+}
+
+func (odc *CLIApp) passwdForgot(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing EMAIL")
+		logger.Err(err).Msg("missing EMAIL.")
+		return err
+	}
+
+	if err := app.RequestPasswordReset(c.Args().First()); err != nil {
+		logger.Err(err).Msg("error while requesting password reset.")
+		return err
+	}
+
+	logger.Info().Str("Email", c.Args().First()).Msg("check your emails to reset your password.")
+
+	return nil
+}
+
+func (odc *CLIApp) passwdReset(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing TOKEN")
+		logger.Err(err).Msg("missing TOKEN.")
+		return err
+	}
+
+	password, err := readNewPassword()
+	if err != nil {
+		logger.Err(err).Msg("error while reading password.")
+		return err
+	}
+
+	if err := app.CompletePasswordReset(c.Args().First(), password); err != nil {
+		logger.Err(err).Msg("error while resetting password.")
+		return err
+	}
+
+	logger.Info().Msg("successfully reset password: you can now login.")
+
+	return nil
+}
+
+func (odc *CLIApp) twoFactorEnable(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	setup, err := app.SetupTwoFactor()
+	if err != nil {
+		logger.Err(err).Msg("error while setting up two-factor authentication.")
+		return err
+	}
+
+	fmt.Println("Scan this QR code using your authenticator app:")
+	fmt.Println()
+	if err := printQRCode(os.Stdout, setup.URI); err != nil {
+		logger.Err(err).Msg("error while generating QR code.")
+	}
+	fmt.Println()
+	fmt.Printf("Or enter the secret manually: %s\n", setup.Secret)
+	fmt.Printf("URI: %s\n", setup.URI)
+	fmt.Println()
+
+	codes, err := app.EnableTwoFactor(readTwoFactorCode())
+	if err != nil {
+		logger.Err(err).Msg("error while enabling two-factor authentication.")
+		return err
+	}
+
+	logger.Info().Msg("two-factor authentication enabled. store the recovery codes safely, they won't be displayed again.")
+	printRecoveryCodes(codes)
+
+	return nil
+}
+
+func (odc *CLIApp) twoFactorDisable(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if err := app.DisableTwoFactor(readTwoFactorCode()); err != nil {
+		logger.Err(err).Msg("error while disabling two-factor authentication.")
+		return err
+	}
+
+	logger.Info().Msg("two-factor authentication disabled.")
+
+	return nil
+}
+
+func (odc *CLIApp) twoFactorRecoveryCodes(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	codes, err := app.RegenerateRecoveryCodes(readTwoFactorCode())
+	if err != nil {
+		logger.Err(err).Msg("error while replacing recovery codes.")
+		return err
+	}
+
+	logger.Info().Msg("recovery codes replaced. the previous ones can no longer be used.")
+	printRecoveryCodes(codes)
+
+	return nil
+}
+
+// readTwoFactorCode prompt for a TOTP code (or a recovery code)
+func readTwoFactorCode() string {
+	fmt.Printf("Two-factor code (or recovery code): ")
+
+	var code string
+	_, _ = fmt.Scanln(&code)
+
+	return strings.TrimSpace(code)
+}
+
+func printRecoveryCodes(codes []string) {
+	for _, code := range codes {
+		fmt.Println(code)
+	}
+}
+
+// readNewPassword prompt twice for the new password
+func readNewPassword() (string, error) {
+	fmt.Printf("New password: ")
+	password, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+
+	fmt.Printf("Confirm new password: ")
+	confirmation, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+
+	if string(password) != string(confirmation) {
+		return "", fmt.Errorf("passwords do not match")
+	}
+
+	return string(password), nil
+}
+
 func (odc *CLIApp) ls(c *cli.Context) error {
 	app, logger, err := getInstance(c)
 	if err != nil {
@@ -148,6 +642,7 @@ func (odc *CLIApp) lsAliases(c cli2.CLI, logger *zerolog.Logger) error {
 	for _, alias := range aliases {
 		logger.Info().
 			Str("Domain", alias.Domain).
+			Str("Type", alias.Type).
 			Str("Value", alias.Value).
 			Bool("Synchronize", alias.Synchronize).
 			Msg("")
@@ -188,7 +683,7 @@ func (odc *CLIApp) register(c *cli.Context) error {
 
 	name := c.Args().First()
 
-	ip, err := odc.getRemoteIP()
+	ips, err := app.GetRemoteIPs()
 	if err != nil {
 		logger.Err(err).Msg("error while getting remote IP.")
 		return err
@@ -196,7 +691,7 @@ func (odc *CLIApp) register(c *cli.Context) error {
 
 	alias, err := app.RegisterAlias(proto.AliasDto{
 		Domain: name,
-		Value:  ip,
+		Value:  ips[0],
 	})
 
 	if err != nil {
@@ -204,6 +699,14 @@ func (odc *CLIApp) register(c *cli.Context) error {
 		return err
 	}
 
+	// Publish the other IP family too (if any)
+	for _, ip := range ips[1:] {
+		if _, err := app.UpdateAlias(proto.AliasDto{Domain: name, Value: ip}); err != nil {
+			logger.Err(err).Str("Domain", name).Str("Value", ip).Msg("error while updating alias.")
+			return err
+		}
+	}
+
 	logger.Info().Str("Domain", alias.Domain).Msg("successfully registered alias.")
 	return nil
 }
@@ -307,23 +810,263 @@ func (odc *CLIApp) synchronize(c *cli.Context) error {
 		return err
 	}
 
-	ip, err := odc.getRemoteIP()
+	ips, err := app.GetRemoteIPs()
 	if err != nil {
 		logger.Err(err).Msg("error while getting remote IP.")
 		return err
 	}
 
-	return app.Synchronize(ip)
+	return app.Synchronize(ips)
+}
+
+func (odc *CLIApp) tokenCreate(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ALIAS")
+		logger.Err(err).Msg("missing ALIAS.")
+		return err
+	}
+
+	name := c.Args().First()
+
+	token, err := app.CreateUpdateToken(name)
+	if err != nil {
+		logger.Err(err).Str("Domain", name).Msg("error while creating update token.")
+		return err
+	}
+
+	logger.Info().
+		Str("Domain", name).
+		Uint("ID", token.ID).
+		Msg("successfully created update token. it won't be displayed again.")
+	fmt.Println(token.Token)
+
+	return nil
+}
+
+func (odc *CLIApp) tokenLs(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ALIAS")
+		logger.Err(err).Msg("missing ALIAS.")
+		return err
+	}
+
+	name := c.Args().First()
+
+	tokens, err := app.GetUpdateTokens(name)
+	if err != nil {
+		logger.Err(err).Str("Domain", name).Msg("error while listing update tokens.")
+		return err
+	}
+
+	if len(tokens) == 0 {
+		logger.Info().Msg("no update tokens found.")
+		return nil
+	}
+
+	for _, token := range tokens {
+		logger.Info().
+			Uint("ID", token.ID).
+			Str("Prefix", token.Prefix).
+			Time("CreatedAt", token.CreatedAt).
+			Msg("")
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) tokenRevoke(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Args().Len() != 2 {
+		err := fmt.Errorf("missing ALIAS ID")
+		logger.Err(err).Msg("missing ALIAS ID.")
+		return err
+	}
+
+	name := c.Args().First()
+
+	id, err := strconv.ParseUint(c.Args().Get(1), 10, 64)
+	if err != nil {
+		logger.Err(err).Msg("invalid ID.")
+		return err
+	}
+
+	if err := app.RevokeUpdateToken(name, uint(id)); err != nil {
+		logger.Err(err).Str("Domain", name).Uint64("ID", id).Msg("error while revoking update token.")
+		return err
+	}
+
+	logger.Info().Str("Domain", name).Uint64("ID", id).Msg("successfully revoked update token.")
+	return nil
+}
+
+func (odc *CLIApp) adminLs(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	users, err := app.GetUsers()
+	if err != nil {
+		logger.Err(err).Msg("error while listing users.")
+		return err
+	}
+
+	for _, user := range users {
+		logger.Info().
+			Uint("ID", user.ID).
+			Str("Email", user.Email).
+			Bool("Admin", user.Admin).
+			Bool("Disabled", user.Disabled).
+			Time("CreatedAt", user.CreatedAt).
+			Msg("")
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) adminCreate(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing EMAIL")
+		logger.Err(err).Msg("missing EMAIL.")
+		return err
+	}
+
+	email := c.Args().First()
+
+	fmt.Printf("Password: ")
+	password, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+
+	user, err := app.CreateUser(proto.CreateUserDto{
+		Email:    email,
+		Password: string(password),
+		Admin:    c.Bool("admin"),
+	})
+	if err != nil {
+		logger.Err(err).Str("Email", email).Msg("error while creating user.")
+		return err
+	}
+
+	logger.Info().
+		Uint("ID", user.ID).
+		Str("Email", user.Email).
+		Bool("Admin", user.Admin).
+		Msg("successfully created user.")
+	return nil
+}
+
+func (odc *CLIApp) adminDisable(c *cli.Context) error {
+	return odc.adminSetDisabled(c, true)
+}
+
+func (odc *CLIApp) adminEnable(c *cli.Context) error {
+	return odc.adminSetDisabled(c, false)
+}
+
+func (odc *CLIApp) adminSetDisabled(c *cli.Context, disabled bool) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := getUserID(c, logger)
+	if err != nil {
+		return err
+	}
+
+	if err := app.SetUserDisabled(id, disabled); err != nil {
+		logger.Err(err).Uint("ID", id).Msg("error while updating user.")
+		return err
+	}
+
+	m := logger.Info().Uint("ID", id)
+	if disabled {
+		m.Msg("successfully disabled user.")
+	} else {
+		m.Msg("successfully enabled user.")
+	}
+
+	return nil
+}
+
+func (odc *CLIApp) adminResetPassword(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := getUserID(c, logger)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("New password: ")
+	password, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+
+	if err := app.ResetPassword(id, string(password)); err != nil {
+		logger.Err(err).Uint("ID", id).Msg("error while resetting password.")
+		return err
+	}
+
+	logger.Info().Uint("ID", id).Msg("successfully reset password.")
+	return nil
+}
+
+func (odc *CLIApp) adminRm(c *cli.Context) error {
+	app, logger, err := getInstance(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := getUserID(c, logger)
+	if err != nil {
+		return err
+	}
+
+	if err := app.DeleteUser(id); err != nil {
+		logger.Err(err).Uint("ID", id).Msg("error while deleting user.")
+		return err
+	}
+
+	logger.Info().Uint("ID", id).Msg("successfully deleted user.")
+	return nil
 }
 
-func (odc *CLIApp) getRemoteIP() (string, error) {
-	c := resty.New()
-	r, err := c.R().Get("https://ifconfig.me/ip")
+// getUserID parse the user ID given as first argument
+func getUserID(c *cli.Context, logger *zerolog.Logger) (uint, error) {
+	if !c.Args().Present() {
+		err := fmt.Errorf("missing ID")
+		logger.Err(err).Msg("missing ID.")
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(c.Args().First(), 10, 64)
 	if err != nil {
-		return "", err
+		logger.Err(err).Msg("invalid ID.")
+		return 0, err
 	}
 
-	return r.String(), nil
+	return uint(id), nil
 }
 
 // TODO better?
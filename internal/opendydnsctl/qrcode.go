@@ -0,0 +1,46 @@
+package opendydnsctl
+
+import (
+	"io"
+	"rsc.io/qr"
+	"strings"
+)
+
+// qrQuietZone is the number of light modules around the QR code
+const qrQuietZone = 2
+
+// printQRCode print given text as a QR code using the Unicode half blocks
+// two rows of modules are printed per line, the light modules being drawn
+// (as expected by the scanners on a dark terminal)
+func printQRCode(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return err
+	}
+
+	// the modules outside of the code are light
+	light := func(x, y int) bool {
+		return !code.Black(x, y)
+	}
+
+	var sb strings.Builder
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
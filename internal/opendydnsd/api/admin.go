@@ -0,0 +1,133 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+// adminMiddleware guard the admin endpoints: only the admin users are allowed
+// it must be used after the authentication middleware
+func adminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !getUserContext(c).Admin {
+			return echo.ErrForbidden
+		}
+
+		return next(c)
+	}
+}
+
+func (a *API) reconcile(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		dryRun := false
+		if v := c.QueryParam("dry-run"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return c.NoContent(http.StatusBadRequest)
+			}
+			dryRun = b
+		}
+
+		report, err := d.Reconcile(dryRun)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+func (a *API) getUsers(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		users, err := d.GetUsers()
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, users)
+	}
+}
+
+func (a *API) createUser(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var user proto.CreateUserDto
+		if err := c.Bind(&user); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		created, err := d.CreateUser(proto.CredentialsDto{Email: user.Email, Password: user.Password}, user.Admin)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, created)
+	}
+}
+
+func (a *API) setUserDisabled(d daemon.Daemon, disabled bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := getTargetUserID(c)
+		if err != nil {
+			return err
+		}
+
+		if err := d.SetUserDisabled(userID, disabled); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+func (a *API) resetPassword(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			return proto.ErrUserNotFound
+		}
+
+		var password proto.PasswordDto
+		if err := c.Bind(&password); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		if err := d.ResetPassword(uint(userID), password.Password); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+func (a *API) deleteUser(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := getTargetUserID(c)
+		if err != nil {
+			return err
+		}
+
+		if err := d.DeleteUser(userID); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// getTargetUserID return the ID of the user targeted by the admin request
+// an admin cannot disable nor delete himself
+func getTargetUserID(c echo.Context) (uint, error) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, proto.ErrUserNotFound
+	}
+
+	if uint(userID) == getUserContext(c).UserID {
+		return 0, proto.ErrInvalidParameters
+	}
+
+	return uint(userID), nil
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI_Admin_NotAdmin(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, _ := newTestAPI(t, mockCtrl)
+
+	token, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := adminRequest(a, http.MethodPost, "/admin/reconcile", token.Token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+	if rec := adminRequest(a, http.MethodGet, "/admin/users", token.Token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_Admin_InvalidToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, _ := newTestAPI(t, mockCtrl)
+
+	if rec := adminRequest(a, http.MethodPost, "/admin/reconcile", "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+	if rec := adminRequest(a, http.MethodPost, "/admin/reconcile", "wrong", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_Admin_RoleRevoked(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().CheckUserContext(proto.UserContext{UserID: 1, Admin: true}, gomock.Any()).Return(proto.ErrInvalidSession)
+
+	if rec := adminRequest(a, http.MethodGet, "/admin/users", makeAdminToken(t), nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_Reconcile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+	token := makeAdminToken(t)
+
+	daemonMock.EXPECT().Reconcile(true).Return(proto.ReconcileReportDto{
+		DryRun:   true,
+		Missing:  []proto.RecordDto{{Name: "foo.bar.baz", Type: "A", Expected: "1.1.1.1"}},
+		Stale:    []proto.RecordDto{},
+		Orphaned: []proto.RecordDto{},
+	}, nil)
+
+	rec := adminRequest(a, http.MethodPost, "/admin/reconcile?dry-run=true", token, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"missing":[{"name":"foo.bar.baz","type":"A","expected":"1.1.1.1"}]`) {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := adminRequest(a, http.MethodPost, "/admin/reconcile?dry-run=maybe", token, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_GetUsers(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().GetUsers().Return([]proto.UserDto{{ID: 2, Email: "lunamicard@gmail.com", Disabled: true}}, nil)
+
+	rec := adminRequest(a, http.MethodGet, "/admin/users", makeAdminToken(t), nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"email":"lunamicard@gmail.com","admin":false,"disabled":true`) {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPI_CreateUser(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().
+		CreateUser(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "secret"}, true).
+		Return(proto.UserDto{ID: 2, Email: "lunamicard@gmail.com", Admin: true, CreatedAt: time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)}, nil)
+
+	body := strings.NewReader(`{"email":"lunamicard@gmail.com","password":"secret","admin":true}`)
+	rec := adminRequest(a, http.MethodPost, "/admin/users", makeAdminToken(t), body)
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"id":2,"email":"lunamicard@gmail.com","admin":true`) ||
+		!strings.Contains(rec.Body.String(), `"created_at":"2020-09-01T12:00:00Z"`) {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPI_SetUserDisabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+	token := makeAdminToken(t)
+
+	daemonMock.EXPECT().SetUserDisabled(uint(2), true).Return(nil)
+	daemonMock.EXPECT().SetUserDisabled(uint(2), false).Return(nil)
+
+	for target, code := range map[string]int{
+		"/admin/users/2/disable":   http.StatusOK,
+		"/admin/users/2/enable":    http.StatusOK,
+		"/admin/users/abc/disable": http.StatusNotFound,
+		"/admin/users/1/disable":   http.StatusBadRequest, // himself
+	} {
+		if rec := adminRequest(a, http.MethodPost, target, token, nil); rec.Code != code {
+			t.Errorf("%s: wrong status code: %d", target, rec.Code)
+		}
+	}
+}
+
+func TestAPI_ResetPassword(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().ResetPassword(uint(2), "secret").Return(nil)
+
+	rec := adminRequest(a, http.MethodPut, "/admin/users/2/password", makeAdminToken(t), strings.NewReader(`{"password":"secret"}`))
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_DeleteUser(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+	token := makeAdminToken(t)
+
+	daemonMock.EXPECT().DeleteUser(uint(2)).Return(nil)
+	daemonMock.EXPECT().DeleteUser(uint(3)).Return(proto.ErrUserNotFound)
+
+	for target, code := range map[string]int{
+		"/admin/users/2": http.StatusOK,
+		"/admin/users/3": http.StatusNotFound,
+		"/admin/users/1": http.StatusBadRequest, // himself
+	} {
+		if rec := adminRequest(a, http.MethodDelete, target, token, nil); rec.Code != code {
+			t.Errorf("%s: wrong status code: %d", target, rec.Code)
+		}
+	}
+}
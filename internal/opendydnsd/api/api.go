@@ -7,18 +7,36 @@ import (
 	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"golang.org/x/crypto/acme/autocert"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
+// readinessCacheTTL is the time the readiness of the dependencies is cached:
+// the probe must not hammer the database and the DNS providers
+const readinessCacheTTL = 5 * time.Second
+
 // API represent the Daemon REST API
 type API struct {
-	e      *echo.Echo
-	conf   config.APIConfig
-	logger *zerolog.Logger
+	e             *echo.Echo
+	metricsServer *http.Server
+	conf          config.APIConfig
+	logger        *zerolog.Logger
+
+	// brute-force protection & rate limits (nil if disabled)
+	authLimiter    *rateLimiter
+	accountLimiter *rateLimiter
+	aliasLimiter   *rateLimiter
+	lockout        *lockoutTracker
+
+	readinessLock      sync.Mutex
+	readinessStatus    proto.ReadinessDto
+	readinessCheckedAt time.Time
 }
 
 // NewAPI return a new API instance, wrapped around given Daemon instance
@@ -28,6 +46,18 @@ func NewAPI(d daemon.Daemon, conf config.APIConfig) (*API, error) {
 	e := echo.New()
 	e.Logger.SetOutput(ioutil.Discard)
 
+	// Resolve client IP using trusted proxies headers
+	trustedProxies, err := parseCIDRs(conf.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %s", err)
+	}
+	e.IPExtractor = newIPExtractor(trustedProxies)
+
+	// The JWT tokens are short-lived: they are renewed using the refresh tokens
+	if conf.TokenTTL == 0 {
+		conf.TokenTTL = defaultTokenTTL
+	}
+
 	// Determinate if should run HTTPS
 	if conf.SSLEnabled() {
 		e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(conf.Hostname)
@@ -36,25 +66,84 @@ func NewAPI(d daemon.Daemon, conf config.APIConfig) (*API, error) {
 
 	// Create the API
 	a := API{
-		e:      e,
-		conf:   conf,
-		logger: d.Logger(),
+		e:              e,
+		conf:           conf,
+		logger:         d.Logger(),
+		authLimiter:    newRateLimiter(conf.AuthRateLimit),
+		accountLimiter: newRateLimiter(conf.AccountRateLimit),
+		aliasLimiter:   newRateLimiter(conf.AliasRateLimit),
+		lockout:        newLockoutTracker(conf.Lockout),
 	}
 
 	// Register global middlewares
 	e.Use(newZeroLogMiddleware(d.Logger()))
 
 	// Register per-route middlewares
-	authMiddleware := getAuthMiddleware(a.conf.SigningKey)
+	authMiddleware := getAuthMiddleware(d, a.conf.SigningKey)
+	authRateLimit := rateLimitByIP(a.authLimiter)
+	aliasRateLimit := rateLimitByUser(a.aliasLimiter)
 
 	// Register endpoints
-	e.POST("/sessions", a.authenticate(d))
+	e.POST("/sessions", a.authenticate(d), authRateLimit)
+	e.POST("/sessions/2fa", a.verifyTwoFactor(d), authRateLimit)
+	e.GET("/sessions/oidc", a.getOIDCConfig(d))
+	e.POST("/sessions/oidc", a.authenticateOIDC(d), authRateLimit)
+	e.POST("/sessions/refresh", a.refreshSession(d), authRateLimit)
+	e.GET("/sessions", a.getSessions(d), authMiddleware)
+	e.DELETE("/sessions/:id", a.revokeSession(d), authMiddleware)
+	e.GET("/ip", a.getIP())
+
+	// Self-service signup (disabled unless configured)
+	e.POST("/users", a.signUp(d), authRateLimit)
+	e.GET("/users/verify", a.verifyEmail(d), authRateLimit)
+
+	// Password change & reset by email
+	e.PUT("/users/me/password", a.changePassword(d), authMiddleware)
+	e.POST("/users/password-reset", a.requestPasswordReset(d), authRateLimit)
+	e.PUT("/users/password-reset", a.completePasswordReset(d), authRateLimit)
+
+	// TOTP two-factor authentication
+	e.POST("/users/me/2fa", a.setupTwoFactor(d), authMiddleware)
+	e.PUT("/users/me/2fa", a.enableTwoFactor(d), authMiddleware)
+	e.POST("/users/me/2fa/disable", a.disableTwoFactor(d), authMiddleware)
+	e.POST("/users/me/2fa/recovery-codes", a.regenerateRecoveryCodes(d), authMiddleware)
+
 	e.GET("/aliases", a.getAliases(d), authMiddleware)
-	e.POST("/aliases", a.registerAlias(d), authMiddleware)
-	e.PUT("/aliases", a.updateAlias(d), authMiddleware)
-	e.DELETE("/aliases/:name", a.deleteAlias(d), authMiddleware)
+	e.POST("/aliases", a.registerAlias(d), authMiddleware, aliasRateLimit)
+	e.PUT("/aliases", a.updateAlias(d), authMiddleware, aliasRateLimit)
+	e.DELETE("/aliases/:name", a.deleteAlias(d), authMiddleware, aliasRateLimit)
+	e.POST("/aliases/:name/tokens", a.createUpdateToken(d), authMiddleware)
+	e.GET("/aliases/:name/tokens", a.getUpdateTokens(d), authMiddleware)
+	e.DELETE("/aliases/:name/tokens/:id", a.revokeUpdateToken(d), authMiddleware)
+
+	// Alias update authenticated using a per-alias update token
+	e.PUT("/aliases/:name", a.updateAliasWithToken(d), authRateLimit)
 	e.GET("/domains", a.getDomains(d), authMiddleware)
 
+	// DynDNS2 compatibility endpoint (authenticated using HTTP basic auth)
+	e.GET("/nic/update", a.dynDNSUpdate(d))
+
+	// Admin endpoints
+	admin := e.Group("/admin", authMiddleware, adminMiddleware)
+	admin.POST("/reconcile", a.reconcile(d))
+	admin.GET("/users", a.getUsers(d))
+	admin.POST("/users", a.createUser(d))
+	admin.POST("/users/:id/disable", a.setUserDisabled(d, true))
+	admin.POST("/users/:id/enable", a.setUserDisabled(d, false))
+	admin.PUT("/users/:id/password", a.resetPassword(d))
+	admin.DELETE("/users/:id", a.deleteUser(d))
+
+	// Health & readiness probes
+	e.GET("/healthz", a.health())
+	e.GET("/readyz", a.readiness(d))
+
+	// Prometheus metrics, served by the API unless a dedicated address is configured
+	if a.conf.MetricsListenAddr == "" {
+		e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	} else {
+		a.metricsServer = &http.Server{Addr: a.conf.MetricsListenAddr, Handler: promhttp.Handler()}
+	}
+
 	return &a, nil
 }
 
@@ -65,21 +154,55 @@ func (a *API) authenticate(d daemon.Daemon) echo.HandlerFunc {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
 
-		userCtx, err := d.Authenticate(cred)
+		userCtx, challenge, err := a.authenticatePassword(c, d, cred)
 		if err != nil {
 			return err
 		}
 
-		// Create the JWT token
-		token, err := makeToken(userCtx, a.conf.SigningKey, a.conf.TokenTTL)
-		if err != nil {
-			return c.NoContent(http.StatusInternalServerError)
+		// the session is only created once the second step is completed
+		if challenge != "" {
+			return c.JSON(http.StatusOK, proto.TokenDto{TwoFactorToken: challenge})
 		}
 
-		return c.JSON(http.StatusOK, token)
+		return a.newSession(c, d, userCtx)
 	}
 }
 
+// authenticatePassword validate the user credentials, enforcing the per-account
+// rate limit and lockout. Only the invalid credentials count as failure, and the
+// failures are only reset once the two-factor challenge, if any, is completed
+func (a *API) authenticatePassword(c echo.Context, d daemon.Daemon, cred proto.CredentialsDto) (proto.UserContext, string, error) {
+	key := accountKey(cred.Email)
+	if err := a.checkAccountLimits(c, key); err != nil {
+		return proto.UserContext{}, "", err
+	}
+
+	userCtx, challenge, err := d.Authenticate(cred)
+	switch {
+	case err == proto.ErrInvalidParameters && key != "":
+		a.lockout.failure(key)
+	case err == nil && challenge != "":
+		a.lockout.challenge(challenge, key)
+	case err == nil:
+		a.lockout.success(key)
+	}
+
+	return userCtx, challenge, err
+}
+
+// checkAccountLimits return ErrTooManyRequests if given account is locked out
+// or has been targeted by too many authentication attempts
+func (a *API) checkAccountLimits(c echo.Context, key string) error {
+	if retryAfter := a.lockout.locked(key); retryAfter > 0 {
+		return tooManyRequests(c, "lockout", retryAfter)
+	}
+	if ok, retryAfter := a.accountLimiter.allow(key); !ok {
+		return tooManyRequests(c, "account", retryAfter)
+	}
+
+	return nil
+}
+
 func (a *API) getAliases(d daemon.Daemon) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userCtx := getUserContext(c)
@@ -120,6 +243,11 @@ func (a *API) updateAlias(d daemon.Daemon) echo.HandlerFunc {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
 
+		// Use the request source address if no value provided
+		if alias.Value == "" {
+			alias.Value = c.RealIP()
+		}
+
 		alias, err := d.UpdateAlias(userCtx, alias)
 		if err != nil {
 			return err
@@ -156,8 +284,66 @@ func (a *API) getDomains(d daemon.Daemon) echo.HandlerFunc {
 	}
 }
 
+func (a *API) health() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, proto.StatusDto{Status: proto.StatusOK})
+	}
+}
+
+// readiness return the status of the dependencies, without the error details
+// (logged by the daemon) since the endpoint is not authenticated
+func (a *API) readiness(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		readiness := a.checkReadiness(d)
+
+		readiness.Database.Error = ""
+		domains := readiness.Domains
+		if domains != nil {
+			readiness.Domains = map[string]proto.StatusDto{}
+			for domain, status := range domains {
+				readiness.Domains[domain] = proto.StatusDto{Status: status.Status}
+			}
+		}
+
+		if !readiness.Ready {
+			return c.JSON(http.StatusServiceUnavailable, readiness)
+		}
+
+		return c.JSON(http.StatusOK, readiness)
+	}
+}
+
+// checkReadiness return the readiness of the dependencies, checked at most every readinessCacheTTL
+// the concurrent probes wait for the running check instead of starting another one
+func (a *API) checkReadiness(d daemon.Daemon) proto.ReadinessDto {
+	a.readinessLock.Lock()
+	defer a.readinessLock.Unlock()
+
+	if time.Since(a.readinessCheckedAt) >= readinessCacheTTL {
+		a.readinessStatus = d.CheckReadiness(a.conf.ReadinessCheckProvisioners)
+		a.readinessCheckedAt = time.Now()
+	}
+
+	return a.readinessStatus
+}
+
+func (a *API) getIP() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, proto.IPDto{IP: c.RealIP()})
+	}
+}
+
 // Start the API server
 func (a *API) Start(address string) error {
+	if a.metricsServer != nil {
+		go func() {
+			if err := a.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				a.logger.Err(err).Msg("metrics server stopped.")
+			}
+		}()
+		a.logger.Info().Str("Addr", a.metricsServer.Addr).Msg("metrics server started.")
+	}
+
 	// determinate if should run HTTPS
 	if a.conf.SSLEnabled() {
 		a.logger.Debug().Msg("SSL support enabled.")
@@ -176,6 +362,13 @@ func (a *API) Start(address string) error {
 // Shutdown terminate the API server cleanly
 func (a *API) Shutdown(ctx context.Context) error {
 	a.logger.Debug().Msg("shutting down API.")
+
+	if a.metricsServer != nil {
+		if err := a.metricsServer.Shutdown(ctx); err != nil {
+			a.logger.Err(err).Msg("error while shutting down the metrics server.")
+		}
+	}
+
 	return a.e.Shutdown(ctx)
 }
 
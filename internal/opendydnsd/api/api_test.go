@@ -1 +1,202 @@
 package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAPI_InvalidTrustedProxy(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	if _, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test", TrustedProxies: []string{"foo"}}); err == nil {
+		t.Error("NewAPI() should have failed")
+	}
+}
+
+func TestAPI_GetIP(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, _ := newTestAPI(t, mockCtrl)
+
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = "8.8.4.4:1234"
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"ip":"8.8.4.4"}` {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPI_UpdateAlias_DefaultValue(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	token, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().
+		UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.4.4"}).
+		Return(proto.AliasDto{Domain: "foo.bar.baz", Type: proto.RecordTypeA, Value: "8.8.4.4"}, nil)
+
+	req := httptest.NewRequest(http.MethodPut, "/aliases", strings.NewReader(`{"domain":"foo.bar.baz"}`))
+	req.RemoteAddr = "8.8.4.4:1234"
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_Metrics(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, _ := newTestAPI(t, mockCtrl)
+
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	a.e.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	for _, metric := range []string{
+		`opendydnsd_http_requests_total{method="GET",route="/ip",status="200"}`,
+		`opendydnsd_http_request_duration_seconds_count{method="GET",route="/ip"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), metric) {
+			t.Errorf("missing metric %s", metric)
+		}
+	}
+}
+
+func TestAPI_Metrics_DedicatedAddress(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test", MetricsListenAddr: "127.0.0.1:9090"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not served by the API
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+	if a.metricsServer == nil || a.metricsServer.Addr != "127.0.0.1:9090" {
+		t.Error("metrics server not configured")
+	}
+}
+
+func TestAPI_Health(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, _ := newTestAPI(t, mockCtrl)
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"status":"ok"}` {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPI_Readiness(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().CheckReadiness(false).Return(proto.ReadinessDto{
+		Ready:    true,
+		Database: proto.StatusDto{Status: proto.StatusOK},
+	})
+	daemonMock.EXPECT().CheckReadiness(false).Return(proto.ReadinessDto{
+		Ready:    false,
+		Database: proto.StatusDto{Status: proto.StatusError, Error: "database is closed"},
+	})
+
+	for _, code := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		rec := httptest.NewRecorder()
+		a.e.ServeHTTP(rec, req)
+
+		if rec.Code != code || !strings.Contains(rec.Body.String(), `"database":{"status"`) ||
+			strings.Contains(rec.Body.String(), "database is closed") {
+			t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+		}
+
+		a.readinessCheckedAt = time.Time{} // expire the cache
+	}
+}
+
+func TestAPI_Readiness_Cache(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+	a.conf.ReadinessCheckProvisioners = true
+
+	// the dependencies are checked once
+	daemonMock.EXPECT().CheckReadiness(true).Return(proto.ReadinessDto{
+		Ready:    false,
+		Database: proto.StatusDto{Status: proto.StatusOK},
+		Domains: map[string]proto.StatusDto{
+			"bar.baz": {Status: proto.StatusError, Error: "ovh API error: invalid application key"},
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		rec := httptest.NewRecorder()
+		a.e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusServiceUnavailable ||
+			!strings.Contains(rec.Body.String(), `"bar.baz":{"status":"error"}`) {
+			t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// the cached readiness is not altered
+	if a.readinessStatus.Domains["bar.baz"].Error == "" {
+		t.Error("cached readiness should not have been altered")
+	}
+}
@@ -1,18 +1,34 @@
 package api
 
 import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"strconv"
 	"time"
 )
 
+const defaultTokenTTL = 15 * time.Minute
+
 // getAuthMiddleware instantiate a authentication middleware
-func getAuthMiddleware(signingKey string) echo.MiddlewareFunc {
-	return middleware.JWTWithConfig(middleware.JWTConfig{
+// once the JWT token validated, the daemon make sure the user
+// is still allowed to use it (not deleted, disabled nor issued before a password change)
+func getAuthMiddleware(d daemon.Daemon, signingKey string) echo.MiddlewareFunc {
+	jwtMiddleware := middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey: []byte(signingKey),
 	})
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return jwtMiddleware(func(c echo.Context) error {
+			if err := d.CheckUserContext(getUserContext(c), getIssuedAt(c)); err != nil {
+				return err
+			}
+
+			return next(c)
+		})
+	}
 }
 
 // getUserContext extract the user context from current request
@@ -20,11 +36,33 @@ func getUserContext(c echo.Context) proto.UserContext {
 	user := c.Get("user").(*jwt.Token)
 	claims := user.Claims.(jwt.MapClaims)
 
+	admin, _ := claims["admin"].(bool)
+
+	// the tokens issued before the sessions have no jti
+	jti, _ := claims["jti"].(string)
+	sessionID, _ := strconv.ParseUint(jti, 10, 64)
+
 	return proto.UserContext{
-		UserID: uint(claims["userID"].(float64)),
+		UserID:    uint(claims["userID"].(float64)),
+		Admin:     admin,
+		SessionID: uint(sessionID),
 	}
 }
 
+// getIssuedAt return the issue date of the JWT token of current request
+// the zero time is returned for the tokens without iat claim
+func getIssuedAt(c echo.Context) time.Time {
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(iat), 0)
+}
+
 // makeToken create & signed a new JWT token
 func makeToken(userCtx proto.UserContext, secretKey string, tokenTTL time.Duration) (proto.TokenDto, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
@@ -32,9 +70,14 @@ func makeToken(userCtx proto.UserContext, secretKey string, tokenTTL time.Durati
 	// Set claims
 	claims := token.Claims.(jwt.MapClaims)
 	claims["userID"] = userCtx.UserID
+	claims["admin"] = userCtx.Admin
+	claims["jti"] = strconv.FormatUint(uint64(userCtx.SessionID), 10)
+	claims["iat"] = time.Now().Unix()
 
+	var expiresAt time.Time
 	if tokenTTL != 0 {
-		claims["exp"] = time.Now().Add(tokenTTL).Unix()
+		expiresAt = time.Now().Add(tokenTTL)
+		claims["exp"] = expiresAt.Unix()
 	}
 
 	// Generate encoded token and send it as response.
@@ -44,6 +87,7 @@ func makeToken(userCtx proto.UserContext, secretKey string, tokenTTL time.Durati
 	}
 
 	return proto.TokenDto{
-		Token: t,
+		Token:     t,
+		ExpiresAt: expiresAt,
 	}, nil
 }
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DynDNS2 protocol return codes
+// see https://help.dyn.com/remote-access-api/return-codes/
+const (
+	dynDNSGood    = "good"
+	dynDNSNoChg   = "nochg"
+	dynDNSBadAuth = "badauth"
+	dynDNSNoHost  = "nohost"
+	dynDNSNotFQDN = "notfqdn"
+	dynDNSNumHost = "numhost"
+	dynDNSBadIP   = "badip" // not part of the original protocol
+	dynDNSAbuse   = "abuse"
+	dynDNSServErr = "911"
+)
+
+// dynDNSMaxHosts is the maximum number of hostnames per update request
+const dynDNSMaxHosts = 20
+
+// dynDNSUpdate serve the DynDNS2 compatible update endpoint
+// GET /nic/update?hostname=<ALIAS>[,<ALIAS>]&myip=<IP>[,<IP>]
+// the credentials are provided using HTTP basic auth. The password may be
+// an update token, in which case only the alias bound to the token can be updated
+func (a *API) dynDNSUpdate(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// the DynDNS2 clients expect a plain text answer: the rate limits are not enforced using a middleware
+		if ok, retryAfter := a.authLimiter.allow(addressKey(c.RealIP())); !ok {
+			_ = tooManyRequests(c, "ip", retryAfter)
+			return c.String(http.StatusTooManyRequests, dynDNSAbuse)
+		}
+
+		email, password, ok := c.Request().BasicAuth()
+		if !ok {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="OpenDyDNS"`)
+			return c.String(http.StatusUnauthorized, dynDNSBadAuth)
+		}
+
+		hostnames := splitList(c.QueryParam("hostname"))
+
+		userCtx, err := a.dynDNSAuthenticate(c, d, email, password, hostnames)
+		if err == proto.ErrTooManyRequests {
+			return c.String(http.StatusTooManyRequests, dynDNSAbuse)
+		}
+		if err != nil {
+			return c.String(http.StatusUnauthorized, dynDNSBadAuth)
+		}
+
+		if len(hostnames) == 0 {
+			return c.String(http.StatusOK, dynDNSNotFQDN)
+		}
+		if len(hostnames) > dynDNSMaxHosts {
+			return c.String(http.StatusOK, dynDNSNumHost)
+		}
+
+		// Determinate the IP(s) to use: fallback to the request source address
+		ips := append(splitList(c.QueryParam("myip")), splitList(c.QueryParam("myipv6"))...)
+		if len(ips) == 0 {
+			ips = []string{c.RealIP()}
+		}
+
+		aliases, err := d.GetAliases(userCtx)
+		if err != nil {
+			return c.String(http.StatusOK, dynDNSServErr)
+		}
+
+		var results []string
+		for _, hostname := range hostnames {
+			results = append(results, a.dynDNSUpdateHost(d, userCtx, aliases, hostname, ips))
+		}
+
+		return c.String(http.StatusOK, strings.Join(results, "\n"))
+	}
+}
+
+// dynDNSAuthenticate authenticate the request using either the user credentials
+// or an update token valid for every requested hostname
+func (a *API) dynDNSAuthenticate(c echo.Context, d daemon.Daemon, email, password string,
+	hostnames []string) (proto.UserContext, error) {
+	if !daemon.IsUpdateToken(password) {
+		return a.dynDNSAuthenticatePassword(c, d, proto.CredentialsDto{Email: email, Password: password})
+	}
+
+	if len(hostnames) == 0 {
+		return proto.UserContext{}, proto.ErrInvalidUpdateToken
+	}
+
+	var userCtx proto.UserContext
+	for _, hostname := range hostnames {
+		ctx, err := d.AuthenticateUpdateToken(password, hostname)
+		if err != nil {
+			return proto.UserContext{}, err
+		}
+		userCtx = ctx
+	}
+
+	return userCtx, nil
+}
+
+// dynDNSAuthenticatePassword authenticate the request using the user credentials
+// no two-factor challenge is started: the password alone is not enough if the user enabled
+// the two-factor authentication, the update tokens must be used instead
+func (a *API) dynDNSAuthenticatePassword(c echo.Context, d daemon.Daemon, cred proto.CredentialsDto) (proto.UserContext, error) {
+	key := accountKey(cred.Email)
+	if err := a.checkAccountLimits(c, key); err != nil {
+		return proto.UserContext{}, err
+	}
+
+	// a valid password rejected because of the two-factor authentication does not reset the failures
+	userCtx, err := d.AuthenticatePassword(cred)
+	switch {
+	case err == proto.ErrInvalidParameters && key != "":
+		a.lockout.failure(key)
+	case err == nil:
+		a.lockout.success(key)
+	}
+
+	return userCtx, err
+}
+
+// dynDNSUpdateHost update given hostname and return the corresponding DynDNS2 result line
+func (a *API) dynDNSUpdateHost(d daemon.Daemon, userCtx proto.UserContext, aliases []proto.AliasDto,
+	hostname string, ips []string) string {
+	if !hasAlias(aliases, hostname) {
+		return dynDNSNoHost
+	}
+
+	changed := false
+	for _, ip := range ips {
+		if hasAliasValue(aliases, hostname, ip) {
+			continue
+		}
+
+		if ok, _ := a.aliasLimiter.allow(userKey(userCtx)); !ok {
+			metrics.ThrottledRequests.WithLabelValues("alias").Inc()
+			return dynDNSAbuse
+		}
+
+		if _, err := d.UpdateAlias(userCtx, proto.AliasDto{Domain: hostname, Value: ip}); err != nil {
+			a.logger.Warn().Err(err).Str("Domain", hostname).Str("Value", ip).Msg("dyndns update failed.")
+			return getDynDNSCode(err)
+		}
+		changed = true
+	}
+
+	if !changed {
+		return dynDNSNoChg + " " + strings.Join(ips, ",")
+	}
+
+	return dynDNSGood + " " + strings.Join(ips, ",")
+}
+
+// getDynDNSCode map given daemon error into DynDNS2 return code
+func getDynDNSCode(err error) string {
+	switch err {
+	case proto.ErrAliasNotFound, proto.ErrDomainNotFound:
+		return dynDNSNoHost
+	case proto.ErrInvalidParameters:
+		return dynDNSNotFQDN
+	case proto.ErrInvalidAddress, proto.ErrAddressNotAllowed:
+		return dynDNSBadIP
+	default:
+		return dynDNSServErr
+	}
+}
+
+func hasAlias(aliases []proto.AliasDto, name string) bool {
+	for _, alias := range aliases {
+		if alias.Domain == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAliasValue(aliases []proto.AliasDto, name, value string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+
+	for _, alias := range aliases {
+		if alias.Domain == name && alias.Value == ip.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func dynDNSRequest(a *API, query string, auth bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/nic/update?"+query, nil)
+	req.RemoteAddr = "8.8.4.4:1234"
+	if auth {
+		req.SetBasicAuth("lunamicard@gmail.com", "test")
+	}
+
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDynDNSUpdate_MissingAuth(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, _ := newTestAPI(t, mockCtrl)
+
+	rec := dynDNSRequest(a, "hostname=foo.bar.baz&myip=8.8.8.8", false)
+	if rec.Code != http.StatusUnauthorized || rec.Body.String() != "badauth" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("missing WWW-Authenticate header")
+	}
+}
+
+func TestDynDNSUpdate_BadAuth(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().
+		AuthenticatePassword(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}).
+		Return(proto.UserContext{}, proto.ErrInvalidParameters)
+
+	rec := dynDNSRequest(a, "hostname=foo.bar.baz&myip=8.8.8.8", true)
+	if rec.Body.String() != "badauth" {
+		t.Errorf("wrong response: %s", rec.Body.String())
+	}
+}
+
+func TestDynDNSUpdate_TwoFactorEnabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	// the password alone is not enough, and no two-factor challenge is started
+	daemonMock.EXPECT().
+		AuthenticatePassword(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}).
+		Return(proto.UserContext{}, proto.ErrTwoFactorRequired)
+
+	rec := dynDNSRequest(a, "hostname=foo.bar.baz&myip=8.8.8.8", true)
+	if rec.Code != http.StatusUnauthorized || rec.Body.String() != "badauth" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDynDNSUpdate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	userCtx := proto.UserContext{UserID: 1}
+	daemonMock.EXPECT().
+		AuthenticatePassword(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}).
+		Return(userCtx, nil)
+	daemonMock.EXPECT().GetAliases(userCtx).Return([]proto.AliasDto{
+		{Domain: "foo.bar.baz", Type: proto.RecordTypeA, Value: "1.1.1.1"},
+		{Domain: "nochg.bar.baz", Type: proto.RecordTypeA, Value: "8.8.8.8"},
+		{Domain: "bad.bar.baz", Type: proto.RecordTypeA, Value: "1.1.1.1"},
+	}, nil)
+	daemonMock.EXPECT().
+		UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"}).
+		Return(proto.AliasDto{Domain: "foo.bar.baz", Type: proto.RecordTypeA, Value: "8.8.8.8"}, nil)
+	daemonMock.EXPECT().
+		UpdateAlias(userCtx, proto.AliasDto{Domain: "bad.bar.baz", Value: "8.8.8.8"}).
+		Return(proto.AliasDto{}, proto.ErrAddressNotAllowed)
+
+	rec := dynDNSRequest(a, "hostname=foo.bar.baz,nochg.bar.baz,unknown.bar.baz,bad.bar.baz&myip=8.8.8.8", true)
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	expected := "good 8.8.8.8\nnochg 8.8.8.8\nnohost\nbadip"
+	if rec.Body.String() != expected {
+		t.Errorf("wrong response: %s", rec.Body.String())
+	}
+}
+
+func TestDynDNSUpdate_DefaultIP(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	userCtx := proto.UserContext{UserID: 1}
+	daemonMock.EXPECT().AuthenticatePassword(gomock.Any()).Return(userCtx, nil)
+	daemonMock.EXPECT().GetAliases(userCtx).Return([]proto.AliasDto{
+		{Domain: "foo.bar.baz", Type: proto.RecordTypeA, Value: "1.1.1.1"},
+	}, nil)
+	daemonMock.EXPECT().
+		UpdateAlias(userCtx, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.4.4"}).
+		Return(proto.AliasDto{Domain: "foo.bar.baz", Type: proto.RecordTypeA, Value: "8.8.4.4"}, nil)
+
+	rec := dynDNSRequest(a, "hostname=foo.bar.baz", true)
+	if rec.Body.String() != "good 8.8.4.4" {
+		t.Errorf("wrong response: %s", rec.Body.String())
+	}
+}
+
+func TestGetDynDNSCode(t *testing.T) {
+	if getDynDNSCode(proto.ErrAliasNotFound) != dynDNSNoHost {
+		t.Error()
+	}
+	if getDynDNSCode(proto.ErrInvalidParameters) != dynDNSNotFQDN {
+		t.Error()
+	}
+	if getDynDNSCode(proto.ErrInvalidAddress) != dynDNSBadIP {
+		t.Error()
+	}
+	if getDynDNSCode(http.ErrHandlerTimeout) != dynDNSServErr {
+		t.Error()
+	}
+}
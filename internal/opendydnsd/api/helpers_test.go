@@ -0,0 +1,71 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestAPI return an API backed by a mocked daemon which accept every user context
+func newTestAPI(t *testing.T, mockCtrl *gomock.Controller) (*API, *daemon_mock.MockDaemon) {
+	return newTestAPIWithConfig(t, mockCtrl, config.APIConfig{})
+}
+
+// newTestAPIWithConfig is newTestAPI using given configuration
+func newTestAPIWithConfig(t *testing.T, mockCtrl *gomock.Controller, conf config.APIConfig) (*API, *daemon_mock.MockDaemon) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+	daemonMock.EXPECT().CheckUserContext(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	conf.ListenAddr = "127.0.0.1:8888"
+	conf.SigningKey = "test"
+
+	a, err := NewAPI(daemonMock, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a, daemonMock
+}
+
+// adminRequest send a JSON request authenticated using given JWT token (if any)
+func adminRequest(a *API, method, target, token string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// adminRequestWithUserAgent send an unauthenticated JSON request using given user agent
+func adminRequestWithUserAgent(a *API, method, target string, body io.Reader, userAgent string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// makeAdminToken return the JWT token of an admin user
+func makeAdminToken(t *testing.T) string {
+	token, err := makeToken(proto.UserContext{UserID: 1, Admin: true}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Token
+}
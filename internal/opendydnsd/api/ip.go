@@ -0,0 +1,107 @@
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// newIPExtractor return an echo.IPExtractor resolving the client IP address
+// the Forwarded / X-Forwarded-For headers are only honored when the request
+// comes from one of the trusted proxies
+func newIPExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	isTrusted := func(ip net.IP) bool {
+		for _, n := range trustedProxies {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(req *http.Request) string {
+		remoteIP := parseHostIP(req.RemoteAddr)
+		if remoteIP == nil {
+			return req.RemoteAddr
+		}
+
+		if !isTrusted(remoteIP) {
+			return remoteIP.String()
+		}
+
+		// Walk the proxy chain from right to left: the first untrusted hop is the client
+		chain := getForwardedChain(req)
+		clientIP := remoteIP
+		for i := len(chain) - 1; i >= 0; i-- {
+			ip := parseHostIP(chain[i])
+			if ip == nil {
+				break
+			}
+
+			clientIP = ip
+			if !isTrusted(ip) {
+				break
+			}
+		}
+
+		return clientIP.String()
+	}
+}
+
+// getForwardedChain return the list of forwarded addresses (client first)
+// using Forwarded header (RFC 7239) when available, X-Forwarded-For otherwise
+func getForwardedChain(req *http.Request) []string {
+	var chain []string
+
+	if forwarded := req.Header.Values("Forwarded"); len(forwarded) > 0 {
+		for _, element := range strings.Split(strings.Join(forwarded, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(parts) == 2 && strings.EqualFold(parts[0], "for") {
+					chain = append(chain, strings.Trim(parts[1], `"`))
+				}
+			}
+		}
+		return chain
+	}
+
+	for _, xff := range req.Header.Values(echo.HeaderXForwardedFor) {
+		for _, addr := range strings.Split(xff, ",") {
+			chain = append(chain, strings.TrimSpace(addr))
+		}
+	}
+
+	return chain
+}
+
+// parseHostIP parse an address of the form ip, ip:port, [ipv6] or [ipv6]:port
+func parseHostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+// parseCIDRs parse given list of CIDR (single IP are accepted too)
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewIPExtractor(t *testing.T) {
+	trustedProxies, err := parseCIDRs([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractor := newIPExtractor(trustedProxies)
+
+	tests := []struct {
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		// direct connection
+		{"8.8.8.8:1234", nil, "8.8.8.8"},
+		// untrusted source: headers are ignored
+		{"8.8.8.8:1234", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "8.8.8.8"},
+		{"8.8.8.8:1234", map[string]string{"Forwarded": "for=1.1.1.1"}, "8.8.8.8"},
+		// trusted proxy
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "1.1.1.1"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "9.9.9.9, 1.1.1.1, 10.0.0.2"}, "1.1.1.1"},
+		{"[2001:db8::1]:1234", map[string]string{"X-Forwarded-For": "2001:4860:4860::8888"}, "2001:4860:4860::8888"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:4860:4860::8888]:4711";proto=https`}, "2001:4860:4860::8888"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=9.9.9.9, for=1.1.1.1;by=10.0.0.1", "X-Forwarded-For": "8.8.4.4"}, "1.1.1.1"},
+		// trusted proxy without headers
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		// obfuscated identifier
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = test.remoteAddr
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+
+		if ip := extractor(req); ip != test.expected {
+			t.Errorf("wrong IP for %s %v: %s (expected %s)", test.remoteAddr, test.headers, ip, test.expected)
+		}
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs([]string{"10.0.0.0/8", "127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nets) != 3 {
+		t.Fatal("wrong number of networks returned")
+	}
+	if nets[1].String() != "127.0.0.1/32" || nets[2].String() != "::1/128" {
+		t.Error("single IP should be parsed as host network")
+	}
+
+	if _, err := parseCIDRs([]string{"10.0.0.0/42"}); err == nil {
+		t.Error("parseCIDRs() should have failed")
+	}
+}
@@ -1,17 +1,31 @@
 package api
 
 import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog"
+	"strconv"
+	"time"
 )
 
 func newZeroLogMiddleware(logger *zerolog.Logger) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			start := time.Now()
+
 			if err := next(c); err != nil {
 				c.Error(err)
 			}
 
+			// c.Path() is the route (i.e /aliases/:name) which keep the labels cardinality low
+			route := c.Path()
+			if route == "" {
+				route = "unknown"
+			}
+			method := c.Request().Method
+			metrics.HTTPRequests.WithLabelValues(method, route, strconv.Itoa(c.Response().Status)).Inc()
+			metrics.HTTPRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+
 			logger.Debug().
 				Str("RemoteAddr", c.RealIP()).
 				Int("Status", c.Response().Status).
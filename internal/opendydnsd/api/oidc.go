@@ -0,0 +1,40 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+func (a *API) getOIDCConfig(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		conf, err := d.GetOIDCConfig()
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, conf)
+	}
+}
+
+func (a *API) authenticateOIDC(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var token proto.OIDCTokenDto
+		if err := c.Bind(&token); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		userCtx, challenge, err := d.AuthenticateOIDC(token.IDToken)
+		if err != nil {
+			return err
+		}
+
+		// the session is only created once the second step is completed
+		if challenge != "" {
+			return c.JSON(http.StatusOK, proto.TokenDto{TwoFactorToken: challenge})
+		}
+
+		return a.newSession(c, d, userCtx)
+	}
+}
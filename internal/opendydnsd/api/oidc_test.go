@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPI_GetOIDCConfig(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().GetOIDCConfig().Return(proto.OIDCConfigDto{
+		Issuer:   "https://accounts.example.org",
+		ClientID: "opendydnsctl",
+		Scopes:   []string{"openid", "email"},
+	}, nil)
+
+	rec := adminRequest(a, http.MethodGet, "/sessions/oidc", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var conf proto.OIDCConfigDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Issuer != "https://accounts.example.org" || conf.ClientID != "opendydnsctl" || len(conf.Scopes) != 2 {
+		t.Errorf("wrong config returned: %+v", conf)
+	}
+
+	daemonMock.EXPECT().GetOIDCConfig().Return(proto.OIDCConfigDto{}, proto.ErrOIDCDisabled)
+	if rec := adminRequest(a, http.MethodGet, "/sessions/oidc", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_AuthenticateOIDC(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().AuthenticateOIDC("id-token").Return(proto.UserContext{UserID: 1}, "", nil)
+	daemonMock.EXPECT().CreateSession(proto.UserContext{UserID: 1}, "opendydnsctl", "192.0.2.1").
+		Return(proto.UserContext{UserID: 1, SessionID: 4}, "odr_test", nil)
+
+	req := strings.NewReader(`{"id_token":"id-token"}`)
+	rec := adminRequestWithUserAgent(a, http.MethodPost, "/sessions/oidc", req, "opendydnsctl")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var token proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	if token.Token == "" || token.RefreshToken != "odr_test" {
+		t.Errorf("wrong token returned: %+v", token)
+	}
+
+	daemonMock.EXPECT().AuthenticateOIDC("invalid").Return(proto.UserContext{}, "", proto.ErrInvalidIDToken)
+	req = strings.NewReader(`{"id_token":"invalid"}`)
+	if rec := adminRequest(a, http.MethodPost, "/sessions/oidc", "", req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_AuthenticateOIDC_TwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	// no session is created until the second step
+	daemonMock.EXPECT().AuthenticateOIDC("id-token").Return(proto.UserContext{}, "challenge", nil)
+
+	rec := adminRequest(a, http.MethodPost, "/sessions/oidc", "", strings.NewReader(`{"id_token":"id-token"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var token proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "" || token.RefreshToken != "" || token.TwoFactorToken != "challenge" {
+		t.Errorf("wrong token returned: %+v", token)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+func (a *API) changePassword(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		var password proto.ChangePasswordDto
+		if err := c.Bind(&password); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		if err := d.ChangePassword(userCtx, password); err != nil {
+			return err
+		}
+
+		// the sessions of the user have been revoked
+		return a.newSession(c, d, userCtx)
+	}
+}
+
+func (a *API) requestPasswordReset(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request proto.PasswordResetRequestDto
+		if err := c.Bind(&request); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		if err := d.RequestPasswordReset(request.Email); err != nil {
+			return err
+		}
+
+		// the same response is returned whether the account exist or not
+		return c.NoContent(http.StatusAccepted)
+	}
+}
+
+func (a *API) completePasswordReset(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var reset proto.PasswordResetDto
+		if err := c.Bind(&reset); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		if err := d.CompletePasswordReset(reset); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
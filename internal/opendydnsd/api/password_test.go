@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI_ChangePassword(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	token, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().
+		ChangePassword(proto.UserContext{UserID: 1}, proto.ChangePasswordDto{CurrentPassword: "wrong", NewPassword: "new"}).
+		Return(proto.ErrInvalidPassword)
+	daemonMock.EXPECT().
+		ChangePassword(proto.UserContext{UserID: 1}, proto.ChangePasswordDto{CurrentPassword: "current", NewPassword: "new"}).
+		Return(nil)
+	daemonMock.EXPECT().CreateSession(proto.UserContext{UserID: 1}, gomock.Any(), gomock.Any()).
+		Return(proto.UserContext{UserID: 1, SessionID: 4}, "odr_test", nil)
+
+	rec := adminRequest(a, http.MethodPut, "/users/me/password", token.Token,
+		strings.NewReader(`{"current_password":"wrong","new_password":"new"}`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	// a new token is issued
+	rec = adminRequest(a, http.MethodPut, "/users/me/password", token.Token,
+		strings.NewReader(`{"current_password":"current","new_password":"new"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var newToken proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &newToken); err != nil || newToken.Token == "" || newToken.RefreshToken != "odr_test" {
+		t.Errorf("wrong token returned: %s %v", rec.Body.String(), err)
+	}
+}
+
+func TestAPI_ChangePassword_SessionInvalidated(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	daemonMock := daemon_mock.NewMockDaemon(mockCtrl)
+	daemonMock.EXPECT().Logger().Return(&logger).AnyTimes()
+
+	a, err := NewAPI(daemonMock, config.APIConfig{SigningKey: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the token has been issued before the password change
+	daemonMock.EXPECT().CheckUserContext(proto.UserContext{UserID: 1}, gomock.Any()).
+		DoAndReturn(func(userCtx proto.UserContext, issuedAt time.Time) error {
+			if time.Since(issuedAt) > time.Minute {
+				t.Errorf("wrong issue date: %s", issuedAt)
+			}
+			return proto.ErrInvalidSession
+		})
+
+	rec := adminRequest(a, http.MethodPut, "/users/me/password", token.Token,
+		strings.NewReader(`{"current_password":"current","new_password":"new"}`))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_RequestPasswordReset(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().RequestPasswordReset("luna@example.org").Return(nil)
+
+	rec := adminRequest(a, http.MethodPost, "/users/password-reset", "", strings.NewReader(`{"email":"luna@example.org"}`))
+	if rec.Code != http.StatusAccepted || rec.Body.Len() != 0 {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPI_CompletePasswordReset(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().CompletePasswordReset(proto.PasswordResetDto{Token: "good", Password: "new"}).Return(nil)
+	daemonMock.EXPECT().CompletePasswordReset(proto.PasswordResetDto{Token: "used", Password: "new"}).
+		Return(proto.ErrInvalidResetToken)
+
+	rec := adminRequest(a, http.MethodPut, "/users/password-reset", "", strings.NewReader(`{"token":"good","password":"new"}`))
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	rec = adminRequest(a, http.MethodPut, "/users/password-reset", "", strings.NewReader(`{"token":"used","password":"new"}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
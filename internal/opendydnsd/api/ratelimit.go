@@ -0,0 +1,372 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimitPeriod    = time.Minute
+	defaultLockoutDuration    = time.Minute
+	defaultLockoutMaxDuration = time.Hour
+
+	// pruneInterval is the minimal interval between two cleanups of the idle entries
+	pruneInterval = time.Minute
+	// challengeTTL is the lifetime of the two-factor challenges issued by the daemon
+	challengeTTL = 5 * time.Minute
+	// ipv6PrefixLen is the prefix length of the IPv6 networks sharing a rate limit:
+	// a client usually owns the whole /64
+	ipv6PrefixLen = 64
+)
+
+// rateLimiter is an in-memory token bucket rate limiter
+// a nil rateLimiter allow every request
+type rateLimiter struct {
+	rate      float64 // tokens added per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	now       func() time.Time
+	lock      sync.Mutex
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// newRateLimiter return a rate limiter configured using given config
+// or nil if the rate limit is disabled
+func newRateLimiter(conf config.RateLimitConfig) *rateLimiter {
+	if !conf.Enabled() {
+		return nil
+	}
+
+	if conf.Period <= 0 {
+		conf.Period = defaultRateLimitPeriod
+	}
+	if conf.Burst < conf.Requests {
+		conf.Burst = conf.Requests
+	}
+
+	return &rateLimiter{
+		rate:    float64(conf.Requests) / conf.Period.Seconds(),
+		burst:   float64(conf.Burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// allow consume a token from the bucket identified by given key
+// if the bucket is empty, the time to wait before the next token is returned
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	now := rl.now()
+	rl.prune(now)
+
+	b, exist := rl.buckets[key]
+	if !exist {
+		b = &bucket{tokens: rl.burst, updatedAt: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens = rl.tokensAt(b, now)
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// tokensAt return the number of tokens available in given bucket at given time
+func (rl *rateLimiter) tokensAt(b *bucket, t time.Time) float64 {
+	return math.Min(rl.burst, b.tokens+t.Sub(b.updatedAt).Seconds()*rl.rate)
+}
+
+// prune remove the buckets refilled since their last use
+// they behave the same as the new ones
+func (rl *rateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) < pruneInterval {
+		return
+	}
+	rl.lastPrune = now
+
+	for key, b := range rl.buckets {
+		if rl.tokensAt(b, now) >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// lockoutTracker lock the accounts after repeated authentication failures
+// the lock duration is doubled on each failure once the threshold is reached.
+// a nil lockoutTracker never lock any account
+type lockoutTracker struct {
+	threshold   int
+	duration    time.Duration
+	maxDuration time.Duration
+	entries     map[string]*lockoutEntry
+	challenges  map[string]challengeEntry // pending two-factor challenges, by token
+	lastPrune   time.Time
+	now         func() time.Time
+	lock        sync.Mutex
+}
+
+type challengeEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// newLockoutTracker return a lockout tracker configured using given config
+// or nil if the lockout is disabled
+func newLockoutTracker(conf config.LockoutConfig) *lockoutTracker {
+	if !conf.Enabled() {
+		return nil
+	}
+
+	if conf.Duration <= 0 {
+		conf.Duration = defaultLockoutDuration
+	}
+	if conf.MaxDuration <= 0 {
+		conf.MaxDuration = defaultLockoutMaxDuration
+	}
+	if conf.MaxDuration < conf.Duration {
+		conf.MaxDuration = conf.Duration
+	}
+
+	return &lockoutTracker{
+		threshold:   conf.Threshold,
+		duration:    conf.Duration,
+		maxDuration: conf.MaxDuration,
+		entries:     map[string]*lockoutEntry{},
+		challenges:  map[string]challengeEntry{},
+		now:         time.Now,
+	}
+}
+
+// locked return the remaining lock duration of the account identified by given key
+// or 0 if the account is not locked
+func (lt *lockoutTracker) locked(key string) time.Duration {
+	if lt == nil {
+		return 0
+	}
+
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+
+	entry, exist := lt.entries[key]
+	if !exist {
+		return 0
+	}
+
+	if remaining := entry.lockedUntil.Sub(lt.now()); remaining > 0 {
+		return remaining
+	}
+
+	return 0
+}
+
+// failure record an authentication failure for the account identified by given key
+// the failures are forgotten once MaxDuration elapsed without new failure
+func (lt *lockoutTracker) failure(key string) {
+	if lt == nil {
+		return
+	}
+
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+
+	now := lt.now()
+	lt.prune(now)
+
+	entry, exist := lt.entries[key]
+	if !exist || now.Sub(entry.lastFailure) > lt.maxDuration {
+		entry = &lockoutEntry{}
+		lt.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures >= lt.threshold {
+		entry.lockedUntil = now.Add(lt.lockDuration(entry.failures - lt.threshold))
+	}
+}
+
+// success reset the failures of the account identified by given key
+func (lt *lockoutTracker) success(key string) {
+	if lt == nil {
+		return
+	}
+
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+
+	delete(lt.entries, key)
+}
+
+// challenge bind given two-factor challenge to the account identified by given key
+// the failures of the account are kept until the challenge is completed
+func (lt *lockoutTracker) challenge(token, key string) {
+	if lt == nil {
+		return
+	}
+
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+
+	now := lt.now()
+	lt.prune(now)
+
+	lt.challenges[token] = challengeEntry{key: key, expiresAt: now.Add(challengeTTL)}
+}
+
+// challengeKey return the key of the account bound to given two-factor challenge
+// or an empty string if the challenge is unknown
+func (lt *lockoutTracker) challengeKey(token string) string {
+	if lt == nil {
+		return ""
+	}
+
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+
+	entry, exist := lt.challenges[token]
+	if !exist || !lt.now().Before(entry.expiresAt) {
+		return ""
+	}
+
+	return entry.key
+}
+
+// endChallenge forget given two-factor challenge, which cannot be used again
+func (lt *lockoutTracker) endChallenge(token string) {
+	if lt == nil {
+		return
+	}
+
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+
+	delete(lt.challenges, token)
+}
+
+// lockDuration return the lock duration after given number of failures above the threshold
+func (lt *lockoutTracker) lockDuration(exceeded int) time.Duration {
+	duration := lt.duration
+	for i := 0; i < exceeded && duration < lt.maxDuration; i++ {
+		duration *= 2
+	}
+
+	if duration > lt.maxDuration {
+		return lt.maxDuration
+	}
+
+	return duration
+}
+
+// prune remove the entries whose failures are forgotten and the expired challenges
+func (lt *lockoutTracker) prune(now time.Time) {
+	if now.Sub(lt.lastPrune) < pruneInterval {
+		return
+	}
+	lt.lastPrune = now
+
+	for key, entry := range lt.entries {
+		if now.Sub(entry.lastFailure) > lt.maxDuration && !now.Before(entry.lockedUntil) {
+			delete(lt.entries, key)
+		}
+	}
+
+	for token, entry := range lt.challenges {
+		if !now.Before(entry.expiresAt) {
+			delete(lt.challenges, token)
+		}
+	}
+}
+
+// rateLimitByIP return a middleware throttling the requests per source address
+func rateLimitByIP(rl *rateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if ok, retryAfter := rl.allow(addressKey(c.RealIP())); !ok {
+				return tooManyRequests(c, "ip", retryAfter)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// rateLimitByUser return a middleware throttling the requests per authenticated user
+// the middleware must be registered after the auth middleware
+func rateLimitByUser(rl *rateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if ok, retryAfter := rl.allow(userKey(getUserContext(c))); !ok {
+				return tooManyRequests(c, "alias", retryAfter)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// tooManyRequests set the Retry-After header and return ErrTooManyRequests
+func tooManyRequests(c echo.Context, limit string, retryAfter time.Duration) error {
+	metrics.ThrottledRequests.WithLabelValues(limit).Inc()
+
+	// Retry-After is expressed in (rounded up) seconds
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+
+	return proto.ErrTooManyRequests
+}
+
+// addressKey return the rate limit key of given source address
+// the IPv6 clients are identified by their /64 network
+func addressKey(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() != nil {
+		return address
+	}
+
+	network := net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6PrefixLen, 128)), Mask: net.CIDRMask(ipv6PrefixLen, 128)}
+	return network.String()
+}
+
+// accountKey return the rate limit / lockout key of given account
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// userKey return the rate limit key of given user
+func userKey(userCtx proto.UserContext) string {
+	return strconv.FormatUint(uint64(userCtx.UserID), 10)
+}
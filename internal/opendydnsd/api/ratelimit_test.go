@@ -0,0 +1,321 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(config.RateLimitConfig{}) != nil {
+		t.Error("rate limit should be disabled")
+	}
+
+	var disabled *rateLimiter
+	if ok, _ := disabled.allow("foo"); !ok {
+		t.Error("disabled rate limit should allow every request")
+	}
+
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(config.RateLimitConfig{Requests: 2, Period: time.Minute, Burst: 3})
+	rl.now = func() time.Time { return now }
+
+	// the burst is allowed
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.allow("foo"); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	ok, retryAfter := rl.allow("foo")
+	if ok || retryAfter != 30*time.Second {
+		t.Errorf("request should be throttled: %v %s", ok, retryAfter)
+	}
+
+	// the buckets are independent
+	if ok, _ := rl.allow("bar"); !ok {
+		t.Error("request should be allowed")
+	}
+
+	// a token is added every 30 seconds
+	now = now.Add(30 * time.Second)
+	if ok, _ := rl.allow("foo"); !ok {
+		t.Error("request should be allowed")
+	}
+	if ok, _ := rl.allow("foo"); ok {
+		t.Error("request should be throttled")
+	}
+
+	// the refilled buckets are pruned
+	now = now.Add(2 * time.Minute)
+	rl.allow("baz")
+	if len(rl.buckets) != 1 {
+		t.Errorf("wrong number of buckets: %d", len(rl.buckets))
+	}
+}
+
+func TestLockoutTracker(t *testing.T) {
+	if newLockoutTracker(config.LockoutConfig{}) != nil {
+		t.Error("lockout should be disabled")
+	}
+
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	lt := newLockoutTracker(config.LockoutConfig{Threshold: 3, Duration: time.Minute, MaxDuration: 5 * time.Minute})
+	lt.now = func() time.Time { return now }
+
+	lt.failure("foo")
+	lt.failure("foo")
+	if d := lt.locked("foo"); d != 0 {
+		t.Errorf("account should not be locked: %s", d)
+	}
+
+	// the lock duration is doubled on each failure, up to the max duration
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		lt.failure("foo")
+		if d := lt.locked("foo"); d != want {
+			t.Errorf("wrong lock duration: got %s want %s", d, want)
+		}
+	}
+
+	if d := lt.locked("bar"); d != 0 {
+		t.Errorf("account should not be locked: %s", d)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if d := lt.locked("foo"); d != 0 {
+		t.Errorf("account should not be locked: %s", d)
+	}
+
+	// a successful authentication reset the failures
+	lt.success("foo")
+	lt.failure("foo")
+	if d := lt.locked("foo"); d != 0 {
+		t.Errorf("account should not be locked: %s", d)
+	}
+
+	// the failures are forgotten after the max duration
+	lt.failure("foo")
+	now = now.Add(6 * time.Minute)
+	lt.failure("foo")
+	if d := lt.locked("foo"); d != 0 {
+		t.Errorf("account should not be locked: %s", d)
+	}
+
+	// the two-factor challenges are bound to the account until completed or expired
+	lt.challenge("first", "foo")
+	lt.challenge("second", "foo")
+	if key := lt.challengeKey("first"); key != "foo" {
+		t.Errorf("wrong challenge key: %s", key)
+	}
+	lt.endChallenge("first")
+	if key := lt.challengeKey("first"); key != "" {
+		t.Errorf("wrong challenge key: %s", key)
+	}
+	now = now.Add(challengeTTL)
+	if key := lt.challengeKey("second"); key != "" {
+		t.Errorf("wrong challenge key: %s", key)
+	}
+}
+
+func TestAPI_Authenticate_RateLimitByIP(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPIWithConfig(t, mockCtrl, config.APIConfig{
+		AuthRateLimit: config.RateLimitConfig{Requests: 1, Period: time.Hour},
+	})
+
+	daemonMock.EXPECT().Authenticate(gomock.Any()).Return(proto.UserContext{}, "", proto.ErrInvalidParameters)
+
+	req := `{"email":"luna@example.org","password":"test"}`
+	if rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req)); rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	// the daemon is not called anymore
+	rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestAPI_Authenticate_Lockout(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPIWithConfig(t, mockCtrl, config.APIConfig{
+		Lockout: config.LockoutConfig{Threshold: 2, Duration: time.Minute},
+	})
+
+	daemonMock.EXPECT().Authenticate(gomock.Any()).Return(proto.UserContext{}, "", proto.ErrInvalidParameters).Times(2)
+
+	req := `{"email":"luna@example.org","password":"test"}`
+	for i := 0; i < 2; i++ {
+		if rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req)); rec.Code != http.StatusBadRequest {
+			t.Errorf("wrong status code: %d", rec.Code)
+		}
+	}
+
+	// the account is locked, even using the right password (the email is not case sensitive)
+	req = `{"email":"Luna@example.org","password":"right"}`
+	rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// the other accounts are not locked
+	daemonMock.EXPECT().Authenticate(proto.CredentialsDto{Email: "other@example.org", Password: "test"}).
+		Return(proto.UserContext{}, "", proto.ErrUserDisabled)
+	req = `{"email":"other@example.org","password":"test"}`
+	if rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req)); rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_Authenticate_TwoFactorLockout(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPIWithConfig(t, mockCtrl, config.APIConfig{
+		Lockout: config.LockoutConfig{Threshold: 2, Duration: time.Minute},
+	})
+
+	// the valid passwords do not reset the failures of the invalid two-factor codes
+	req := `{"email":"luna@example.org","password":"right"}`
+	for _, challenge := range []string{"first", "second"} {
+		daemonMock.EXPECT().Authenticate(gomock.Any()).Return(proto.UserContext{}, challenge, nil)
+		if rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req)); rec.Code != http.StatusOK {
+			t.Errorf("wrong status code: %d", rec.Code)
+		}
+
+		daemonMock.EXPECT().VerifyTwoFactor(proto.TwoFactorDto{Token: challenge, Code: "000000"}).
+			Return(proto.UserContext{}, proto.ErrInvalidTwoFactorCode)
+		body := strings.NewReader(`{"token":"` + challenge + `","code":"000000"}`)
+		if rec := adminRequest(a, http.MethodPost, "/sessions/2fa", "", body); rec.Code != http.StatusForbidden {
+			t.Errorf("wrong status code: %d", rec.Code)
+		}
+	}
+
+	// the account is locked
+	rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestAddressKey(t *testing.T) {
+	for address, want := range map[string]string{
+		"192.0.2.12":           "192.0.2.12",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1:2::/64",
+		"2001:db8:1:2:ffff::1": "2001:db8:1:2::/64",
+		"2001:db8:1:3::1":      "2001:db8:1:3::/64",
+		"::ffff:192.0.2.12":    "::ffff:192.0.2.12",
+		"not an address":       "not an address",
+	} {
+		if got := addressKey(address); got != want {
+			t.Errorf("addressKey(%s): got %s want %s", address, got, want)
+		}
+	}
+}
+
+func TestAPI_Authenticate_RateLimitByAccount(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPIWithConfig(t, mockCtrl, config.APIConfig{
+		AccountRateLimit: config.RateLimitConfig{Requests: 1, Period: time.Minute},
+	})
+
+	daemonMock.EXPECT().Authenticate(gomock.Any()).Return(proto.UserContext{}, "", proto.ErrInvalidParameters)
+
+	req := `{"email":"luna@example.org","password":"test"}`
+	if rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req)); rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(req))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestAPI_RateLimitAliases(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPIWithConfig(t, mockCtrl, config.APIConfig{
+		AliasRateLimit: config.RateLimitConfig{Requests: 1, Period: time.Minute},
+	})
+
+	userToken, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().DeleteAlias(proto.UserContext{UserID: 1}, "foo.bar.baz").Return(nil)
+
+	if rec := adminRequest(a, http.MethodDelete, "/aliases/foo.bar.baz", userToken.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	req := `{"domain":"foo.bar.baz","value":"8.8.8.8"}`
+	if rec := adminRequest(a, http.MethodPost, "/aliases", userToken.Token, strings.NewReader(req)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	// the read-only endpoints are not limited
+	daemonMock.EXPECT().GetAliases(proto.UserContext{UserID: 1}).Return([]proto.AliasDto{}, nil)
+	if rec := adminRequest(a, http.MethodGet, "/aliases", userToken.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	// the limit is per user
+	otherToken, err := makeToken(proto.UserContext{UserID: 2}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().DeleteAlias(proto.UserContext{UserID: 2}, "foo.bar.baz").Return(nil)
+	if rec := adminRequest(a, http.MethodDelete, "/aliases/foo.bar.baz", otherToken.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestDynDNSUpdate_RateLimit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPIWithConfig(t, mockCtrl, config.APIConfig{
+		Lockout:        config.LockoutConfig{Threshold: 1, Duration: time.Minute},
+		AliasRateLimit: config.RateLimitConfig{Requests: 1, Period: time.Minute},
+	})
+
+	daemonMock.EXPECT().AuthenticatePassword(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}).
+		Return(proto.UserContext{UserID: 1}, nil)
+	daemonMock.EXPECT().GetAliases(proto.UserContext{UserID: 1}).
+		Return([]proto.AliasDto{{Domain: "foo.bar.baz", Value: "1.1.1.1"}, {Domain: "bar.bar.baz", Value: "1.1.1.1"}}, nil)
+	daemonMock.EXPECT().UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"}).
+		Return(proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"}, nil)
+
+	// the second update is throttled
+	rec := dynDNSRequest(a, "hostname=foo.bar.baz,bar.bar.baz&myip=8.8.8.8", true)
+	if rec.Code != http.StatusOK || rec.Body.String() != "good 8.8.8.8\nabuse" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// the failed authentication lock the account
+	daemonMock.EXPECT().AuthenticatePassword(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}).
+		Return(proto.UserContext{}, proto.ErrInvalidParameters)
+	if rec := dynDNSRequest(a, "hostname=foo.bar.baz", true); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	rec = dynDNSRequest(a, "hostname=foo.bar.baz", true)
+	if rec.Code != http.StatusTooManyRequests || rec.Body.String() != "abuse" || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
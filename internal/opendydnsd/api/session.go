@@ -0,0 +1,76 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+func (a *API) refreshSession(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var refresh proto.RefreshTokenDto
+		if err := c.Bind(&refresh); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		userCtx, refreshToken, err := d.RefreshSession(refresh.RefreshToken)
+		if err != nil {
+			return err
+		}
+
+		return a.sendTokens(c, userCtx, refreshToken)
+	}
+}
+
+func (a *API) getSessions(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		sessions, err := d.GetSessions(userCtx)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, sessions)
+	}
+}
+
+func (a *API) revokeSession(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		sessionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			return proto.ErrSessionNotFound
+		}
+
+		if err := d.RevokeSession(userCtx, uint(sessionID)); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// newSession create a new session for the authenticated user and send its tokens
+func (a *API) newSession(c echo.Context, d daemon.Daemon, userCtx proto.UserContext) error {
+	userCtx, refreshToken, err := d.CreateSession(userCtx, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return err
+	}
+
+	return a.sendTokens(c, userCtx, refreshToken)
+}
+
+// sendTokens send the JWT token of given session together with its refresh token
+func (a *API) sendTokens(c echo.Context, userCtx proto.UserContext, refreshToken string) error {
+	token, err := makeToken(userCtx, a.conf.SigningKey, a.conf.TokenTTL)
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	token.RefreshToken = refreshToken
+
+	return c.JSON(http.StatusOK, token)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI_Authenticate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().Authenticate(proto.CredentialsDto{Email: "luna@example.org", Password: "test"}).
+		Return(proto.UserContext{UserID: 1}, "", nil)
+	daemonMock.EXPECT().CreateSession(proto.UserContext{UserID: 1}, "opendydnsctl", "192.0.2.1").
+		Return(proto.UserContext{UserID: 1, SessionID: 4}, "odr_test", nil)
+
+	req := strings.NewReader(`{"email":"luna@example.org","password":"test"}`)
+	rec := adminRequestWithUserAgent(a, http.MethodPost, "/sessions", req, "opendydnsctl")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var token proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+
+	// the JWT token is short-lived
+	if token.RefreshToken != "odr_test" || token.ExpiresAt.After(time.Now().Add(defaultTokenTTL)) ||
+		token.ExpiresAt.Before(time.Now()) {
+		t.Errorf("wrong token returned: %+v", token)
+	}
+
+	// the JWT token identify the session
+	daemonMock.EXPECT().GetSessions(proto.UserContext{UserID: 1, SessionID: 4}).Return([]proto.SessionDto{}, nil)
+	if rec := adminRequest(a, http.MethodGet, "/sessions", token.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_RefreshSession(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().RefreshSession("odr_old").Return(proto.UserContext{UserID: 1, SessionID: 4}, "odr_new", nil)
+	daemonMock.EXPECT().RefreshSession("odr_used").Return(proto.UserContext{}, "", proto.ErrInvalidRefreshToken)
+
+	rec := adminRequest(a, http.MethodPost, "/sessions/refresh", "", strings.NewReader(`{"refresh_token":"odr_old"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var token proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil || token.Token == "" || token.RefreshToken != "odr_new" {
+		t.Errorf("wrong token returned: %+v %v", token, err)
+	}
+
+	rec = adminRequest(a, http.MethodPost, "/sessions/refresh", "", strings.NewReader(`{"refresh_token":"odr_used"}`))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_RevokeSession(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	token, err := makeToken(proto.UserContext{UserID: 1, SessionID: 4}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().RevokeSession(proto.UserContext{UserID: 1, SessionID: 4}, uint(4)).Return(nil)
+	daemonMock.EXPECT().RevokeSession(proto.UserContext{UserID: 1, SessionID: 4}, uint(5)).Return(proto.ErrSessionNotFound)
+
+	for target, code := range map[string]int{
+		"/sessions/4":   http.StatusOK,
+		"/sessions/5":   http.StatusNotFound,
+		"/sessions/abc": http.StatusNotFound,
+	} {
+		if rec := adminRequest(a, http.MethodDelete, target, token.Token, nil); rec.Code != code {
+			t.Errorf("%s: wrong status code: %d", target, rec.Code)
+		}
+	}
+}
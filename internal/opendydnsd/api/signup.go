@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+func (a *API) signUp(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var cred proto.CredentialsDto
+		if err := c.Bind(&cred); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		if err := d.SignUp(cred); err != nil {
+			return err
+		}
+
+		// the same response is returned whether the account already exist or not
+		return c.NoContent(http.StatusAccepted)
+	}
+}
+
+func (a *API) verifyEmail(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.QueryParam("token")
+		if token == "" {
+			return proto.ErrInvalidVerificationToken
+		}
+
+		if err := d.VerifyEmail(token); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPI_SignUp(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().SignUp(proto.CredentialsDto{Email: "luna@example.org", Password: "test"}).Return(nil)
+	daemonMock.EXPECT().SignUp(proto.CredentialsDto{Email: "luna@other.org", Password: "test"}).Return(proto.ErrInvalidParameters)
+
+	rec := adminRequest(a, http.MethodPost, "/users", "", strings.NewReader(`{"email":"luna@example.org","password":"test"}`))
+	if rec.Code != http.StatusAccepted || rec.Body.Len() != 0 {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = adminRequest(a, http.MethodPost, "/users", "", strings.NewReader(`{"email":"luna@other.org","password":"test"}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_SignUp_Disabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().SignUp(gomock.Any()).Return(proto.ErrSignupDisabled)
+
+	rec := adminRequest(a, http.MethodPost, "/users", "", strings.NewReader(`{"email":"luna@example.org","password":"test"}`))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_VerifyEmail(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().VerifyEmail("good").Return(nil)
+	daemonMock.EXPECT().VerifyEmail("bad").Return(proto.ErrInvalidVerificationToken)
+
+	for target, code := range map[string]int{
+		"/users/verify?token=good": http.StatusOK,
+		"/users/verify?token=bad":  http.StatusBadRequest,
+		"/users/verify":            http.StatusBadRequest,
+	} {
+		if rec := adminRequest(a, http.MethodGet, target, "", nil); rec.Code != code {
+			t.Errorf("%s: wrong status code: %d", target, rec.Code)
+		}
+	}
+}
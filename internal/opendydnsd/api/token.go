@@ -0,0 +1,89 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (a *API) createUpdateToken(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		token, err := d.CreateUpdateToken(userCtx, c.Param("name"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, token)
+	}
+}
+
+func (a *API) getUpdateTokens(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		tokens, err := d.GetUpdateTokens(userCtx, c.Param("name"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, tokens)
+	}
+}
+
+func (a *API) revokeUpdateToken(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			return proto.ErrUpdateTokenNotFound
+		}
+
+		if err := d.RevokeUpdateToken(userCtx, c.Param("name"), uint(id)); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// updateAliasWithToken update the alias using an update token
+// provided as bearer token. The token is only valid for his alias
+func (a *API) updateAliasWithToken(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+
+		token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		userCtx, err := d.AuthenticateUpdateToken(token, name)
+		if err != nil {
+			return err
+		}
+
+		var alias proto.AliasDto
+		if err := c.Bind(&alias); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+		alias.Domain = name
+
+		// Use the request source address if no value provided
+		if alias.Value == "" {
+			alias.Value = c.RealIP()
+		}
+
+		if ok, retryAfter := a.aliasLimiter.allow(userKey(userCtx)); !ok {
+			return tooManyRequests(c, "alias", retryAfter)
+		}
+
+		alias, err = d.UpdateAlias(userCtx, alias)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, alias)
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPI_CreateUpdateToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	token, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().
+		CreateUpdateToken(proto.UserContext{UserID: 1}, "foo.bar.baz").
+		Return(proto.UpdateTokenDto{ID: 3, Alias: "foo.bar.baz", Prefix: "odt_abcdefgh", Token: "odt_abcdefghijkl"}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/aliases/foo.bar.baz/tokens", nil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"token":"odt_abcdefghijkl"`) {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPI_RevokeUpdateToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	token, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().RevokeUpdateToken(proto.UserContext{UserID: 1}, "foo.bar.baz", uint(3)).Return(nil)
+
+	for target, code := range map[string]int{
+		"/aliases/foo.bar.baz/tokens/3":   http.StatusOK,
+		"/aliases/foo.bar.baz/tokens/abc": http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodDelete, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		rec := httptest.NewRecorder()
+		a.e.ServeHTTP(rec, req)
+
+		if rec.Code != code {
+			t.Errorf("%s: wrong status code: %d", target, rec.Code)
+		}
+	}
+}
+
+func TestAPI_UpdateAliasWithToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().AuthenticateUpdateToken("odt_test", "foo.bar.baz").Return(proto.UserContext{UserID: 12}, nil)
+	daemonMock.EXPECT().
+		UpdateAlias(proto.UserContext{UserID: 12}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.4.4"}).
+		Return(proto.AliasDto{Domain: "foo.bar.baz", Type: proto.RecordTypeA, Value: "8.8.4.4"}, nil)
+
+	req := httptest.NewRequest(http.MethodPut, "/aliases/foo.bar.baz", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer odt_test")
+	req.RemoteAddr = "8.8.4.4:1234"
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPI_UpdateAliasWithToken_InvalidToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().AuthenticateUpdateToken("odt_test", "other.bar.baz").Return(proto.UserContext{}, proto.ErrInvalidUpdateToken)
+
+	req := httptest.NewRequest(http.MethodPut, "/aliases/other.bar.baz", strings.NewReader(`{"value":"1.1.1.1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer odt_test")
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestDynDNSUpdate_UpdateToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().AuthenticateUpdateToken("odt_test", "foo.bar.baz").Return(proto.UserContext{UserID: 12}, nil)
+	daemonMock.EXPECT().GetAliases(proto.UserContext{UserID: 12}).
+		Return([]proto.AliasDto{{Domain: "foo.bar.baz", Type: proto.RecordTypeA, Value: "1.1.1.1"}}, nil)
+	daemonMock.EXPECT().UpdateAlias(proto.UserContext{UserID: 12}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"}).
+		Return(proto.AliasDto{}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/nic/update?hostname=foo.bar.baz&myip=8.8.8.8", nil)
+	req.SetBasicAuth("router", "odt_test")
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "good 8.8.8.8" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDynDNSUpdate_UpdateToken_OtherAlias(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	daemonMock.EXPECT().AuthenticateUpdateToken("odt_test", "foo.bar.baz").Return(proto.UserContext{UserID: 12}, nil)
+	daemonMock.EXPECT().AuthenticateUpdateToken("odt_test", "other.bar.baz").Return(proto.UserContext{}, proto.ErrInvalidUpdateToken)
+
+	req := httptest.NewRequest(http.MethodGet, "/nic/update?hostname=foo.bar.baz,other.bar.baz&myip=8.8.8.8", nil)
+	req.SetBasicAuth("router", "odt_test")
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized || rec.Body.String() != "badauth" {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+func (a *API) verifyTwoFactor(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var challenge proto.TwoFactorDto
+		if err := c.Bind(&challenge); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		// the invalid codes count as failures of the account which requested the challenge
+		key := a.lockout.challengeKey(challenge.Token)
+		if retryAfter := a.lockout.locked(key); key != "" && retryAfter > 0 {
+			return tooManyRequests(c, "lockout", retryAfter)
+		}
+
+		userCtx, err := d.VerifyTwoFactor(challenge)
+		if key != "" {
+			// the challenge is consumed once a code has been checked
+			switch {
+			case err == proto.ErrInvalidTwoFactorCode:
+				a.lockout.endChallenge(challenge.Token)
+				a.lockout.failure(key)
+			case err == nil:
+				a.lockout.endChallenge(challenge.Token)
+				a.lockout.success(key)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		return a.newSession(c, d, userCtx)
+	}
+}
+
+func (a *API) setupTwoFactor(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		setup, err := d.SetupTwoFactor(userCtx)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, setup)
+	}
+}
+
+func (a *API) enableTwoFactor(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		var code proto.TwoFactorCodeDto
+		if err := c.Bind(&code); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		codes, err := d.EnableTwoFactor(userCtx, code.Code)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, codes)
+	}
+}
+
+func (a *API) disableTwoFactor(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		var code proto.TwoFactorCodeDto
+		if err := c.Bind(&code); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		if err := d.DisableTwoFactor(userCtx, code.Code); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+func (a *API) regenerateRecoveryCodes(d daemon.Daemon) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userCtx := getUserContext(c)
+
+		var code proto.TwoFactorCodeDto
+		if err := c.Bind(&code); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+
+		codes, err := d.RegenerateRecoveryCodes(userCtx, code.Code)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, codes)
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPI_Authenticate_TwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	// no session is created until the second step
+	daemonMock.EXPECT().Authenticate(proto.CredentialsDto{Email: "luna@example.org", Password: "test"}).
+		Return(proto.UserContext{}, "challenge", nil)
+
+	rec := adminRequest(a, http.MethodPost, "/sessions", "", strings.NewReader(`{"email":"luna@example.org","password":"test"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	var token proto.TokenDto
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "" || token.RefreshToken != "" || token.TwoFactorToken != "challenge" {
+		t.Errorf("wrong token returned: %+v", token)
+	}
+
+	daemonMock.EXPECT().VerifyTwoFactor(proto.TwoFactorDto{Token: "challenge", Code: "123456"}).
+		Return(proto.UserContext{UserID: 1}, nil)
+	daemonMock.EXPECT().CreateSession(proto.UserContext{UserID: 1}, gomock.Any(), gomock.Any()).
+		Return(proto.UserContext{UserID: 1, SessionID: 4}, "odr_test", nil)
+
+	rec = adminRequest(a, http.MethodPost, "/sessions/2fa", "", strings.NewReader(`{"token":"challenge","code":"123456"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: %d", rec.Code)
+	}
+
+	token = proto.TokenDto{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	if token.Token == "" || token.RefreshToken != "odr_test" || token.TwoFactorToken != "" {
+		t.Errorf("wrong token returned: %+v", token)
+	}
+
+	// the challenge is single use
+	daemonMock.EXPECT().VerifyTwoFactor(proto.TwoFactorDto{Token: "challenge", Code: "123456"}).
+		Return(proto.UserContext{}, proto.ErrInvalidTwoFactorToken)
+
+	rec = adminRequest(a, http.MethodPost, "/sessions/2fa", "", strings.NewReader(`{"token":"challenge","code":"123456"}`))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
+
+func TestAPI_TwoFactorSettings(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	a, daemonMock := newTestAPI(t, mockCtrl)
+
+	token, err := makeToken(proto.UserContext{UserID: 1}, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemonMock.EXPECT().SetupTwoFactor(proto.UserContext{UserID: 1}).
+		Return(proto.TwoFactorSetupDto{Secret: "JBSWY3DPEHPK3PXP", URI: "otpauth://totp/test"}, nil)
+	rec := adminRequest(a, http.MethodPost, "/users/me/2fa", token.Token, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"secret":"JBSWY3DPEHPK3PXP"`) {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	daemonMock.EXPECT().EnableTwoFactor(proto.UserContext{UserID: 1}, "123456").
+		Return(proto.RecoveryCodesDto{Codes: []string{"abcde-fghij"}}, nil)
+	rec = adminRequest(a, http.MethodPut, "/users/me/2fa", token.Token, strings.NewReader(`{"code":"123456"}`))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"codes":["abcde-fghij"]`) {
+		t.Errorf("wrong response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	daemonMock.EXPECT().RegenerateRecoveryCodes(proto.UserContext{UserID: 1}, "654321").
+		Return(proto.RecoveryCodesDto{}, proto.ErrInvalidTwoFactorCode)
+	rec = adminRequest(a, http.MethodPost, "/users/me/2fa/recovery-codes", token.Token, strings.NewReader(`{"code":"654321"}`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+
+	daemonMock.EXPECT().DisableTwoFactor(proto.UserContext{UserID: 1}, "abcde-fghij").Return(nil)
+	rec = adminRequest(a, http.MethodPost, "/users/me/2fa/disable", token.Token, strings.NewReader(`{"code":"abcde-fghij"}`))
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: %d", rec.Code)
+	}
+}
@@ -11,6 +11,26 @@ var DefaultConfig = Config{
 	APIConfig: APIConfig{
 		ListenAddr: "127.0.0.1:8888",
 		SigningKey: "",
+		AuthRateLimit: RateLimitConfig{
+			Requests: 10,
+			Period:   time.Minute,
+			Burst:    20,
+		},
+		AccountRateLimit: RateLimitConfig{
+			Requests: 5,
+			Period:   time.Minute,
+			Burst:    10,
+		},
+		AliasRateLimit: RateLimitConfig{
+			Requests: 30,
+			Period:   time.Minute,
+			Burst:    60,
+		},
+		Lockout: LockoutConfig{
+			Threshold:   5,
+			Duration:    time.Minute,
+			MaxDuration: time.Hour,
+		},
 	},
 	DaemonConfig: DaemonConfig{},
 	DatabaseConfig: DatabaseConfig{
@@ -24,6 +44,7 @@ type Config struct {
 	APIConfig      APIConfig `toml:"ApiConfig"`
 	DaemonConfig   DaemonConfig
 	DatabaseConfig DatabaseConfig
+	SMTPConfig     SMTPConfig `toml:"SmtpConfig"`
 }
 
 // Valid determinate if config is valid one
@@ -33,12 +54,20 @@ func (c Config) Valid() bool {
 
 // APIConfig represent the API configuration
 type APIConfig struct {
-	ListenAddr   string
-	SigningKey   string
-	CertCacheDir string
-	Hostname     string
-	AutoTLS      bool
-	TokenTTL     time.Duration
+	ListenAddr                 string
+	SigningKey                 string
+	CertCacheDir               string
+	Hostname                   string
+	AutoTLS                    bool
+	TokenTTL                   time.Duration   // lifetime of the JWT tokens, renewed using the refresh tokens (default 15m)
+	TrustedProxies             []string        // CIDRs allowed to set Forwarded / X-Forwarded-For
+	ShutdownTimeout            time.Duration   // time given to the in-flight requests to complete on shutdown (default 30s)
+	MetricsListenAddr          string          // dedicated address serving /metrics, served by the API if empty
+	ReadinessCheckProvisioners bool            // check the DNS provisioners of every domain in /readyz
+	AuthRateLimit              RateLimitConfig // authentication, signup and password reset requests per source address
+	AccountRateLimit           RateLimitConfig // authentication requests per account (email address)
+	AliasRateLimit             RateLimitConfig // alias changes per user
+	Lockout                    LockoutConfig   // temporary account lockout after repeated authentication failures
 }
 
 // Valid determinate if config is valid one
@@ -46,14 +75,150 @@ func (ac APIConfig) Valid() bool {
 	return ac.ListenAddr != "" && ac.SigningKey != ""
 }
 
+// applyDefaults set the default rate limits and lockout of the sections missing from the configuration
+// they are disabled using negative values
+func (ac *APIConfig) applyDefaults() {
+	if ac.AuthRateLimit == (RateLimitConfig{}) {
+		ac.AuthRateLimit = DefaultConfig.APIConfig.AuthRateLimit
+	}
+	if ac.AccountRateLimit == (RateLimitConfig{}) {
+		ac.AccountRateLimit = DefaultConfig.APIConfig.AccountRateLimit
+	}
+	if ac.AliasRateLimit == (RateLimitConfig{}) {
+		ac.AliasRateLimit = DefaultConfig.APIConfig.AliasRateLimit
+	}
+	if ac.Lockout == (LockoutConfig{}) {
+		ac.Lockout = DefaultConfig.APIConfig.Lockout
+	}
+}
+
 // SSLEnabled determinate if SSL (HTTPS) is enabled for the API
 func (ac APIConfig) SSLEnabled() bool {
 	return ac.CertCacheDir != "" && ac.Hostname != ""
 }
 
+// RateLimitConfig represent a token bucket rate limit: Requests are allowed
+// per Period, with bursts of up to Burst requests. The limit is disabled if Requests is negative
+type RateLimitConfig struct {
+	Requests int
+	Period   time.Duration // default 1m
+	Burst    int           // default Requests
+}
+
+// Enabled determinate if the rate limit is configured
+func (rc RateLimitConfig) Enabled() bool {
+	return rc.Requests > 0
+}
+
+// LockoutConfig represent the account lockout configuration
+// the account is locked for Duration once Threshold consecutive authentications failed,
+// the duration is doubled on each new failure up to MaxDuration. The lockout is disabled if Threshold is negative
+type LockoutConfig struct {
+	Threshold   int
+	Duration    time.Duration // default 1m
+	MaxDuration time.Duration // default 1h
+}
+
+// Enabled determinate if the account lockout is configured
+func (lc LockoutConfig) Enabled() bool {
+	return lc.Threshold > 0
+}
+
 // DaemonConfig represent the daemon configuration
 type DaemonConfig struct {
 	DNSProvisioners []DNSProvisionerConfig `toml:"DnsProvisioner"`
+	AddressPolicy   AddressPolicyConfig
+	DNSServer       DNSServerConfig `toml:"DnsServer"`
+	OutboxInterval  time.Duration   // interval between retries of the pending DNS operations (default 1m)
+	Reconciler      ReconcilerConfig
+	Signup          SignupConfig
+	PasswordReset   PasswordResetConfig
+	SessionTTL      time.Duration // lifetime of the sessions (refresh tokens), extended on each refresh (default 30 days)
+	TwoFactorIssuer string        // issuer shown in the authenticator apps (default OpenDyDNS)
+	OIDC            OIDCConfig    `toml:"Oidc"`
+	Authentication  AuthenticationConfig
+}
+
+// SignupConfig represent the self-service signup configuration
+// the signup requires the SMTP relay to send the verification emails
+type SignupConfig struct {
+	Enabled         bool
+	AllowedDomains  []string      // email domains allowed to sign up, every domain if empty
+	VerificationURL string        // link sent by email, the token is added as query parameter (i.e https://dyndns.example.org/users/verify)
+	VerificationTTL time.Duration // validity of the verification link (default 24h)
+}
+
+// AuthenticationConfig represent the backend used to check the user credentials
+type AuthenticationConfig struct {
+	Backend string     // local (bcrypt hash stored in the database, default) or ldap
+	LDAP    LDAPConfig `toml:"Ldap"`
+}
+
+// LDAPConfig represent the LDAP authentication backend configuration
+// the user entry is searched using the service account, then the credentials are checked by binding as the user.
+// the local users are created on their first successful bind
+type LDAPConfig struct {
+	URL            string // ldap://ldap.example.org:389 or ldaps://ldap.example.org:636
+	StartTLS       bool   // upgrade the ldap:// connection using StartTLS
+	AllowInsecure  bool   // allow the ldap:// connections without StartTLS: the passwords are sent in clear text
+	BindDN         string // service account used to search the users, anonymous search if empty
+	BindPassword   string
+	BaseDN         string        // i.e ou=people,dc=example,dc=org
+	UserFilter     string        // %s is replaced by the (escaped) email, i.e (&(objectClass=inetOrgPerson)(mail=%s))
+	MailAttribute  string        // email address of the user (default mail)
+	GroupAttribute string        // DN of the groups of the user (default memberOf)
+	AllowedGroups  []string      // DN of the groups allowed to login, every user if empty
+	AdminGroups    []string      // DN of the groups whose members are admins, the admin role is managed locally if empty
+	Timeout        time.Duration // connection and requests timeout (default 10s)
+}
+
+// OIDCConfig represent the OpenID Connect login configuration
+// the login is only enabled when both Issuer and ClientID are set
+type OIDCConfig struct {
+	Issuer            string   // i.e https://accounts.example.org, the ID tokens are validated against its JWKS
+	ClientID          string   // public client used by opendydnsctl (device authorization grant), expected audience of the ID tokens
+	Scopes            []string // requested by opendydnsctl (default openid, email, profile)
+	AutoProvision     bool     // create the unknown users on their first login
+	LinkExistingUsers bool     // link the existing accounts (admins excepted) to the identity using the same email address
+	AllowedDomains    []string // email domains allowed to login, every domain if empty
+	GroupsClaim       string   // claim listing the groups of the user (default groups)
+	AllowedGroups     []string // groups allowed to login, every group if empty
+}
+
+// Enabled determinate if the OpenID Connect login is configured
+func (oc OIDCConfig) Enabled() bool {
+	return oc.Issuer != "" && oc.ClientID != ""
+}
+
+// PasswordResetConfig represent the password reset by email configuration
+// the reset requires the SMTP relay to send the reset tokens
+type PasswordResetConfig struct {
+	Enabled  bool
+	TokenTTL time.Duration // validity of the reset token (default 1h)
+}
+
+// ReconcilerConfig represent the DNS / database reconciler configuration
+type ReconcilerConfig struct {
+	Interval      time.Duration // interval between two reconciliations, disabled if 0
+	Repair        bool          // re-create missing and fix stale records
+	DeleteOrphans bool          // delete the records without alias (within the configured hosts)
+}
+
+// DNSServerConfig represent the built-in authoritative DNS server configuration
+// the server is only started when ListenAddr is set
+type DNSServerConfig struct {
+	ListenAddr string // UDP & TCP address, i.e 0.0.0.0:53
+}
+
+// AddressPolicyConfig determinate which special purpose address ranges
+// are allowed as alias value. Every range is rejected by default
+type AddressPolicyConfig struct {
+	AllowLoopback      bool // 127.0.0.0/8, ::1
+	AllowPrivate       bool // RFC1918, fc00::/7
+	AllowLinkLocal     bool // 169.254.0.0/16, fe80::/10
+	AllowCGNAT         bool // 100.64.0.0/10
+	AllowMulticast     bool // 224.0.0.0/4, ff00::/8
+	AllowDocumentation bool // 192.0.2.0/24, 198.51.100.0/24, 203.0.113.0/24, 2001:db8::/32
 }
 
 // DNSProvisionerConfig represent the configuration of a DNS provisioner
@@ -64,9 +229,11 @@ type DNSProvisionerConfig struct {
 }
 
 // DomainConfig represent a domain
+// the Config entries override the DNS provisioner ones for this domain
 type DomainConfig struct {
 	Domain string
 	Host   string
+	Config map[string]string
 }
 
 func (dc DomainConfig) String() string {
@@ -84,8 +251,11 @@ func (dc DaemonConfig) Valid() bool {
 
 // DatabaseConfig represent the database configuration
 type DatabaseConfig struct {
-	Driver string
-	DSN    string
+	Driver          string // sqlite, postgres or mysql
+	DSN             string
+	MaxOpenConns    int           // maximum number of open connections, unlimited if 0
+	MaxIdleConns    int           // maximum number of idle connections, default (2) if 0
+	ConnMaxLifetime time.Duration // maximum amount of time a connection may be reused, unlimited if 0
 }
 
 // Valid determinate if config is valid one
@@ -93,6 +263,20 @@ func (dc DatabaseConfig) Valid() bool {
 	return dc.Driver != "" && dc.DSN != ""
 }
 
+// SMTPConfig represent the SMTP relay used to send emails
+// no email is sent if Addr is empty
+type SMTPConfig struct {
+	Addr     string // i.e smtp.example.org:587, STARTTLS is used when supported
+	Username string // PLAIN authentication, disabled if empty
+	Password string
+	From     string
+}
+
+// Enabled determinate if the SMTP relay is configured
+func (sc SMTPConfig) Enabled() bool {
+	return sc.Addr != "" && sc.From != ""
+}
+
 // Load load configuration from given path
 func Load(path string) (Config, error) {
 	var config Config
@@ -100,6 +284,8 @@ func Load(path string) (Config, error) {
 		return Config{}, err
 	}
 
+	config.APIConfig.applyDefaults()
+
 	if !config.Valid() {
 		return Config{}, fmt.Errorf("invalid config file `%s`", path)
 	}
@@ -1,6 +1,12 @@
 package config
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestConfig_Valid(t *testing.T) {
 	c := Config{}
@@ -91,3 +97,49 @@ func TestDomainConfig_String(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestLoad_Defaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a configuration written before the rate limits were introduced
+	path := filepath.Join(dir, "opendydnsd.toml")
+	content := `
+[ApiConfig]
+  ListenAddr = "127.0.0.1:8888"
+  SigningKey = "test"
+
+  [ApiConfig.AliasRateLimit]
+    Requests = -1
+
+[DatabaseConfig]
+  Driver = "sqlite"
+  DSN = "test.db"
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.APIConfig.AuthRateLimit != DefaultConfig.APIConfig.AuthRateLimit {
+		t.Errorf("wrong auth rate limit: %+v", c.APIConfig.AuthRateLimit)
+	}
+	if c.APIConfig.AccountRateLimit != DefaultConfig.APIConfig.AccountRateLimit {
+		t.Errorf("wrong account rate limit: %+v", c.APIConfig.AccountRateLimit)
+	}
+	if c.APIConfig.Lockout != (LockoutConfig{Threshold: 5, Duration: time.Minute, MaxDuration: time.Hour}) {
+		t.Errorf("wrong lockout: %+v", c.APIConfig.Lockout)
+	}
+
+	// the explicitly disabled limits are kept
+	if c.APIConfig.AliasRateLimit.Enabled() {
+		t.Errorf("alias rate limit should be disabled: %+v", c.APIConfig.AliasRateLimit)
+	}
+}
@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/proto"
+	"net"
+)
+
+// addressRange represent a special purpose address range
+// that may be rejected by the address policy
+type addressRange struct {
+	name    string
+	nets    []*net.IPNet
+	allowed func(policy config.AddressPolicyConfig) bool
+}
+
+var addressRanges = []addressRange{
+	{
+		name:    "loopback",
+		nets:    mustParseCIDRs("127.0.0.0/8", "::1/128"),
+		allowed: func(p config.AddressPolicyConfig) bool { return p.AllowLoopback },
+	},
+	{
+		name:    "private",
+		nets:    mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"),
+		allowed: func(p config.AddressPolicyConfig) bool { return p.AllowPrivate },
+	},
+	{
+		name:    "link-local",
+		nets:    mustParseCIDRs("169.254.0.0/16", "fe80::/10"),
+		allowed: func(p config.AddressPolicyConfig) bool { return p.AllowLinkLocal },
+	},
+	{
+		name:    "cgnat",
+		nets:    mustParseCIDRs("100.64.0.0/10"),
+		allowed: func(p config.AddressPolicyConfig) bool { return p.AllowCGNAT },
+	},
+	{
+		name:    "multicast",
+		nets:    mustParseCIDRs("224.0.0.0/4", "ff00::/8"),
+		allowed: func(p config.AddressPolicyConfig) bool { return p.AllowMulticast },
+	},
+	{
+		name:    "documentation",
+		nets:    mustParseCIDRs("192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "2001:db8::/32"),
+		allowed: func(p config.AddressPolicyConfig) bool { return p.AllowDocumentation },
+	},
+}
+
+// parseAddress strictly parse given IPv4 / IPv6 address
+// unspecified & broadcast addresses are never valid alias values
+func parseAddress(value string) (net.IP, error) {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.IsUnspecified() || ip.Equal(net.IPv4bcast) {
+		return nil, proto.ErrInvalidAddress
+	}
+
+	return ip, nil
+}
+
+// checkAddressPolicy make sure given IP does not belong to a range
+// rejected by the address policy. It returns the name of the offending range
+func checkAddressPolicy(ip net.IP, policy config.AddressPolicyConfig) (string, error) {
+	for _, r := range addressRanges {
+		if r.allowed(policy) {
+			continue
+		}
+
+		for _, n := range r.nets {
+			if n.Contains(ip) {
+				return r.name, proto.ErrAddressNotAllowed
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
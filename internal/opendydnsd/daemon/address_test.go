@@ -0,0 +1,80 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/proto"
+	"net"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	for _, value := range []string{"8.8.8.8", "2001:4860:4860::8888", "::ffff:8.8.8.8"} {
+		if _, err := parseAddress(value); err != nil {
+			t.Errorf("parseAddress(%s) should not have failed", value)
+		}
+	}
+
+	for _, value := range []string{"", "1.2.3", "1.2.3.4.5", "256.1.1.1", "8.8.8.8 ", "fe80::1%eth0", "0.0.0.0", "::", "255.255.255.255", "example.org"} {
+		if _, err := parseAddress(value); err != proto.ErrInvalidAddress {
+			t.Errorf("parseAddress(%s) should have returned ErrInvalidAddress", value)
+		}
+	}
+}
+
+func TestCheckAddressPolicy(t *testing.T) {
+	rejected := map[string]string{
+		"127.0.0.1":       "loopback",
+		"::1":             "loopback",
+		"10.0.0.1":        "private",
+		"172.16.5.4":      "private",
+		"192.168.1.1":     "private",
+		"fd00::1":         "private",
+		"169.254.1.1":     "link-local",
+		"fe80::1":         "link-local",
+		"100.64.0.1":      "cgnat",
+		"224.0.0.1":       "multicast",
+		"ff02::1":         "multicast",
+		"192.0.2.1":       "documentation",
+		"198.51.100.1":    "documentation",
+		"203.0.113.1":     "documentation",
+		"2001:db8::1":     "documentation",
+		"::ffff:10.0.0.1": "private",
+	}
+
+	for value, expectedRange := range rejected {
+		rangeName, err := checkAddressPolicy(net.ParseIP(value), config.AddressPolicyConfig{})
+		if err != proto.ErrAddressNotAllowed {
+			t.Errorf("%s should have been rejected", value)
+		}
+		if rangeName != expectedRange {
+			t.Errorf("%s: wrong range %s (expected %s)", value, rangeName, expectedRange)
+		}
+	}
+
+	for _, value := range []string{"8.8.8.8", "1.1.1.1", "2001:4860:4860::8888", "172.32.0.1", "100.128.0.1"} {
+		if _, err := checkAddressPolicy(net.ParseIP(value), config.AddressPolicyConfig{}); err != nil {
+			t.Errorf("%s should have been allowed", value)
+		}
+	}
+}
+
+func TestCheckAddressPolicy_Allowed(t *testing.T) {
+	policy := config.AddressPolicyConfig{
+		AllowLoopback:      true,
+		AllowPrivate:       true,
+		AllowLinkLocal:     true,
+		AllowCGNAT:         true,
+		AllowMulticast:     true,
+		AllowDocumentation: true,
+	}
+
+	for _, value := range []string{"127.0.0.1", "10.0.0.1", "fe80::1", "100.64.0.1", "224.0.0.1", "2001:db8::1"} {
+		if _, err := checkAddressPolicy(net.ParseIP(value), policy); err != nil {
+			t.Errorf("%s should have been allowed", value)
+		}
+	}
+
+	if _, err := checkAddressPolicy(net.ParseIP("10.0.0.1"), config.AddressPolicyConfig{AllowLoopback: true}); err == nil {
+		t.Error("10.0.0.1 should have been rejected")
+	}
+}
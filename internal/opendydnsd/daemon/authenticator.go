@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+)
+
+// The supported authentication backends
+const (
+	authBackendLocal = "local"
+	authBackendLDAP  = "ldap"
+)
+
+// Authenticator check the user credentials against an authentication backend
+// and return the matching local user. proto.ErrInvalidParameters is returned
+// if the credentials are invalid
+type Authenticator interface {
+	Authenticate(email, password string) (database.User, error)
+	// Refresh check the user is still allowed by the backend when its session is refreshed
+	// and return the (synchronized) user. proto.ErrInvalidParameters is returned if it is not
+	Refresh(user database.User) (database.User, error)
+}
+
+// newAuthenticator return the authenticator of the configured backend
+func (d *daemon) newAuthenticator(conf config.AuthenticationConfig) (Authenticator, error) {
+	switch conf.Backend {
+	case "", authBackendLocal:
+		return &localAuthenticator{d: d}, nil
+	case authBackendLDAP:
+		return newLDAPAuthenticator(conf.LDAP, d.conn, d.logger, d.ldapDial)
+	default:
+		return nil, fmt.Errorf("unknown authentication backend: %s", conf.Backend)
+	}
+}
+
+// validateAuthenticationConfig make sure the authentication backend can be used
+// the passwords of the external backends cannot be managed by the daemon
+func (d *daemon) validateAuthenticationConfig(conf config.DaemonConfig) error {
+	if _, err := d.newAuthenticator(conf.Authentication); err != nil {
+		return err
+	}
+
+	if isLocalBackend(conf.Authentication) {
+		return nil
+	}
+
+	if conf.Signup.Enabled {
+		return fmt.Errorf("signup requires the local authentication backend")
+	}
+	if conf.PasswordReset.Enabled {
+		return fmt.Errorf("password reset requires the local authentication backend")
+	}
+
+	return nil
+}
+
+// getAuthenticator return the authenticator of the current configuration
+func (d *daemon) getAuthenticator() (Authenticator, error) {
+	return d.newAuthenticator(d.getConfig().Authentication)
+}
+
+// checkLocalBackend return ErrPasswordManagedExternally if the passwords are not managed by the daemon
+func (d *daemon) checkLocalBackend() error {
+	if !isLocalBackend(d.getConfig().Authentication) {
+		return proto.ErrPasswordManagedExternally
+	}
+
+	return nil
+}
+
+func isLocalBackend(conf config.AuthenticationConfig) bool {
+	return conf.Backend == "" || conf.Backend == authBackendLocal
+}
+
+// localAuthenticator validate the credentials using the bcrypt hash stored in the database
+type localAuthenticator struct {
+	d *daemon
+}
+
+func (la *localAuthenticator) Authenticate(email, password string) (database.User, error) {
+	user, err := la.d.conn.FindUser(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		metrics.AuthenticationFailures.WithLabelValues("password").Inc()
+		return database.User{}, proto.ErrInvalidParameters // not 404 to prevent email discovery
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	// Validate the password
+	if !la.d.validatePassword(user.Password, password) {
+		la.d.logger.Warn().Msg("invalid authentication request: invalid password.")
+		metrics.AuthenticationFailures.WithLabelValues("password").Inc()
+		return database.User{}, proto.ErrInvalidParameters // not 404 to prevent email discovery
+	}
+
+	return user, nil
+}
+
+// Refresh return the user as is: the local accounts are already checked by the daemon
+func (la *localAuthenticator) Refresh(user database.User) (database.User, error) {
+	return user, nil
+}
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"testing"
+)
+
+func newLDAPConfig() config.AuthenticationConfig {
+	return config.AuthenticationConfig{
+		Backend: authBackendLDAP,
+		LDAP: config.LDAPConfig{
+			URL:        "ldaps://ldap.example.org",
+			BaseDN:     "ou=people,dc=example,dc=org",
+			UserFilter: "(mail=%s)",
+		},
+	}
+}
+
+func TestDaemon_ValidateAuthenticationConfig(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	d := &daemon{logger: &logger, conn: database_mock.NewMockConnection(mockCtrl)}
+
+	for _, conf := range []config.DaemonConfig{
+		{},
+		{Authentication: config.AuthenticationConfig{Backend: authBackendLocal}, Signup: config.SignupConfig{Enabled: true}},
+		{Authentication: newLDAPConfig()},
+	} {
+		if err := d.validateAuthenticationConfig(conf); err != nil {
+			t.Errorf("validateAuthenticationConfig() should have succeeded: %v", err)
+		}
+	}
+
+	for _, conf := range []config.DaemonConfig{
+		{Authentication: config.AuthenticationConfig{Backend: "kerberos"}},
+		{Authentication: config.AuthenticationConfig{Backend: authBackendLDAP}},
+		{Authentication: newLDAPConfig(), Signup: config.SignupConfig{Enabled: true}},
+		{Authentication: newLDAPConfig(), PasswordReset: config.PasswordResetConfig{Enabled: true}},
+	} {
+		if err := d.validateAuthenticationConfig(conf); err == nil {
+			t.Errorf("validateAuthenticationConfig() should have failed: %+v", conf.Authentication)
+		}
+	}
+}
+
+func TestDaemon_PasswordManagedExternally(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	d := &daemon{
+		logger: &logger,
+		conn:   database_mock.NewMockConnection(mockCtrl),
+		config: config.DaemonConfig{Authentication: newLDAPConfig()},
+	}
+
+	if _, err := d.CreateUser(proto.CredentialsDto{Email: "luna@example.org", Password: "test"}, false); err != proto.ErrPasswordManagedExternally {
+		t.Errorf("CreateUser() should have returned ErrPasswordManagedExternally: %v", err)
+	}
+
+	if err := d.ChangePassword(proto.UserContext{UserID: 12}, proto.ChangePasswordDto{CurrentPassword: "test", NewPassword: "new"}); err != proto.ErrPasswordManagedExternally {
+		t.Errorf("ChangePassword() should have returned ErrPasswordManagedExternally: %v", err)
+	}
+
+	if err := d.ResetPassword(12, "new"); err != proto.ErrPasswordManagedExternally {
+		t.Errorf("ResetPassword() should have returned ErrPasswordManagedExternally: %v", err)
+	}
+}
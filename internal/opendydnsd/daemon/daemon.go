@@ -1,29 +1,67 @@
 package daemon
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/mail"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
 	"github.com/creekorful/open-dydns/proto"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
 //go:generate mockgen -source daemon.go -destination=../daemon_mock/daemon_mock.go -package=daemon_mock
 
 // Daemon represent OpenDyDNSD
 type Daemon interface {
-	CreateUser(cred proto.CredentialsDto) (proto.UserContext, error)
-	Authenticate(cred proto.CredentialsDto) (proto.UserContext, error)
+	CreateUser(cred proto.CredentialsDto, admin bool) (proto.UserDto, error)
+	Authenticate(cred proto.CredentialsDto) (proto.UserContext, string, error)
+	AuthenticatePassword(cred proto.CredentialsDto) (proto.UserContext, error)
+	VerifyTwoFactor(challenge proto.TwoFactorDto) (proto.UserContext, error)
+	GetOIDCConfig() (proto.OIDCConfigDto, error)
+	AuthenticateOIDC(idToken string) (proto.UserContext, string, error)
+	CheckUserContext(userCtx proto.UserContext, issuedAt time.Time) error
+	CreateSession(userCtx proto.UserContext, userAgent, ip string) (proto.UserContext, string, error)
+	RefreshSession(refreshToken string) (proto.UserContext, string, error)
+	GetSessions(userCtx proto.UserContext) ([]proto.SessionDto, error)
+	RevokeSession(userCtx proto.UserContext, sessionID uint) error
+	GetUsers() ([]proto.UserDto, error)
+	SetUserDisabled(userID uint, disabled bool) error
+	ResetPassword(userID uint, password string) error
+	DeleteUser(userID uint) error
+	SignUp(cred proto.CredentialsDto) error
+	VerifyEmail(token string) error
+	ChangePassword(userCtx proto.UserContext, password proto.ChangePasswordDto) error
+	RequestPasswordReset(email string) error
+	CompletePasswordReset(reset proto.PasswordResetDto) error
+	SetupTwoFactor(userCtx proto.UserContext) (proto.TwoFactorSetupDto, error)
+	EnableTwoFactor(userCtx proto.UserContext, code string) (proto.RecoveryCodesDto, error)
+	DisableTwoFactor(userCtx proto.UserContext, code string) error
+	RegenerateRecoveryCodes(userCtx proto.UserContext, code string) (proto.RecoveryCodesDto, error)
 	GetAliases(userCtx proto.UserContext) ([]proto.AliasDto, error)
 	RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto) (proto.AliasDto, error)
 	UpdateAlias(userCtx proto.UserContext, alias proto.AliasDto) (proto.AliasDto, error)
 	DeleteAlias(userCtx proto.UserContext, aliasName string) error
+	CreateUpdateToken(userCtx proto.UserContext, aliasName string) (proto.UpdateTokenDto, error)
+	GetUpdateTokens(userCtx proto.UserContext, aliasName string) ([]proto.UpdateTokenDto, error)
+	RevokeUpdateToken(userCtx proto.UserContext, aliasName string, tokenID uint) error
+	AuthenticateUpdateToken(token, aliasName string) (proto.UserContext, error)
 	GetDomains(userCtx proto.UserContext) ([]proto.DomainDto, error)
+	Reconcile(dryRun bool) (proto.ReconcileReportDto, error)
+	CheckReadiness(checkProvisioners bool) proto.ReadinessDto
+	Start() error
+	Reload(conf config.DaemonConfig) error
+	Shutdown(ctx context.Context) error
 	Logger() *zerolog.Logger
 }
 
@@ -31,7 +69,21 @@ type daemon struct {
 	conn        database.Connection
 	logger      *zerolog.Logger
 	config      config.DaemonConfig
+	configLock  sync.RWMutex
 	dnsProvider dns.Provider
+	dnsServer   *dns.Server
+	mailer      mail.Mailer // nil if no SMTP relay configured
+	oidc        oidcVerifier
+	ldapDial    func(conf config.LDAPConfig) (ldapConn, error)
+	stop        chan struct{}
+	stopOnce    sync.Once
+	workers     sync.WaitGroup
+	// shutdownOnce make Shutdown release the resources only once
+	shutdownOnce sync.Once
+	// notifications are the emails being sent in background, see notify
+	notifications sync.WaitGroup
+	// aliasesCollector expose the number of aliases per domain, unregistered on shutdown
+	aliasesCollector prometheus.Collector
 }
 
 // NewDaemon return a new Daemon instance with given configuration
@@ -43,95 +95,204 @@ func NewDaemon(c config.Config, logger *zerolog.Logger) (Daemon, error) {
 	}
 	logger.Info().Str("Driver", c.DatabaseConfig.Driver).Msg("database connection established!")
 
+	// Refuse to work on an outdated schema
+	pending, err := database.PendingMigrations(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if len(pending) > 0 {
+		logger.Error().Int("Pending", len(pending)).Msg("database schema is out of date.")
+		_ = conn.Close()
+		return nil, database.ErrSchemaOutdated
+	}
+
 	d := &daemon{
 		conn:        conn,
 		logger:      logger,
 		config:      c.DaemonConfig,
 		dnsProvider: dns.NewProvider(),
+		ldapDial:    dialLDAP,
+	}
+
+	if c.SMTPConfig.Enabled() {
+		d.mailer = mail.NewMailer(c.SMTPConfig)
+	}
+
+	if err := d.validateSignupConfig(c.DaemonConfig.Signup); err != nil {
+		logger.Err(err).Msg("invalid signup configuration.")
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := d.validatePasswordResetConfig(c.DaemonConfig.PasswordReset); err != nil {
+		logger.Err(err).Msg("invalid password reset configuration.")
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := d.validateAuthenticationConfig(c.DaemonConfig); err != nil {
+		logger.Err(err).Msg("invalid authentication configuration.")
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// Expose the number of aliases per domain
+	// registered last since the collector use the database connection
+	d.aliasesCollector = metrics.NewAliasesCollector(conn.CountDomainAliases)
+	if err := prometheus.Register(d.aliasesCollector); err != nil {
+		_ = conn.Close()
+		return nil, err
 	}
 
 	return d, nil
 }
 
-func (d *daemon) CreateUser(cred proto.CredentialsDto) (proto.UserContext, error) {
+func (d *daemon) CreateUser(cred proto.CredentialsDto, admin bool) (proto.UserDto, error) {
 	if cred.Email == "" || cred.Password == "" {
 		d.logger.Warn().Msg("invalid create user request: bad request.")
-		return proto.UserContext{}, proto.ErrInvalidParameters
+		return proto.UserDto{}, proto.ErrInvalidParameters
+	}
+
+	// the users of the external backends are created on first login
+	if err := d.checkLocalBackend(); err != nil {
+		return proto.UserDto{}, err
 	}
 
 	// Make sure user doesn't already exist
 	_, err := d.conn.FindUser(cred.Email)
-	if err != nil && !errors.As(err, &gorm.ErrRecordNotFound) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		d.logger.Err(err).Msg("error while fetching database.")
-		return proto.UserContext{}, err
+		return proto.UserDto{}, err
 	} else if err == nil {
 		d.logger.Warn().Msg("email address already taken.")
-		return proto.UserContext{}, proto.ErrInvalidParameters // not 409 to prevent email discovery
+		return proto.UserDto{}, proto.ErrInvalidParameters // not 409 to prevent email discovery
 	}
 
 	// Doesn't exist yet!
 	pass, err := d.hashPassword(cred.Password)
 	if err != nil {
-		return proto.UserContext{}, err
+		return proto.UserDto{}, err
 	}
 
-	if _, err := d.conn.CreateUser(cred.Email, pass); err != nil {
-		return proto.UserContext{}, err
+	// the users created by an admin are trusted
+	user, err := d.conn.CreateUser(database.User{Email: cred.Email, Password: pass, Admin: admin, Verified: true})
+	if err != nil {
+		d.logger.Err(err).Msg("error while creating user.")
+		return proto.UserDto{}, err
 	}
 
-	return d.Authenticate(cred)
+	return newUserDto(user), nil
 }
 
-func (d *daemon) Authenticate(cred proto.CredentialsDto) (proto.UserContext, error) {
-	if cred.Email == "" || cred.Password == "" {
-		d.logger.Warn().Msg("invalid authentication request: bad request.")
-		return proto.UserContext{}, proto.ErrInvalidParameters
+// Authenticate validate the user credentials
+// if the user enabled the two-factor authentication, the returned user context is empty
+// and a two-factor challenge token is returned instead: see VerifyTwoFactor
+func (d *daemon) Authenticate(cred proto.CredentialsDto) (proto.UserContext, string, error) {
+	user, err := d.authenticateCredentials(cred)
+	if err != nil {
+		return proto.UserContext{}, "", err
 	}
 
-	user, err := d.conn.FindUser(cred.Email)
-	if errors.As(err, &gorm.ErrRecordNotFound) {
-		return proto.UserContext{}, proto.ErrInvalidParameters // not 404 to prevent email discovery
+	if user.TOTPEnabled {
+		challenge, err := d.createTwoFactorChallenge(user)
+		if err != nil {
+			return proto.UserContext{}, "", err
+		}
+
+		d.logger.Debug().Str("Email", user.Email).Msg("password validated, two-factor authentication required.")
+		return proto.UserContext{}, challenge, nil
 	}
+
+	d.logger.Debug().Str("Email", user.Email).Msg("successfully authenticated.")
+
+	return proto.UserContext{
+		UserID: user.ID,
+		Admin:  user.Admin,
+	}, "", nil
+}
+
+// AuthenticatePassword validate the user credentials without starting a two-factor challenge
+// the pending challenges of the user are left untouched: ErrTwoFactorRequired is returned
+// if the user enabled the two-factor authentication (used by the DynDNS2 endpoint)
+func (d *daemon) AuthenticatePassword(cred proto.CredentialsDto) (proto.UserContext, error) {
+	user, err := d.authenticateCredentials(cred)
 	if err != nil {
 		return proto.UserContext{}, err
 	}
 
-	// Validate the password
-	if !d.validatePassword(user.Password, cred.Password) {
-		d.logger.Warn().Msg("invalid authentication request: invalid password.")
-		return proto.UserContext{}, proto.ErrInvalidParameters // not 404 to prevent email discovery
+	if user.TOTPEnabled {
+		d.logger.Warn().Str("Email", user.Email).Msg("invalid authentication request: two-factor authentication required.")
+		return proto.UserContext{}, proto.ErrTwoFactorRequired
 	}
 
 	d.logger.Debug().Str("Email", user.Email).Msg("successfully authenticated.")
 
 	return proto.UserContext{
 		UserID: user.ID,
+		Admin:  user.Admin,
 	}, nil
 }
 
+// authenticateCredentials validate the user credentials against the configured backend
+// and return the matching user, if allowed to log in
+func (d *daemon) authenticateCredentials(cred proto.CredentialsDto) (database.User, error) {
+	if cred.Email == "" || cred.Password == "" {
+		d.logger.Warn().Msg("invalid authentication request: bad request.")
+		return database.User{}, proto.ErrInvalidParameters
+	}
+
+	authenticator, err := d.getAuthenticator()
+	if err != nil {
+		return database.User{}, err
+	}
+
+	// Validate the credentials against the configured backend
+	user, err := authenticator.Authenticate(cred.Email, cred.Password)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if user.Disabled {
+		d.logger.Warn().Str("Email", user.Email).Msg("invalid authentication request: user disabled.")
+		metrics.AuthenticationFailures.WithLabelValues("password").Inc()
+		return database.User{}, proto.ErrUserDisabled
+	}
+
+	if !user.Verified {
+		d.logger.Warn().Str("Email", user.Email).Msg("invalid authentication request: email not verified.")
+		metrics.AuthenticationFailures.WithLabelValues("password").Inc()
+		return database.User{}, proto.ErrEmailNotVerified
+	}
+
+	return user, nil
+}
+
 func (d *daemon) GetAliases(userCtx proto.UserContext) ([]proto.AliasDto, error) {
 	aliases, err := d.conn.FindUserAliases(userCtx.UserID)
 
-	if err != nil && !errors.As(err, &gorm.ErrRecordNotFound) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		d.logger.Err(err).Msg("error while fetching database.")
 		return nil, err
 	}
 
 	var aliasesDto []proto.AliasDto
 	for _, alias := range aliases {
-		aliasesDto = append(aliasesDto, newAliasDto(alias))
+		aliasesDto = append(aliasesDto, newAliasDtos(alias)...)
 	}
 
 	return aliasesDto, nil
 }
 
 func (d *daemon) RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto) (proto.AliasDto, error) {
-	if !isAliasValid(alias) {
-		d.logger.Warn().Msg("invalid register alias request: bad request.")
-		return proto.AliasDto{}, proto.ErrInvalidParameters
+	alias, err := d.validateAlias(alias)
+	if err != nil {
+		d.logger.Warn().Err(err).Msg("invalid register alias request: bad request.")
+		return proto.AliasDto{}, err
 	}
 
 	a := newAlias(alias)
+	recordType := getRecordType(alias.Value)
 
 	provisioner, domainConf, err := d.findDNSProvisioner(a.Domain)
 	if err != nil {
@@ -142,7 +303,7 @@ func (d *daemon) RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto)
 	res, err := d.conn.FindAlias(a.Host, a.Domain)
 
 	// technical error
-	if err != nil && !errors.As(err, &gorm.ErrRecordNotFound) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		d.logger.Err(err).Msg("error while fetching database.")
 		return proto.AliasDto{}, err
 	}
@@ -158,35 +319,79 @@ func (d *daemon) RegisterAlias(userCtx proto.UserContext, alias proto.AliasDto)
 		return proto.AliasDto{}, proto.ErrAliasAlreadyExist
 	}
 
-	// alias available: perform registration
+	// alias available: reserve it first, the database guarantee
+	// that only one user can register it
+	created, err := d.conn.CreateAlias(a, userCtx.UserID)
+	if errors.Is(err, database.ErrDuplicateAlias) {
+		d.logger.Debug().Msg("alias taken.")
+		return proto.AliasDto{}, proto.ErrAliasTaken
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while creating alias.")
+		return proto.AliasDto{}, err
+	}
+	a = created
+
 	host, domain := getRealHostAndDomain(alias, domainConf)
-	if err := provisioner.AddRecord(host, domain, a.Value); err != nil {
+	if err := provisioner.AddRecord(host, domain, recordType, alias.Value); err != nil {
 		d.logger.Err(err).
 			Str("Domain", domain).
 			Str("Host", host).
-			Str("Value", a.Value).
+			Str("Type", recordType).
+			Str("Value", alias.Value).
 			Msg("error while adding DNS record.")
-		return proto.AliasDto{}, err
-	}
 
-	a, err = d.conn.CreateAlias(newAlias(alias), userCtx.UserID)
-	if err != nil {
+		// the provider may have applied the record anyway (i.e on timeout): its deletion is retried by the outbox
+		d.enqueueDNSOperation(database.DNSOperation{
+			Action:     dnsActionDelete,
+			Host:       a.Host,
+			Domain:     a.Domain,
+			RecordType: recordType,
+			Attempts:   1,
+			LastError:  err.Error(),
+		})
+
+		d.releaseAlias(a, userCtx.UserID)
 		return proto.AliasDto{}, err
 	}
+	d.clearDNSOperations(a.Host, a.Domain, recordType)
+
 	d.logger.Info().
 		Uint("UserID", userCtx.UserID).
 		Str("Domain", a.Domain).
 		Str("Host", a.Host).
-		Str("Value", a.Value).
+		Str("Type", recordType).
+		Str("Value", alias.Value).
 		Msg("new alias created.")
+	metrics.AliasOperations.WithLabelValues("register").Inc()
+
+	return newAliasDto(a, recordType), nil
+}
+
+// releaseAlias delete the alias reserved by RegisterAlias, retrying on failure
+func (d *daemon) releaseAlias(alias database.Alias, userID uint) {
+	var err error
+	for attempt := 1; attempt <= releaseAliasAttempts; attempt++ {
+		if err = d.conn.DeleteAlias(alias.Host, alias.Domain, userID); err == nil {
+			return
+		}
 
-	return newAliasDto(a), nil
+		d.logger.Warn().Err(err).Str("Domain", alias.Domain).Str("Host", alias.Host).Int("Attempt", attempt).
+			Msg("error while releasing alias.")
+		if attempt < releaseAliasAttempts {
+			time.Sleep(releaseAliasRetryDelay)
+		}
+	}
+
+	d.logger.Err(err).Str("Domain", alias.Domain).Str("Host", alias.Host).
+		Msg("unable to release alias. the alias is kept without DNS record.")
 }
 
 func (d *daemon) UpdateAlias(userCtx proto.UserContext, alias proto.AliasDto) (proto.AliasDto, error) {
-	if !isAliasValid(alias) {
-		d.logger.Warn().Msg("invalid update alias request: bad request.")
-		return proto.AliasDto{}, proto.ErrInvalidParameters
+	alias, err := d.validateAlias(alias)
+	if err != nil {
+		d.logger.Warn().Err(err).Msg("invalid update alias request: bad request.")
+		return proto.AliasDto{}, err
 	}
 
 	al, err := d.findUserAlias(alias, userCtx.UserID)
@@ -194,6 +399,11 @@ func (d *daemon) UpdateAlias(userCtx proto.UserContext, alias proto.AliasDto) (p
 		return proto.AliasDto{}, err
 	}
 
+	// Remember if a record of this type already exist
+	recordType := getRecordType(alias.Value)
+	previousValue := getAliasValue(al, recordType)
+	recordExist := previousValue != ""
+
 	// Update the alias
 	updateAlias(&al, alias)
 
@@ -203,34 +413,57 @@ func (d *daemon) UpdateAlias(userCtx proto.UserContext, alias proto.AliasDto) (p
 		return proto.AliasDto{}, err
 	}
 
+	// Create the record if the alias does not hold a value of this type yet
 	host, domain := getRealHostAndDomain(alias, domainConf)
-	if err := provisioner.UpdateRecord(host, domain, al.Value); err != nil {
+	if recordExist {
+		err = provisioner.UpdateRecord(host, domain, recordType, alias.Value)
+	} else {
+		err = provisioner.AddRecord(host, domain, recordType, alias.Value)
+	}
+	if err != nil {
 		d.logger.Err(err).
 			Str("Domain", domain).
 			Str("Host", host).
-			Str("Value", al.Value).
+			Str("Type", recordType).
+			Str("Value", alias.Value).
 			Msg("error while updating DNS record.")
 		return proto.AliasDto{}, err
 	}
 
-	al, err = d.conn.UpdateAlias(al)
+	updated, err := d.conn.UpdateAlias(al)
 	if err != nil {
 		d.logger.Err(err).Msg("error while updating alias.")
+
+		// Revert the DNS change
+		op := database.DNSOperation{Action: dnsActionDelete, Host: al.Host, Domain: al.Domain, RecordType: recordType}
+		if recordExist {
+			op.Action = dnsActionUpdate
+			op.Value = previousValue
+		}
+		d.compensate(op)
+
 		return proto.AliasDto{}, err
 	}
+	al = updated
+	d.clearDNSOperations(al.Host, al.Domain, recordType)
 
 	d.logger.Info().
 		Uint("UserID", userCtx.UserID).
 		Str("Domain", al.Domain).
 		Str("Host", al.Host).
+		Str("Type", recordType).
 		Str("Value", alias.Value).
 		Msg("successfully updated alias.")
+	metrics.AliasOperations.WithLabelValues("update").Inc()
 
-	return newAliasDto(al), err
+	return newAliasDto(al, recordType), err
 }
 
 func (d *daemon) DeleteAlias(userCtx proto.UserContext, aliasName string) error {
-	a := newAlias(proto.AliasDto{Domain: aliasName})
+	a, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
+	if err != nil {
+		return err
+	}
 
 	provisioner, domainConf, err := d.findDNSProvisioner(a.Domain)
 	if err != nil {
@@ -238,13 +471,20 @@ func (d *daemon) DeleteAlias(userCtx proto.UserContext, aliasName string) error
 		return err
 	}
 
+	// Delete each record held by the alias
 	host, domain := getRealHostAndDomain(proto.AliasDto{Domain: aliasName}, domainConf)
-	if err := provisioner.DeleteRecord(host, domain); err != nil {
-		d.logger.Err(err).
-			Str("Domain", domain).
-			Str("Host", host).
-			Msg("error while deleting DNS record.")
-		return err
+	var deleted []proto.AliasDto
+	for _, dto := range newAliasDtos(a) {
+		if err := provisioner.DeleteRecord(host, domain, dto.Type); err != nil {
+			d.logger.Err(err).
+				Str("Domain", domain).
+				Str("Host", host).
+				Str("Type", dto.Type).
+				Msg("error while deleting DNS record.")
+			d.restoreRecords(a, deleted)
+			return err
+		}
+		deleted = append(deleted, dto)
 	}
 
 	if err := d.conn.DeleteAlias(a.Host, a.Domain, userCtx.UserID); err != nil {
@@ -252,22 +492,41 @@ func (d *daemon) DeleteAlias(userCtx proto.UserContext, aliasName string) error
 			Str("Domain", a.Domain).
 			Str("Host", a.Host).
 			Msg("unable to delete alias.")
+		d.restoreRecords(a, deleted)
 		return err
 	}
 
+	for _, dto := range deleted {
+		d.clearDNSOperations(a.Host, a.Domain, dto.Type)
+	}
+
 	d.logger.Info().
 		Uint("UserID", userCtx.UserID).
 		Str("Domain", a.Domain).
 		Str("Host", a.Host).
 		Msg("successfully deleted alias.")
+	metrics.AliasOperations.WithLabelValues("delete").Inc()
 
 	return nil
 }
 
+// restoreRecords re-create the given (deleted) records of the alias
+func (d *daemon) restoreRecords(alias database.Alias, records []proto.AliasDto) {
+	for _, dto := range records {
+		d.compensate(database.DNSOperation{
+			Action:     dnsActionAdd,
+			Host:       alias.Host,
+			Domain:     alias.Domain,
+			RecordType: dto.Type,
+			Value:      dto.Value,
+		})
+	}
+}
+
 func (d *daemon) GetDomains(_ proto.UserContext) ([]proto.DomainDto, error) {
 	var domains []proto.DomainDto
 
-	for _, dnsProvisioner := range d.config.DNSProvisioners {
+	for _, dnsProvisioner := range d.getConfig().DNSProvisioners {
 		for _, domain := range dnsProvisioner.Domains {
 			domains = append(domains, proto.DomainDto{
 				Domain: domain.String(),
@@ -278,10 +537,227 @@ func (d *daemon) GetDomains(_ proto.UserContext) ([]proto.DomainDto, error) {
 	return domains, nil
 }
 
+// Start the daemon background services (pending DNS operations worker, expired sessions purge,
+// reconciler, built-in DNS server)
+func (d *daemon) Start() error {
+	conf := d.getConfig()
+	d.stop = make(chan struct{})
+
+	interval := conf.OutboxInterval
+	if interval <= 0 {
+		interval = defaultOutboxInterval
+	}
+	d.workers.Add(1)
+	go d.runOutbox(interval)
+
+	d.workers.Add(1)
+	go d.runSessionsPurge(sessionsPurgeInterval)
+
+	if conf.Reconciler.Interval > 0 {
+		d.workers.Add(1)
+		go d.runReconciler(conf.Reconciler.Interval)
+	}
+
+	if conf.DNSServer.ListenAddr == "" {
+		return nil
+	}
+
+	zones, err := getZones(conf)
+	if err != nil {
+		d.stopWorkers()
+		return err
+	}
+
+	dnsServer := dns.NewServer(conf.DNSServer.ListenAddr, zones, d.conn, d.logger)
+	if err := dnsServer.Start(); err != nil {
+		d.stopWorkers()
+		return err
+	}
+	d.dnsServer = dnsServer
+
+	d.logger.Info().
+		Str("Addr", conf.DNSServer.ListenAddr).
+		Int("Zones", len(zones)).
+		Msg("built-in DNS server started.")
+
+	return nil
+}
+
+// Reload validate given configuration and replace the current one (provisioners,
+// domains and zones served by the DNS server) if valid.
+// the background services intervals and the DNS server address are not reloaded
+func (d *daemon) Reload(conf config.DaemonConfig) error {
+	// Block the readers while validating to prevent
+	// an alias registration on a domain being removed
+	d.configLock.Lock()
+	defer d.configLock.Unlock()
+
+	if err := d.validateConfig(d.config, conf); err != nil {
+		d.logger.Err(err).Msg("invalid daemon configuration.")
+		return err
+	}
+
+	zones, err := getZones(conf)
+	if err != nil {
+		d.logger.Err(err).Msg("invalid daemon configuration.")
+		return err
+	}
+
+	d.config = conf
+	if d.dnsServer != nil {
+		d.dnsServer.SetZones(zones)
+	}
+
+	d.logger.Info().Int("Zones", len(zones)).Msg("daemon configuration reloaded.")
+
+	return nil
+}
+
+// validateConfig make sure the new configuration can replace the current one:
+// every provisioner must be valid and the removed domains must not have aliases
+func (d *daemon) validateConfig(current, conf config.DaemonConfig) error {
+	if err := d.validateSignupConfig(conf.Signup); err != nil {
+		return err
+	}
+
+	if err := d.validatePasswordResetConfig(conf.PasswordReset); err != nil {
+		return err
+	}
+
+	if err := d.validateAuthenticationConfig(conf); err != nil {
+		return err
+	}
+
+	domains := map[string]bool{}
+	for _, dnsProvisioner := range conf.DNSProvisioners {
+		for _, domainConf := range dnsProvisioner.Domains {
+			domain := domainConf.String()
+			if domains[domain] {
+				return fmt.Errorf("domain %s is configured twice", domain)
+			}
+			domains[domain] = true
+
+			if _, err := d.dnsProvider.GetProvisioner(dnsProvisioner.Name, mergeConfig(dnsProvisioner.Config, domainConf.Config)); err != nil {
+				return fmt.Errorf("invalid provisioner %s for domain %s: %s", dnsProvisioner.Name, domain, err)
+			}
+		}
+	}
+
+	var counts map[string]int64
+	for _, dnsProvisioner := range current.DNSProvisioners {
+		for _, domainConf := range dnsProvisioner.Domains {
+			domain := domainConf.String()
+			if domains[domain] {
+				continue
+			}
+
+			if counts == nil {
+				var err error
+				if counts, err = d.conn.CountDomainAliases(); err != nil {
+					return err
+				}
+			}
+
+			// the aliases of the removed domain, or of a nested name no longer configured
+			var count int64
+			for aliasDomain, aliases := range counts {
+				if !domains[aliasDomain] && (aliasDomain == domain || strings.HasSuffix(aliasDomain, "."+domain)) {
+					count += aliases
+				}
+			}
+			if count > 0 {
+				return fmt.Errorf("domain %s cannot be removed: %d alias(es) still exist", domain, count)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Shutdown stop the daemon background services, try a last time
+// to apply the pending DNS operations and close the database connection
+// the next calls do nothing and return nil
+func (d *daemon) Shutdown(ctx context.Context) error {
+	var err error
+	d.shutdownOnce.Do(func() {
+		err = d.shutdown(ctx)
+	})
+
+	return err
+}
+
+// stopWorkers stop the background services and wait for them to complete
+// used when the daemon fails to start
+func (d *daemon) stopWorkers() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	d.workers.Wait()
+}
+
+func (d *daemon) shutdown(ctx context.Context) error {
+	d.logger.Debug().Msg("shutting down daemon.")
+
+	// Wait for the background services to complete their current run
+	if d.stop != nil {
+		d.stopOnce.Do(func() {
+			close(d.stop)
+		})
+
+		done := make(chan struct{})
+		go func() {
+			d.workers.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			// Flush the pending DNS operations
+			if err := d.processDNSOperations(); err != nil {
+				d.logger.Err(err).Msg("error while processing pending DNS operations.")
+			}
+		case <-ctx.Done():
+			d.logger.Warn().Msg("timeout while waiting for the background services.")
+		}
+	}
+
+	// Wait for the emails being sent
+	done := make(chan struct{})
+	go func() {
+		d.notifications.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.logger.Warn().Msg("timeout while waiting for the emails being sent.")
+	}
+
+	if d.dnsServer != nil {
+		if err := d.dnsServer.Shutdown(); err != nil {
+			d.logger.Err(err).Msg("error while shutting down the DNS server.")
+		}
+	}
+
+	if d.aliasesCollector != nil {
+		prometheus.Unregister(d.aliasesCollector)
+	}
+
+	return d.conn.Close()
+}
+
 func (d *daemon) Logger() *zerolog.Logger {
 	return d.logger
 }
 
+func (d *daemon) getConfig() config.DaemonConfig {
+	d.configLock.RLock()
+	defer d.configLock.RUnlock()
+
+	return d.config
+}
+
 func (d *daemon) hashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
 	if err != nil {
@@ -301,11 +777,35 @@ func (d *daemon) validatePassword(hashedPassword, plainPassword string) bool {
 	return true
 }
 
+// validateAlias make sure given alias is valid and that his value
+// is allowed by the address policy. The returned alias has his value normalized
+func (d *daemon) validateAlias(alias proto.AliasDto) (proto.AliasDto, error) {
+	if !isAliasValid(alias) {
+		return proto.AliasDto{}, proto.ErrInvalidParameters
+	}
+
+	ip, err := parseAddress(alias.Value)
+	if err != nil {
+		return proto.AliasDto{}, err
+	}
+
+	if rangeName, err := checkAddressPolicy(ip, d.getConfig().AddressPolicy); err != nil {
+		d.logger.Warn().
+			Str("Value", alias.Value).
+			Str("Range", rangeName).
+			Msg("address rejected by policy.")
+		return proto.AliasDto{}, err
+	}
+
+	alias.Value = ip.String()
+	return alias, nil
+}
+
 func (d *daemon) findUserAlias(alias proto.AliasDto, userID uint) (database.Alias, error) {
 	a := newAlias(alias)
 	al, err := d.conn.FindAlias(a.Host, a.Domain)
 	if err != nil {
-		if errors.As(err, &gorm.ErrRecordNotFound) {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return database.Alias{}, proto.ErrAliasNotFound
 		}
 
@@ -320,10 +820,10 @@ func (d *daemon) findUserAlias(alias proto.AliasDto, userID uint) (database.Alia
 }
 
 func (d *daemon) findDNSProvisioner(domain string) (dns.Provisioner, config.DomainConfig, error) {
-	for _, dnsProvisioner := range d.config.DNSProvisioners {
+	for _, dnsProvisioner := range d.getConfig().DNSProvisioners {
 		for _, domainConf := range dnsProvisioner.Domains {
 			if domainConf.String() == domain {
-				p, err := d.dnsProvider.GetProvisioner(dnsProvisioner.Name, dnsProvisioner.Config)
+				p, err := d.getProvisioner(dnsProvisioner, domainConf)
 				return p, domainConf, err
 			}
 		}
@@ -332,22 +832,57 @@ func (d *daemon) findDNSProvisioner(domain string) (dns.Provisioner, config.Doma
 	return nil, config.DomainConfig{}, fmt.Errorf("no DNS provisioner found for domain %s", domain)
 }
 
-// Alias -> AliasDto
-func newAliasDto(alias database.Alias) proto.AliasDto {
+// getZones return the zones served by the built-in DNS server
+func getZones(conf config.DaemonConfig) ([]dns.Zone, error) {
+	var zones []dns.Zone
+
+	for _, dnsProvisioner := range conf.DNSProvisioners {
+		if dnsProvisioner.Name != dns.AuthoritativeProvisionerName {
+			continue
+		}
+
+		for _, domainConf := range dnsProvisioner.Domains {
+			zone, err := dns.NewZone(domainConf.String(), mergeConfig(dnsProvisioner.Config, domainConf.Config))
+			if err != nil {
+				return nil, fmt.Errorf("invalid zone %s: %s", domainConf.String(), err)
+			}
+			zones = append(zones, zone)
+		}
+	}
+
+	return zones, nil
+}
+
+// Alias -> AliasDto (for given record type)
+func newAliasDto(alias database.Alias, recordType string) proto.AliasDto {
 	return proto.AliasDto{
 		Domain: fmt.Sprintf("%s.%s", alias.Host, alias.Domain),
-		Value:  alias.Value,
+		Type:   recordType,
+		Value:  getAliasValue(alias, recordType),
 	}
 }
 
+// Alias -> []AliasDto (one per record held by the alias)
+func newAliasDtos(alias database.Alias) []proto.AliasDto {
+	var dtos []proto.AliasDto
+	for _, recordType := range []string{proto.RecordTypeA, proto.RecordTypeAAAA} {
+		if getAliasValue(alias, recordType) != "" {
+			dtos = append(dtos, newAliasDto(alias, recordType))
+		}
+	}
+	return dtos
+}
+
 // AliasDto -> Alias
 func newAlias(alias proto.AliasDto) database.Alias {
 	parts := strings.Split(alias.Domain, ".")
-	return database.Alias{
+	a := database.Alias{
 		Host:   parts[0],
 		Domain: strings.Replace(alias.Domain, parts[0]+".", "", 1),
-		Value:  alias.Value,
 	}
+	setAliasValue(&a, getRecordType(alias.Value), alias.Value)
+
+	return a
 }
 
 // Update an existing alias using given DTO
@@ -355,14 +890,62 @@ func updateAlias(alias *database.Alias, dto proto.AliasDto) {
 	a := newAlias(dto)
 
 	alias.Host = a.Host
-	alias.Value = a.Value
+	setAliasValue(alias, getRecordType(dto.Value), dto.Value)
+}
+
+// getAliasValue return the alias value for given record type
+func getAliasValue(alias database.Alias, recordType string) string {
+	switch recordType {
+	case proto.RecordTypeA:
+		return alias.IPv4
+	case proto.RecordTypeAAAA:
+		return alias.IPv6
+	default:
+		return ""
+	}
+}
+
+// setAliasValue set the alias value for given record type
+func setAliasValue(alias *database.Alias, recordType, value string) {
+	switch recordType {
+	case proto.RecordTypeA:
+		alias.IPv4 = value
+	case proto.RecordTypeAAAA:
+		alias.IPv6 = value
+	}
+}
+
+// getRecordType determinate the DNS record type to use for given value
+// return an empty string if value is not a valid IP address
+func getRecordType(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return ""
+	}
+
+	if ip.To4() != nil {
+		return proto.RecordTypeA
+	}
+
+	return proto.RecordTypeAAAA
 }
 
 func isAliasValid(alias proto.AliasDto) bool {
-	// TODO make sure value is valid IPv4 / IpV6
 	return alias.Domain != "" && strings.Count(alias.Domain, ".") >= 2 && alias.Value != ""
 }
 
+// mergeConfig merge the provisioner config with the domain specific one
+func mergeConfig(provisionerConfig, domainConfig map[string]string) map[string]string {
+	conf := map[string]string{}
+	for key, value := range provisionerConfig {
+		conf[key] = value
+	}
+	for key, value := range domainConfig {
+		conf[key] = value
+	}
+	return conf
+}
+
 func getRealHostAndDomain(alias proto.AliasDto, domainConf config.DomainConfig) (string, string) {
 	host := strings.Replace(alias.Domain, "."+domainConf.Domain, "", 1)
 	return host, domainConf.Domain
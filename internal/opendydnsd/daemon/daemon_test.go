@@ -5,6 +5,7 @@ import (
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/dns_mock"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/golang/mock/gomock"
@@ -13,6 +14,7 @@ import (
 	"gorm.io/gorm"
 	"io/ioutil"
 	"testing"
+	"time"
 )
 
 // TODO test provisioning fails case
@@ -35,21 +37,47 @@ func TestNewAliasDto(t *testing.T) {
 	alias := newAliasDto(database.Alias{
 		Domain: "bar.baz",
 		Host:   "foo",
-		Value:  "value",
-	})
+		IPv4:   "8.8.8.8",
+	}, proto.RecordTypeA)
 
 	if alias.Domain != "foo.bar.baz" {
 		t.FailNow()
 	}
-	if alias.Value != "value" {
+	if alias.Type != proto.RecordTypeA {
+		t.FailNow()
+	}
+	if alias.Value != "8.8.8.8" {
 		t.FailNow()
 	}
 }
 
+func TestNewAliasDtos(t *testing.T) {
+	aliases := newAliasDtos(database.Alias{
+		Domain: "bar.baz",
+		Host:   "foo",
+		IPv4:   "8.8.8.8",
+		IPv6:   "2001:4860:4860::8888",
+	})
+
+	if len(aliases) != 2 {
+		t.Fatal("wrong number of aliases returned")
+	}
+	if aliases[0].Type != proto.RecordTypeA || aliases[0].Value != "8.8.8.8" {
+		t.Error("wrong A record returned")
+	}
+	if aliases[1].Type != proto.RecordTypeAAAA || aliases[1].Value != "2001:4860:4860::8888" {
+		t.Error("wrong AAAA record returned")
+	}
+
+	if len(newAliasDtos(database.Alias{Domain: "bar.baz", Host: "foo"})) != 0 {
+		t.Error("alias without value should not have records")
+	}
+}
+
 func TestNewAlias(t *testing.T) {
 	alias := newAlias(proto.AliasDto{
 		Domain: "foo.bar.baz",
-		Value:  "value",
+		Value:  "8.8.8.8",
 	})
 
 	if alias.Domain != "bar.baz" {
@@ -58,7 +86,18 @@ func TestNewAlias(t *testing.T) {
 	if alias.Host != "foo" {
 		t.FailNow()
 	}
-	if alias.Value != "value" {
+	if alias.IPv4 != "8.8.8.8" || alias.IPv6 != "" {
+		t.FailNow()
+	}
+}
+
+func TestNewAlias_IPv6(t *testing.T) {
+	alias := newAlias(proto.AliasDto{
+		Domain: "foo.bar.baz",
+		Value:  "2001:4860:4860::8888",
+	})
+
+	if alias.IPv4 != "" || alias.IPv6 != "2001:4860:4860::8888" {
 		t.FailNow()
 	}
 }
@@ -66,7 +105,7 @@ func TestNewAlias(t *testing.T) {
 func TestNewAlias_WithSubDomain(t *testing.T) {
 	alias := newAlias(proto.AliasDto{
 		Domain: "demo.foo.bar.baz",
-		Value:  "value",
+		Value:  "8.8.8.8",
 	})
 
 	if alias.Domain != "foo.bar.baz" {
@@ -75,11 +114,23 @@ func TestNewAlias_WithSubDomain(t *testing.T) {
 	if alias.Host != "demo" {
 		t.FailNow()
 	}
-	if alias.Value != "value" {
+	if alias.IPv4 != "8.8.8.8" {
 		t.FailNow()
 	}
 }
 
+func TestGetRecordType(t *testing.T) {
+	if getRecordType("8.8.8.8") != proto.RecordTypeA {
+		t.Error("8.8.8.8 should be an A record")
+	}
+	if getRecordType("2001:4860:4860::8888") != proto.RecordTypeAAAA {
+		t.Error("2001:4860:4860::8888 should be an AAAA record")
+	}
+	if getRecordType("1.2.3") != "" {
+		t.Error("1.2.3 should not be a valid record value")
+	}
+}
+
 func TestGetRealHostAndDomain(t *testing.T) {
 	host, domain := getRealHostAndDomain(proto.AliasDto{Domain: "foo.bar.baz"}, config.DomainConfig{Domain: "bar.baz"})
 	if host != "foo" {
@@ -100,6 +151,47 @@ func TestGetRealHostAndDomain_WithSubDomain(t *testing.T) {
 	}
 }
 
+func TestMergeConfig(t *testing.T) {
+	conf := mergeConfig(map[string]string{"api-token": "token", "ttl": "1"}, map[string]string{"ttl": "300"})
+	if len(conf) != 2 || conf["api-token"] != "token" || conf["ttl"] != "300" {
+		t.Errorf("wrong merged config: %v", conf)
+	}
+
+	if conf := mergeConfig(nil, nil); conf == nil || len(conf) != 0 {
+		t.Error("merged config should be empty")
+	}
+}
+
+func TestDaemon_GetZones(t *testing.T) {
+	d := daemon{config: config.DaemonConfig{DNSProvisioners: []config.DNSProvisionerConfig{
+		{
+			Name:    "ovh",
+			Domains: []config.DomainConfig{{Domain: "example.com"}},
+		},
+		{
+			Name:   dns.AuthoritativeProvisionerName,
+			Config: map[string]string{"nameservers": "ns1.example.org"},
+			Domains: []config.DomainConfig{
+				{Domain: "example.org", Host: "dyn"},
+				{Domain: "example.net", Config: map[string]string{"ttl": "30"}},
+			},
+		},
+	}}}
+
+	zones, err := getZones(d.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 2 || zones[0].Domain != "dyn.example.org" || zones[1].Domain != "example.net" || zones[1].TTL != 30 {
+		t.Errorf("wrong zones: %+v", zones)
+	}
+
+	d.config.DNSProvisioners[1].Config = nil
+	if _, err := getZones(d.config); err == nil {
+		t.Error("getZones() should have failed")
+	}
+}
+
 func TestIsAliasValid(t *testing.T) {
 	if isAliasValid(proto.AliasDto{
 		Domain: "foo",
@@ -116,6 +208,36 @@ func TestIsAliasValid(t *testing.T) {
 	}
 }
 
+func TestDaemon_ValidateAlias(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	d := daemon{
+		logger: &logger,
+	}
+
+	if _, err := d.validateAlias(proto.AliasDto{Domain: "foo", Value: "8.8.8.8"}); err != proto.ErrInvalidParameters {
+		t.Error("validateAlias() should have returned ErrInvalidParameters")
+	}
+	if _, err := d.validateAlias(proto.AliasDto{Domain: "foo.bar.baz", Value: "1.2.3"}); err != proto.ErrInvalidAddress {
+		t.Error("validateAlias() should have returned ErrInvalidAddress")
+	}
+	if _, err := d.validateAlias(proto.AliasDto{Domain: "foo.bar.baz", Value: "192.168.1.1"}); err != proto.ErrAddressNotAllowed {
+		t.Error("validateAlias() should have returned ErrAddressNotAllowed")
+	}
+
+	d.config.AddressPolicy.AllowPrivate = true
+	if _, err := d.validateAlias(proto.AliasDto{Domain: "foo.bar.baz", Value: "192.168.1.1"}); err != nil {
+		t.Error("validateAlias() should not have failed")
+	}
+
+	alias, err := d.validateAlias(proto.AliasDto{Domain: "foo.bar.baz", Value: "2001:4860:4860:0:0:0:0:8888"})
+	if err != nil {
+		t.Error(err)
+	}
+	if alias.Value != "2001:4860:4860::8888" {
+		t.Error("alias value should have been normalized")
+	}
+}
+
 func TestDaemon_CreateUser_InvalidRequest(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -128,7 +250,7 @@ func TestDaemon_CreateUser_InvalidRequest(t *testing.T) {
 		conn:   dbMock,
 	}
 
-	if _, err := d.CreateUser(proto.CredentialsDto{Email: "test@gmail.com"}); err != proto.ErrInvalidParameters {
+	if _, err := d.CreateUser(proto.CredentialsDto{Email: "test@gmail.com"}, false); err != proto.ErrInvalidParameters {
 		t.Errorf("CreateUser() should have returned ErrInvalidParameters")
 	}
 }
@@ -147,7 +269,7 @@ func TestDaemon_CreateUser_EmailTaken(t *testing.T) {
 
 	dbMock.EXPECT().FindUser("lunamicard@gmail.com").Return(database.User{}, nil)
 
-	if _, err := d.CreateUser(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}); err != proto.ErrInvalidParameters {
+	if _, err := d.CreateUser(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}, false); err != proto.ErrInvalidParameters {
 		t.Error("CreateUser() should have returned ErrInvalidParameters")
 	}
 }
@@ -168,15 +290,23 @@ func TestDaemon_CreateUser(t *testing.T) {
 		FindUser("lunamicard@gmail.com").
 		Return(database.User{}, gorm.ErrRecordNotFound)
 	dbMock.EXPECT().
-		CreateUser("lunamicard@gmail.com", gomock.Any()).
-		Return(database.User{}, nil)
-	dbMock.EXPECT().
-		FindUser("lunamicard@gmail.com").
-		Return(database.User{Password: "$2a$04$5eQwROjKESuWP2y.sAVsPeqhG48UXWw.htYp5G./JsRjWwUMOi7xC"}, nil)
-
-	if _, err := d.CreateUser(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}); err != nil {
+		CreateUser(gomock.Any()).
+		DoAndReturn(func(user database.User) (database.User, error) {
+			if user.Email != "lunamicard@gmail.com" || !user.Admin || !user.Verified {
+				t.Errorf("wrong user created: %+v", user)
+			}
+			user.ID = 2
+			user.CreatedAt = time.Now()
+			return user, nil
+		})
+
+	user, err := d.CreateUser(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}, true)
+	if err != nil {
 		t.Errorf("CreateUser() should not have failed: %s", err)
 	}
+	if user.ID != 2 || !user.Admin || user.Email != "lunamicard@gmail.com" || user.CreatedAt.IsZero() {
+		t.Errorf("wrong user returned: %+v", user)
+	}
 }
 
 func TestDaemon_Authenticate_InvalidRequest(t *testing.T) {
@@ -185,7 +315,7 @@ func TestDaemon_Authenticate_InvalidRequest(t *testing.T) {
 		logger: &logger,
 	}
 
-	_, err := d.Authenticate(proto.CredentialsDto{})
+	_, _, err := d.Authenticate(proto.CredentialsDto{})
 	if !errors.As(err, &proto.ErrInvalidParameters) {
 		t.Error("Authenticate() should have failed")
 	}
@@ -207,7 +337,7 @@ func TestDaemon_Authenticate_NonExistingUser(t *testing.T) {
 		FindUser("lunamicard@gmail.com").
 		Return(database.User{}, gorm.ErrRecordNotFound)
 
-	_, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"})
+	_, _, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"})
 	if !errors.As(err, &proto.ErrInvalidParameters) {
 		t.Error("Authenticate() should have returned ErrInvalidParameters")
 	}
@@ -234,7 +364,7 @@ func TestDaemon_Authenticate_InvalidPassword(t *testing.T) {
 		FindUser("lunamicard@gmail.com").
 		Return(database.User{Email: "lunamicard@gmail.com", Password: pass}, nil)
 
-	_, err = d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "testa"})
+	_, _, err = d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "testa"})
 	if !errors.As(err, &proto.ErrInvalidParameters) {
 		t.Error("Authenticate() should have returned ErrInvalidParameters")
 	}
@@ -263,19 +393,72 @@ func TestDaemon_Authenticate(t *testing.T) {
 			Model:    gorm.Model{ID: 1},
 			Email:    "lunamicard@gmail.com",
 			Password: pass,
+			Verified: true,
 			Aliases:  nil,
 		}, nil)
 
-	u, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"})
+	u, challenge, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"})
 	if err != nil {
 		t.Error(err)
 	}
 
-	if u.UserID != 1 {
+	if u.UserID != 1 || challenge != "" {
 		t.Error("wrong userID")
 	}
 }
 
+func TestDaemon_Authenticate_Disabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	pass, err := d.hashPassword("test")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dbMock.EXPECT().
+		FindUser("lunamicard@gmail.com").
+		Return(database.User{Model: gorm.Model{ID: 1}, Email: "lunamicard@gmail.com", Password: pass, Disabled: true}, nil)
+
+	if _, _, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}); err != proto.ErrUserDisabled {
+		t.Error("Authenticate() should have returned ErrUserDisabled")
+	}
+}
+
+func TestDaemon_Authenticate_NotVerified(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	pass, err := d.hashPassword("test")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dbMock.EXPECT().
+		FindUser("lunamicard@gmail.com").
+		Return(database.User{Model: gorm.Model{ID: 1}, Email: "lunamicard@gmail.com", Password: pass}, nil)
+
+	if _, _, err := d.Authenticate(proto.CredentialsDto{Email: "lunamicard@gmail.com", Password: "test"}); err != proto.ErrEmailNotVerified {
+		t.Error("Authenticate() should have returned ErrEmailNotVerified")
+	}
+}
+
 func TestDaemon_GetAliases(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -290,7 +473,7 @@ func TestDaemon_GetAliases(t *testing.T) {
 
 	dbMock.EXPECT().
 		FindUserAliases(uint(1)).
-		Return([]database.Alias{{Domain: "bar.baz", Host: "foo", Value: "8.8.8.8"}}, nil)
+		Return([]database.Alias{{Domain: "bar.baz", Host: "foo", IPv4: "8.8.8.8"}}, nil)
 
 	aliases, err := d.GetAliases(proto.UserContext{UserID: 1})
 	if err != nil {
@@ -362,7 +545,7 @@ func TestDaemon_RegisterAlias_AliasTaken(t *testing.T) {
 	}, nil)
 
 	_, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{
-		Domain: "www.creekorful.fr", Value: "127.0.0.1",
+		Domain: "www.creekorful.fr", Value: "1.1.1.1",
 	})
 
 	if !errors.As(err, &proto.ErrAliasTaken) {
@@ -402,7 +585,7 @@ func TestDaemon_RegisterAlias_AliasAlreadyExist(t *testing.T) {
 	}, nil)
 
 	_, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{
-		Domain: "www.example.org", Value: "127.0.0.1",
+		Domain: "www.example.org", Value: "1.1.1.1",
 	})
 
 	if !errors.As(err, &proto.ErrAliasAlreadyExist) {
@@ -439,27 +622,28 @@ func TestDaemon_RegisterAlias(t *testing.T) {
 		Return(database.Alias{}, gorm.ErrRecordNotFound)
 
 	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
-	provisionerMock.EXPECT().AddRecord("test.demo", "dydns.org", "127.0.0.1").Return(nil)
+	provisionerMock.EXPECT().AddRecord("test.demo", "dydns.org", "A", "1.1.1.1").Return(nil)
 
 	dbMock.EXPECT().
-		CreateAlias(database.Alias{Domain: "demo.dydns.org", Host: "test", Value: "127.0.0.1"}, uint(1)).
+		CreateAlias(database.Alias{Domain: "demo.dydns.org", Host: "test", IPv4: "1.1.1.1"}, uint(1)).
 		Return(database.Alias{
 			Model:  gorm.Model{ID: 12},
 			Domain: "demo.dydns.org",
 			Host:   "test",
-			Value:  "127.0.0.1",
+			IPv4:   "1.1.1.1",
 			UserID: 1,
 		}, nil)
+	dbMock.EXPECT().DeleteDNSOperations("test", "demo.dydns.org", "A").Return(nil)
 
 	r, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{
-		Domain: "test.demo.dydns.org", Value: "127.0.0.1",
+		Domain: "test.demo.dydns.org", Value: "1.1.1.1",
 	})
 
 	if err != nil {
 		t.Error(err)
 	}
 
-	if r.Domain != "test.demo.dydns.org" || r.Value != "127.0.0.1" {
+	if r.Domain != "test.demo.dydns.org" || r.Type != proto.RecordTypeA || r.Value != "1.1.1.1" {
 		t.Error("Wrong alias created")
 	}
 }
@@ -476,7 +660,7 @@ func TestDaemon_UpdateAlias_InvalidAlias(t *testing.T) {
 		conn:   dbMock,
 	}
 
-	_, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "bar.baz", Value: "127.0.0.1"})
+	_, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "bar.baz", Value: "1.1.1.1"})
 	if err != proto.ErrInvalidParameters {
 		t.Error("UpdateAlias() should have returned ErrInvalidParameters")
 	}
@@ -498,7 +682,7 @@ func TestDaemon_UpdateAlias_AliasDoesNotExist(t *testing.T) {
 		FindAlias("foo", "bar.baz").
 		Return(database.Alias{}, gorm.ErrRecordNotFound)
 
-	_, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "127.0.0.1"})
+	_, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "1.1.1.1"})
 	if err != proto.ErrAliasNotFound {
 		t.Error("UpdateAlias() should have returned ErrAliasNotFound")
 	}
@@ -522,7 +706,7 @@ func TestDaemon_UpdateAlias_AliasNotOwned(t *testing.T) {
 			UserID: 12,
 		}, nil)
 
-	_, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "127.0.0.1"})
+	_, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "1.1.1.1"})
 	if err != proto.ErrAliasNotFound {
 		t.Error("UpdateAlias() should have returned ErrAliasNotFound")
 	}
@@ -558,26 +742,27 @@ func TestDaemon_UpdateAlias(t *testing.T) {
 			Model:  gorm.Model{ID: 42},
 			Domain: "bar.baz",
 			Host:   "foo",
-			Value:  "127.0.0.1",
+			IPv4:   "1.1.1.1",
 			UserID: 1,
 		}, nil)
 
 	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
-	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "8.8.8.8").Return(nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "A", "8.8.8.8").Return(nil)
 
 	dbMock.EXPECT().UpdateAlias(database.Alias{
 		Model:  gorm.Model{ID: 42},
 		Domain: "bar.baz",
 		Host:   "foo",
-		Value:  "8.8.8.8",
+		IPv4:   "8.8.8.8",
 		UserID: uint(1),
 	}).Return(database.Alias{
 		Model:  gorm.Model{ID: 42},
 		Domain: "bar.baz",
 		Host:   "foo",
-		Value:  "8.8.8.8",
+		IPv4:   "8.8.8.8",
 		UserID: 1,
 	}, nil)
+	dbMock.EXPECT().DeleteDNSOperations("foo", "bar.baz", "A").Return(nil)
 
 	a, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"})
 	if err != nil {
@@ -589,6 +774,92 @@ func TestDaemon_UpdateAlias(t *testing.T) {
 	}
 }
 
+func TestDaemon_UpdateAlias_AddIPv6(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: config.DaemonConfig{
+			DNSProvisioners: []config.DNSProvisionerConfig{
+				{
+					Name:    "dummy",
+					Config:  map[string]string{},
+					Domains: []config.DomainConfig{{Domain: "bar.baz"}},
+				},
+			},
+		},
+		dnsProvider: providerMock,
+	}
+
+	dbMock.EXPECT().
+		FindAlias("foo", "bar.baz").
+		Return(database.Alias{
+			Model:  gorm.Model{ID: 42},
+			Domain: "bar.baz",
+			Host:   "foo",
+			IPv4:   "8.8.8.8",
+			UserID: 1,
+		}, nil)
+
+	// no AAAA record yet: should be created
+	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "AAAA", "2001:4860:4860::8888").Return(nil)
+
+	dbMock.EXPECT().UpdateAlias(database.Alias{
+		Model:  gorm.Model{ID: 42},
+		Domain: "bar.baz",
+		Host:   "foo",
+		IPv4:   "8.8.8.8",
+		IPv6:   "2001:4860:4860::8888",
+		UserID: uint(1),
+	}).Return(database.Alias{
+		Model:  gorm.Model{ID: 42},
+		Domain: "bar.baz",
+		Host:   "foo",
+		IPv4:   "8.8.8.8",
+		IPv6:   "2001:4860:4860::8888",
+		UserID: 1,
+	}, nil)
+	dbMock.EXPECT().DeleteDNSOperations("foo", "bar.baz", "AAAA").Return(nil)
+
+	a, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "2001:4860:4860::8888"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if a.Type != proto.RecordTypeAAAA || a.Value != "2001:4860:4860::8888" {
+		t.Error("Alias not updated")
+	}
+}
+
+func TestDaemon_DeleteAlias_AliasNotOwned(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().
+		FindAlias("www", "creekorful.be").
+		Return(database.Alias{UserID: 12}, nil)
+
+	if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "www.creekorful.be"); err != proto.ErrAliasNotFound {
+		t.Error("DeleteAlias() should have returned ErrAliasNotFound")
+	}
+}
+
 func TestDaemon_DeleteAlias(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -613,10 +884,21 @@ func TestDaemon_DeleteAlias(t *testing.T) {
 		dnsProvider: providerMock,
 	}
 
+	dbMock.EXPECT().FindAlias("www", "creekorful.be").Return(database.Alias{
+		Domain: "creekorful.be",
+		Host:   "www",
+		IPv4:   "8.8.8.8",
+		IPv6:   "2001:4860:4860::8888",
+		UserID: 1,
+	}, nil)
+
 	providerMock.EXPECT().GetProvisioner("dummy", map[string]string{}).Return(provisionerMock, nil)
-	provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be").Return(nil)
+	provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be", "A").Return(nil)
+	provisionerMock.EXPECT().DeleteRecord("www", "creekorful.be", "AAAA").Return(nil)
 
 	dbMock.EXPECT().DeleteAlias("www", "creekorful.be", uint(1)).Return(nil)
+	dbMock.EXPECT().DeleteDNSOperations("www", "creekorful.be", "A").Return(nil)
+	dbMock.EXPECT().DeleteDNSOperations("www", "creekorful.be", "AAAA").Return(nil)
 
 	if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "www.creekorful.be"); err != nil {
 		t.Error(err)
@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/mail_mock"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+)
+
+// newTestDaemon return a daemon using given configuration backed by a mocked database
+func newTestDaemon(mockCtrl *gomock.Controller, conf config.DaemonConfig) (*daemon, *database_mock.MockConnection) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	return &daemon{
+		logger: &logger,
+		conn:   dbMock,
+		config: conf,
+	}, dbMock
+}
+
+// mockDummyProvisioner make the `dummy` DNS provisioners of the daemon resolve to the returned mock
+func mockDummyProvisioner(mockCtrl *gomock.Controller, d *daemon) *dns_mock.MockProvisioner {
+	provisionerMock := dns_mock.NewMockProvisioner(mockCtrl)
+	providerMock := dns_mock.NewMockProvider(mockCtrl)
+
+	providerMock.EXPECT().GetProvisioner("dummy", gomock.Any()).Return(provisionerMock, nil).AnyTimes()
+	d.dnsProvider = providerMock
+
+	return provisionerMock
+}
+
+// mockMailer make the daemon send its emails using the returned mock
+func mockMailer(mockCtrl *gomock.Controller, d *daemon) *mail_mock.MockMailer {
+	mailerMock := mail_mock.NewMockMailer(mockCtrl)
+	d.mailer = mailerMock
+
+	return mailerMock
+}
+
+// dummyProvisionerConfig return the configuration of a `dummy` DNS provisioner serving given domains
+func dummyProvisionerConfig(domains ...config.DomainConfig) config.DNSProvisionerConfig {
+	return config.DNSProvisionerConfig{
+		Name:    "dummy",
+		Config:  map[string]string{},
+		Domains: domains,
+	}
+}
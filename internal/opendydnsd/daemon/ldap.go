@@ -0,0 +1,279 @@
+package daemon
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLDAPMailAttribute  = "mail"
+	defaultLDAPGroupAttribute = "memberOf"
+	defaultLDAPTimeout        = 10 * time.Second
+)
+
+// ldapConn is the subset of the LDAP connection used by the authenticator
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// ldapAuthenticator validate the credentials by binding as the user on a LDAP server
+// the user entry is first searched using the service account, the local users are created on first login
+type ldapAuthenticator struct {
+	conf   config.LDAPConfig
+	conn   database.Connection
+	logger *zerolog.Logger
+	dial   func(conf config.LDAPConfig) (ldapConn, error)
+}
+
+// newLDAPAuthenticator return a LDAP authenticator using given config
+// with the default values applied. The connections are opened using dial
+func newLDAPAuthenticator(conf config.LDAPConfig, conn database.Connection, logger *zerolog.Logger,
+	dial func(conf config.LDAPConfig) (ldapConn, error)) (*ldapAuthenticator, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil || conf.URL == "" {
+		return nil, fmt.Errorf("invalid LDAP URL: %s", conf.URL)
+	}
+
+	// the passwords are only sent over an encrypted connection, unless explicitly allowed
+	switch u.Scheme {
+	case "ldaps":
+		if conf.StartTLS {
+			return nil, fmt.Errorf("StartTLS cannot be used with a ldaps:// URL: %s", conf.URL)
+		}
+	case "ldap":
+		if !conf.StartTLS && !conf.AllowInsecure {
+			return nil, fmt.Errorf("the ldap:// URL requires StartTLS (or AllowInsecure): %s", conf.URL)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme: %s", conf.URL)
+	}
+
+	if conf.BaseDN == "" {
+		return nil, fmt.Errorf("missing LDAP base DN")
+	}
+	if strings.Count(conf.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("the LDAP user filter must contain a single %%s: %s", conf.UserFilter)
+	}
+
+	if conf.MailAttribute == "" {
+		conf.MailAttribute = defaultLDAPMailAttribute
+	}
+	if conf.GroupAttribute == "" {
+		conf.GroupAttribute = defaultLDAPGroupAttribute
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultLDAPTimeout
+	}
+
+	return &ldapAuthenticator{
+		conf:   conf,
+		conn:   conn,
+		logger: logger,
+		dial:   dial,
+	}, nil
+}
+
+func (la *ldapAuthenticator) Authenticate(email, password string) (database.User, error) {
+	conn, err := la.dial(la.conf)
+	if err != nil {
+		la.logger.Err(err).Str("URL", la.conf.URL).Msg("error while connecting to the LDAP server.")
+		return database.User{}, err
+	}
+	defer conn.Close()
+
+	entry, err := la.findEntry(conn, email)
+	if err == proto.ErrInvalidParameters {
+		la.logger.Warn().Str("Email", email).Msg("invalid authentication request: LDAP user not found or not unique.")
+		metrics.AuthenticationFailures.WithLabelValues("password").Inc()
+		return database.User{}, err // not 404 to prevent email discovery
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	// Validate the password by binding as the user
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			la.logger.Warn().Msg("invalid authentication request: invalid password.")
+			metrics.AuthenticationFailures.WithLabelValues("password").Inc()
+			return database.User{}, proto.ErrInvalidParameters // not 404 to prevent email discovery
+		}
+
+		la.logger.Err(err).Msg("error while binding the LDAP user.")
+		return database.User{}, err
+	}
+
+	groups := entry.GetAttributeValues(la.conf.GroupAttribute)
+	if !la.isAllowed(groups) {
+		la.logger.Warn().Str("DN", entry.DN).Msg("invalid authentication request: LDAP group not allowed.")
+		metrics.AuthenticationFailures.WithLabelValues("password").Inc()
+		return database.User{}, proto.ErrInvalidParameters // not 403 to prevent groups discovery
+	}
+
+	// the email address of the directory is used if any
+	if mail := entry.GetAttributeValue(la.conf.MailAttribute); mail != "" {
+		email = mail
+	}
+
+	return la.provisionUser(email, isLDAPMember(groups, la.conf.AdminGroups))
+}
+
+// Refresh search the user entry again using the service account: the user must still
+// exist in the directory and be member of the allowed groups. The admin role is synchronized
+func (la *ldapAuthenticator) Refresh(user database.User) (database.User, error) {
+	conn, err := la.dial(la.conf)
+	if err != nil {
+		la.logger.Err(err).Str("URL", la.conf.URL).Msg("error while connecting to the LDAP server.")
+		return database.User{}, err
+	}
+	defer conn.Close()
+
+	entry, err := la.findEntry(conn, user.Email)
+	if err == proto.ErrInvalidParameters {
+		la.logger.Warn().Str("Email", user.Email).Msg("invalid session refresh: LDAP user not found or not unique.")
+		return database.User{}, err
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	groups := entry.GetAttributeValues(la.conf.GroupAttribute)
+	if !la.isAllowed(groups) {
+		la.logger.Warn().Str("DN", entry.DN).Msg("invalid session refresh: LDAP group not allowed.")
+		return database.User{}, proto.ErrInvalidParameters
+	}
+
+	return la.syncAdmin(user, isLDAPMember(groups, la.conf.AdminGroups))
+}
+
+// isAllowed determinate if the member of given groups is allowed to login
+func (la *ldapAuthenticator) isAllowed(groups []string) bool {
+	return len(la.conf.AllowedGroups) == 0 || isLDAPMember(groups, la.conf.AllowedGroups)
+}
+
+// findEntry search the entry of the user identified by given email using the service account
+// proto.ErrInvalidParameters is returned if no single entry match
+func (la *ldapAuthenticator) findEntry(conn ldapConn, email string) (*ldap.Entry, error) {
+	if la.conf.BindDN != "" {
+		if err := conn.Bind(la.conf.BindDN, la.conf.BindPassword); err != nil {
+			la.logger.Err(err).Msg("error while binding the LDAP service account.")
+			return nil, err
+		}
+	}
+
+	req := ldap.NewSearchRequest(
+		la.conf.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(la.conf.Timeout.Seconds()), false,
+		fmt.Sprintf(la.conf.UserFilter, ldap.EscapeFilter(email)),
+		[]string{la.conf.MailAttribute, la.conf.GroupAttribute},
+		nil,
+	)
+
+	res, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		la.logger.Err(err).Msg("error while searching the LDAP user.")
+		return nil, err
+	}
+
+	// the filter must match a single entry
+	if res == nil || len(res.Entries) != 1 {
+		return nil, proto.ErrInvalidParameters
+	}
+
+	return res.Entries[0], nil
+}
+
+// provisionUser return the local user with given email, created on first login
+// the admin role is synchronized with the directory if AdminGroups is set
+func (la *ldapAuthenticator) provisionUser(email string, admin bool) (database.User, error) {
+	user, err := la.conn.FindUser(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// the users have no local password: they can only login using the directory
+		user, err = la.conn.CreateUser(database.User{Email: email, Verified: true, Admin: admin})
+		if err != nil {
+			la.logger.Err(err).Msg("error while creating user.")
+			return database.User{}, err
+		}
+
+		la.logger.Info().Str("Email", email).Bool("Admin", admin).Msg("user created on first LDAP login.")
+		return user, nil
+	}
+	if err != nil {
+		la.logger.Err(err).Msg("error while fetching database.")
+		return database.User{}, err
+	}
+
+	return la.syncAdmin(user, admin)
+}
+
+// syncAdmin update the admin role of given user if it is managed by the directory (AdminGroups is set)
+func (la *ldapAuthenticator) syncAdmin(user database.User, admin bool) (database.User, error) {
+	if len(la.conf.AdminGroups) > 0 && user.Admin != admin {
+		user.Admin = admin
+		if _, err := la.conn.UpdateUser(user); err != nil {
+			la.logger.Err(err).Msg("error while updating user.")
+			return database.User{}, err
+		}
+
+		la.logger.Info().Str("Email", user.Email).Bool("Admin", admin).Msg("admin role synchronized from LDAP groups.")
+	}
+
+	return user, nil
+}
+
+// dialLDAP open a connection to the configured LDAP server
+func dialLDAP(conf config.LDAPConfig) (ldapConn, error) {
+	conn, err := ldap.DialURL(conf.URL, ldap.DialWithDialer(&net.Dialer{Timeout: conf.Timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(conf.Timeout)
+
+	if conf.StartTLS {
+		u, _ := url.Parse(conf.URL)
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// isLDAPMember determinate if one of given groups DN is part of the wanted ones
+func isLDAPMember(groups, wanted []string) bool {
+	for _, group := range groups {
+		for _, w := range wanted {
+			if isSameDN(group, w) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isSameDN compare given DN, ignoring the case and the spacing
+func isSameDN(a, b string) bool {
+	dnA, errA := ldap.ParseDN(strings.ToLower(a))
+	dnB, errB := ldap.ParseDN(strings.ToLower(b))
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+
+	return dnA.Equal(dnB)
+}
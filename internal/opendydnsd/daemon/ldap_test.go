@@ -0,0 +1,316 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"testing"
+)
+
+const (
+	ldapUserDN     = "uid=luna,ou=people,dc=example,dc=org"
+	ldapUserGroup  = "cn=dyndns,ou=groups,dc=example,dc=org"
+	ldapAdminGroup = "cn=admins,ou=groups,dc=example,dc=org"
+)
+
+// fakeLDAPConn is an in-memory directory with the passwords indexed by DN
+type fakeLDAPConn struct {
+	passwords map[string]string
+	entries   []*ldap.Entry
+	filter    string
+	closed    bool
+}
+
+func (f *fakeLDAPConn) Bind(username, password string) error {
+	if p, exist := f.passwords[username]; !exist || p != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	return nil
+}
+
+func (f *fakeLDAPConn) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	f.filter = searchRequest.Filter
+	return &ldap.SearchResult{Entries: f.entries}, nil
+}
+
+func (f *fakeLDAPConn) Close() {
+	f.closed = true
+}
+
+// newLDAPTestDaemon return a daemon using the LDAP backend, connected to the returned in-memory directory
+func newLDAPTestDaemon(mockCtrl *gomock.Controller) (*daemon, *fakeLDAPConn, *database_mock.MockConnection) {
+	d, dbMock := newTestDaemon(mockCtrl, config.DaemonConfig{
+		Authentication: config.AuthenticationConfig{
+			Backend: authBackendLDAP,
+			LDAP: config.LDAPConfig{
+				URL:           "ldap://ldap.example.org",
+				StartTLS:      true,
+				BindDN:        "cn=opendydns,dc=example,dc=org",
+				BindPassword:  "service",
+				BaseDN:        "ou=people,dc=example,dc=org",
+				UserFilter:    "(mail=%s)",
+				AllowedGroups: []string{ldapUserGroup},
+				AdminGroups:   []string{ldapAdminGroup},
+			},
+		},
+	})
+
+	conn := &fakeLDAPConn{
+		passwords: map[string]string{
+			"cn=opendydns,dc=example,dc=org": "service",
+			ldapUserDN:                       "test",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry(ldapUserDN, map[string][]string{
+				"mail":     {"luna@example.org"},
+				"memberOf": {"CN=dyndns, OU=groups, DC=example, DC=org"},
+			}),
+		},
+	}
+	d.ldapDial = func(conf config.LDAPConfig) (ldapConn, error) {
+		return conn, nil
+	}
+
+	return d, conn, dbMock
+}
+
+// newLDAPTestAuthenticator return the LDAP authenticator of a daemon created using newLDAPTestDaemon
+func newLDAPTestAuthenticator(t *testing.T, mockCtrl *gomock.Controller) (*ldapAuthenticator, *fakeLDAPConn, *database_mock.MockConnection) {
+	d, conn, dbMock := newLDAPTestDaemon(mockCtrl)
+
+	authenticator, err := d.getAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authenticator.(*ldapAuthenticator), conn, dbMock
+}
+
+func TestNewLDAPAuthenticator(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	for _, conf := range []config.LDAPConfig{
+		{},
+		{URL: "ldaps://ldap.example.org", UserFilter: "(mail=%s)"},
+		{URL: "ldaps://ldap.example.org", BaseDN: "dc=example,dc=org"},
+		{URL: "ldaps://ldap.example.org", BaseDN: "dc=example,dc=org", UserFilter: "(|(mail=%s)(uid=%s))"},
+		// the passwords would be sent in clear text
+		{URL: "ldap://ldap.example.org", BaseDN: "dc=example,dc=org", UserFilter: "(mail=%s)"},
+		// the ldaps:// connections are already encrypted
+		{URL: "ldaps://ldap.example.org", StartTLS: true, BaseDN: "dc=example,dc=org", UserFilter: "(mail=%s)"},
+		{URL: "http://ldap.example.org", BaseDN: "dc=example,dc=org", UserFilter: "(mail=%s)"},
+	} {
+		if _, err := newLDAPAuthenticator(conf, nil, &logger, dialLDAP); err == nil {
+			t.Errorf("newLDAPAuthenticator() should have failed: %+v", conf)
+		}
+	}
+
+	for _, conf := range []config.LDAPConfig{
+		{URL: "ldap://ldap.example.org", StartTLS: true, BaseDN: "dc=example,dc=org", UserFilter: "(mail=%s)"},
+		{URL: "ldap://ldap.example.org", AllowInsecure: true, BaseDN: "dc=example,dc=org", UserFilter: "(mail=%s)"},
+	} {
+		if _, err := newLDAPAuthenticator(conf, nil, &logger, dialLDAP); err != nil {
+			t.Errorf("newLDAPAuthenticator() should have succeeded (%+v): %v", conf, err)
+		}
+	}
+
+	la, err := newLDAPAuthenticator(config.LDAPConfig{
+		URL:        "ldaps://ldap.example.org",
+		BaseDN:     "dc=example,dc=org",
+		UserFilter: "(mail=%s)",
+	}, nil, &logger, dialLDAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if la.conf.MailAttribute != defaultLDAPMailAttribute || la.conf.GroupAttribute != defaultLDAPGroupAttribute ||
+		la.conf.Timeout != defaultLDAPTimeout {
+		t.Errorf("default values not applied: %+v", la.conf)
+	}
+}
+
+func TestLDAPAuthenticator_Authenticate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	la, conn, dbMock := newLDAPTestAuthenticator(t, mockCtrl)
+
+	// the user is created on first login
+	dbMock.EXPECT().FindUser("luna@example.org").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().CreateUser(database.User{Email: "luna@example.org", Verified: true}).
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true}, nil)
+
+	user, err := la.Authenticate("Luna@example.org*", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 12 || user.Admin {
+		t.Errorf("wrong user: %+v", user)
+	}
+	if conn.filter != "(mail=Luna@example.org\\2a)" {
+		t.Errorf("wrong search filter: %s", conn.filter)
+	}
+	if !conn.closed {
+		t.Error("the LDAP connection should have been closed")
+	}
+
+	// the user already exist
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true}, nil)
+
+	if user, err := la.Authenticate("luna@example.org", "test"); err != nil || user.ID != 12 {
+		t.Errorf("wrong user: %+v %v", user, err)
+	}
+}
+
+func TestLDAPAuthenticator_Authenticate_InvalidCredentials(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	la, conn, _ := newLDAPTestAuthenticator(t, mockCtrl)
+
+	// invalid password
+	if _, err := la.Authenticate("luna@example.org", "nope"); err != proto.ErrInvalidParameters {
+		t.Errorf("Authenticate() should have returned ErrInvalidParameters: %v", err)
+	}
+
+	// user not found
+	conn.entries = nil
+	if _, err := la.Authenticate("lily@example.org", "test"); err != proto.ErrInvalidParameters {
+		t.Errorf("Authenticate() should have returned ErrInvalidParameters: %v", err)
+	}
+
+	// the filter match several users
+	conn.entries = []*ldap.Entry{ldap.NewEntry(ldapUserDN, nil), ldap.NewEntry("uid=lily,dc=example,dc=org", nil)}
+	if _, err := la.Authenticate("luna@example.org", "test"); err != proto.ErrInvalidParameters {
+		t.Errorf("Authenticate() should have returned ErrInvalidParameters: %v", err)
+	}
+
+	// invalid service account
+	la.conf.BindPassword = "nope"
+	if _, err := la.Authenticate("luna@example.org", "test"); err == nil || err == proto.ErrInvalidParameters {
+		t.Errorf("Authenticate() should have returned the bind error: %v", err)
+	}
+}
+
+func TestLDAPAuthenticator_Authenticate_GroupNotAllowed(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	la, conn, _ := newLDAPTestAuthenticator(t, mockCtrl)
+
+	conn.entries[0] = ldap.NewEntry(ldapUserDN, map[string][]string{
+		"mail":     {"luna@example.org"},
+		"memberOf": {"cn=staff,ou=groups,dc=example,dc=org"},
+	})
+
+	if _, err := la.Authenticate("luna@example.org", "test"); err != proto.ErrInvalidParameters {
+		t.Errorf("Authenticate() should have returned ErrInvalidParameters: %v", err)
+	}
+}
+
+func TestLDAPAuthenticator_Authenticate_AdminGroups(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	la, conn, dbMock := newLDAPTestAuthenticator(t, mockCtrl)
+
+	conn.entries[0] = ldap.NewEntry(ldapUserDN, map[string][]string{
+		"mail":     {"luna@example.org"},
+		"memberOf": {ldapUserGroup, ldapAdminGroup},
+	})
+
+	// the admin role is granted
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true}, nil)
+	dbMock.EXPECT().UpdateUser(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true, Admin: true}).
+		Return(database.User{}, nil)
+
+	if user, err := la.Authenticate("luna@example.org", "test"); err != nil || !user.Admin {
+		t.Errorf("wrong user: %+v %v", user, err)
+	}
+
+	// the admin role is revoked
+	conn.entries[0] = ldap.NewEntry(ldapUserDN, map[string][]string{"memberOf": {ldapUserGroup}})
+
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true, Admin: true}, nil)
+	dbMock.EXPECT().UpdateUser(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true}).
+		Return(database.User{}, nil)
+
+	if user, err := la.Authenticate("luna@example.org", "test"); err != nil || user.Admin {
+		t.Errorf("wrong user: %+v %v", user, err)
+	}
+
+	// the admin role is managed locally
+	la.conf.AdminGroups = nil
+
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true, Admin: true}, nil)
+
+	if user, err := la.Authenticate("luna@example.org", "test"); err != nil || !user.Admin {
+		t.Errorf("wrong user: %+v %v", user, err)
+	}
+}
+
+func TestLDAPAuthenticator_Refresh(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	la, conn, dbMock := newLDAPTestAuthenticator(t, mockCtrl)
+
+	user := database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true}
+
+	// the entry is searched using the service account
+	if refreshed, err := la.Refresh(user); err != nil || refreshed.ID != 12 || refreshed.Admin {
+		t.Errorf("wrong user: %+v %v", refreshed, err)
+	}
+	if conn.filter != "(mail=luna@example.org)" || !conn.closed {
+		t.Errorf("wrong search: %s %v", conn.filter, conn.closed)
+	}
+
+	// the admin role is synchronized
+	conn.entries[0] = ldap.NewEntry(ldapUserDN, map[string][]string{"memberOf": {ldapUserGroup, ldapAdminGroup}})
+	dbMock.EXPECT().UpdateUser(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true, Admin: true}).
+		Return(database.User{}, nil)
+
+	if refreshed, err := la.Refresh(user); err != nil || !refreshed.Admin {
+		t.Errorf("wrong user: %+v %v", refreshed, err)
+	}
+
+	// the user is no longer member of the allowed groups
+	conn.entries[0] = ldap.NewEntry(ldapUserDN, map[string][]string{"memberOf": {"cn=staff,ou=groups,dc=example,dc=org"}})
+	if _, err := la.Refresh(user); err != proto.ErrInvalidParameters {
+		t.Errorf("Refresh() should have returned ErrInvalidParameters: %v", err)
+	}
+
+	// the user has been removed from the directory
+	conn.entries = nil
+	if _, err := la.Refresh(user); err != proto.ErrInvalidParameters {
+		t.Errorf("Refresh() should have returned ErrInvalidParameters: %v", err)
+	}
+}
+
+func TestIsSameDN(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		same bool
+	}{
+		{"cn=dyndns,dc=example,dc=org", "cn=dyndns,dc=example,dc=org", true},
+		{"cn=dyndns,dc=example,dc=org", "CN=DynDNS, DC=example, DC=org", true},
+		{"cn=dyndns,dc=example,dc=org", "cn=dyndns,dc=example,dc=com", false},
+		{"cn=dyndns,dc=example,dc=org", "cn=dyndns,ou=groups,dc=example,dc=org", false},
+	} {
+		if isSameDN(test.a, test.b) != test.same {
+			t.Errorf("isSameDN(%s, %s) should have returned %v", test.a, test.b, test.same)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"time"
+)
+
+// instrumentedProvisioner is a dns.Provisioner recording
+// the latency and the errors of the calls made to the wrapped one
+type instrumentedProvisioner struct {
+	provisioner dns.Provisioner
+	name        string
+	domain      string
+}
+
+// getProvisioner return the (instrumented) DNS provisioner of given domain
+func (d *daemon) getProvisioner(dnsProvisioner config.DNSProvisionerConfig, domainConf config.DomainConfig) (dns.Provisioner, error) {
+	p, err := d.dnsProvider.GetProvisioner(dnsProvisioner.Name, mergeConfig(dnsProvisioner.Config, domainConf.Config))
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedProvisioner{
+		provisioner: p,
+		name:        dnsProvisioner.Name,
+		domain:      domainConf.String(),
+	}, nil
+}
+
+func (ip *instrumentedProvisioner) AddRecord(host, domain, recordType, value string) error {
+	start := time.Now()
+	err := ip.provisioner.AddRecord(host, domain, recordType, value)
+	ip.observe("add", start, err)
+	return err
+}
+
+func (ip *instrumentedProvisioner) UpdateRecord(host, domain, recordType, value string) error {
+	start := time.Now()
+	err := ip.provisioner.UpdateRecord(host, domain, recordType, value)
+	ip.observe("update", start, err)
+	return err
+}
+
+func (ip *instrumentedProvisioner) DeleteRecord(host, domain, recordType string) error {
+	start := time.Now()
+	err := ip.provisioner.DeleteRecord(host, domain, recordType)
+	ip.observe("delete", start, err)
+	return err
+}
+
+func (ip *instrumentedProvisioner) ListRecords(domain string) ([]dns.Record, error) {
+	start := time.Now()
+	records, err := ip.provisioner.ListRecords(domain)
+	ip.observe("list", start, err)
+	return records, err
+}
+
+func (ip *instrumentedProvisioner) Check(domain string) error {
+	start := time.Now()
+	err := ip.provisioner.Check(domain)
+	ip.observe("check", start, err)
+	return err
+}
+
+func (ip *instrumentedProvisioner) observe(operation string, start time.Time, err error) {
+	metrics.ProvisionerCallDuration.
+		WithLabelValues(ip.name, ip.domain, operation).
+		Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.ProvisionerCallErrors.WithLabelValues(ip.name, ip.domain, operation).Inc()
+	}
+}
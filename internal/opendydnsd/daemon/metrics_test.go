@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstrumentedProvisioner(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, _, provisionerMock := newOutboxTestDaemon(mockCtrl)
+
+	provisioner, err := d.getProvisioner(config.DNSProvisionerConfig{Name: "dummy", Config: map[string]string{}},
+		config.DomainConfig{Domain: "bar.baz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures := metrics.ProvisionerCallErrors.WithLabelValues("dummy", "bar.baz", "add")
+	before := testutil.ToFloat64(failures)
+
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "A", "1.1.1.1").Return(nil)
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "A", "1.1.1.1").Return(fmt.Errorf("provider unavailable"))
+
+	if err := provisioner.AddRecord("foo", "bar.baz", "A", "1.1.1.1"); err != nil {
+		t.Error(err)
+	}
+	if err := provisioner.AddRecord("foo", "bar.baz", "A", "1.1.1.1"); err == nil {
+		t.Error("AddRecord() should have failed")
+	}
+
+	if testutil.ToFloat64(failures) != before+1 {
+		t.Errorf("wrong errors count: %f", testutil.ToFloat64(failures))
+	}
+	if testutil.CollectAndCount(metrics.ProvisionerCallDuration) == 0 {
+		t.Error("call latency not observed")
+	}
+}
+
+func TestNewDaemon_AliasesCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	conf := config.Config{DatabaseConfig: config.DatabaseConfig{Driver: "sqlite", DSN: filepath.Join(dir, "opendydnsd.db")}}
+
+	conn, err := database.OpenConnection(conf.DatabaseConfig, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	// the collector is not registered if the configuration is invalid
+	invalid := conf
+	invalid.DaemonConfig.Signup.Enabled = true
+	if _, err := NewDaemon(invalid, &logger); err == nil {
+		t.Fatal("NewDaemon() should have failed")
+	}
+
+	// the collector is unregistered on shutdown
+	for i := 0; i < 2; i++ {
+		d, err := NewDaemon(conf, &logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
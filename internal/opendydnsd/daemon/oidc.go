@@ -0,0 +1,248 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"github.com/creekorful/open-dydns/internal/oidc"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOIDCGroupsClaim = "groups"
+	oidcVerifyTimeout      = 15 * time.Second
+)
+
+var defaultOIDCScopes = []string{"openid", "email", "profile"}
+
+// oidcVerifier hold the ID tokens verifier of the configured OpenID Provider
+// the verifier (and its cached keys) is replaced when the provider configuration change
+type oidcVerifier struct {
+	lock     sync.Mutex
+	conf     config.OIDCConfig
+	verifier *oidc.Verifier
+}
+
+// get return the verifier matching given configuration
+func (ov *oidcVerifier) get(conf config.OIDCConfig) *oidc.Verifier {
+	ov.lock.Lock()
+	defer ov.lock.Unlock()
+
+	if ov.verifier == nil || ov.conf.Issuer != conf.Issuer || ov.conf.ClientID != conf.ClientID ||
+		ov.conf.GroupsClaim != conf.GroupsClaim {
+		ov.conf = conf
+		ov.verifier = oidc.NewVerifier(conf.Issuer, conf.ClientID, conf.GroupsClaim)
+	}
+
+	return ov.verifier
+}
+
+// GetOIDCConfig return the OpenID Provider and client to use to login
+func (d *daemon) GetOIDCConfig() (proto.OIDCConfigDto, error) {
+	conf := d.getOIDCConfig()
+	if !conf.Enabled() {
+		return proto.OIDCConfigDto{}, proto.ErrOIDCDisabled
+	}
+
+	return proto.OIDCConfigDto{
+		Issuer:   conf.Issuer,
+		ClientID: conf.ClientID,
+		Scopes:   conf.Scopes,
+	}, nil
+}
+
+// AuthenticateOIDC validate given ID token and return the corresponding user
+// the user is matched using the token subject, linked using the (verified) email address if LinkExistingUsers
+// is enabled, or provisioned on first login if AutoProvision is enabled.
+// if the user enabled the two-factor authentication, a two-factor challenge token is returned instead: see VerifyTwoFactor
+func (d *daemon) AuthenticateOIDC(idToken string) (proto.UserContext, string, error) {
+	conf := d.getOIDCConfig()
+	if !conf.Enabled() {
+		return proto.UserContext{}, "", proto.ErrOIDCDisabled
+	}
+
+	if idToken == "" {
+		return proto.UserContext{}, "", proto.ErrInvalidParameters
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcVerifyTimeout)
+	defer cancel()
+
+	claims, err := d.oidc.get(conf).Verify(ctx, idToken)
+	if err != nil {
+		d.logger.Warn().Err(err).Msg("invalid OpenID Connect authentication request: invalid ID token.")
+		metrics.AuthenticationFailures.WithLabelValues("oidc").Inc()
+		return proto.UserContext{}, "", proto.ErrInvalidIDToken
+	}
+
+	if err := d.checkOIDCClaims(conf, claims); err != nil {
+		metrics.AuthenticationFailures.WithLabelValues("oidc").Inc()
+		return proto.UserContext{}, "", err
+	}
+
+	user, err := d.findOIDCUser(conf, claims)
+	if err != nil {
+		if err == proto.ErrOIDCUserNotAllowed {
+			metrics.AuthenticationFailures.WithLabelValues("oidc").Inc()
+		}
+		return proto.UserContext{}, "", err
+	}
+
+	if user.Disabled {
+		d.logger.Warn().Str("Email", user.Email).Msg("invalid OpenID Connect authentication request: user disabled.")
+		metrics.AuthenticationFailures.WithLabelValues("oidc").Inc()
+		return proto.UserContext{}, "", proto.ErrUserDisabled
+	}
+
+	// the local second factor is required even if the OpenID Provider enforce its own
+	if user.TOTPEnabled {
+		challenge, err := d.createTwoFactorChallenge(user)
+		if err != nil {
+			return proto.UserContext{}, "", err
+		}
+
+		d.logger.Debug().Str("Email", user.Email).Msg("ID token validated, two-factor authentication required.")
+		return proto.UserContext{}, challenge, nil
+	}
+
+	d.logger.Debug().Str("Email", user.Email).Msg("successfully authenticated using OpenID Connect.")
+
+	return proto.UserContext{
+		UserID: user.ID,
+		Admin:  user.Admin,
+	}, "", nil
+}
+
+// checkOIDCClaims make sure the identity is allowed to login:
+// verified email address within the allowed domains, member of an allowed group
+func (d *daemon) checkOIDCClaims(conf config.OIDCConfig, claims oidc.Claims) error {
+	if !isEmailValid(claims.Email) || !claims.EmailVerified {
+		d.logger.Warn().Str("Subject", claims.Subject).
+			Msg("invalid OpenID Connect authentication request: missing or unverified email address.")
+		return proto.ErrOIDCUserNotAllowed
+	}
+
+	if !isEmailDomainAllowed(claims.Email, conf.AllowedDomains) {
+		d.logger.Warn().Str("Email", claims.Email).
+			Msg("invalid OpenID Connect authentication request: email domain not allowed.")
+		return proto.ErrOIDCUserNotAllowed
+	}
+
+	if !isGroupAllowed(claims.Groups, conf.AllowedGroups) {
+		d.logger.Warn().Str("Email", claims.Email).
+			Msg("invalid OpenID Connect authentication request: group not allowed.")
+		return proto.ErrOIDCUserNotAllowed
+	}
+
+	return nil
+}
+
+// findOIDCUser return the user linked to the identity
+// on first login, the existing account using the same email address is linked if allowed, or a new one is provisioned
+func (d *daemon) findOIDCUser(conf config.OIDCConfig, claims oidc.Claims) (database.User, error) {
+	user, err := d.conn.FindUserByOIDCSubject(claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return database.User{}, err
+	}
+
+	user, err = d.conn.FindUser(claims.Email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return database.User{}, err
+	}
+
+	// Link the existing account
+	if err == nil {
+		if !conf.LinkExistingUsers || user.Admin {
+			d.logger.Warn().Str("Email", user.Email).
+				Msg("invalid OpenID Connect authentication request: account not linked to the identity.")
+			return database.User{}, proto.ErrOIDCUserNotAllowed
+		}
+		if user.OIDCSubject != "" {
+			d.logger.Warn().Str("Email", user.Email).
+				Msg("invalid OpenID Connect authentication request: account linked to another identity.")
+			return database.User{}, proto.ErrOIDCUserNotAllowed
+		}
+
+		// the unverified account may have been created by someone else using this email address:
+		// its password and pending tokens are revoked so only the identity owner can login
+		if !user.Verified {
+			for _, purpose := range []string{userTokenVerifyEmail, userTokenResetPassword} {
+				if err := d.conn.DeleteUserTokens(user.ID, purpose); err != nil {
+					d.logger.Err(err).Msg("error while deleting user tokens.")
+					return database.User{}, err
+				}
+			}
+
+			user.Password = ""
+		}
+
+		// the email address has been verified by the provider
+		user.OIDCSubject = claims.Subject
+		user.Verified = true
+		if _, err := d.conn.UpdateUser(user); err != nil {
+			d.logger.Err(err).Msg("error while updating user.")
+			return database.User{}, err
+		}
+
+		d.logger.Info().Str("Email", user.Email).Msg("account linked to OpenID Connect identity.")
+
+		return user, nil
+	}
+
+	if !conf.AutoProvision {
+		d.logger.Warn().Str("Email", claims.Email).
+			Msg("invalid OpenID Connect authentication request: user not provisioned.")
+		return database.User{}, proto.ErrOIDCUserNotAllowed
+	}
+
+	// the provisioned users have no password: they can only login using OpenID Connect
+	user, err = d.conn.CreateUser(database.User{Email: claims.Email, Verified: true, OIDCSubject: claims.Subject})
+	if err != nil {
+		d.logger.Err(err).Msg("error while creating user.")
+		return database.User{}, err
+	}
+
+	d.logger.Info().Str("Email", user.Email).Msg("user provisioned from OpenID Connect identity.")
+
+	return user, nil
+}
+
+// getOIDCConfig return the OpenID Connect configuration with the default values applied
+func (d *daemon) getOIDCConfig() config.OIDCConfig {
+	conf := d.getConfig().OIDC
+
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = defaultOIDCScopes
+	}
+	if conf.GroupsClaim == "" {
+		conf.GroupsClaim = defaultOIDCGroupsClaim
+	}
+
+	return conf
+}
+
+func isGroupAllowed(groups, allowedGroups []string) bool {
+	if len(allowedGroups) == 0 {
+		return true
+	}
+
+	for _, group := range groups {
+		for _, allowed := range allowedGroups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,276 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/oidc/oidctest"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// oidcConfig return the configuration of a daemon trusting given issuer
+func oidcConfig(issuer *oidctest.Issuer) config.DaemonConfig {
+	return config.DaemonConfig{
+		OIDC: config.OIDCConfig{
+			Issuer:         issuer.URL,
+			ClientID:       issuer.ClientID,
+			AutoProvision:  true,
+			AllowedDomains: []string{"example.org"},
+			AllowedGroups:  []string{"dyndns"},
+		},
+	}
+}
+
+// oidcClaims return the claims of an allowed identity
+func oidcClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":            "248289761001",
+		"email":          "luna@example.org",
+		"email_verified": true,
+		"groups":         []string{"staff", "dyndns"},
+	}
+}
+
+func TestDaemon_GetOIDCConfig(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	d, _ := newTestDaemon(mockCtrl, oidcConfig(issuer))
+
+	conf, err := d.GetOIDCConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Issuer != issuer.URL || conf.ClientID != "opendydnsctl" || len(conf.Scopes) != 3 {
+		t.Errorf("wrong config: %+v", conf)
+	}
+
+	d.config.OIDC = config.OIDCConfig{}
+	if _, err := d.GetOIDCConfig(); err != proto.ErrOIDCDisabled {
+		t.Errorf("GetOIDCConfig() should have returned ErrOIDCDisabled: %v", err)
+	}
+	if _, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims())); err != proto.ErrOIDCDisabled {
+		t.Errorf("AuthenticateOIDC() should have returned ErrOIDCDisabled: %v", err)
+	}
+}
+
+func TestDaemon_AuthenticateOIDC(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	d, dbMock := newTestDaemon(mockCtrl, oidcConfig(issuer))
+
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Admin: true}, nil)
+
+	userCtx, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userCtx.UserID != 12 || !userCtx.Admin {
+		t.Errorf("wrong user context: %+v", userCtx)
+	}
+
+	// disabled user
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Disabled: true}, nil)
+	if _, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims())); err != proto.ErrUserDisabled {
+		t.Errorf("AuthenticateOIDC() should have returned ErrUserDisabled: %v", err)
+	}
+}
+
+func TestDaemon_AuthenticateOIDC_TwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	d, dbMock := newTestDaemon(mockCtrl, oidcConfig(issuer))
+
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", TOTPEnabled: true}, nil)
+	dbMock.EXPECT().DeleteUserTokens(uint(12), userTokenTwoFactor).Return(nil)
+
+	var stored database.UserToken
+	dbMock.EXPECT().CreateUserToken(gomock.Any()).DoAndReturn(func(token database.UserToken) (database.UserToken, error) {
+		stored = token
+		return token, nil
+	})
+
+	// no user context is returned until the second step is completed
+	userCtx, challenge, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userCtx != (proto.UserContext{}) || challenge == "" {
+		t.Errorf("wrong result: %+v %s", userCtx, challenge)
+	}
+	if stored.UserID != 12 || stored.Purpose != userTokenTwoFactor || stored.Hash != hashSecret(challenge) {
+		t.Errorf("wrong token stored: %+v", stored)
+	}
+}
+
+func TestDaemon_AuthenticateOIDC_InvalidToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	d, _ := newTestDaemon(mockCtrl, oidcConfig(issuer))
+
+	if _, _, err := d.AuthenticateOIDC(""); err != proto.ErrInvalidParameters {
+		t.Errorf("AuthenticateOIDC() should have returned ErrInvalidParameters: %v", err)
+	}
+
+	// issued for another client
+	claims := oidcClaims()
+	claims["aud"] = "other"
+	if _, _, err := d.AuthenticateOIDC(issuer.IDToken(claims)); err != proto.ErrInvalidIDToken {
+		t.Errorf("AuthenticateOIDC() should have returned ErrInvalidIDToken: %v", err)
+	}
+
+	// issued by another provider
+	other := oidctest.NewIssuer("opendydnsctl")
+	defer other.Close()
+
+	if _, _, err := d.AuthenticateOIDC(other.IDToken(oidcClaims())); err != proto.ErrInvalidIDToken {
+		t.Errorf("AuthenticateOIDC() should have returned ErrInvalidIDToken: %v", err)
+	}
+}
+
+func TestDaemon_AuthenticateOIDC_ClaimsNotAllowed(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	d, _ := newTestDaemon(mockCtrl, oidcConfig(issuer))
+
+	for name, value := range map[string]interface{}{
+		"email":          "luna@example.com",
+		"email_verified": false,
+		"groups":         []string{"staff"},
+	} {
+		claims := oidcClaims()
+		claims[name] = value
+
+		if _, _, err := d.AuthenticateOIDC(issuer.IDToken(claims)); err != proto.ErrOIDCUserNotAllowed {
+			t.Errorf("AuthenticateOIDC() should have returned ErrOIDCUserNotAllowed (%s): %v", name, err)
+		}
+	}
+}
+
+func TestDaemon_AuthenticateOIDC_LinkAccount(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	d, dbMock := newTestDaemon(mockCtrl, oidcConfig(issuer))
+
+	// the existing accounts are not linked by default
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Password: "hash"}, nil)
+
+	if _, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims())); err != proto.ErrOIDCUserNotAllowed {
+		t.Errorf("AuthenticateOIDC() should have returned ErrOIDCUserNotAllowed: %v", err)
+	}
+
+	d.config.OIDC.LinkExistingUsers = true
+
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Password: "hash", Verified: true}, nil)
+	dbMock.EXPECT().UpdateUser(database.User{
+		Model:       gorm.Model{ID: 12},
+		Email:       "luna@example.org",
+		Password:    "hash",
+		Verified:    true,
+		OIDCSubject: "248289761001",
+	}).Return(database.User{}, nil)
+
+	userCtx, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims()))
+	if err != nil || userCtx.UserID != 12 {
+		t.Errorf("wrong user context: %+v %v", userCtx, err)
+	}
+
+	// the password and pending tokens of an unverified account are revoked
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Password: "hash", Verified: false}, nil)
+	dbMock.EXPECT().DeleteUserTokens(uint(12), userTokenVerifyEmail).Return(nil)
+	dbMock.EXPECT().DeleteUserTokens(uint(12), userTokenResetPassword).Return(nil)
+	dbMock.EXPECT().UpdateUser(database.User{
+		Model:       gorm.Model{ID: 12},
+		Email:       "luna@example.org",
+		Verified:    true,
+		OIDCSubject: "248289761001",
+	}).Return(database.User{}, nil)
+
+	userCtx, _, err = d.AuthenticateOIDC(issuer.IDToken(oidcClaims()))
+	if err != nil || userCtx.UserID != 12 {
+		t.Errorf("wrong user context: %+v %v", userCtx, err)
+	}
+
+	// the account is already linked to another identity
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", OIDCSubject: "other"}, nil)
+
+	if _, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims())); err != proto.ErrOIDCUserNotAllowed {
+		t.Errorf("AuthenticateOIDC() should have returned ErrOIDCUserNotAllowed: %v", err)
+	}
+
+	// the admins are never linked
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Admin: true}, nil)
+
+	if _, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims())); err != proto.ErrOIDCUserNotAllowed {
+		t.Errorf("AuthenticateOIDC() should have returned ErrOIDCUserNotAllowed: %v", err)
+	}
+}
+
+func TestDaemon_AuthenticateOIDC_Provisioning(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	issuer := oidctest.NewIssuer("opendydnsctl")
+	defer issuer.Close()
+
+	d, dbMock := newTestDaemon(mockCtrl, oidcConfig(issuer))
+
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindUser("luna@example.org").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().CreateUser(database.User{Email: "luna@example.org", Verified: true, OIDCSubject: "248289761001"}).
+		Return(database.User{Model: gorm.Model{ID: 13}, Email: "luna@example.org", Verified: true}, nil)
+
+	userCtx, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims()))
+	if err != nil || userCtx.UserID != 13 || userCtx.Admin {
+		t.Errorf("wrong user context: %+v %v", userCtx, err)
+	}
+
+	// the provisioning is disabled
+	d.config.OIDC.AutoProvision = false
+
+	dbMock.EXPECT().FindUserByOIDCSubject("248289761001").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindUser("luna@example.org").Return(database.User{}, gorm.ErrRecordNotFound)
+
+	if _, _, err := d.AuthenticateOIDC(issuer.IDToken(oidcClaims())); err != proto.ErrOIDCUserNotAllowed {
+		t.Errorf("AuthenticateOIDC() should have returned ErrOIDCUserNotAllowed: %v", err)
+	}
+}
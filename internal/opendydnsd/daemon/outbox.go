@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"time"
+)
+
+const (
+	dnsActionAdd    = "add"
+	dnsActionUpdate = "update"
+	dnsActionDelete = "delete"
+
+	defaultOutboxInterval = time.Minute
+
+	// releaseAliasAttempts is the number of attempts to release an alias whose DNS record cannot be added
+	releaseAliasAttempts   = 3
+	releaseAliasRetryDelay = 100 * time.Millisecond
+)
+
+// applyDNSOperation apply given operation against the domain DNS provisioner
+func (d *daemon) applyDNSOperation(op database.DNSOperation) error {
+	provisioner, domainConf, err := d.findDNSProvisioner(op.Domain)
+	if err != nil {
+		return err
+	}
+
+	host, domain := getRealHostAndDomain(proto.AliasDto{Domain: op.Host + "." + op.Domain}, domainConf)
+
+	switch op.Action {
+	case dnsActionAdd:
+		return provisioner.AddRecord(host, domain, op.RecordType, op.Value)
+	case dnsActionUpdate:
+		return provisioner.UpdateRecord(host, domain, op.RecordType, op.Value)
+	case dnsActionDelete:
+		return provisioner.DeleteRecord(host, domain, op.RecordType)
+	default:
+		return fmt.Errorf("unknown DNS action `%s`", op.Action)
+	}
+}
+
+// compensate revert a DNS change whose database counterpart has failed.
+// If the revert itself fails, the operation is persisted to be retried later
+func (d *daemon) compensate(op database.DNSOperation) {
+	err := d.applyDNSOperation(op)
+	if err == nil {
+		d.logger.Info().
+			Str("Action", op.Action).
+			Str("Domain", op.Domain).
+			Str("Host", op.Host).
+			Str("Type", op.RecordType).
+			Msg("DNS change reverted.")
+		return
+	}
+
+	d.logger.Err(err).
+		Str("Action", op.Action).
+		Str("Domain", op.Domain).
+		Str("Host", op.Host).
+		Str("Type", op.RecordType).
+		Msg("unable to revert DNS change. will retry later.")
+
+	op.Attempts = 1
+	op.LastError = err.Error()
+	d.enqueueDNSOperation(op)
+}
+
+// enqueueDNSOperation persist given operation, replacing any pending one for the same record
+func (d *daemon) enqueueDNSOperation(op database.DNSOperation) {
+	if err := d.conn.DeleteDNSOperations(op.Host, op.Domain, op.RecordType); err != nil {
+		d.logger.Err(err).Msg("error while deleting pending DNS operations.")
+	}
+
+	if _, err := d.conn.CreateDNSOperation(op); err != nil {
+		d.logger.Err(err).
+			Str("Action", op.Action).
+			Str("Domain", op.Domain).
+			Str("Host", op.Host).
+			Str("Type", op.RecordType).
+			Msg("unable to persist pending DNS operation. DNS and database may disagree.")
+	}
+}
+
+// clearDNSOperations drop the pending operations for a record that has just been written
+// so that a stale operation does not override it
+func (d *daemon) clearDNSOperations(host, domain, recordType string) {
+	if err := d.conn.DeleteDNSOperations(host, domain, recordType); err != nil {
+		d.logger.Err(err).Msg("error while deleting pending DNS operations.")
+	}
+}
+
+// processDNSOperations retry the pending DNS operations once
+func (d *daemon) processDNSOperations() error {
+	ops, err := d.conn.FindDNSOperations()
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if err := d.applyDNSOperation(op); err != nil {
+			d.logger.Warn().
+				Err(err).
+				Str("Action", op.Action).
+				Str("Domain", op.Domain).
+				Str("Host", op.Host).
+				Str("Type", op.RecordType).
+				Int("Attempts", op.Attempts+1).
+				Msg("pending DNS operation failed.")
+
+			op.Attempts++
+			op.LastError = err.Error()
+			if _, err := d.conn.UpdateDNSOperation(op); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := d.conn.DeleteDNSOperation(op.ID); err != nil {
+			return err
+		}
+
+		d.logger.Info().
+			Str("Action", op.Action).
+			Str("Domain", op.Domain).
+			Str("Host", op.Host).
+			Str("Type", op.RecordType).
+			Msg("pending DNS operation applied.")
+	}
+
+	return nil
+}
+
+// runOutbox retry the pending DNS operations periodically
+func (d *daemon) runOutbox(interval time.Duration) {
+	defer d.workers.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.processDNSOperations(); err != nil {
+				d.logger.Err(err).Msg("error while processing pending DNS operations.")
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
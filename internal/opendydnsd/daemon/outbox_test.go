@@ -0,0 +1,351 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newOutboxTestDaemon(mockCtrl *gomock.Controller) (*daemon, *database_mock.MockConnection, *dns_mock.MockProvisioner) {
+	d, dbMock := newTestDaemon(mockCtrl, config.DaemonConfig{
+		DNSProvisioners: []config.DNSProvisionerConfig{dummyProvisionerConfig(config.DomainConfig{Domain: "bar.baz"})},
+	})
+
+	return d, dbMock, mockDummyProvisioner(mockCtrl, d)
+}
+
+func TestDaemon_RegisterAlias_DatabaseFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, _ := newOutboxTestDaemon(mockCtrl)
+	dbErr := fmt.Errorf("database is locked")
+
+	// the alias is reserved first: no DNS change
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().CreateAlias(gomock.Any(), uint(1)).Return(database.Alias{}, dbErr)
+
+	if _, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "1.1.1.1"}); err != dbErr {
+		t.Errorf("RegisterAlias() should have failed: %v", err)
+	}
+}
+
+func TestDaemon_RegisterAlias_DNSFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+	dnsErr := fmt.Errorf("provider unavailable")
+
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().CreateAlias(gomock.Any(), uint(1)).
+		Return(database.Alias{Model: gorm.Model{ID: 12}, Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1", UserID: 1}, nil)
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "A", "1.1.1.1").Return(dnsErr)
+
+	// the record may have been added anyway: its deletion is queued
+	dbMock.EXPECT().DeleteDNSOperations("foo", "bar.baz", "A").Return(nil)
+	dbMock.EXPECT().CreateDNSOperation(database.DNSOperation{
+		Action: dnsActionDelete, Host: "foo", Domain: "bar.baz", RecordType: "A", Attempts: 1, LastError: dnsErr.Error(),
+	}).Return(database.DNSOperation{}, nil)
+
+	// the alias is released, retrying on failure
+	gomock.InOrder(
+		dbMock.EXPECT().DeleteAlias("foo", "bar.baz", uint(1)).Return(fmt.Errorf("database is locked")),
+		dbMock.EXPECT().DeleteAlias("foo", "bar.baz", uint(1)).Return(nil),
+	)
+
+	if _, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "1.1.1.1"}); err != dnsErr {
+		t.Errorf("RegisterAlias() should have failed: %v", err)
+	}
+}
+
+func TestDaemon_RegisterAlias_Conflict(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, _ := newOutboxTestDaemon(mockCtrl)
+
+	// registered concurrently by someone else: no DNS change
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(database.Alias{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().CreateAlias(gomock.Any(), uint(1)).Return(database.Alias{}, database.ErrDuplicateAlias)
+
+	if _, err := d.RegisterAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "1.1.1.1"}); err != proto.ErrAliasTaken {
+		t.Errorf("RegisterAlias() should have returned ErrAliasTaken: %v", err)
+	}
+}
+
+func TestDaemon_UpdateAlias_DatabaseFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+	dbErr := fmt.Errorf("database is locked")
+
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").
+		Return(database.Alias{Domain: "bar.baz", Host: "foo", IPv4: "1.1.1.1", UserID: 1}, nil)
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "A", "8.8.8.8").Return(nil)
+	dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(database.Alias{}, dbErr)
+
+	// the previous value is restored
+	provisionerMock.EXPECT().UpdateRecord("foo", "bar.baz", "A", "1.1.1.1").Return(nil)
+
+	if _, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "8.8.8.8"}); err != dbErr {
+		t.Errorf("UpdateAlias() should have failed: %v", err)
+	}
+}
+
+func TestDaemon_UpdateAlias_AddIPv6_DatabaseFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+	dbErr := fmt.Errorf("database is locked")
+
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").
+		Return(database.Alias{Domain: "bar.baz", Host: "foo", IPv4: "1.1.1.1", UserID: 1}, nil)
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "AAAA", "2001:4860:4860::8888").Return(nil)
+	dbMock.EXPECT().UpdateAlias(gomock.Any()).Return(database.Alias{}, dbErr)
+
+	// the new record is removed
+	provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz", "AAAA").Return(nil)
+
+	if _, err := d.UpdateAlias(proto.UserContext{UserID: 1}, proto.AliasDto{Domain: "foo.bar.baz", Value: "2001:4860:4860::8888"}); err != dbErr {
+		t.Errorf("UpdateAlias() should have failed: %v", err)
+	}
+}
+
+func TestDaemon_DeleteAlias_DatabaseFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+	dbErr := fmt.Errorf("database is locked")
+
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").
+		Return(database.Alias{Domain: "bar.baz", Host: "foo", IPv4: "1.1.1.1", IPv6: "2001:4860:4860::8888", UserID: 1}, nil)
+	provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz", "A").Return(nil)
+	provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz", "AAAA").Return(nil)
+	dbMock.EXPECT().DeleteAlias("foo", "bar.baz", uint(1)).Return(dbErr)
+
+	// the records are restored
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "A", "1.1.1.1").Return(nil)
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "AAAA", "2001:4860:4860::8888").Return(nil)
+
+	if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "foo.bar.baz"); err != dbErr {
+		t.Errorf("DeleteAlias() should have failed: %v", err)
+	}
+}
+
+func TestDaemon_DeleteAlias_PartialDNSFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+	dnsErr := fmt.Errorf("provider unavailable")
+
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").
+		Return(database.Alias{Domain: "bar.baz", Host: "foo", IPv4: "1.1.1.1", IPv6: "2001:4860:4860::8888", UserID: 1}, nil)
+	provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz", "A").Return(nil)
+	provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz", "AAAA").Return(dnsErr)
+
+	// the already deleted record is restored
+	provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "A", "1.1.1.1").Return(nil)
+
+	if err := d.DeleteAlias(proto.UserContext{UserID: 1}, "foo.bar.baz"); err != dnsErr {
+		t.Errorf("DeleteAlias() should have failed: %v", err)
+	}
+}
+
+func TestDaemon_ProcessDNSOperations(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+
+	dbMock.EXPECT().FindDNSOperations().Return([]database.DNSOperation{
+		{Model: gorm.Model{ID: 1}, Action: dnsActionDelete, Host: "foo", Domain: "bar.baz", RecordType: "A", Attempts: 1},
+		{Model: gorm.Model{ID: 2}, Action: dnsActionAdd, Host: "bar", Domain: "bar.baz", RecordType: "AAAA", Value: "2001:4860:4860::8888", Attempts: 3},
+	}, nil)
+
+	// first operation succeed and is removed
+	provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz", "A").Return(nil)
+	dbMock.EXPECT().DeleteDNSOperation(uint(1)).Return(nil)
+
+	// second one fails and is kept
+	provisionerMock.EXPECT().AddRecord("bar", "bar.baz", "AAAA", "2001:4860:4860::8888").Return(fmt.Errorf("provider unavailable"))
+	dbMock.EXPECT().UpdateDNSOperation(database.DNSOperation{
+		Model:      gorm.Model{ID: 2},
+		Action:     dnsActionAdd,
+		Host:       "bar",
+		Domain:     "bar.baz",
+		RecordType: "AAAA",
+		Value:      "2001:4860:4860::8888",
+		Attempts:   4,
+		LastError:  "provider unavailable",
+	}).Return(database.DNSOperation{}, nil)
+
+	if err := d.processDNSOperations(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_Shutdown(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+	d.config.OutboxInterval = time.Hour
+
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending operations are flushed before closing the database
+	gomock.InOrder(
+		dbMock.EXPECT().FindDNSOperations().Return([]database.DNSOperation{
+			{Model: gorm.Model{ID: 1}, Action: dnsActionAdd, Host: "foo", Domain: "bar.baz", RecordType: "A", Value: "1.1.1.1"},
+		}, nil),
+		provisionerMock.EXPECT().AddRecord("foo", "bar.baz", "A", "1.1.1.1").Return(nil),
+		dbMock.EXPECT().DeleteDNSOperation(uint(1)).Return(nil),
+		dbMock.EXPECT().Close().Return(nil),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := d.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+
+	// the daemon is only stopped once
+	if err := d.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_Start_DNSServerFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, _ := newOutboxTestDaemon(mockCtrl)
+	d.config.OutboxInterval = time.Hour
+	d.config.DNSServer.ListenAddr = "256.0.0.1:53"
+
+	if err := d.Start(); err == nil {
+		t.Fatal("Start() should have failed")
+	}
+
+	// the background services are stopped
+	select {
+	case <-d.stop:
+	default:
+		t.Error("the background services should have been stopped")
+	}
+
+	// the resources are still released on shutdown
+	gomock.InOrder(
+		dbMock.EXPECT().FindDNSOperations().Return(nil, nil),
+		dbMock.EXPECT().Close().Return(nil),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := d.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_Reload(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, _, _ := newOutboxTestDaemon(mockCtrl)
+
+	conf := config.DaemonConfig{DNSProvisioners: []config.DNSProvisionerConfig{
+		{Name: "dummy", Config: map[string]string{}, Domains: []config.DomainConfig{{Domain: "bar.baz"}, {Domain: "example.org"}}},
+	}}
+
+	if err := d.Reload(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if domains, _ := d.GetDomains(proto.UserContext{}); len(domains) != 2 {
+		t.Errorf("configuration not reloaded: %+v", domains)
+	}
+}
+
+func TestDaemon_Reload_DomainInUse(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, _ := newOutboxTestDaemon(mockCtrl)
+
+	conf := config.DaemonConfig{DNSProvisioners: []config.DNSProvisionerConfig{
+		{Name: "dummy", Config: map[string]string{}, Domains: []config.DomainConfig{{Domain: "example.org"}}},
+	}}
+
+	dbMock.EXPECT().CountDomainAliases().Return(map[string]int64{"bar.baz": 1}, nil)
+
+	if err := d.Reload(conf); err == nil {
+		t.Error("Reload() should have failed")
+	}
+
+	// the current configuration is kept
+	if domains, _ := d.GetDomains(proto.UserContext{}); len(domains) != 1 || domains[0].Domain != "bar.baz" {
+		t.Errorf("configuration should not have been reloaded: %+v", domains)
+	}
+
+	// the aliases of a nested name are stranded too
+	dbMock.EXPECT().CountDomainAliases().Return(map[string]int64{"dyn.bar.baz": 2, "example.org": 1}, nil)
+
+	if err := d.Reload(conf); err == nil {
+		t.Error("Reload() should have failed")
+	}
+
+	// without aliases the domain can be removed
+	dbMock.EXPECT().CountDomainAliases().Return(map[string]int64{"example.org": 1}, nil)
+
+	if err := d.Reload(conf); err != nil {
+		t.Error(err)
+	}
+	if domains, _ := d.GetDomains(proto.UserContext{}); len(domains) != 1 || domains[0].Domain != "example.org" {
+		t.Errorf("configuration not reloaded: %+v", domains)
+	}
+}
+
+func TestDaemon_Reload_InvalidConfig(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, _, _ := newOutboxTestDaemon(mockCtrl)
+	providerMock := d.dnsProvider.(*dns_mock.MockProvider)
+
+	// invalid provisioner
+	conf := config.DaemonConfig{DNSProvisioners: []config.DNSProvisionerConfig{
+		{Name: "dummy", Config: map[string]string{}, Domains: []config.DomainConfig{{Domain: "bar.baz"}}},
+		{Name: "ovh", Domains: []config.DomainConfig{{Domain: "example.org"}}},
+	}}
+	providerMock.EXPECT().GetProvisioner("ovh", gomock.Any()).Return(nil, fmt.Errorf("missing config `app-key`"))
+
+	if err := d.Reload(conf); err == nil {
+		t.Error("Reload() should have failed")
+	}
+
+	// domain configured twice
+	conf = config.DaemonConfig{DNSProvisioners: []config.DNSProvisionerConfig{
+		{Name: "dummy", Config: map[string]string{}, Domains: []config.DomainConfig{{Domain: "bar.baz"}, {Domain: "bar.baz"}}},
+	}}
+
+	if err := d.Reload(conf); err == nil {
+		t.Error("Reload() should have failed")
+	}
+}
@@ -0,0 +1,166 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	userTokenResetPassword  = "reset_password"
+	defaultPasswordResetTTL = time.Hour
+)
+
+// ChangePassword replace the password of the user after checking the current one
+func (d *daemon) ChangePassword(userCtx proto.UserContext, password proto.ChangePasswordDto) error {
+	if password.CurrentPassword == "" || password.NewPassword == "" {
+		return proto.ErrInvalidParameters
+	}
+
+	if err := d.checkLocalBackend(); err != nil {
+		return err
+	}
+
+	user, err := d.findUser(userCtx.UserID)
+	if err != nil {
+		return err
+	}
+
+	if !d.validatePassword(user.Password, password.CurrentPassword) {
+		d.logger.Warn().Uint("UserID", user.ID).Msg("invalid password change request: invalid password.")
+		return proto.ErrInvalidPassword
+	}
+
+	if err := d.setPassword(user, password.NewPassword); err != nil {
+		return err
+	}
+
+	d.logger.Info().Uint("UserID", user.ID).Msg("successfully changed password.")
+
+	return nil
+}
+
+// RequestPasswordReset send a password reset token to given email address
+// the same (nil) result is returned, in about the same time, whether the account exist or not
+// to prevent email discovery: the token is created and sent in background
+func (d *daemon) RequestPasswordReset(email string) error {
+	conf := d.getConfig().PasswordReset
+	if !conf.Enabled {
+		return proto.ErrPasswordResetDisabled
+	}
+
+	if email == "" {
+		return proto.ErrInvalidParameters
+	}
+
+	user, err := d.conn.FindUser(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return err
+	}
+
+	d.notify(func() {
+		switch {
+		case err != nil:
+			d.logger.Debug().Str("Email", email).Msg("password reset requested for unknown user.")
+		case user.Disabled || !user.Verified:
+			// the unverified users must sign up again to receive a verification email
+			d.logger.Debug().Uint("UserID", user.ID).Msg("password reset requested for inactive user.")
+		default:
+			d.sendPasswordResetEmail(user, conf)
+		}
+	})
+
+	return nil
+}
+
+// sendPasswordResetEmail replace the user password reset token and send it by email
+// the errors are only logged: the user can request a new token
+func (d *daemon) sendPasswordResetEmail(user database.User, conf config.PasswordResetConfig) {
+	ttl := conf.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultPasswordResetTTL
+	}
+
+	token, err := d.createUserToken(user.ID, userTokenResetPassword, ttl)
+	if err != nil {
+		return
+	}
+
+	d.sendEmail(user.Email, "Reset your password", fmt.Sprintf("A password reset has been requested for your "+
+		"OpenDyDNS account.\n\nPlease run the following command within %s to choose a new password:\n\n"+
+		"opendydnsctl passwd reset %s\n\nIf you did not request it, you can ignore this email.\n", ttl, token))
+
+	d.logger.Info().Uint("UserID", user.ID).Msg("password reset requested.")
+}
+
+// CompletePasswordReset replace the password of the user owning given reset token
+// the token is revoked once used
+func (d *daemon) CompletePasswordReset(reset proto.PasswordResetDto) error {
+	if !d.getConfig().PasswordReset.Enabled {
+		return proto.ErrPasswordResetDisabled
+	}
+
+	if reset.Token == "" || reset.Password == "" {
+		return proto.ErrInvalidParameters
+	}
+
+	user, err := d.useTokenUser(userTokenResetPassword, reset.Token, proto.ErrInvalidResetToken)
+	if err != nil {
+		return err
+	}
+
+	if user.Disabled {
+		return proto.ErrUserDisabled
+	}
+
+	if err := d.conn.DeleteUserTokens(user.ID, userTokenResetPassword); err != nil {
+		d.logger.Err(err).Msg("error while deleting password reset tokens.")
+		return err
+	}
+
+	if err := d.setPassword(user, reset.Password); err != nil {
+		return err
+	}
+
+	d.logger.Info().Uint("UserID", user.ID).Msg("successfully reset password using token.")
+
+	return nil
+}
+
+// setPassword replace the password of given user
+// the sessions of the user are revoked
+func (d *daemon) setPassword(user database.User, password string) error {
+	pass, err := d.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user.Password = pass
+	user.PasswordChangedAt = time.Now()
+
+	if _, err := d.conn.UpdateUser(user); err != nil {
+		d.logger.Err(err).Msg("error while updating user.")
+		return err
+	}
+
+	if err := d.conn.DeleteUserSessions(user.ID); err != nil {
+		d.logger.Err(err).Msg("error while deleting sessions.")
+		return err
+	}
+
+	return nil
+}
+
+// validatePasswordResetConfig make sure the reset tokens can be sent if the password reset is enabled
+func (d *daemon) validatePasswordResetConfig(conf config.PasswordResetConfig) error {
+	if conf.Enabled && d.mailer == nil {
+		return fmt.Errorf("password reset requires the SMTP relay to be configured")
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemon_ChangePassword(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, _ := newSignupTestDaemon(mockCtrl)
+
+	pass, err := d.hashPassword("current")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.ChangePassword(proto.UserContext{UserID: 1}, proto.ChangePasswordDto{NewPassword: "new"}); err != proto.ErrInvalidParameters {
+		t.Error("ChangePassword() should have returned ErrInvalidParameters")
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Password: pass}, nil).Times(2)
+
+	// wrong current password
+	if err := d.ChangePassword(proto.UserContext{UserID: 1}, proto.ChangePasswordDto{
+		CurrentPassword: "wrong",
+		NewPassword:     "new",
+	}); err != proto.ErrInvalidPassword {
+		t.Error("ChangePassword() should have returned ErrInvalidPassword")
+	}
+
+	dbMock.EXPECT().UpdateUser(gomock.Any()).DoAndReturn(func(user database.User) (database.User, error) {
+		if !d.validatePassword(user.Password, "new") || time.Since(user.PasswordChangedAt) > time.Minute {
+			t.Errorf("wrong user updated: %+v", user)
+		}
+		return user, nil
+	})
+	dbMock.EXPECT().DeleteUserSessions(uint(1)).Return(nil)
+
+	if err := d.ChangePassword(proto.UserContext{UserID: 1}, proto.ChangePasswordDto{
+		CurrentPassword: "current",
+		NewPassword:     "new",
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_RequestPasswordReset(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, mailerMock := newSignupTestDaemon(mockCtrl)
+
+	if err := d.RequestPasswordReset("luna@example.org"); err != proto.ErrPasswordResetDisabled {
+		t.Error("RequestPasswordReset() should have returned ErrPasswordResetDisabled")
+	}
+
+	d.config.PasswordReset = config.PasswordResetConfig{Enabled: true}
+
+	// unknown and inactive users: nothing is sent
+	dbMock.EXPECT().FindUser("unknown@example.org").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().FindUser("disabled@example.org").Return(database.User{Verified: true, Disabled: true}, nil)
+	dbMock.EXPECT().FindUser("unverified@example.org").Return(database.User{}, nil)
+	for _, email := range []string{"unknown@example.org", "disabled@example.org", "unverified@example.org"} {
+		if err := d.RequestPasswordReset(email); err != nil {
+			t.Errorf("RequestPasswordReset(%s) should not have failed: %s", email, err)
+		}
+	}
+	d.notifications.Wait()
+
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true}, nil)
+	dbMock.EXPECT().DeleteUserTokens(uint(12), userTokenResetPassword).Return(nil)
+
+	var stored database.UserToken
+	dbMock.EXPECT().CreateUserToken(gomock.Any()).DoAndReturn(func(token database.UserToken) (database.UserToken, error) {
+		stored = token
+		return token, nil
+	})
+
+	var body string
+	mailerMock.EXPECT().Send("luna@example.org", "Reset your password", gomock.Any()).
+		DoAndReturn(func(to, subject, b string) error {
+			body = b
+			return nil
+		})
+
+	if err := d.RequestPasswordReset("luna@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	d.notifications.Wait()
+
+	if stored.UserID != 12 || stored.Purpose != userTokenResetPassword ||
+		stored.ExpiresAt.After(time.Now().Add(defaultPasswordResetTTL)) {
+		t.Errorf("wrong token stored: %+v", stored)
+	}
+
+	idx := strings.Index(body, "opendydnsctl passwd reset ")
+	if idx == -1 {
+		t.Fatalf("missing reset command: %s", body)
+	}
+	token := strings.Fields(body[idx+len("opendydnsctl passwd reset "):])[0]
+	if stored.Hash != hashSecret(token) {
+		t.Errorf("wrong token hash stored")
+	}
+}
+
+func TestDaemon_CompletePasswordReset(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, _ := newSignupTestDaemon(mockCtrl)
+	d.config.PasswordReset = config.PasswordResetConfig{Enabled: true}
+
+	if err := d.CompletePasswordReset(proto.PasswordResetDto{Token: "good"}); err != proto.ErrInvalidParameters {
+		t.Error("CompletePasswordReset() should have returned ErrInvalidParameters")
+	}
+
+	// unknown or already used token
+	dbMock.EXPECT().UseUserToken(userTokenResetPassword, hashSecret("used")).
+		Return(database.UserToken{}, gorm.ErrRecordNotFound)
+	if err := d.CompletePasswordReset(proto.PasswordResetDto{Token: "used", Password: "new"}); err != proto.ErrInvalidResetToken {
+		t.Error("CompletePasswordReset() should have returned ErrInvalidResetToken")
+	}
+
+	// expired token: not consumed by the database
+	dbMock.EXPECT().UseUserToken(userTokenResetPassword, hashSecret("expired")).
+		Return(database.UserToken{}, gorm.ErrRecordNotFound)
+	if err := d.CompletePasswordReset(proto.PasswordResetDto{Token: "expired", Password: "new"}); err != proto.ErrInvalidResetToken {
+		t.Error("CompletePasswordReset() should have returned ErrInvalidResetToken")
+	}
+
+	dbMock.EXPECT().UseUserToken(userTokenResetPassword, hashSecret("good")).
+		Return(database.UserToken{UserID: 12, ExpiresAt: time.Now().Add(time.Hour)}, nil)
+	dbMock.EXPECT().FindUserByID(uint(12)).Return(database.User{Model: gorm.Model{ID: 12}, Verified: true}, nil)
+	dbMock.EXPECT().DeleteUserTokens(uint(12), userTokenResetPassword).Return(nil)
+	dbMock.EXPECT().UpdateUser(gomock.Any()).DoAndReturn(func(user database.User) (database.User, error) {
+		if !d.validatePassword(user.Password, "new") || user.PasswordChangedAt.IsZero() {
+			t.Errorf("wrong user updated: %+v", user)
+		}
+		return user, nil
+	})
+	dbMock.EXPECT().DeleteUserSessions(uint(12)).Return(nil)
+
+	if err := d.CompletePasswordReset(proto.PasswordResetDto{Token: "good", Password: "new"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_ValidatePasswordResetConfig(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, _, _ := newSignupTestDaemon(mockCtrl)
+
+	if err := d.validatePasswordResetConfig(config.PasswordResetConfig{Enabled: true}); err != nil {
+		t.Error(err)
+	}
+
+	d.mailer = nil
+	if err := d.validatePasswordResetConfig(config.PasswordResetConfig{Enabled: true}); err == nil {
+		t.Error("validatePasswordResetConfig() should have failed")
+	}
+	if err := d.validatePasswordResetConfig(config.PasswordResetConfig{}); err != nil {
+		t.Error(err)
+	}
+}
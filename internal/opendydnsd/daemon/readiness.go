@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/proto"
+	"sync"
+	"time"
+)
+
+// readinessCheckTimeout is the time given to a DNS provisioner to answer a readiness check
+const readinessCheckTimeout = 5 * time.Second
+
+// CheckReadiness check the database connection and,
+// if asked to, the DNS provisioner of each configured domain
+func (d *daemon) CheckReadiness(checkProvisioners bool) proto.ReadinessDto {
+	readiness := proto.ReadinessDto{
+		Ready:    true,
+		Database: newStatusDto(d.conn.Ping()),
+	}
+	if readiness.Database.Status != proto.StatusOK {
+		d.logger.Warn().Str("Error", readiness.Database.Error).Msg("database is not ready.")
+		readiness.Ready = false
+	}
+
+	if !checkProvisioners {
+		return readiness
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	readiness.Domains = map[string]proto.StatusDto{}
+
+	for _, dnsProvisioner := range d.getConfig().DNSProvisioners {
+		for _, domainConf := range dnsProvisioner.Domains {
+			wg.Add(1)
+			go func(dnsProvisioner config.DNSProvisionerConfig, domainConf config.DomainConfig) {
+				defer wg.Done()
+
+				status := newStatusDto(d.checkProvisioner(dnsProvisioner, domainConf))
+
+				lock.Lock()
+				defer lock.Unlock()
+
+				readiness.Domains[domainConf.String()] = status
+				if status.Status != proto.StatusOK {
+					d.logger.Warn().
+						Str("Provisioner", dnsProvisioner.Name).
+						Str("Domain", domainConf.String()).
+						Str("Error", status.Error).
+						Msg("DNS provisioner is not ready.")
+					readiness.Ready = false
+				}
+			}(dnsProvisioner, domainConf)
+		}
+	}
+
+	wg.Wait()
+
+	return readiness
+}
+
+// checkProvisioner run the check of the domain DNS provisioner
+// giving up after readinessCheckTimeout
+func (d *daemon) checkProvisioner(dnsProvisioner config.DNSProvisionerConfig, domainConf config.DomainConfig) error {
+	provisioner, err := d.getProvisioner(dnsProvisioner, domainConf)
+	if err != nil {
+		return err
+	}
+
+	res := make(chan error, 1)
+	go func() {
+		res <- provisioner.Check(domainConf.Domain)
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-time.After(readinessCheckTimeout):
+		return fmt.Errorf("%s provisioner did not answer within %s", dnsProvisioner.Name, readinessCheckTimeout)
+	}
+}
+
+func newStatusDto(err error) proto.StatusDto {
+	if err != nil {
+		return proto.StatusDto{Status: proto.StatusError, Error: err.Error()}
+	}
+
+	return proto.StatusDto{Status: proto.StatusOK}
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"testing"
+)
+
+func TestDaemon_CheckReadiness(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+
+	dbMock.EXPECT().Ping().Return(nil).Times(2)
+	provisionerMock.EXPECT().Check("bar.baz").Return(nil)
+
+	// DNS provisioners are not checked unless asked
+	readiness := d.CheckReadiness(false)
+	if !readiness.Ready || readiness.Database.Status != proto.StatusOK || readiness.Domains != nil {
+		t.Errorf("wrong readiness: %+v", readiness)
+	}
+
+	readiness = d.CheckReadiness(true)
+	if !readiness.Ready || readiness.Domains["bar.baz"].Status != proto.StatusOK {
+		t.Errorf("wrong readiness: %+v", readiness)
+	}
+}
+
+func TestDaemon_CheckReadiness_Failure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+
+	dbMock.EXPECT().Ping().Return(nil)
+	provisionerMock.EXPECT().Check("bar.baz").Return(fmt.Errorf("zone not found"))
+
+	readiness := d.CheckReadiness(true)
+	if readiness.Ready || readiness.Database.Status != proto.StatusOK {
+		t.Errorf("wrong readiness: %+v", readiness)
+	}
+	if status := readiness.Domains["bar.baz"]; status.Status != proto.StatusError || status.Error != "zone not found" {
+		t.Errorf("wrong domain status: %+v", status)
+	}
+
+	dbMock.EXPECT().Ping().Return(fmt.Errorf("database is closed"))
+
+	readiness = d.CheckReadiness(false)
+	if readiness.Ready || readiness.Database.Status != proto.StatusError || readiness.Database.Error != "database is closed" {
+		t.Errorf("wrong readiness: %+v", readiness)
+	}
+}
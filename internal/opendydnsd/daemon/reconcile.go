@@ -0,0 +1,235 @@
+package daemon
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
+	"github.com/creekorful/open-dydns/proto"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reconcile compare the records served by the DNS providers with the aliases
+// and report the missing, stale and orphaned records. Unless dryRun is set
+// missing and stale records are repaired, and orphaned ones are deleted if allowed
+func (d *daemon) Reconcile(dryRun bool) (proto.ReconcileReportDto, error) {
+	report := proto.ReconcileReportDto{
+		DryRun:   dryRun,
+		Missing:  []proto.RecordDto{},
+		Stale:    []proto.RecordDto{},
+		Orphaned: []proto.RecordDto{},
+	}
+
+	// Records with a pending DNS operation are left to the outbox worker
+	ops, err := d.conn.FindDNSOperations()
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching pending DNS operations.")
+		return proto.ReconcileReportDto{}, err
+	}
+	pending := map[string]bool{}
+	for _, op := range ops {
+		pending[recordKey(op.Host+"."+op.Domain, op.RecordType)] = true
+	}
+
+	for _, dnsProvisioner := range d.getConfig().DNSProvisioners {
+		// records served by the built-in DNS server cannot drift
+		if dnsProvisioner.Name == dns.AuthoritativeProvisionerName {
+			continue
+		}
+
+		for _, domainConf := range dnsProvisioner.Domains {
+			if err := d.reconcileDomain(dnsProvisioner, domainConf, pending, &report); err != nil {
+				d.logger.Err(err).Str("Domain", domainConf.String()).Msg("error while reconciling domain.")
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", domainConf.String(), err))
+			}
+		}
+	}
+
+	for _, records := range [][]proto.RecordDto{report.Missing, report.Stale, report.Orphaned} {
+		sortRecords(records)
+	}
+
+	d.logger.Info().
+		Bool("DryRun", dryRun).
+		Int("Missing", len(report.Missing)).
+		Int("Stale", len(report.Stale)).
+		Int("Orphaned", len(report.Orphaned)).
+		Int("Repaired", report.Repaired).
+		Int("Errors", len(report.Errors)).
+		Msg("reconciliation done.")
+
+	return report, nil
+}
+
+func (d *daemon) reconcileDomain(dnsProvisioner config.DNSProvisionerConfig, domainConf config.DomainConfig,
+	pending map[string]bool, report *proto.ReconcileReportDto) error {
+	provisioner, err := d.getProvisioner(dnsProvisioner, domainConf)
+	if err != nil {
+		return err
+	}
+
+	records, err := provisioner.ListRecords(domainConf.Domain)
+	if err != nil {
+		return err
+	}
+
+	aliases, err := d.conn.FindDomainAliases(domainConf.String())
+	if err != nil {
+		return err
+	}
+
+	// only the records of the aliases created on this domain (deleted ones included) are managed:
+	// the other records of the zone belong to the user or to another configured domain
+	hosts, err := d.conn.FindDomainAliasHosts(domainConf.String())
+	if err != nil {
+		return err
+	}
+	managed := map[string]bool{}
+	for _, host := range hosts {
+		name := getRecordName(host, domainConf.String())
+		if !d.isServedByOtherDomain(name, domainConf) {
+			managed[strings.ToLower(name)] = true
+		}
+	}
+
+	// record name + type -> served values
+	served := map[string][]string{}
+	for _, record := range records {
+		key := recordKey(getRecordName(record.Host, domainConf.Domain), record.Type)
+		served[key] = append(served[key], record.Value)
+	}
+
+	// Find the missing & stale records
+	expected := map[string]bool{}
+	for _, alias := range aliases {
+		host, domain := getRealHostAndDomain(proto.AliasDto{Domain: alias.Host + "." + alias.Domain}, domainConf)
+
+		for _, dto := range newAliasDtos(alias) {
+			key := recordKey(dto.Domain, dto.Type)
+			expected[key] = true
+
+			if pending[key] {
+				continue
+			}
+
+			record := proto.RecordDto{Name: dto.Domain, Type: dto.Type, Expected: dto.Value}
+			values := served[key]
+
+			var repair func() error
+			switch {
+			case len(values) == 0:
+				report.Missing = append(report.Missing, record)
+				repair = func() error { return provisioner.AddRecord(host, domain, dto.Type, dto.Value) }
+			case len(values) != 1 || !sameAddress(values[0], dto.Value):
+				record.Value = strings.Join(values, ",")
+				report.Stale = append(report.Stale, record)
+				repair = func() error { return provisioner.UpdateRecord(host, domain, dto.Type, dto.Value) }
+			default:
+				continue
+			}
+
+			if !report.DryRun {
+				d.repair(report, record, repair)
+			}
+		}
+	}
+
+	// Find the orphaned records
+	for _, record := range records {
+		name := getRecordName(record.Host, domainConf.Domain)
+		key := recordKey(name, record.Type)
+		if expected[key] || pending[key] || !managed[strings.ToLower(name)] {
+			continue
+		}
+		expected[key] = true // report each record set only once
+
+		dto := proto.RecordDto{Name: name, Type: record.Type, Value: strings.Join(served[key], ",")}
+		report.Orphaned = append(report.Orphaned, dto)
+
+		if !report.DryRun && d.getConfig().Reconciler.DeleteOrphans {
+			host, recordType := record.Host, record.Type
+			d.repair(report, dto, func() error { return provisioner.DeleteRecord(host, domainConf.Domain, recordType) })
+		}
+	}
+
+	return nil
+}
+
+func (d *daemon) repair(report *proto.ReconcileReportDto, record proto.RecordDto, repair func() error) {
+	if err := repair(); err != nil {
+		d.logger.Err(err).Str("Name", record.Name).Str("Type", record.Type).Msg("error while repairing DNS record.")
+		report.Errors = append(report.Errors, fmt.Sprintf("%s %s: %s", record.Name, record.Type, err))
+		return
+	}
+
+	d.logger.Info().Str("Name", record.Name).Str("Type", record.Type).Msg("DNS record repaired.")
+	report.Repaired++
+}
+
+// runReconciler reconcile the DNS providers with the database periodically
+func (d *daemon) runReconciler(interval time.Duration) {
+	defer d.workers.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := d.Reconcile(!d.getConfig().Reconciler.Repair); err != nil {
+				d.logger.Err(err).Msg("error while reconciling DNS records.")
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// isServedByOtherDomain determinate if given record name belongs to a configured domain
+// more specific than given one (i.e dyn.bar.baz for bar.baz)
+func (d *daemon) isServedByOtherDomain(name string, domainConf config.DomainConfig) bool {
+	name = strings.ToLower(name)
+
+	for _, dnsProvisioner := range d.getConfig().DNSProvisioners {
+		for _, other := range dnsProvisioner.Domains {
+			domain := strings.ToLower(other.String())
+			if len(domain) <= len(domainConf.String()) {
+				continue
+			}
+
+			if name == domain || strings.HasSuffix(name, "."+domain) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func sameAddress(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipA.Equal(ipB)
+}
+
+func getRecordName(host, domain string) string {
+	if host == "" {
+		return domain
+	}
+
+	return host + "." + domain
+}
+
+func recordKey(name, recordType string) string {
+	return strings.ToLower(name) + " " + recordType
+}
+
+func sortRecords(records []proto.RecordDto) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Type < records[j].Type
+	})
+}
@@ -0,0 +1,199 @@
+package daemon
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/dns_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"testing"
+)
+
+func newReconcileTestDaemon(mockCtrl *gomock.Controller, deleteOrphans bool) (*daemon, *database_mock.MockConnection, *dns_mock.MockProvisioner) {
+	d, dbMock := newTestDaemon(mockCtrl, config.DaemonConfig{
+		DNSProvisioners: []config.DNSProvisionerConfig{
+			dummyProvisionerConfig(config.DomainConfig{Domain: "bar.baz", Host: "dyn"}),
+			{
+				Name:    dns.AuthoritativeProvisionerName,
+				Config:  map[string]string{"nameservers": "ns1.bar.baz"},
+				Domains: []config.DomainConfig{{Domain: "example.org"}},
+			},
+		},
+		Reconciler: config.ReconcilerConfig{DeleteOrphans: deleteOrphans},
+	})
+
+	return d, dbMock, mockDummyProvisioner(mockCtrl, d)
+}
+
+func expectReconcileState(dbMock *database_mock.MockConnection, provisionerMock *dns_mock.MockProvisioner) {
+	dbMock.EXPECT().FindDNSOperations().Return([]database.DNSOperation{
+		{Action: dnsActionDelete, Host: "pending", Domain: "dyn.bar.baz", RecordType: "A"},
+	}, nil)
+
+	provisionerMock.EXPECT().ListRecords("bar.baz").Return([]dns.Record{
+		{Host: "www", Type: "A", Value: "9.9.9.9"},                           // not managed
+		{Host: "ok.dyn", Type: "A", Value: "1.1.1.1"},                        // in sync
+		{Host: "ok.dyn", Type: "AAAA", Value: "2001:4860:4860:0:0:0:0:8888"}, // in sync (other notation)
+		{Host: "stale.dyn", Type: "A", Value: "8.8.8.8"},                     // wrong value
+		{Host: "orphan.dyn", Type: "A", Value: "4.4.4.4"},                    // no alias
+		{Host: "pending.dyn", Type: "A", Value: "5.5.5.5"},                   // pending DNS operation
+	}, nil)
+
+	dbMock.EXPECT().FindDomainAliases("dyn.bar.baz").Return([]database.Alias{
+		{Host: "ok", Domain: "dyn.bar.baz", IPv4: "1.1.1.1", IPv6: "2001:4860:4860::8888"},
+		{Host: "stale", Domain: "dyn.bar.baz", IPv4: "1.1.1.1"},
+		{Host: "missing", Domain: "dyn.bar.baz", IPv6: "2001:4860:4860::8844"},
+	}, nil)
+	dbMock.EXPECT().FindDomainAliasHosts("dyn.bar.baz").Return([]string{"missing", "ok", "orphan", "pending", "stale"}, nil)
+}
+
+func TestDaemon_Reconcile_DryRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newReconcileTestDaemon(mockCtrl, true)
+	expectReconcileState(dbMock, provisionerMock)
+
+	report, err := d.Reconcile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.DryRun || report.Repaired != 0 || len(report.Errors) != 0 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	if len(report.Missing) != 1 || report.Missing[0] != (proto.RecordDto{Name: "missing.dyn.bar.baz", Type: "AAAA", Expected: "2001:4860:4860::8844"}) {
+		t.Errorf("wrong missing records: %+v", report.Missing)
+	}
+	if len(report.Stale) != 1 || report.Stale[0] != (proto.RecordDto{Name: "stale.dyn.bar.baz", Type: "A", Value: "8.8.8.8", Expected: "1.1.1.1"}) {
+		t.Errorf("wrong stale records: %+v", report.Stale)
+	}
+	if len(report.Orphaned) != 1 || report.Orphaned[0] != (proto.RecordDto{Name: "orphan.dyn.bar.baz", Type: "A", Value: "4.4.4.4"}) {
+		t.Errorf("wrong orphaned records: %+v", report.Orphaned)
+	}
+}
+
+func TestDaemon_Reconcile_Repair(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newReconcileTestDaemon(mockCtrl, false)
+	expectReconcileState(dbMock, provisionerMock)
+
+	provisionerMock.EXPECT().UpdateRecord("stale.dyn", "bar.baz", "A", "1.1.1.1").Return(nil)
+	provisionerMock.EXPECT().AddRecord("missing.dyn", "bar.baz", "AAAA", "2001:4860:4860::8844").Return(fmt.Errorf("provider unavailable"))
+
+	report, err := d.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// orphans are only reported since not allowed to delete them
+	if report.Repaired != 1 || len(report.Errors) != 1 || len(report.Orphaned) != 1 {
+		t.Errorf("wrong report: %+v", report)
+	}
+}
+
+func TestDaemon_Reconcile_DeleteOrphans(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newReconcileTestDaemon(mockCtrl, true)
+	expectReconcileState(dbMock, provisionerMock)
+
+	provisionerMock.EXPECT().UpdateRecord("stale.dyn", "bar.baz", "A", "1.1.1.1").Return(nil)
+	provisionerMock.EXPECT().AddRecord("missing.dyn", "bar.baz", "AAAA", "2001:4860:4860::8844").Return(nil)
+	provisionerMock.EXPECT().DeleteRecord("orphan.dyn", "bar.baz", "A").Return(nil)
+
+	report, err := d.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Repaired != 3 || len(report.Errors) != 0 {
+		t.Errorf("wrong report: %+v", report)
+	}
+}
+
+func TestDaemon_Reconcile_ListFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newReconcileTestDaemon(mockCtrl, false)
+
+	dbMock.EXPECT().FindDNSOperations().Return(nil, nil)
+	provisionerMock.EXPECT().ListRecords("bar.baz").Return(nil, fmt.Errorf("provider unavailable"))
+
+	report, err := d.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Errors) != 1 || report.Errors[0] != "dyn.bar.baz: provider unavailable" {
+		t.Errorf("wrong report: %+v", report)
+	}
+}
+
+func TestDaemon_Reconcile_SharedZone(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// two domains served by the same zone
+	d, dbMock := newTestDaemon(mockCtrl, config.DaemonConfig{
+		DNSProvisioners: []config.DNSProvisionerConfig{
+			dummyProvisionerConfig(config.DomainConfig{Domain: "bar.baz"}, config.DomainConfig{Domain: "bar.baz", Host: "dyn"}),
+		},
+		Reconciler: config.ReconcilerConfig{DeleteOrphans: true},
+	})
+	provisionerMock := mockDummyProvisioner(mockCtrl, d)
+
+	dbMock.EXPECT().FindDNSOperations().Return(nil, nil)
+	provisionerMock.EXPECT().ListRecords("bar.baz").Return([]dns.Record{
+		{Host: "", Type: "A", Value: "9.9.9.9"},        // apex, not managed
+		{Host: "www", Type: "A", Value: "9.9.9.9"},     // not managed
+		{Host: "dyn", Type: "A", Value: "9.9.9.9"},     // apex of dyn.bar.baz
+		{Host: "foo.dyn", Type: "A", Value: "1.1.1.1"}, // alias of dyn.bar.baz
+		{Host: "old", Type: "A", Value: "4.4.4.4"},     // deleted alias of bar.baz
+	}, nil).Times(2)
+
+	dbMock.EXPECT().FindDomainAliases("bar.baz").Return(nil, nil)
+	dbMock.EXPECT().FindDomainAliasHosts("bar.baz").Return([]string{"dyn", "old"}, nil)
+	dbMock.EXPECT().FindDomainAliases("dyn.bar.baz").
+		Return([]database.Alias{{Host: "foo", Domain: "dyn.bar.baz", IPv4: "1.1.1.1"}}, nil)
+	dbMock.EXPECT().FindDomainAliasHosts("dyn.bar.baz").Return([]string{"foo"}, nil)
+
+	// only the deleted alias is removed
+	provisionerMock.EXPECT().DeleteRecord("old", "bar.baz", "A").Return(nil)
+
+	report, err := d.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Orphaned) != 1 || report.Orphaned[0].Name != "old.bar.baz" || report.Repaired != 1 ||
+		len(report.Missing) != 0 || len(report.Stale) != 0 || len(report.Errors) != 0 {
+		t.Errorf("wrong report: %+v", report)
+	}
+}
+
+func TestDaemon_IsServedByOtherDomain(t *testing.T) {
+	d := &daemon{config: config.DaemonConfig{
+		DNSProvisioners: []config.DNSProvisionerConfig{
+			dummyProvisionerConfig(config.DomainConfig{Domain: "bar.baz"}, config.DomainConfig{Domain: "bar.baz", Host: "dyn"}),
+		},
+	}}
+	apex := config.DomainConfig{Domain: "bar.baz"}
+
+	if d.isServedByOtherDomain("foo.bar.baz", apex) {
+		t.Error("foo.bar.baz should be served by bar.baz")
+	}
+	if !d.isServedByOtherDomain("dyn.bar.baz", apex) || !d.isServedByOtherDomain("foo.DYN.bar.baz", apex) {
+		t.Error("dyn.bar.baz should be served by dyn.bar.baz")
+	}
+	if d.isServedByOtherDomain("foo.dyn.bar.baz", config.DomainConfig{Domain: "bar.baz", Host: "dyn"}) {
+		t.Error("foo.dyn.bar.baz should be served by dyn.bar.baz")
+	}
+}
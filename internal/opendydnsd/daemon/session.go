@@ -0,0 +1,227 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	refreshTokenPrefix = "odr_"
+	defaultSessionTTL  = 30 * 24 * time.Hour
+	// sessionsPurgeInterval is the interval between the deletion of the expired sessions
+	sessionsPurgeInterval = time.Hour
+)
+
+// CreateSession create a new session for the (authenticated) user
+// the returned user context identify the session, the refresh token is only returned once
+func (d *daemon) CreateSession(userCtx proto.UserContext, userAgent, ip string) (proto.UserContext, string, error) {
+	refreshToken, err := generateSecret(refreshTokenPrefix)
+	if err != nil {
+		d.logger.Err(err).Msg("error while generating refresh token.")
+		return proto.UserContext{}, "", err
+	}
+
+	now := time.Now()
+	session, err := d.conn.CreateSession(database.Session{
+		UserID:      userCtx.UserID,
+		RefreshHash: hashSecret(refreshToken),
+		UserAgent:   userAgent,
+		IP:          ip,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(d.getSessionTTL()),
+	})
+	if err != nil {
+		d.logger.Err(err).Msg("error while creating session.")
+		return proto.UserContext{}, "", err
+	}
+
+	d.logger.Debug().Uint("UserID", userCtx.UserID).Uint("SessionID", session.ID).Msg("session created.")
+
+	userCtx.SessionID = session.ID
+	return userCtx, refreshToken, nil
+}
+
+// RefreshSession rotate given refresh token and return the user context of its session
+// the user account is checked again since the JWT token carry its role, as well as
+// the authentication backend: the session is revoked if the backend no longer allow the user
+func (d *daemon) RefreshSession(refreshToken string) (proto.UserContext, string, error) {
+	if refreshToken == "" {
+		return proto.UserContext{}, "", proto.ErrInvalidParameters
+	}
+
+	session, err := d.conn.FindSessionByRefreshHash(hashSecret(refreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.UserContext{}, "", proto.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.UserContext{}, "", err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		d.logger.Debug().Uint("SessionID", session.ID).Msg("expired session.")
+		return proto.UserContext{}, "", proto.ErrInvalidRefreshToken
+	}
+
+	user, err := d.findUser(session.UserID)
+	if err == proto.ErrUserNotFound {
+		return proto.UserContext{}, "", proto.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return proto.UserContext{}, "", err
+	}
+
+	if user.Disabled {
+		return proto.UserContext{}, "", proto.ErrUserDisabled
+	}
+
+	if !user.Verified {
+		return proto.UserContext{}, "", proto.ErrEmailNotVerified
+	}
+
+	authenticator, err := d.getAuthenticator()
+	if err != nil {
+		return proto.UserContext{}, "", err
+	}
+
+	user, err = authenticator.Refresh(user)
+	if err == proto.ErrInvalidParameters {
+		d.logger.Info().Uint("SessionID", session.ID).Msg("session revoked: user no longer allowed by the authentication backend.")
+		if err := d.conn.DeleteSession(session.UserID, session.ID); err != nil {
+			d.logger.Err(err).Msg("error while deleting session.")
+			return proto.UserContext{}, "", err
+		}
+		return proto.UserContext{}, "", proto.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return proto.UserContext{}, "", err
+	}
+
+	newRefreshToken, err := generateSecret(refreshTokenPrefix)
+	if err != nil {
+		d.logger.Err(err).Msg("error while generating refresh token.")
+		return proto.UserContext{}, "", err
+	}
+
+	now := time.Now()
+	refreshHash := session.RefreshHash
+	session.RefreshHash = hashSecret(newRefreshToken)
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(d.getSessionTTL())
+
+	// the refresh token is only rotated once, even by concurrent requests
+	if _, err := d.conn.RotateSession(session, refreshHash); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			d.logger.Warn().Uint("SessionID", session.ID).Msg("refresh token already rotated.")
+			return proto.UserContext{}, "", proto.ErrInvalidRefreshToken
+		}
+
+		d.logger.Err(err).Msg("error while updating session.")
+		return proto.UserContext{}, "", err
+	}
+
+	return proto.UserContext{
+		UserID:    user.ID,
+		Admin:     user.Admin,
+		SessionID: session.ID,
+	}, newRefreshToken, nil
+}
+
+// GetSessions return the active sessions of the user
+func (d *daemon) GetSessions(userCtx proto.UserContext) ([]proto.SessionDto, error) {
+	sessions, err := d.conn.FindUserSessions(userCtx.UserID)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, err
+	}
+
+	sessionDtos := make([]proto.SessionDto, 0, len(sessions))
+	for _, session := range sessions {
+		sessionDtos = append(sessionDtos, proto.SessionDto{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == userCtx.SessionID,
+		})
+	}
+
+	return sessionDtos, nil
+}
+
+// RevokeSession delete given session of the user
+// its refresh token and JWT tokens can no longer be used
+func (d *daemon) RevokeSession(userCtx proto.UserContext, sessionID uint) error {
+	if err := d.conn.DeleteSession(userCtx.UserID, sessionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return proto.ErrSessionNotFound
+		}
+
+		d.logger.Err(err).Msg("error while deleting session.")
+		return err
+	}
+
+	d.logger.Debug().Uint("UserID", userCtx.UserID).Uint("SessionID", sessionID).Msg("session revoked.")
+
+	return nil
+}
+
+// checkSession make sure the session still exist and belongs to given user
+func (d *daemon) checkSession(userID, sessionID uint) error {
+	session, err := d.conn.FindSession(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return proto.ErrInvalidSession
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return err
+	}
+
+	if session.UserID != userID || time.Now().After(session.ExpiresAt) {
+		return proto.ErrInvalidSession
+	}
+
+	return nil
+}
+
+// runSessionsPurge delete the expired sessions periodically
+func (d *daemon) runSessionsPurge(interval time.Duration) {
+	defer d.workers.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.purgeSessions()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// purgeSessions delete the expired sessions, errors are logged
+func (d *daemon) purgeSessions() {
+	count, err := d.conn.DeleteExpiredSessions()
+	if err != nil {
+		d.logger.Err(err).Msg("error while deleting expired sessions.")
+		return
+	}
+
+	if count > 0 {
+		d.logger.Debug().Int64("Count", count).Msg("expired sessions deleted.")
+	}
+}
+
+func (d *daemon) getSessionTTL() time.Duration {
+	if ttl := d.getConfig().SessionTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultSessionTTL
+}
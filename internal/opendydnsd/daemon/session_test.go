@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemon_CreateSession(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	var stored database.Session
+	dbMock.EXPECT().CreateSession(gomock.Any()).DoAndReturn(func(session database.Session) (database.Session, error) {
+		stored = session
+		session.ID = 4
+		return session, nil
+	})
+
+	userCtx, refreshToken, err := d.CreateSession(proto.UserContext{UserID: 1, Admin: true}, "opendydnsctl", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userCtx.UserID != 1 || !userCtx.Admin || userCtx.SessionID != 4 || !strings.HasPrefix(refreshToken, refreshTokenPrefix) {
+		t.Errorf("wrong session returned: %+v %s", userCtx, refreshToken)
+	}
+
+	// only the hash is stored
+	if stored.UserID != 1 || stored.RefreshHash != hashSecret(refreshToken) || stored.UserAgent != "opendydnsctl" ||
+		stored.IP != "192.0.2.1" || stored.ExpiresAt.Before(time.Now().Add(defaultSessionTTL-time.Minute)) {
+		t.Errorf("wrong session stored: %+v", stored)
+	}
+}
+
+func TestDaemon_RefreshSession(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	if _, _, err := d.RefreshSession(""); err != proto.ErrInvalidParameters {
+		t.Error("RefreshSession() should have returned ErrInvalidParameters")
+	}
+
+	// unknown (or already rotated) refresh token
+	dbMock.EXPECT().FindSessionByRefreshHash(hashSecret("odr_unknown")).Return(database.Session{}, gorm.ErrRecordNotFound)
+	if _, _, err := d.RefreshSession("odr_unknown"); err != proto.ErrInvalidRefreshToken {
+		t.Error("RefreshSession() should have returned ErrInvalidRefreshToken")
+	}
+
+	// expired session
+	dbMock.EXPECT().FindSessionByRefreshHash(hashSecret("odr_expired")).
+		Return(database.Session{Model: gorm.Model{ID: 3}, UserID: 1, ExpiresAt: time.Now().Add(-time.Minute)}, nil)
+	if _, _, err := d.RefreshSession("odr_expired"); err != proto.ErrInvalidRefreshToken {
+		t.Error("RefreshSession() should have returned ErrInvalidRefreshToken")
+	}
+
+	session := database.Session{Model: gorm.Model{ID: 4}, UserID: 1, RefreshHash: hashSecret("odr_test"), ExpiresAt: time.Now().Add(time.Hour)}
+	dbMock.EXPECT().FindSessionByRefreshHash(hashSecret("odr_test")).Return(session, nil).Times(4)
+
+	// disabled user
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Disabled: true, Verified: true}, nil)
+	if _, _, err := d.RefreshSession("odr_test"); err != proto.ErrUserDisabled {
+		t.Error("RefreshSession() should have returned ErrUserDisabled")
+	}
+
+	// unverified user
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}}, nil)
+	if _, _, err := d.RefreshSession("odr_test"); err != proto.ErrEmailNotVerified {
+		t.Error("RefreshSession() should have returned ErrEmailNotVerified")
+	}
+
+	// refresh token rotated by a concurrent request
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Verified: true}, nil)
+	dbMock.EXPECT().RotateSession(gomock.Any(), hashSecret("odr_test")).Return(database.Session{}, gorm.ErrRecordNotFound)
+	if _, _, err := d.RefreshSession("odr_test"); err != proto.ErrInvalidRefreshToken {
+		t.Error("RefreshSession() should have returned ErrInvalidRefreshToken")
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Admin: true, Verified: true}, nil)
+
+	var stored database.Session
+	dbMock.EXPECT().RotateSession(gomock.Any(), hashSecret("odr_test")).DoAndReturn(func(session database.Session, _ string) (database.Session, error) {
+		stored = session
+		return session, nil
+	})
+
+	userCtx, refreshToken, err := d.RefreshSession("odr_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userCtx != (proto.UserContext{UserID: 1, Admin: true, SessionID: 4}) {
+		t.Errorf("wrong user context: %+v", userCtx)
+	}
+
+	// the refresh token is rotated
+	if refreshToken == "odr_test" || stored.RefreshHash != hashSecret(refreshToken) ||
+		!stored.ExpiresAt.After(session.ExpiresAt) {
+		t.Errorf("wrong session stored: %+v", stored)
+	}
+}
+
+func TestDaemon_RefreshSession_LDAP(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, conn, dbMock := newLDAPTestDaemon(mockCtrl)
+
+	session := database.Session{Model: gorm.Model{ID: 4}, UserID: 1, RefreshHash: hashSecret("odr_test"), ExpiresAt: time.Now().Add(time.Hour)}
+	user := database.User{Model: gorm.Model{ID: 1}, Email: "luna@example.org", Verified: true, Admin: true}
+
+	// the admin role is revoked by the directory
+	dbMock.EXPECT().FindSessionByRefreshHash(hashSecret("odr_test")).Return(session, nil)
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(user, nil)
+	dbMock.EXPECT().UpdateUser(gomock.Any()).Return(database.User{}, nil)
+	dbMock.EXPECT().RotateSession(gomock.Any(), hashSecret("odr_test")).Return(database.Session{}, nil)
+
+	userCtx, _, err := d.RefreshSession("odr_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userCtx != (proto.UserContext{UserID: 1, SessionID: 4}) {
+		t.Errorf("wrong user context: %+v", userCtx)
+	}
+
+	// the user has been removed from the directory: the session is revoked
+	conn.entries = nil
+
+	dbMock.EXPECT().FindSessionByRefreshHash(hashSecret("odr_test")).Return(session, nil)
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(user, nil)
+	dbMock.EXPECT().DeleteSession(uint(1), uint(4)).Return(nil)
+
+	if _, _, err := d.RefreshSession("odr_test"); err != proto.ErrInvalidRefreshToken {
+		t.Errorf("RefreshSession() should have returned ErrInvalidRefreshToken: %v", err)
+	}
+}
+
+func TestDaemon_GetSessions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserSessions(uint(1)).Return([]database.Session{
+		{Model: gorm.Model{ID: 3}, UserID: 1, UserAgent: "curl", IP: "192.0.2.1"},
+		{Model: gorm.Model{ID: 4}, UserID: 1, UserAgent: "opendydnsctl", IP: "192.0.2.2"},
+	}, nil)
+
+	sessions, err := d.GetSessions(proto.UserContext{UserID: 1, SessionID: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("wrong number of sessions: %d", len(sessions))
+	}
+	if sessions[0].ID != 3 || sessions[0].Current || sessions[0].UserAgent != "curl" {
+		t.Errorf("wrong session: %+v", sessions[0])
+	}
+	if sessions[1].ID != 4 || !sessions[1].Current || sessions[1].IP != "192.0.2.2" {
+		t.Errorf("wrong session: %+v", sessions[1])
+	}
+}
+
+func TestDaemon_RevokeSession(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().DeleteSession(uint(1), uint(3)).Return(nil)
+	dbMock.EXPECT().DeleteSession(uint(1), uint(4)).Return(gorm.ErrRecordNotFound)
+
+	if err := d.RevokeSession(proto.UserContext{UserID: 1}, 3); err != nil {
+		t.Error(err)
+	}
+	if err := d.RevokeSession(proto.UserContext{UserID: 1}, 4); err != proto.ErrSessionNotFound {
+		t.Error("RevokeSession() should have returned ErrSessionNotFound")
+	}
+}
+
+func TestDaemon_PurgeSessions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock := newTestDaemon(mockCtrl, config.DaemonConfig{})
+
+	dbMock.EXPECT().DeleteExpiredSessions().Return(int64(2), nil)
+	d.purgeSessions()
+
+	// the errors are only logged
+	dbMock.EXPECT().DeleteExpiredSessions().Return(int64(0), errors.New("database is locked"))
+	d.purgeSessions()
+}
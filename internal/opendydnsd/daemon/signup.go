@@ -0,0 +1,162 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	userTokenVerifyEmail   = "verify_email"
+	defaultVerificationTTL = 24 * time.Hour
+)
+
+// SignUp create an unverified account and send the verification email
+// the same (nil) result is returned, in about the same time, whether the account already exist or not
+// to prevent email discovery, the already registered users are notified by email instead
+func (d *daemon) SignUp(cred proto.CredentialsDto) error {
+	conf := d.getConfig().Signup
+	if !conf.Enabled {
+		return proto.ErrSignupDisabled
+	}
+
+	if !isEmailValid(cred.Email) || cred.Password == "" {
+		d.logger.Warn().Msg("invalid signup request: bad request.")
+		return proto.ErrInvalidParameters
+	}
+
+	if !isEmailDomainAllowed(cred.Email, conf.AllowedDomains) {
+		d.logger.Warn().Str("Email", cred.Email).Msg("invalid signup request: email domain not allowed.")
+		return proto.ErrInvalidParameters
+	}
+
+	// the password is hashed even if the account already exist: hashing is what takes time
+	pass, err := d.hashPassword(cred.Password)
+	if err != nil {
+		return err
+	}
+
+	user, err := d.conn.FindUser(cred.Email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return err
+	}
+
+	switch {
+	case err == nil && user.Verified:
+		d.notify(func() {
+			d.sendEmail(user.Email, "Sign up attempt", "Someone tried to sign up using this email address, "+
+				"but an account already exist.\n\nIf you forgot your password, please contact your administrator.\n")
+		})
+		return nil
+	case err == nil:
+		// the password of the previous signup is replaced: otherwise someone could register
+		// the address first and log in with his own password once the owner verified it
+		user.Password = pass
+		if _, err := d.conn.UpdateUser(user); err != nil {
+			d.logger.Err(err).Msg("error while updating user.")
+			return err
+		}
+
+		d.logger.Info().Uint("UserID", user.ID).Msg("unverified user signed up again.")
+	default:
+		user, err = d.conn.CreateUser(database.User{Email: cred.Email, Password: pass})
+		if err != nil {
+			d.logger.Err(err).Msg("error while creating user.")
+			return err
+		}
+
+		d.logger.Info().Uint("UserID", user.ID).Msg("new user signed up.")
+	}
+
+	d.notify(func() { d.sendVerificationEmail(user, conf) })
+
+	return nil
+}
+
+// VerifyEmail mark the user owning given verification token as verified
+func (d *daemon) VerifyEmail(token string) error {
+	user, err := d.useTokenUser(userTokenVerifyEmail, token, proto.ErrInvalidVerificationToken)
+	if err != nil {
+		return err
+	}
+
+	user.Verified = true
+	if _, err := d.conn.UpdateUser(user); err != nil {
+		d.logger.Err(err).Msg("error while updating user.")
+		return err
+	}
+
+	if err := d.conn.DeleteUserTokens(user.ID, userTokenVerifyEmail); err != nil {
+		d.logger.Err(err).Msg("error while deleting verification tokens.")
+	}
+
+	d.logger.Info().Uint("UserID", user.ID).Msg("email address verified.")
+
+	return nil
+}
+
+// sendVerificationEmail replace the user verification token and send it by email
+// the errors are only logged: the user can sign up again to receive a new token
+func (d *daemon) sendVerificationEmail(user database.User, conf config.SignupConfig) {
+	ttl := conf.VerificationTTL
+	if ttl <= 0 {
+		ttl = defaultVerificationTTL
+	}
+
+	token, err := d.createUserToken(user.ID, userTokenVerifyEmail, ttl)
+	if err != nil {
+		return
+	}
+
+	link := conf.VerificationURL + "?token=" + url.QueryEscape(token)
+	d.sendEmail(user.Email, "Verify your email address", fmt.Sprintf("Welcome to OpenDyDNS!\n\n"+
+		"Please verify your email address by opening the following link within %s:\n\n%s\n", ttl, link))
+}
+
+// validateSignupConfig make sure the verification emails can be sent if the signup is enabled
+func (d *daemon) validateSignupConfig(conf config.SignupConfig) error {
+	if !conf.Enabled {
+		return nil
+	}
+
+	if d.mailer == nil {
+		return fmt.Errorf("signup requires the SMTP relay to be configured")
+	}
+
+	if u, err := url.Parse(conf.VerificationURL); err != nil || !u.IsAbs() {
+		return fmt.Errorf("invalid signup verification URL `%s`", conf.VerificationURL)
+	}
+
+	return nil
+}
+
+// isEmailValid determinate if given value is a bare email address
+func isEmailValid(email string) bool {
+	addr, err := netmail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// isEmailDomainAllowed determinate if the domain of given email is allowed
+// every domain is allowed if the list is empty
+func isEmailDomainAllowed(email string, allowedDomains []string) bool {
+	if len(allowedDomains) == 0 {
+		return true
+	}
+
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range allowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,198 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/mail_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSignupTestDaemon(mockCtrl *gomock.Controller) (*daemon, *database_mock.MockConnection, *mail_mock.MockMailer) {
+	d, dbMock := newTestDaemon(mockCtrl, config.DaemonConfig{
+		Signup: config.SignupConfig{
+			Enabled:         true,
+			AllowedDomains:  []string{"example.org"},
+			VerificationURL: "https://dydns.example.org/verify",
+		},
+	})
+
+	return d, dbMock, mockMailer(mockCtrl, d)
+}
+
+func TestDaemon_SignUp_Disabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, _, _ := newSignupTestDaemon(mockCtrl)
+	d.config.Signup.Enabled = false
+
+	if err := d.SignUp(proto.CredentialsDto{Email: "luna@example.org", Password: "test"}); err != proto.ErrSignupDisabled {
+		t.Error("SignUp() should have returned ErrSignupDisabled")
+	}
+}
+
+func TestDaemon_SignUp_InvalidRequest(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, _, _ := newSignupTestDaemon(mockCtrl)
+
+	for _, cred := range []proto.CredentialsDto{
+		{},
+		{Email: "luna@example.org"},
+		{Email: "luna", Password: "test"},
+		{Email: "Luna <luna@example.org>", Password: "test"},
+		{Email: "luna@other.org", Password: "test"},
+		{Email: "luna@sub.example.org", Password: "test"},
+	} {
+		if err := d.SignUp(cred); err != proto.ErrInvalidParameters {
+			t.Errorf("SignUp(%+v) should have returned ErrInvalidParameters", cred)
+		}
+	}
+}
+
+func TestDaemon_SignUp(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, mailerMock := newSignupTestDaemon(mockCtrl)
+
+	dbMock.EXPECT().FindUser("luna@Example.org").Return(database.User{}, gorm.ErrRecordNotFound)
+	dbMock.EXPECT().CreateUser(gomock.Any()).DoAndReturn(func(user database.User) (database.User, error) {
+		if user.Email != "luna@Example.org" || user.Verified || user.Admin || user.Password == "test" {
+			t.Errorf("wrong user created: %+v", user)
+		}
+		user.ID = 12
+		return user, nil
+	})
+	dbMock.EXPECT().DeleteUserTokens(uint(12), userTokenVerifyEmail).Return(nil)
+
+	var stored database.UserToken
+	dbMock.EXPECT().CreateUserToken(gomock.Any()).DoAndReturn(func(token database.UserToken) (database.UserToken, error) {
+		stored = token
+		return token, nil
+	})
+
+	var body string
+	mailerMock.EXPECT().Send("luna@Example.org", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(to, subject, b string) error {
+			body = b
+			return nil
+		})
+
+	if err := d.SignUp(proto.CredentialsDto{Email: "luna@Example.org", Password: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	d.notifications.Wait()
+
+	if stored.UserID != 12 || stored.Purpose != userTokenVerifyEmail ||
+		stored.ExpiresAt.Before(time.Now().Add(defaultVerificationTTL-time.Minute)) {
+		t.Errorf("wrong token stored: %+v", stored)
+	}
+
+	// only the hash of the token sent by email is stored
+	idx := strings.Index(body, "https://dydns.example.org/verify?token=")
+	if idx == -1 {
+		t.Fatalf("missing verification link: %s", body)
+	}
+	token := strings.Fields(body[idx+len("https://dydns.example.org/verify?token="):])[0]
+	if stored.Hash != hashSecret(token) {
+		t.Errorf("wrong token hash stored")
+	}
+}
+
+func TestDaemon_SignUp_ExistingUser(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, mailerMock := newSignupTestDaemon(mockCtrl)
+
+	// verified user: notified, nothing is created
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Verified: true}, nil)
+	mailerMock.EXPECT().Send("luna@example.org", "Sign up attempt", gomock.Any()).Return(nil)
+
+	if err := d.SignUp(proto.CredentialsDto{Email: "luna@example.org", Password: "test"}); err != nil {
+		t.Error(err)
+	}
+	d.notifications.Wait()
+
+	// unverified user: the password is replaced and the verification email is sent again
+	dbMock.EXPECT().FindUser("luna@example.org").
+		Return(database.User{Model: gorm.Model{ID: 12}, Email: "luna@example.org", Password: "previous"}, nil)
+	dbMock.EXPECT().UpdateUser(gomock.Any()).DoAndReturn(func(user database.User) (database.User, error) {
+		if user.ID != 12 || user.Verified || !d.validatePassword(user.Password, "test") {
+			t.Errorf("wrong user updated: %+v", user)
+		}
+		return user, nil
+	})
+	dbMock.EXPECT().DeleteUserTokens(uint(12), userTokenVerifyEmail).Return(nil)
+	dbMock.EXPECT().CreateUserToken(gomock.Any()).Return(database.UserToken{}, nil)
+	mailerMock.EXPECT().Send("luna@example.org", "Verify your email address", gomock.Any()).
+		Return(errors.New("relay unavailable"))
+
+	// the mail errors are not leaked
+	if err := d.SignUp(proto.CredentialsDto{Email: "luna@example.org", Password: "test"}); err != nil {
+		t.Error(err)
+	}
+	d.notifications.Wait()
+}
+
+func TestDaemon_VerifyEmail(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, _ := newSignupTestDaemon(mockCtrl)
+
+	// unknown token
+	dbMock.EXPECT().UseUserToken(userTokenVerifyEmail, hashSecret("unknown")).
+		Return(database.UserToken{}, gorm.ErrRecordNotFound)
+	if err := d.VerifyEmail("unknown"); err != proto.ErrInvalidVerificationToken {
+		t.Error("VerifyEmail() should have returned ErrInvalidVerificationToken")
+	}
+
+	// expired or already used token: not consumed by the database
+	dbMock.EXPECT().UseUserToken(userTokenVerifyEmail, hashSecret("expired")).
+		Return(database.UserToken{}, gorm.ErrRecordNotFound)
+	if err := d.VerifyEmail("expired"); err != proto.ErrInvalidVerificationToken {
+		t.Error("VerifyEmail() should have returned ErrInvalidVerificationToken")
+	}
+
+	dbMock.EXPECT().UseUserToken(userTokenVerifyEmail, hashSecret("good")).
+		Return(database.UserToken{UserID: 12, ExpiresAt: time.Now().Add(time.Hour)}, nil)
+	dbMock.EXPECT().FindUserByID(uint(12)).Return(database.User{Model: gorm.Model{ID: 12}}, nil)
+	dbMock.EXPECT().UpdateUser(database.User{Model: gorm.Model{ID: 12}, Verified: true}).Return(database.User{}, nil)
+	dbMock.EXPECT().DeleteUserTokens(uint(12), userTokenVerifyEmail).Return(nil)
+	if err := d.VerifyEmail("good"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_ValidateSignupConfig(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, _, _ := newSignupTestDaemon(mockCtrl)
+
+	if err := d.validateSignupConfig(d.config.Signup); err != nil {
+		t.Error(err)
+	}
+	if err := d.validateSignupConfig(config.SignupConfig{Enabled: true, VerificationURL: "/verify"}); err == nil {
+		t.Error("validateSignupConfig() should have failed")
+	}
+
+	d.mailer = nil
+	if err := d.validateSignupConfig(d.config.Signup); err == nil {
+		t.Error("validateSignupConfig() should have failed")
+	}
+	if err := d.validateSignupConfig(config.SignupConfig{}); err != nil {
+		t.Error(err)
+	}
+}
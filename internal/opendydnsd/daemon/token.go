@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	"strings"
+)
+
+const (
+	updateTokenPrefix    = "odt_"
+	updateTokenHintChars = 8  // random chars kept (with the prefix) to identify a token
+	secretSize           = 32 // random bytes of the generated secrets
+)
+
+func (d *daemon) CreateUpdateToken(userCtx proto.UserContext, aliasName string) (proto.UpdateTokenDto, error) {
+	alias, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
+	if err != nil {
+		return proto.UpdateTokenDto{}, err
+	}
+
+	token, err := generateUpdateToken()
+	if err != nil {
+		d.logger.Err(err).Msg("error while generating update token.")
+		return proto.UpdateTokenDto{}, err
+	}
+
+	t, err := d.conn.CreateUpdateToken(database.UpdateToken{
+		AliasID: alias.ID,
+		Hash:    hashSecret(token),
+		Prefix:  token[:len(updateTokenPrefix)+updateTokenHintChars],
+	})
+	if err != nil {
+		d.logger.Err(err).Msg("error while creating update token.")
+		return proto.UpdateTokenDto{}, err
+	}
+
+	d.logger.Info().
+		Uint("UserID", userCtx.UserID).
+		Str("Alias", aliasName).
+		Uint("TokenID", t.ID).
+		Msg("update token created.")
+
+	dto := newUpdateTokenDto(t, aliasName)
+	dto.Token = token
+	return dto, nil
+}
+
+func (d *daemon) GetUpdateTokens(userCtx proto.UserContext, aliasName string) ([]proto.UpdateTokenDto, error) {
+	alias, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := d.conn.FindAliasUpdateTokens(alias.ID)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, err
+	}
+
+	dtos := []proto.UpdateTokenDto{}
+	for _, token := range tokens {
+		dtos = append(dtos, newUpdateTokenDto(token, aliasName))
+	}
+
+	return dtos, nil
+}
+
+func (d *daemon) RevokeUpdateToken(userCtx proto.UserContext, aliasName string, tokenID uint) error {
+	alias, err := d.findUserAlias(proto.AliasDto{Domain: aliasName}, userCtx.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err := d.conn.DeleteUpdateToken(alias.ID, tokenID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return proto.ErrUpdateTokenNotFound
+		}
+
+		d.logger.Err(err).Msg("error while deleting update token.")
+		return err
+	}
+
+	d.logger.Info().
+		Uint("UserID", userCtx.UserID).
+		Str("Alias", aliasName).
+		Uint("TokenID", tokenID).
+		Msg("update token revoked.")
+
+	return nil
+}
+
+// AuthenticateUpdateToken make sure given update token is bound to given alias
+// and return the context of the alias owner
+func (d *daemon) AuthenticateUpdateToken(token, aliasName string) (proto.UserContext, error) {
+	if !IsUpdateToken(token) {
+		metrics.AuthenticationFailures.WithLabelValues("update_token").Inc()
+		return proto.UserContext{}, proto.ErrInvalidUpdateToken
+	}
+
+	t, err := d.conn.FindUpdateToken(hashSecret(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Warn().Str("Alias", aliasName).Msg("unknown update token.")
+		metrics.AuthenticationFailures.WithLabelValues("update_token").Inc()
+		return proto.UserContext{}, proto.ErrInvalidUpdateToken
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.UserContext{}, err
+	}
+
+	alias, err := d.conn.FindAliasByID(t.AliasID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		metrics.AuthenticationFailures.WithLabelValues("update_token").Inc()
+		return proto.UserContext{}, proto.ErrInvalidUpdateToken
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.UserContext{}, err
+	}
+
+	// the token is only valid for his alias
+	if !strings.EqualFold(alias.Host+"."+alias.Domain, aliasName) {
+		d.logger.Warn().
+			Str("Alias", aliasName).
+			Uint("TokenID", t.ID).
+			Msg("update token used for another alias.")
+		metrics.AuthenticationFailures.WithLabelValues("update_token").Inc()
+		return proto.UserContext{}, proto.ErrInvalidUpdateToken
+	}
+
+	// the update tokens of a disabled user are rejected too
+	user, err := d.conn.FindUserByID(alias.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		metrics.AuthenticationFailures.WithLabelValues("update_token").Inc()
+		return proto.UserContext{}, proto.ErrInvalidUpdateToken
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return proto.UserContext{}, err
+	}
+	if user.Disabled {
+		d.logger.Warn().Uint("UserID", user.ID).Msg("update token of a disabled user.")
+		metrics.AuthenticationFailures.WithLabelValues("update_token").Inc()
+		return proto.UserContext{}, proto.ErrUserDisabled
+	}
+
+	return proto.UserContext{UserID: alias.UserID}, nil
+}
+
+// IsUpdateToken determinate if given secret looks like an update token
+func IsUpdateToken(secret string) bool {
+	return strings.HasPrefix(secret, updateTokenPrefix)
+}
+
+func generateUpdateToken() (string, error) {
+	return generateSecret(updateTokenPrefix)
+}
+
+// generateSecret return a random secret (32 bytes) with given prefix
+func generateSecret(prefix string) (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret return the (stored) hash of given secret
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// UpdateToken -> UpdateTokenDto
+func newUpdateTokenDto(token database.UpdateToken, aliasName string) proto.UpdateTokenDto {
+	return proto.UpdateTokenDto{
+		ID:        token.ID,
+		Alias:     aliasName,
+		Prefix:    token.Prefix,
+		CreatedAt: token.CreatedAt,
+	}
+}
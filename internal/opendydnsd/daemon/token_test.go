@@ -0,0 +1,166 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGenerateUpdateToken(t *testing.T) {
+	token, err := generateUpdateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsUpdateToken(token) || len(token) != len(updateTokenPrefix)+43 {
+		t.Errorf("wrong token generated: %s", token)
+	}
+
+	other, _ := generateUpdateToken()
+	if token == other {
+		t.Error("tokens should be unique")
+	}
+
+	if hashSecret(token) != hashSecret(token) || hashSecret(token) == hashSecret(other) {
+		t.Error("wrong token hash")
+	}
+}
+
+func TestDaemon_CreateUpdateToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").
+		Return(database.Alias{Model: gorm.Model{ID: 42}, Host: "foo", Domain: "bar.baz", UserID: 1}, nil)
+
+	var stored database.UpdateToken
+	dbMock.EXPECT().CreateUpdateToken(gomock.Any()).DoAndReturn(func(token database.UpdateToken) (database.UpdateToken, error) {
+		stored = token
+		token.ID = 3
+		return token, nil
+	})
+
+	token, err := d.CreateUpdateToken(proto.UserContext{UserID: 1}, "foo.bar.baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.ID != 3 || token.Alias != "foo.bar.baz" || !strings.HasPrefix(token.Token, token.Prefix) {
+		t.Errorf("wrong token returned: %+v", token)
+	}
+
+	// only the hash is stored
+	if stored.AliasID != 42 || stored.Hash != hashSecret(token.Token) || strings.Contains(stored.Hash, token.Token) {
+		t.Errorf("wrong token stored: %+v", stored)
+	}
+}
+
+func TestDaemon_CreateUpdateToken_AliasNotOwned(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(database.Alias{UserID: 2}, nil)
+
+	if _, err := d.CreateUpdateToken(proto.UserContext{UserID: 1}, "foo.bar.baz"); err != proto.ErrAliasNotFound {
+		t.Errorf("CreateUpdateToken() should have returned ErrAliasNotFound")
+	}
+}
+
+func TestDaemon_RevokeUpdateToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").
+		Return(database.Alias{Model: gorm.Model{ID: 42}, UserID: 1}, nil).Times(2)
+	dbMock.EXPECT().DeleteUpdateToken(uint(42), uint(3)).Return(nil)
+	dbMock.EXPECT().DeleteUpdateToken(uint(42), uint(4)).Return(gorm.ErrRecordNotFound)
+
+	if err := d.RevokeUpdateToken(proto.UserContext{UserID: 1}, "foo.bar.baz", 3); err != nil {
+		t.Error(err)
+	}
+	if err := d.RevokeUpdateToken(proto.UserContext{UserID: 1}, "foo.bar.baz", 4); err != proto.ErrUpdateTokenNotFound {
+		t.Error("RevokeUpdateToken() should have returned ErrUpdateTokenNotFound")
+	}
+}
+
+func TestDaemon_AuthenticateUpdateToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	token := "odt_test"
+
+	// not an update token
+	if _, err := d.AuthenticateUpdateToken("eyJhbGciOi", "foo.bar.baz"); err != proto.ErrInvalidUpdateToken {
+		t.Error("AuthenticateUpdateToken() should have returned ErrInvalidUpdateToken")
+	}
+
+	// unknown / revoked token
+	dbMock.EXPECT().FindUpdateToken(hashSecret(token)).Return(database.UpdateToken{}, gorm.ErrRecordNotFound)
+	if _, err := d.AuthenticateUpdateToken(token, "foo.bar.baz"); err != proto.ErrInvalidUpdateToken {
+		t.Error("AuthenticateUpdateToken() should have returned ErrInvalidUpdateToken")
+	}
+
+	dbMock.EXPECT().FindUpdateToken(hashSecret(token)).
+		Return(database.UpdateToken{Model: gorm.Model{ID: 3}, AliasID: 42}, nil).Times(3)
+	dbMock.EXPECT().FindAliasByID(uint(42)).
+		Return(database.Alias{Model: gorm.Model{ID: 42}, Host: "foo", Domain: "bar.baz", UserID: 12}, nil).Times(3)
+	dbMock.EXPECT().FindUserByID(uint(12)).Return(database.User{Model: gorm.Model{ID: 12}}, nil)
+
+	// token bound to another alias
+	if _, err := d.AuthenticateUpdateToken(token, "other.bar.baz"); err != proto.ErrInvalidUpdateToken {
+		t.Error("AuthenticateUpdateToken() should have returned ErrInvalidUpdateToken")
+	}
+
+	userCtx, err := d.AuthenticateUpdateToken(token, "foo.bar.baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userCtx.UserID != 12 {
+		t.Errorf("wrong user context: %+v", userCtx)
+	}
+
+	// token of a disabled user
+	dbMock.EXPECT().FindUserByID(uint(12)).Return(database.User{Model: gorm.Model{ID: 12}, Disabled: true}, nil)
+	if _, err := d.AuthenticateUpdateToken(token, "foo.bar.baz"); err != proto.ErrUserDisabled {
+		t.Error("AuthenticateUpdateToken() should have returned ErrUserDisabled")
+	}
+}
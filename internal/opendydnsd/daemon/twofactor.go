@@ -0,0 +1,368 @@
+package daemon
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/metrics"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	userTokenTwoFactor     = "two_factor"
+	twoFactorTokenTTL      = 5 * time.Minute
+	defaultTwoFactorIssuer = "OpenDyDNS"
+
+	// RFC 6238 parameters, the ones supported by every authenticator app
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSkew       = 1 // number of time steps accepted before / after the current one
+	totpSecretSize = 20
+
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 10 // characters, without the separator
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SetupTwoFactor generate a new TOTP secret for the user
+// the two-factor authentication is only enabled once a code is confirmed: see EnableTwoFactor
+func (d *daemon) SetupTwoFactor(userCtx proto.UserContext) (proto.TwoFactorSetupDto, error) {
+	user, err := d.findUser(userCtx.UserID)
+	if err != nil {
+		return proto.TwoFactorSetupDto{}, err
+	}
+
+	if user.TOTPEnabled {
+		return proto.TwoFactorSetupDto{}, proto.ErrTwoFactorEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		d.logger.Err(err).Msg("error while generating TOTP secret.")
+		return proto.TwoFactorSetupDto{}, err
+	}
+
+	user.TOTPSecret = secret
+	if _, err := d.conn.UpdateUser(user); err != nil {
+		d.logger.Err(err).Msg("error while updating user.")
+		return proto.TwoFactorSetupDto{}, err
+	}
+
+	return proto.TwoFactorSetupDto{
+		Secret: secret,
+		URI:    totpURI(d.getTwoFactorIssuer(), user.Email, secret),
+	}, nil
+}
+
+// EnableTwoFactor enable the two-factor authentication once a code generated from the new secret is confirmed
+// the recovery codes are only returned once
+func (d *daemon) EnableTwoFactor(userCtx proto.UserContext, code string) (proto.RecoveryCodesDto, error) {
+	user, err := d.findUser(userCtx.UserID)
+	if err != nil {
+		return proto.RecoveryCodesDto{}, err
+	}
+
+	if user.TOTPEnabled {
+		return proto.RecoveryCodesDto{}, proto.ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return proto.RecoveryCodesDto{}, proto.ErrTwoFactorNotEnabled
+	}
+
+	// only a TOTP code proves the setup of the authenticator app
+	if err := d.useTOTPCode(user, code); err != nil {
+		return proto.RecoveryCodesDto{}, err
+	}
+
+	user.TOTPEnabled = true
+	if _, err := d.conn.UpdateUser(user); err != nil {
+		d.logger.Err(err).Msg("error while updating user.")
+		return proto.RecoveryCodesDto{}, err
+	}
+
+	d.logger.Info().Uint("UserID", user.ID).Msg("two-factor authentication enabled.")
+
+	return d.newRecoveryCodes(user.ID)
+}
+
+// DisableTwoFactor disable the two-factor authentication using a TOTP code or a recovery code
+func (d *daemon) DisableTwoFactor(userCtx proto.UserContext, code string) error {
+	user, err := d.findUser(userCtx.UserID)
+	if err != nil {
+		return err
+	}
+
+	if !user.TOTPEnabled {
+		return proto.ErrTwoFactorNotEnabled
+	}
+
+	if err := d.checkTwoFactorCode(user, code); err != nil {
+		return err
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	if _, err := d.conn.UpdateUser(user); err != nil {
+		d.logger.Err(err).Msg("error while updating user.")
+		return err
+	}
+
+	if err := d.conn.DeleteRecoveryCodes(user.ID); err != nil {
+		d.logger.Err(err).Msg("error while deleting recovery codes.")
+		return err
+	}
+
+	d.logger.Info().Uint("UserID", user.ID).Msg("two-factor authentication disabled.")
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replace the recovery codes of the user using a TOTP code or a recovery code
+func (d *daemon) RegenerateRecoveryCodes(userCtx proto.UserContext, code string) (proto.RecoveryCodesDto, error) {
+	user, err := d.findUser(userCtx.UserID)
+	if err != nil {
+		return proto.RecoveryCodesDto{}, err
+	}
+
+	if !user.TOTPEnabled {
+		return proto.RecoveryCodesDto{}, proto.ErrTwoFactorNotEnabled
+	}
+
+	if err := d.checkTwoFactorCode(user, code); err != nil {
+		return proto.RecoveryCodesDto{}, err
+	}
+
+	return d.newRecoveryCodes(user.ID)
+}
+
+// VerifyTwoFactor complete the authentication started by Authenticate
+// the challenge token is consumed even if the code is invalid: the password must be given again
+func (d *daemon) VerifyTwoFactor(challenge proto.TwoFactorDto) (proto.UserContext, error) {
+	if challenge.Token == "" || challenge.Code == "" {
+		return proto.UserContext{}, proto.ErrInvalidParameters
+	}
+
+	user, err := d.useTokenUser(userTokenTwoFactor, challenge.Token, proto.ErrInvalidTwoFactorToken)
+	if err != nil {
+		return proto.UserContext{}, err
+	}
+
+	if err := d.conn.DeleteUserTokens(user.ID, userTokenTwoFactor); err != nil {
+		d.logger.Err(err).Msg("error while deleting user tokens.")
+		return proto.UserContext{}, err
+	}
+
+	if user.Disabled {
+		return proto.UserContext{}, proto.ErrUserDisabled
+	}
+	if !user.TOTPEnabled {
+		return proto.UserContext{}, proto.ErrInvalidTwoFactorToken
+	}
+
+	if err := d.checkTwoFactorCode(user, challenge.Code); err != nil {
+		return proto.UserContext{}, err
+	}
+
+	d.logger.Debug().Str("Email", user.Email).Msg("successfully authenticated.")
+
+	return proto.UserContext{
+		UserID: user.ID,
+		Admin:  user.Admin,
+	}, nil
+}
+
+// createTwoFactorChallenge return the token identifying the second authentication step of given user
+func (d *daemon) createTwoFactorChallenge(user database.User) (string, error) {
+	return d.createUserToken(user.ID, userTokenTwoFactor, twoFactorTokenTTL)
+}
+
+// checkTwoFactorCode validate given TOTP code or recovery code, which cannot be used again
+func (d *daemon) checkTwoFactorCode(user database.User, code string) error {
+	if isTOTPCode(code) {
+		return d.useTOTPCode(user, code)
+	}
+
+	err := d.conn.DeleteRecoveryCode(user.ID, hashSecret(normalizeRecoveryCode(code)))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Warn().Uint("UserID", user.ID).Msg("invalid two-factor code: invalid recovery code.")
+		metrics.AuthenticationFailures.WithLabelValues("totp").Inc()
+		return proto.ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while deleting recovery code.")
+		return err
+	}
+
+	d.logger.Info().Uint("UserID", user.ID).Msg("recovery code used.")
+
+	return nil
+}
+
+// useTOTPCode validate given TOTP code and make sure it cannot be replayed
+func (d *daemon) useTOTPCode(user database.User, code string) error {
+	step, valid := validateTOTP(user.TOTPSecret, code, time.Now())
+	if !valid {
+		d.logger.Warn().Uint("UserID", user.ID).Msg("invalid two-factor code: invalid TOTP code.")
+		metrics.AuthenticationFailures.WithLabelValues("totp").Inc()
+		return proto.ErrInvalidTwoFactorCode
+	}
+
+	err := d.conn.UseTOTPStep(user.ID, step)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Warn().Uint("UserID", user.ID).Msg("invalid two-factor code: TOTP code replayed.")
+		metrics.AuthenticationFailures.WithLabelValues("totp").Inc()
+		return proto.ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while updating user.")
+		return err
+	}
+
+	return nil
+}
+
+// newRecoveryCodes replace the recovery codes of the user and return them
+func (d *daemon) newRecoveryCodes(userID uint) (proto.RecoveryCodesDto, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			d.logger.Err(err).Msg("error while generating recovery code.")
+			return proto.RecoveryCodesDto{}, err
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashSecret(normalizeRecoveryCode(code)))
+	}
+
+	if err := d.conn.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		d.logger.Err(err).Msg("error while creating recovery codes.")
+		return proto.RecoveryCodesDto{}, err
+	}
+
+	return proto.RecoveryCodesDto{Codes: codes}, nil
+}
+
+func (d *daemon) getTwoFactorIssuer() string {
+	if issuer := d.getConfig().TwoFactorIssuer; issuer != "" {
+		return issuer
+	}
+	return defaultTwoFactorIssuer
+}
+
+// generateTOTPSecret return a random base32 encoded TOTP secret (160 bits as recommended by RFC 4226)
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode return the TOTP code of given time step (RFC 6238, HMAC-SHA1)
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+// validateTOTP determinate if given code is valid at given time and return its time step
+// the codes of the adjacent time steps are accepted to tolerate clock drift
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	if secret == "" || !isTOTPCode(code) {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// isTOTPCode determinate if given code looks like a TOTP code (and not like a recovery code)
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// totpURI return the otpauth URI of given secret, as understood by the authenticator apps
+func totpURI(issuer, email, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + email,
+		RawQuery: params.Encode(),
+	}
+
+	return u.String()
+}
+
+// generateRecoveryCode return a random recovery code (i.e abcde-fghij)
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeSize*5/8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(totpEncoding.EncodeToString(b))
+	return code[:recoveryCodeSize/2] + "-" + code[recoveryCodeSize/2:], nil
+}
+
+// normalizeRecoveryCode return the hashed form of given recovery code
+// the case and the separators are ignored
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,388 @@
+package daemon
+
+import (
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the RFC 6238 test secret ("12345678901234567890")
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B (SHA1), truncated to 6 digits
+	for timestamp, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := totpCode(testTOTPSecret, timestamp/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("wrong code at %d: %s (expected %s)", timestamp, code, expected)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	// the adjacent time steps are accepted
+	for _, offset := range []time.Duration{-totpPeriod * time.Second, 0, totpPeriod * time.Second} {
+		if step, valid := validateTOTP(testTOTPSecret, "005924", now.Add(offset)); !valid || step != 1234567890/totpPeriod {
+			t.Errorf("code should be valid at %s", offset)
+		}
+	}
+
+	if _, valid := validateTOTP(testTOTPSecret, "005924", now.Add(2*totpPeriod*time.Second)); valid {
+		t.Error("code should have expired")
+	}
+	for _, code := range []string{"", "005925", "05924", "abcdef"} {
+		if _, valid := validateTOTP(testTOTPSecret, code, now); valid {
+			t.Errorf("code %s should be invalid", code)
+		}
+	}
+	if _, valid := validateTOTP("", "005924", now); valid {
+		t.Error("code should be invalid without secret")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secret) != 32 || strings.Contains(secret, "=") {
+		t.Errorf("wrong secret generated: %s", secret)
+	}
+	if _, err := totpCode(secret, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(totpURI("OpenDyDNS", "luna@example.org", testTOTPSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/OpenDyDNS:luna@example.org" {
+		t.Errorf("wrong URI: %s", uri)
+	}
+	if uri.Query().Get("secret") != testTOTPSecret || uri.Query().Get("issuer") != "OpenDyDNS" ||
+		uri.Query().Get("digits") != "6" || uri.Query().Get("period") != "30" {
+		t.Errorf("wrong URI parameters: %s", uri)
+	}
+}
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	code, err := generateRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(code) != recoveryCodeSize+1 || code[recoveryCodeSize/2] != '-' || isTOTPCode(code) {
+		t.Errorf("wrong recovery code generated: %s", code)
+	}
+
+	// the case and the separators are ignored
+	if normalizeRecoveryCode(" "+strings.ToUpper(code)) != strings.Replace(code, "-", "", 1) {
+		t.Errorf("wrong normalized code: %s", normalizeRecoveryCode(code))
+	}
+}
+
+func TestDaemon_Authenticate_TwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	pass, err := d.hashPassword("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbMock.EXPECT().FindUser("luna@example.org").Return(database.User{
+		Model:       gorm.Model{ID: 1},
+		Email:       "luna@example.org",
+		Password:    pass,
+		Verified:    true,
+		TOTPSecret:  testTOTPSecret,
+		TOTPEnabled: true,
+	}, nil)
+	dbMock.EXPECT().DeleteUserTokens(uint(1), userTokenTwoFactor).Return(nil)
+
+	var stored database.UserToken
+	dbMock.EXPECT().CreateUserToken(gomock.Any()).DoAndReturn(func(token database.UserToken) (database.UserToken, error) {
+		stored = token
+		return token, nil
+	})
+
+	userCtx, challenge, err := d.Authenticate(proto.CredentialsDto{Email: "luna@example.org", Password: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the password alone is not enough
+	if userCtx.UserID != 0 || challenge == "" {
+		t.Errorf("wrong authentication result: %+v %s", userCtx, challenge)
+	}
+	if stored.Purpose != userTokenTwoFactor || stored.Hash != hashSecret(challenge) ||
+		stored.ExpiresAt.After(time.Now().Add(twoFactorTokenTTL)) {
+		t.Errorf("wrong challenge stored: %+v", stored)
+	}
+}
+
+func TestDaemon_AuthenticatePassword(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	pass, err := d.hashPassword("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.AuthenticatePassword(proto.CredentialsDto{Email: "luna@example.org"}); err != proto.ErrInvalidParameters {
+		t.Error("AuthenticatePassword() should have returned ErrInvalidParameters")
+	}
+
+	// the pending two-factor challenges are left untouched
+	dbMock.EXPECT().FindUser("luna@example.org").Return(database.User{
+		Model:       gorm.Model{ID: 1},
+		Email:       "luna@example.org",
+		Password:    pass,
+		Verified:    true,
+		TOTPSecret:  testTOTPSecret,
+		TOTPEnabled: true,
+	}, nil)
+	if _, err := d.AuthenticatePassword(proto.CredentialsDto{Email: "luna@example.org", Password: "test"}); err != proto.ErrTwoFactorRequired {
+		t.Error("AuthenticatePassword() should have returned ErrTwoFactorRequired")
+	}
+
+	dbMock.EXPECT().FindUser("luna@example.org").Return(database.User{
+		Model:    gorm.Model{ID: 1},
+		Email:    "luna@example.org",
+		Password: pass,
+		Verified: true,
+	}, nil)
+	userCtx, err := d.AuthenticatePassword(proto.CredentialsDto{Email: "luna@example.org", Password: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userCtx != (proto.UserContext{UserID: 1}) {
+		t.Errorf("wrong user context: %+v", userCtx)
+	}
+}
+
+func TestDaemon_SetupTwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, TOTPEnabled: true}, nil)
+	if _, err := d.SetupTwoFactor(proto.UserContext{UserID: 1}); err != proto.ErrTwoFactorEnabled {
+		t.Error("SetupTwoFactor() should have returned ErrTwoFactorEnabled")
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Email: "luna@example.org"}, nil)
+
+	var stored database.User
+	dbMock.EXPECT().UpdateUser(gomock.Any()).DoAndReturn(func(user database.User) (database.User, error) {
+		stored = user
+		return user, nil
+	})
+
+	setup, err := d.SetupTwoFactor(proto.UserContext{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not enabled until a code is confirmed
+	if stored.TOTPSecret != setup.Secret || stored.TOTPEnabled {
+		t.Errorf("wrong user stored: %+v", stored)
+	}
+	if !strings.HasPrefix(setup.URI, "otpauth://totp/OpenDyDNS:luna@example.org?") || !strings.Contains(setup.URI, setup.Secret) {
+		t.Errorf("wrong URI returned: %s", setup.URI)
+	}
+}
+
+func TestDaemon_EnableTwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	step := time.Now().Unix() / totpPeriod
+	code, err := totpCode(testTOTPSecret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := database.User{Model: gorm.Model{ID: 1}, TOTPSecret: testTOTPSecret}
+
+	// the setup has not been started
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}}, nil)
+	if _, err := d.EnableTwoFactor(proto.UserContext{UserID: 1}, code); err != proto.ErrTwoFactorNotEnabled {
+		t.Error("EnableTwoFactor() should have returned ErrTwoFactorNotEnabled")
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(user, nil).Times(3)
+
+	// a recovery code cannot confirm the setup
+	if _, err := d.EnableTwoFactor(proto.UserContext{UserID: 1}, "abcde-fghij"); err != proto.ErrInvalidTwoFactorCode {
+		t.Error("EnableTwoFactor() should have returned ErrInvalidTwoFactorCode")
+	}
+
+	// replayed code
+	dbMock.EXPECT().UseTOTPStep(uint(1), step).Return(gorm.ErrRecordNotFound)
+	if _, err := d.EnableTwoFactor(proto.UserContext{UserID: 1}, code); err != proto.ErrInvalidTwoFactorCode {
+		t.Error("EnableTwoFactor() should have returned ErrInvalidTwoFactorCode")
+	}
+
+	dbMock.EXPECT().UseTOTPStep(uint(1), step).Return(nil)
+	dbMock.EXPECT().UpdateUser(database.User{Model: gorm.Model{ID: 1}, TOTPSecret: testTOTPSecret, TOTPEnabled: true}).
+		Return(database.User{}, nil)
+
+	var hashes []string
+	dbMock.EXPECT().ReplaceRecoveryCodes(uint(1), gomock.Any()).DoAndReturn(func(userID uint, h []string) error {
+		hashes = h
+		return nil
+	})
+
+	codes, err := d.EnableTwoFactor(proto.UserContext{UserID: 1}, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the hashes are stored
+	if len(codes.Codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount ||
+		hashes[0] != hashSecret(normalizeRecoveryCode(codes.Codes[0])) {
+		t.Errorf("wrong recovery codes: %+v %+v", codes, hashes)
+	}
+}
+
+func TestDaemon_VerifyTwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	if _, err := d.VerifyTwoFactor(proto.TwoFactorDto{Token: "challenge"}); err != proto.ErrInvalidParameters {
+		t.Error("VerifyTwoFactor() should have returned ErrInvalidParameters")
+	}
+
+	// unknown / already used challenge
+	dbMock.EXPECT().UseUserToken(userTokenTwoFactor, hashSecret("unknown")).Return(database.UserToken{}, gorm.ErrRecordNotFound)
+	if _, err := d.VerifyTwoFactor(proto.TwoFactorDto{Token: "unknown", Code: "123456"}); err != proto.ErrInvalidTwoFactorToken {
+		t.Error("VerifyTwoFactor() should have returned ErrInvalidTwoFactorToken")
+	}
+
+	step := time.Now().Unix() / totpPeriod
+	code, err := totpCode(testTOTPSecret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbMock.EXPECT().UseUserToken(userTokenTwoFactor, hashSecret("challenge")).
+		Return(database.UserToken{UserID: 1, ExpiresAt: time.Now().Add(time.Minute)}, nil).Times(3)
+	dbMock.EXPECT().FindUserByID(uint(1)).
+		Return(database.User{Model: gorm.Model{ID: 1}, Admin: true, TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil).Times(3)
+	// the challenge is consumed whatever the code
+	dbMock.EXPECT().DeleteUserTokens(uint(1), userTokenTwoFactor).Return(nil).Times(3)
+
+	// invalid recovery code
+	dbMock.EXPECT().DeleteRecoveryCode(uint(1), hashSecret("abcdefghij")).Return(gorm.ErrRecordNotFound)
+	if _, err := d.VerifyTwoFactor(proto.TwoFactorDto{Token: "challenge", Code: "abcde-fghij"}); err != proto.ErrInvalidTwoFactorCode {
+		t.Error("VerifyTwoFactor() should have returned ErrInvalidTwoFactorCode")
+	}
+
+	// TOTP code
+	dbMock.EXPECT().UseTOTPStep(uint(1), step).Return(nil)
+	userCtx, err := d.VerifyTwoFactor(proto.TwoFactorDto{Token: "challenge", Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userCtx != (proto.UserContext{UserID: 1, Admin: true}) {
+		t.Errorf("wrong user context: %+v", userCtx)
+	}
+
+	// recovery code
+	dbMock.EXPECT().DeleteRecoveryCode(uint(1), hashSecret("abcdefghij")).Return(nil)
+	if _, err := d.VerifyTwoFactor(proto.TwoFactorDto{Token: "challenge", Code: "ABCDE-FGHIJ"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_DisableTwoFactor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}}, nil)
+	if err := d.DisableTwoFactor(proto.UserContext{UserID: 1}, "123456"); err != proto.ErrTwoFactorNotEnabled {
+		t.Error("DisableTwoFactor() should have returned ErrTwoFactorNotEnabled")
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(1)).
+		Return(database.User{Model: gorm.Model{ID: 1}, TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil)
+	dbMock.EXPECT().DeleteRecoveryCode(uint(1), hashSecret("abcdefghij")).Return(nil)
+	dbMock.EXPECT().UpdateUser(database.User{Model: gorm.Model{ID: 1}}).Return(database.User{}, nil)
+	dbMock.EXPECT().DeleteRecoveryCodes(uint(1)).Return(nil)
+
+	if err := d.DisableTwoFactor(proto.UserContext{UserID: 1}, "abcde-fghij"); err != nil {
+		t.Error(err)
+	}
+}
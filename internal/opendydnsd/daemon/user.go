@@ -0,0 +1,225 @@
+package daemon
+
+import (
+	"errors"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/proto"
+	"gorm.io/gorm"
+	"time"
+)
+
+// CheckUserContext make sure the session of the (JWT) context has not been revoked
+// and that the user still exist, is not disabled, is verified and still has the admin role if claimed
+// the tokens issued before the last password change are rejected
+func (d *daemon) CheckUserContext(userCtx proto.UserContext, issuedAt time.Time) error {
+	user, err := d.findUser(userCtx.UserID)
+	if err == proto.ErrUserNotFound {
+		return proto.ErrInvalidSession
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := d.checkSession(user.ID, userCtx.SessionID); err != nil {
+		return err
+	}
+
+	if user.Disabled {
+		return proto.ErrUserDisabled
+	}
+
+	if !user.Verified {
+		return proto.ErrEmailNotVerified
+	}
+
+	// the JWT issue date is in seconds
+	if !user.PasswordChangedAt.IsZero() && issuedAt.Unix() < user.PasswordChangedAt.Unix() {
+		d.logger.Debug().Uint("UserID", user.ID).Msg("session issued before password change.")
+		return proto.ErrInvalidSession
+	}
+
+	if userCtx.Admin && !user.Admin {
+		d.logger.Warn().Uint("UserID", user.ID).Msg("admin role has been revoked.")
+		return proto.ErrInvalidSession
+	}
+
+	return nil
+}
+
+func (d *daemon) GetUsers() ([]proto.UserDto, error) {
+	users, err := d.conn.FindUsers()
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return nil, err
+	}
+
+	var usersDto []proto.UserDto
+	for _, user := range users {
+		usersDto = append(usersDto, newUserDto(user))
+	}
+
+	return usersDto, nil
+}
+
+func (d *daemon) SetUserDisabled(userID uint, disabled bool) error {
+	user, err := d.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	user.Disabled = disabled
+	if _, err := d.conn.UpdateUser(user); err != nil {
+		d.logger.Err(err).Msg("error while updating user.")
+		return err
+	}
+
+	d.logger.Info().Uint("UserID", user.ID).Bool("Disabled", disabled).Msg("successfully updated user.")
+
+	return nil
+}
+
+func (d *daemon) ResetPassword(userID uint, password string) error {
+	if password == "" {
+		return proto.ErrInvalidParameters
+	}
+
+	if err := d.checkLocalBackend(); err != nil {
+		return err
+	}
+
+	user, err := d.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := d.setPassword(user, password); err != nil {
+		return err
+	}
+
+	d.logger.Info().Uint("UserID", user.ID).Msg("successfully reset password.")
+
+	return nil
+}
+
+// DeleteUser delete the user aliases (and their DNS records) then the user
+// the user is kept if an alias cannot be deleted
+func (d *daemon) DeleteUser(userID uint) error {
+	user, err := d.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	aliases, err := d.conn.FindUserAliases(user.ID)
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return err
+	}
+
+	userCtx := proto.UserContext{UserID: user.ID}
+	for _, alias := range aliases {
+		if err := d.DeleteAlias(userCtx, alias.Host+"."+alias.Domain); err != nil {
+			return err
+		}
+	}
+
+	if err := d.conn.DeleteUser(user.ID); err != nil {
+		d.logger.Err(err).Msg("error while deleting user.")
+		return err
+	}
+
+	d.logger.Info().
+		Uint("UserID", user.ID).
+		Int("Aliases", len(aliases)).
+		Msg("successfully deleted user.")
+
+	return nil
+}
+
+func (d *daemon) findUser(userID uint) (database.User, error) {
+	user, err := d.conn.FindUserByID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return database.User{}, proto.ErrUserNotFound
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return database.User{}, err
+	}
+
+	return user, nil
+}
+
+// createUserToken create a single use token sent to the user by email and return its value
+// the previous tokens of the user for the same purpose are revoked
+func (d *daemon) createUserToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateSecret("")
+	if err != nil {
+		d.logger.Err(err).Msg("error while generating user token.")
+		return "", err
+	}
+
+	if err := d.conn.DeleteUserTokens(userID, purpose); err != nil {
+		d.logger.Err(err).Msg("error while deleting user tokens.")
+		return "", err
+	}
+
+	if _, err := d.conn.CreateUserToken(database.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Hash:      hashSecret(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		d.logger.Err(err).Msg("error while creating user token.")
+		return "", err
+	}
+
+	return token, nil
+}
+
+// useTokenUser consume given token and return the user owning it
+// invalidErr is returned if the token is unknown, expired or already used
+func (d *daemon) useTokenUser(purpose, token string, invalidErr error) (database.User, error) {
+	t, err := d.conn.UseUserToken(purpose, hashSecret(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Debug().Str("Purpose", purpose).Msg("invalid user token.")
+		return database.User{}, invalidErr
+	}
+	if err != nil {
+		d.logger.Err(err).Msg("error while fetching database.")
+		return database.User{}, err
+	}
+
+	user, err := d.findUser(t.UserID)
+	if err == proto.ErrUserNotFound {
+		return database.User{}, invalidErr
+	}
+
+	return user, err
+}
+
+// notify run given notification (i.e sending an email) in background
+// so the response time does not tell which branch has been taken
+func (d *daemon) notify(notification func()) {
+	d.notifications.Add(1)
+	go func() {
+		defer d.notifications.Done()
+		notification()
+	}()
+}
+
+// sendEmail send given email, errors are logged
+func (d *daemon) sendEmail(to, subject, body string) {
+	if err := d.mailer.Send(to, subject, body); err != nil {
+		d.logger.Err(err).Str("Subject", subject).Msg("error while sending email.")
+	}
+}
+
+// User -> UserDto
+func newUserDto(user database.User) proto.UserDto {
+	return proto.UserDto{
+		ID:        user.ID,
+		Email:     user.Email,
+		Admin:     user.Admin,
+		Disabled:  user.Disabled,
+		CreatedAt: user.CreatedAt,
+	}
+}
@@ -0,0 +1,216 @@
+package daemon
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database_mock"
+	"github.com/creekorful/open-dydns/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestDaemon_CheckUserContext(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	now := time.Now()
+
+	// revoked session
+	dbMock.EXPECT().FindSession(uint(10)).Return(database.Session{}, gorm.ErrRecordNotFound)
+	// session of another user
+	dbMock.EXPECT().FindSession(uint(11)).Return(database.Session{UserID: 2, ExpiresAt: now.Add(time.Hour)}, nil)
+	// expired session
+	dbMock.EXPECT().FindSession(uint(12)).Return(database.Session{UserID: 1, ExpiresAt: now.Add(-time.Hour)}, nil)
+	// the other sessions have the ID of their user
+	dbMock.EXPECT().FindSession(gomock.Any()).DoAndReturn(func(id uint) (database.Session, error) {
+		return database.Session{Model: gorm.Model{ID: id}, UserID: id, ExpiresAt: now.Add(time.Hour)}, nil
+	}).AnyTimes()
+
+	dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Admin: true, Verified: true}, nil)
+	if err := d.CheckUserContext(proto.UserContext{SessionID: 1, UserID: 1, Admin: true}, now); err != nil {
+		t.Error(err)
+	}
+
+	for _, sessionID := range []uint{10, 11, 12} {
+		dbMock.EXPECT().FindUserByID(uint(1)).Return(database.User{Model: gorm.Model{ID: 1}, Verified: true}, nil)
+		if err := d.CheckUserContext(proto.UserContext{SessionID: sessionID, UserID: 1}, now); err != proto.ErrInvalidSession {
+			t.Errorf("CheckUserContext() should have returned ErrInvalidSession for session %d", sessionID)
+		}
+	}
+
+	// deleted user
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{}, gorm.ErrRecordNotFound)
+	if err := d.CheckUserContext(proto.UserContext{SessionID: 2, UserID: 2}, now); err != proto.ErrInvalidSession {
+		t.Error("CheckUserContext() should have returned ErrInvalidSession")
+	}
+
+	// disabled user
+	dbMock.EXPECT().FindUserByID(uint(3)).Return(database.User{Model: gorm.Model{ID: 3}, Disabled: true}, nil)
+	if err := d.CheckUserContext(proto.UserContext{SessionID: 3, UserID: 3}, now); err != proto.ErrUserDisabled {
+		t.Error("CheckUserContext() should have returned ErrUserDisabled")
+	}
+
+	// admin role revoked
+	dbMock.EXPECT().FindUserByID(uint(4)).Return(database.User{Model: gorm.Model{ID: 4}, Verified: true}, nil)
+	if err := d.CheckUserContext(proto.UserContext{SessionID: 4, UserID: 4, Admin: true}, now); err != proto.ErrInvalidSession {
+		t.Error("CheckUserContext() should have returned ErrInvalidSession")
+	}
+
+	// email not verified
+	dbMock.EXPECT().FindUserByID(uint(5)).Return(database.User{Model: gorm.Model{ID: 5}}, nil)
+	if err := d.CheckUserContext(proto.UserContext{SessionID: 5, UserID: 5}, now); err != proto.ErrEmailNotVerified {
+		t.Error("CheckUserContext() should have returned ErrEmailNotVerified")
+	}
+
+	// session issued before the password change
+	dbMock.EXPECT().FindUserByID(uint(6)).
+		Return(database.User{Model: gorm.Model{ID: 6}, Verified: true, PasswordChangedAt: now}, nil).Times(3)
+	if err := d.CheckUserContext(proto.UserContext{SessionID: 6, UserID: 6}, now.Add(-time.Second)); err != proto.ErrInvalidSession {
+		t.Error("CheckUserContext() should have returned ErrInvalidSession")
+	}
+	if err := d.CheckUserContext(proto.UserContext{SessionID: 6, UserID: 6}, time.Time{}); err != proto.ErrInvalidSession {
+		t.Error("CheckUserContext() should have returned ErrInvalidSession")
+	}
+	// the session issued along the change (same second) is valid
+	if err := d.CheckUserContext(proto.UserContext{SessionID: 6, UserID: 6}, time.Unix(now.Unix(), 0)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_GetUsers(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUsers().Return([]database.User{
+		{Model: gorm.Model{ID: 1}, Email: "admin@example.org", Password: "hash", Admin: true},
+		{Model: gorm.Model{ID: 2}, Email: "lunamicard@gmail.com", Password: "hash", Disabled: true},
+	}, nil)
+
+	users, err := d.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 2 || !users[0].Admin || users[1].Email != "lunamicard@gmail.com" || !users[1].Disabled {
+		t.Errorf("wrong users returned: %+v", users)
+	}
+}
+
+func TestDaemon_SetUserDisabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{Model: gorm.Model{ID: 2}, Email: "lunamicard@gmail.com"}, nil)
+	dbMock.EXPECT().UpdateUser(database.User{Model: gorm.Model{ID: 2}, Email: "lunamicard@gmail.com", Disabled: true}).
+		Return(database.User{}, nil)
+
+	if err := d.SetUserDisabled(2, true); err != nil {
+		t.Error(err)
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(3)).Return(database.User{}, gorm.ErrRecordNotFound)
+	if err := d.SetUserDisabled(3, true); err != proto.ErrUserNotFound {
+		t.Error("SetUserDisabled() should have returned ErrUserNotFound")
+	}
+}
+
+func TestDaemon_ResetPassword(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+	dbMock := database_mock.NewMockConnection(mockCtrl)
+
+	d := daemon{
+		logger: &logger,
+		conn:   dbMock,
+	}
+
+	if err := d.ResetPassword(2, ""); err != proto.ErrInvalidParameters {
+		t.Error("ResetPassword() should have returned ErrInvalidParameters")
+	}
+
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{Model: gorm.Model{ID: 2}, Password: "old"}, nil)
+	dbMock.EXPECT().UpdateUser(gomock.Any()).DoAndReturn(func(user database.User) (database.User, error) {
+		if !d.validatePassword(user.Password, "secret") {
+			t.Error("password not updated")
+		}
+		return user, nil
+	})
+	// the sessions of the user are revoked
+	dbMock.EXPECT().DeleteUserSessions(uint(2)).Return(nil)
+
+	if err := d.ResetPassword(2, "secret"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_DeleteUser(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+
+	alias := database.Alias{Model: gorm.Model{ID: 42}, Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1", UserID: 2}
+
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{Model: gorm.Model{ID: 2}}, nil)
+	dbMock.EXPECT().FindUserAliases(uint(2)).Return([]database.Alias{alias}, nil)
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz", proto.RecordTypeA).Return(nil)
+	dbMock.EXPECT().DeleteAlias("foo", "bar.baz", uint(2)).Return(nil)
+	dbMock.EXPECT().DeleteDNSOperations("foo", "bar.baz", proto.RecordTypeA).Return(nil)
+	dbMock.EXPECT().DeleteUser(uint(2)).Return(nil)
+
+	if err := d.DeleteUser(2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemon_DeleteUser_DNSFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	d, dbMock, provisionerMock := newOutboxTestDaemon(mockCtrl)
+	dnsErr := fmt.Errorf("provider unavailable")
+
+	alias := database.Alias{Model: gorm.Model{ID: 42}, Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1", UserID: 2}
+
+	// the user is kept when an alias cannot be deleted
+	dbMock.EXPECT().FindUserByID(uint(2)).Return(database.User{Model: gorm.Model{ID: 2}}, nil)
+	dbMock.EXPECT().FindUserAliases(uint(2)).Return([]database.Alias{alias}, nil)
+	dbMock.EXPECT().FindAlias("foo", "bar.baz").Return(alias, nil)
+	provisionerMock.EXPECT().DeleteRecord("foo", "bar.baz", proto.RecordTypeA).Return(dnsErr)
+
+	if err := d.DeleteUser(2); err != dnsErr {
+		t.Errorf("DeleteUser() should have failed: %v", err)
+	}
+}
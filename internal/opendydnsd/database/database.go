@@ -1,45 +1,176 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"time"
 )
 
 //go:generate mockgen -source database.go -destination=../database_mock/database_mock.go -package=database_mock
 
 // User is the mapping of an user
+// the email is unique among the users not deleted
 type User struct {
 	gorm.Model
 
-	Email    string `gorm:"unique"`
+	Email    string
 	Password string
+	Admin    bool // allowed to use the admin endpoints
+	Disabled bool // disabled by an admin: cannot authenticate
+	Verified bool // email address verified, the users created by an admin are verified
+
+	// PasswordChangedAt invalidate the sessions (JWT tokens) issued before
+	PasswordChangedAt time.Time
+
+	// TOTPSecret is the base32 encoded TOTP secret, set during the two-factor setup
+	// the two-factor authentication is only required once TOTPEnabled
+	TOTPSecret  string `gorm:"column:totp_secret"`
+	TOTPEnabled bool   `gorm:"column:totp_enabled"`
+	// TOTPLastStep is the time step of the last accepted TOTP code: a code cannot be replayed
+	TOTPLastStep int64 `gorm:"column:totp_last_step"`
+
+	// OIDCSubject is the subject (sub claim) of the OpenID Connect identity linked to the user
+	OIDCSubject string `gorm:"column:oidc_subject;index"`
 
 	Aliases []Alias
 }
 
+// UserToken is the mapping of a single use token sent to the user by email
+// only the SHA-256 hash of the token is stored
+type UserToken struct {
+	gorm.Model
+
+	UserID    uint   // FK
+	Purpose   string // i.e verify_email
+	Hash      string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+}
+
+// Session is the mapping of an authenticated session
+// the access tokens carry the session ID (jti claim) and only the SHA-256 hash of the refresh token is stored
+type Session struct {
+	gorm.Model
+
+	UserID      uint   // FK
+	RefreshHash string `gorm:"uniqueIndex"`
+	UserAgent   string
+	IP          string
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+}
+
+// RecoveryCode is the mapping of a two-factor recovery code
+// only the SHA-256 hash of the code is stored, a code is deleted once used
+type RecoveryCode struct {
+	gorm.Model
+
+	UserID uint `gorm:"index"` // FK
+	Hash   string
+}
+
 // Alias is the mapping of a DyDNS alias
+// an alias can hold both an IPv4 (A record) and an IPv6 (AAAA record) value
+// (host, domain) is unique among the aliases not deleted
 type Alias struct {
 	gorm.Model
 
 	Host   string
 	Domain string
-	Value  string
-	UserID uint // FK
+	IPv4   string `gorm:"column:ipv4"`
+	IPv6   string `gorm:"column:ipv6"`
+	UserID uint   // FK
 }
 
+// UpdateToken is the mapping of a per-alias update token
+// only the SHA-256 hash of the token is stored
+type UpdateToken struct {
+	gorm.Model
+
+	AliasID uint   // FK
+	Hash    string `gorm:"uniqueIndex"`
+	Prefix  string // first characters of the token, used to identify it
+}
+
+// DNSOperation is a pending DNS operation (outbox entry)
+// persisted when a DNS change could not be applied nor reverted,
+// and retried until the DNS provider agree with the database
+type DNSOperation struct {
+	gorm.Model
+
+	Action     string // add, update or delete
+	Host       string // alias host
+	Domain     string // alias domain
+	RecordType string
+	Value      string
+	Attempts   int
+	LastError  string
+}
+
+// ErrDuplicateAlias is returned when the alias already exist
+var ErrDuplicateAlias = errors.New("alias already exist")
+
+// models are the mapped structures
+var models = []interface{}{&Alias{}, &User{}, &DNSOperation{}, &UpdateToken{}, &UserToken{}, &Session{}, &RecoveryCode{}, &SchemaVersion{}}
+
 // Connection represent a connection to the database
 // to perform CRUD
 type Connection interface {
-	CreateUser(email, hashedPassword string) (User, error)
+	CreateUser(user User) (User, error)
 	FindUser(email string) (User, error)
+	FindUserByID(id uint) (User, error)
+	FindUserByOIDCSubject(subject string) (User, error)
+	FindUsers() ([]User, error)
+	UpdateUser(user User) (User, error)
+	DeleteUser(id uint) error
 	FindUserAliases(userID uint) ([]Alias, error)
 	FindAlias(host, domain string) (Alias, error)
+	FindDomainAliases(domain string) ([]Alias, error)
+	FindDomainAliasHosts(domain string) ([]string, error)
+	FindAliasByID(id uint) (Alias, error)
 	CreateAlias(alias Alias, userID uint) (Alias, error)
 	DeleteAlias(host, domain string, userID uint) error
 	UpdateAlias(alias Alias) (Alias, error)
+	FindDomainLastUpdate(domain string) (time.Time, error)
+	CountDomainAliases() (map[string]int64, error)
+	CreateDNSOperation(op DNSOperation) (DNSOperation, error)
+	FindDNSOperations() ([]DNSOperation, error)
+	UpdateDNSOperation(op DNSOperation) (DNSOperation, error)
+	DeleteDNSOperation(id uint) error
+	DeleteDNSOperations(host, domain, recordType string) error
+	CreateUpdateToken(token UpdateToken) (UpdateToken, error)
+	FindUpdateToken(hash string) (UpdateToken, error)
+	FindAliasUpdateTokens(aliasID uint) ([]UpdateToken, error)
+	DeleteUpdateToken(aliasID, id uint) error
+	CreateUserToken(token UserToken) (UserToken, error)
+	FindUserToken(purpose, hash string) (UserToken, error)
+	UseUserToken(purpose, hash string) (UserToken, error)
+	DeleteUserTokens(userID uint, purpose string) error
+	CreateSession(session Session) (Session, error)
+	FindSession(id uint) (Session, error)
+	FindSessionByRefreshHash(hash string) (Session, error)
+	FindUserSessions(userID uint) ([]Session, error)
+	RotateSession(session Session, refreshHash string) (Session, error)
+	DeleteSession(userID, id uint) error
+	DeleteUserSessions(userID uint) error
+	DeleteExpiredSessions() (int64, error)
+	UseTOTPStep(userID uint, step int64) error
+	ReplaceRecoveryCodes(userID uint, hashes []string) error
+	DeleteRecoveryCode(userID uint, hash string) error
+	DeleteRecoveryCodes(userID uint) error
+	MigrationStatus() ([]MigrationStatus, error)
+	MigrateUp() ([]Migration, error)
+	MigrateDown() (Migration, error)
+	Ping() error
+	Close() error
 }
 
 type connection struct {
@@ -47,6 +178,7 @@ type connection struct {
 }
 
 // OpenConnection tries to open a new database connection using given config
+// the schema is not migrated: see MigrateUp
 func OpenConnection(conf config.DatabaseConfig, logger *zerolog.Logger) (Connection, error) {
 	driver, err := getDriver(conf)
 	if err != nil {
@@ -60,22 +192,43 @@ func OpenConnection(conf config.DatabaseConfig, logger *zerolog.Logger) (Connect
 		return nil, err
 	}
 
-	// TODO remove? better?
-	if err := conn.AutoMigrate(&Alias{}, &User{}); err != nil {
+	// Configure the connection pool
+	sqlDB, err := conn.DB()
+	if err != nil {
 		return nil, err
 	}
+	if conf.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(conf.MaxOpenConns)
+	}
+	if conf.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(conf.MaxIdleConns)
+	}
+	if conf.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(conf.ConnMaxLifetime)
+	}
 
 	return &connection{
 		connection: conn,
 	}, nil
 }
 
-func (c *connection) CreateUser(email, hashedPassword string) (User, error) {
-	user := User{
-		Email:    email,
-		Password: hashedPassword,
+func (c *connection) Ping() error {
+	sqlDB, err := c.connection.DB()
+	if err != nil {
+		return err
 	}
+	return sqlDB.Ping()
+}
 
+func (c *connection) Close() error {
+	sqlDB, err := c.connection.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func (c *connection) CreateUser(user User) (User, error) {
 	result := c.connection.Create(&user)
 	return user, result.Error
 }
@@ -86,6 +239,73 @@ func (c *connection) FindUser(email string) (User, error) {
 	return user, result.Error
 }
 
+func (c *connection) FindUserByID(id uint) (User, error) {
+	var user User
+	result := c.connection.First(&user, id)
+	return user, result.Error
+}
+
+func (c *connection) FindUserByOIDCSubject(subject string) (User, error) {
+	var user User
+	result := c.connection.Where("oidc_subject = ? AND oidc_subject <> ''", subject).First(&user)
+	return user, result.Error
+}
+
+func (c *connection) FindUsers() ([]User, error) {
+	var users []User
+	result := c.connection.Order("id asc").Find(&users)
+	return users, result.Error
+}
+
+// UpdateUser save the password, admin, disabled, verified, two-factor and OpenID Connect fields of given user
+func (c *connection) UpdateUser(user User) (User, error) {
+	result := c.connection.Model(&user).Updates(map[string]interface{}{
+		"password":            user.Password,
+		"password_changed_at": user.PasswordChangedAt,
+		"admin":               user.Admin,
+		"disabled":            user.Disabled,
+		"verified":            user.Verified,
+		"totp_secret":         user.TOTPSecret,
+		"totp_enabled":        user.TOTPEnabled,
+		"oidc_subject":        user.OIDCSubject,
+	})
+	return user, result.Error
+}
+
+// DeleteUser delete the user with given ID together with the remaining aliases (and their update tokens)
+// gorm.ErrRecordNotFound is returned if the user does not exist
+func (c *connection) DeleteUser(id uint) error {
+	return c.connection.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+
+		aliases := tx.Model(&Alias{}).Select("id").Where("user_id = ?", id)
+		if err := tx.Unscoped().Where("alias_id IN (?)", aliases).Delete(&UpdateToken{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&Alias{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&UserToken{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&user).Error
+	})
+}
+
 func (c *connection) FindUserAliases(userID uint) ([]Alias, error) {
 	var aliases []Alias
 	err := c.connection.Model(&User{Model: gorm.Model{ID: userID}}).Association("Aliases").Find(&aliases)
@@ -98,28 +318,360 @@ func (c *connection) FindAlias(host, domain string) (Alias, error) {
 	return alias, result.Error
 }
 
+func (c *connection) FindAliasByID(id uint) (Alias, error) {
+	var alias Alias
+	result := c.connection.First(&alias, id)
+	return alias, result.Error
+}
+
+func (c *connection) FindDomainAliases(domain string) ([]Alias, error) {
+	var aliases []Alias
+	result := c.connection.Where("domain = ?", domain).Find(&aliases)
+	return aliases, result.Error
+}
+
+// FindDomainAliasHosts return the distinct hosts of the aliases created on given domain
+// the deleted aliases are included: their records may still be served by the DNS provider
+func (c *connection) FindDomainAliasHosts(domain string) ([]string, error) {
+	var hosts []string
+	result := c.connection.Unscoped().Model(&Alias{}).Where("domain = ?", domain).
+		Distinct("host").Order("host asc").Pluck("host", &hosts)
+	return hosts, result.Error
+}
+
+// CreateAlias insert the alias, ErrDuplicateAlias is returned
+// if the alias already exist
 func (c *connection) CreateAlias(alias Alias, userID uint) (Alias, error) {
-	err := c.connection.Model(&User{Model: gorm.Model{ID: userID}}).Association("Aliases").Append(&alias)
-	return alias, err
+	alias.UserID = userID
+
+	result := c.connection.Create(&alias)
+	if isUniqueViolation(result.Error) {
+		return Alias{}, ErrDuplicateAlias
+	}
+	return alias, result.Error
 }
 
+// DeleteAlias delete the alias of given user together with its update tokens
 func (c *connection) DeleteAlias(host, domain string, userID uint) error {
-	result := c.connection.Where("host = ? AND domain = ? AND user_id = ?", host, domain, userID).Delete(Alias{})
-	return result.Error
+	return c.connection.Transaction(func(tx *gorm.DB) error {
+		aliases := tx.Model(&Alias{}).Select("id").Where("host = ? AND domain = ? AND user_id = ?", host, domain, userID)
+		if err := tx.Unscoped().Where("alias_id IN (?)", aliases).Delete(&UpdateToken{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("host = ? AND domain = ? AND user_id = ?", host, domain, userID).Delete(&Alias{}).Error
+	})
 }
 
 func (c *connection) UpdateAlias(alias Alias) (Alias, error) {
 	result := c.connection.Model(&alias).Updates(Alias{
 		Domain: alias.Domain,
-		Value:  alias.Value,
+		IPv4:   alias.IPv4,
+		IPv6:   alias.IPv6,
 	})
 	return alias, result.Error
 }
 
+// FindDomainLastUpdate return the time of the latest alias change
+// (creation, update or deletion) on given domain
+func (c *connection) FindDomainLastUpdate(domain string) (time.Time, error) {
+	var lastUpdate time.Time
+
+	var updated Alias
+	result := c.connection.Unscoped().Where("domain = ?", domain).Order("updated_at desc").Limit(1).Find(&updated)
+	if result.Error != nil {
+		return time.Time{}, result.Error
+	}
+	lastUpdate = updated.UpdatedAt
+
+	var deleted Alias
+	result = c.connection.Unscoped().Where("domain = ? AND deleted_at IS NOT NULL", domain).
+		Order("deleted_at desc").Limit(1).Find(&deleted)
+	if result.Error != nil {
+		return time.Time{}, result.Error
+	}
+	if deleted.DeletedAt.Valid && deleted.DeletedAt.Time.After(lastUpdate) {
+		lastUpdate = deleted.DeletedAt.Time
+	}
+
+	return lastUpdate, nil
+}
+
+// CountDomainAliases return the number of aliases per domain
+func (c *connection) CountDomainAliases() (map[string]int64, error) {
+	var rows []struct {
+		Domain string
+		Count  int64
+	}
+	result := c.connection.Model(&Alias{}).Select("domain, count(*) AS count").Group("domain").Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Domain] = row.Count
+	}
+
+	return counts, nil
+}
+
+func (c *connection) CreateDNSOperation(op DNSOperation) (DNSOperation, error) {
+	result := c.connection.Create(&op)
+	return op, result.Error
+}
+
+// FindDNSOperations return the pending DNS operations, oldest first
+func (c *connection) FindDNSOperations() ([]DNSOperation, error) {
+	var ops []DNSOperation
+	result := c.connection.Order("id asc").Find(&ops)
+	return ops, result.Error
+}
+
+func (c *connection) UpdateDNSOperation(op DNSOperation) (DNSOperation, error) {
+	result := c.connection.Model(&op).Updates(map[string]interface{}{
+		"attempts":   op.Attempts,
+		"last_error": op.LastError,
+	})
+	return op, result.Error
+}
+
+func (c *connection) DeleteDNSOperation(id uint) error {
+	result := c.connection.Unscoped().Delete(&DNSOperation{}, id)
+	return result.Error
+}
+
+// DeleteDNSOperations delete the pending DNS operations targeting given record
+func (c *connection) DeleteDNSOperations(host, domain, recordType string) error {
+	result := c.connection.Unscoped().
+		Where("host = ? AND domain = ? AND record_type = ?", host, domain, recordType).
+		Delete(&DNSOperation{})
+	return result.Error
+}
+
+func (c *connection) CreateUpdateToken(token UpdateToken) (UpdateToken, error) {
+	result := c.connection.Create(&token)
+	return token, result.Error
+}
+
+func (c *connection) FindUpdateToken(hash string) (UpdateToken, error) {
+	var token UpdateToken
+	result := c.connection.Where("hash = ?", hash).First(&token)
+	return token, result.Error
+}
+
+func (c *connection) FindAliasUpdateTokens(aliasID uint) ([]UpdateToken, error) {
+	var tokens []UpdateToken
+	result := c.connection.Where("alias_id = ?", aliasID).Order("id asc").Find(&tokens)
+	return tokens, result.Error
+}
+
+// DeleteUpdateToken delete the update token with given ID
+// gorm.ErrRecordNotFound is returned if the token does not belong to given alias
+func (c *connection) DeleteUpdateToken(aliasID, id uint) error {
+	result := c.connection.Unscoped().Where("alias_id = ?", aliasID).Delete(&UpdateToken{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (c *connection) CreateUserToken(token UserToken) (UserToken, error) {
+	result := c.connection.Create(&token)
+	return token, result.Error
+}
+
+// FindUserToken return the token with given purpose and hash
+// the token may be expired
+func (c *connection) FindUserToken(purpose, hash string) (UserToken, error) {
+	var token UserToken
+	result := c.connection.Where("purpose = ? AND hash = ?", purpose, hash).First(&token)
+	return token, result.Error
+}
+
+// UseUserToken delete the token with given purpose and hash and return it
+// gorm.ErrRecordNotFound is returned if there is no such token, if it is expired
+// or if it has been used concurrently: a token can only be used once
+func (c *connection) UseUserToken(purpose, hash string) (UserToken, error) {
+	token, err := c.FindUserToken(purpose, hash)
+	if err != nil {
+		return UserToken{}, err
+	}
+
+	result := c.connection.Unscoped().Where("id = ? AND expires_at > ?", token.ID, time.Now()).Delete(&UserToken{})
+	if result.Error != nil {
+		return UserToken{}, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return UserToken{}, gorm.ErrRecordNotFound
+	}
+
+	return token, nil
+}
+
+// DeleteUserTokens delete the tokens of the user with given purpose
+func (c *connection) DeleteUserTokens(userID uint, purpose string) error {
+	result := c.connection.Unscoped().Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&UserToken{})
+	return result.Error
+}
+
+func (c *connection) CreateSession(session Session) (Session, error) {
+	result := c.connection.Create(&session)
+	return session, result.Error
+}
+
+// FindSession return the session with given ID
+// the session may be expired
+func (c *connection) FindSession(id uint) (Session, error) {
+	var session Session
+	result := c.connection.First(&session, id)
+	return session, result.Error
+}
+
+// FindSessionByRefreshHash return the session with given refresh token hash
+// the session may be expired
+func (c *connection) FindSessionByRefreshHash(hash string) (Session, error) {
+	var session Session
+	result := c.connection.Where("refresh_hash = ?", hash).First(&session)
+	return session, result.Error
+}
+
+// FindUserSessions return the sessions of the user which are not expired
+func (c *connection) FindUserSessions(userID uint) ([]Session, error) {
+	var sessions []Session
+	result := c.connection.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("id").Find(&sessions)
+	return sessions, result.Error
+}
+
+// RotateSession save the refresh hash, last use and expiration date of given session
+// only if its refresh hash is still refreshHash: a refresh token can only be rotated once.
+// gorm.ErrRecordNotFound is returned if the session has been rotated (or deleted) meanwhile
+func (c *connection) RotateSession(session Session, refreshHash string) (Session, error) {
+	result := c.connection.Model(&Session{}).
+		Where("id = ? AND refresh_hash = ?", session.ID, refreshHash).
+		Updates(map[string]interface{}{
+			"refresh_hash": session.RefreshHash,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+		})
+	if result.Error != nil {
+		return Session{}, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return Session{}, gorm.ErrRecordNotFound
+	}
+
+	return session, nil
+}
+
+// DeleteSession delete the session with given ID owned by given user
+// gorm.ErrRecordNotFound is returned if there is no such session
+func (c *connection) DeleteSession(userID, id uint) error {
+	result := c.connection.Unscoped().Where("user_id = ?", userID).Delete(&Session{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteUserSessions delete every session of given user
+func (c *connection) DeleteUserSessions(userID uint) error {
+	return c.connection.Unscoped().Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+// DeleteExpiredSessions delete the expired sessions and return how many were deleted
+func (c *connection) DeleteExpiredSessions() (int64, error) {
+	result := c.connection.Unscoped().Where("expires_at <= ?", time.Now()).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
+
+// UseTOTPStep save given time step as the last one used by the user
+// gorm.ErrRecordNotFound is returned if the step is not after the last one: the code has been replayed
+func (c *connection) UseTOTPStep(userID uint, step int64) error {
+	result := c.connection.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes replace the recovery codes of the user with given hashes
+func (c *connection) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	return c.connection.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			if err := tx.Create(&RecoveryCode{UserID: userID, Hash: hash}).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteRecoveryCode delete the recovery code of the user with given hash
+// gorm.ErrRecordNotFound is returned if there is no such code (i.e already used)
+func (c *connection) DeleteRecoveryCode(userID uint, hash string) error {
+	result := c.connection.Unscoped().Where("user_id = ? AND hash = ?", userID, hash).Delete(&RecoveryCode{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteRecoveryCodes delete every recovery code of given user
+func (c *connection) DeleteRecoveryCodes(userID uint) error {
+	return c.connection.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+// isUniqueViolation determinate if given error is an unique constraint violation
+func isUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062 // ER_DUP_ENTRY
+	}
+
+	return false
+}
+
 func getDriver(conf config.DatabaseConfig) (gorm.Dialector, error) {
 	switch conf.Driver {
 	case "sqlite":
 		return sqlite.Open(conf.DSN), nil
+	case "postgres":
+		return postgres.Open(conf.DSN), nil
+	case "mysql":
+		return mysql.Open(conf.DSN), nil
 	default:
 		return nil, fmt.Errorf("no database driver named `%s` found", conf.Driver)
 	}
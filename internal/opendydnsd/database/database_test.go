@@ -0,0 +1,705 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// forEachDialect run given test against every available database.
+// SQLite (in-memory) is always used, PostgreSQL and MySQL are enabled by setting
+// OPENDYDNS_TEST_POSTGRES_DSN / OPENDYDNS_TEST_MYSQL_DSN (the tables are dropped!)
+func forEachDialect(t *testing.T, test func(t *testing.T, conn *connection)) {
+	dialects := []config.DatabaseConfig{
+		{Driver: "sqlite", DSN: fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())},
+	}
+	if dsn := os.Getenv("OPENDYDNS_TEST_POSTGRES_DSN"); dsn != "" {
+		dialects = append(dialects, config.DatabaseConfig{Driver: "postgres", DSN: dsn})
+	}
+	if dsn := os.Getenv("OPENDYDNS_TEST_MYSQL_DSN"); dsn != "" {
+		dialects = append(dialects, config.DatabaseConfig{Driver: "mysql", DSN: dsn})
+	}
+
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	for _, dialect := range dialects {
+		dialect := dialect
+		t.Run(dialect.Driver, func(t *testing.T) {
+			conn, err := OpenConnection(dialect, &logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := conn.(*connection)
+
+			// start from a clean database
+			if err := c.connection.Migrator().DropTable(models...); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.MigrateUp(); err != nil {
+				t.Fatal(err)
+			}
+
+			defer c.Close()
+
+			test(t, c)
+		})
+	}
+}
+
+func TestOpenConnection_InvalidDriver(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	if _, err := OpenConnection(config.DatabaseConfig{Driver: "oracle", DSN: "test"}, &logger); err == nil {
+		t.Error("OpenConnection() should have failed")
+	}
+}
+
+func TestOpenConnection_Pool(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	conn, err := OpenConnection(config.DatabaseConfig{
+		Driver:          "sqlite",
+		DSN:             "file:pool?mode=memory&cache=shared",
+		MaxOpenConns:    4,
+		MaxIdleConns:    2,
+		ConnMaxLifetime: time.Minute,
+	}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	sqlDB, err := conn.(*connection).connection.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sqlDB.Stats().MaxOpenConnections != 4 {
+		t.Errorf("wrong max open connections: %d", sqlDB.Stats().MaxOpenConnections)
+	}
+}
+
+func TestConnection_Ping(t *testing.T) {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	conn, err := OpenConnection(config.DatabaseConfig{Driver: "sqlite", DSN: "file:ping?mode=memory"}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.Ping(); err != nil {
+		t.Errorf("Ping() should have succeeded: %v", err)
+	}
+
+	_ = conn.Close()
+
+	if err := conn.Ping(); err == nil {
+		t.Error("Ping() should have failed once the connection is closed")
+	}
+}
+
+func TestConnection_Users(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		if _, err := c.FindUser("lunamicard@gmail.com"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindUser() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"}); err == nil {
+			t.Error("CreateUser() should have failed (email taken)")
+		}
+
+		found, err := c.FindUser("lunamicard@gmail.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.ID != user.ID || found.Password != "hash" || found.Admin || found.Disabled {
+			t.Errorf("wrong user found: %+v", found)
+		}
+
+		admin, err := c.CreateUser(User{Email: "admin@example.org", Password: "hash", Admin: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		users, err := c.FindUsers()
+		if err != nil || len(users) != 2 || users[0].ID != user.ID || !users[1].Admin {
+			t.Errorf("wrong users found: %+v %v", users, err)
+		}
+
+		// update can reset the flags
+		admin.Admin = false
+		admin.Disabled = true
+		admin.Verified = true
+		admin.Password = "other"
+		admin.PasswordChangedAt = time.Now()
+		if _, err := c.UpdateUser(admin); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := c.FindUserByID(admin.ID); err != nil || found.Admin || !found.Disabled || !found.Verified ||
+			found.Password != "other" || found.PasswordChangedAt.Unix() != admin.PasswordChangedAt.Unix() {
+			t.Errorf("wrong user found: %+v %v", found, err)
+		}
+	})
+}
+
+func TestConnection_UserTokens(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expiresAt := time.Now().Add(time.Hour)
+		if _, err := c.CreateUserToken(UserToken{UserID: user.ID, Purpose: "verify_email", Hash: "abcd", ExpiresAt: expiresAt}); err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := c.FindUserToken("verify_email", "abcd")
+		if err != nil || token.UserID != user.ID || !token.ExpiresAt.After(time.Now()) {
+			t.Errorf("wrong token found: %+v %v", token, err)
+		}
+		if _, err := c.FindUserToken("other", "abcd"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindUserToken() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		if err := c.DeleteUserTokens(user.ID, "verify_email"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.FindUserToken("verify_email", "abcd"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindUserToken() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		// the hash can be used again once deleted
+		if _, err := c.CreateUserToken(UserToken{UserID: user.ID, Purpose: "verify_email", Hash: "abcd", ExpiresAt: expiresAt}); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestConnection_UseUserToken(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.CreateUserToken(UserToken{UserID: user.ID, Purpose: "reset_password", Hash: "good", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateUserToken(UserToken{UserID: user.ID, Purpose: "reset_password", Hash: "expired", ExpiresAt: time.Now().Add(-time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := c.UseUserToken("reset_password", "good")
+		if err != nil || token.UserID != user.ID {
+			t.Errorf("wrong token used: %+v %v", token, err)
+		}
+
+		// a token can only be used once
+		if _, err := c.UseUserToken("reset_password", "good"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("UseUserToken() should have returned ErrRecordNotFound: %v", err)
+		}
+		if _, err := c.UseUserToken("reset_password", "expired"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("UseUserToken() should have returned ErrRecordNotFound: %v", err)
+		}
+		if _, err := c.UseUserToken("verify_email", "good"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("UseUserToken() should have returned ErrRecordNotFound: %v", err)
+		}
+	})
+}
+
+func TestConnection_Sessions(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		session, err := c.CreateSession(Session{
+			UserID:      user.ID,
+			RefreshHash: "abcd",
+			UserAgent:   "opendydnsctl",
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateSession(Session{UserID: user.ID, RefreshHash: "expired", ExpiresAt: time.Now().Add(-time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+
+		if found, err := c.FindSession(session.ID); err != nil || found.UserID != user.ID || found.UserAgent != "opendydnsctl" {
+			t.Errorf("wrong session found: %+v %v", found, err)
+		}
+
+		// the refresh token is rotated
+		session.RefreshHash = "efgh"
+		session.LastUsedAt = time.Now()
+		if _, err := c.RotateSession(session, "abcd"); err != nil {
+			t.Fatal(err)
+		}
+
+		// only once: a concurrent rotation of the same token fails
+		replayed := session
+		replayed.RefreshHash = "ijkl"
+		if _, err := c.RotateSession(replayed, "abcd"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("RotateSession() should have returned ErrRecordNotFound: %v", err)
+		}
+		if _, err := c.FindSessionByRefreshHash("abcd"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindSessionByRefreshHash() should have returned ErrRecordNotFound: %v", err)
+		}
+		if found, err := c.FindSessionByRefreshHash("efgh"); err != nil || found.ID != session.ID || found.LastUsedAt.IsZero() {
+			t.Errorf("wrong session found: %+v %v", found, err)
+		}
+
+		// the expired sessions are not listed
+		sessions, err := c.FindUserSessions(user.ID)
+		if err != nil || len(sessions) != 1 || sessions[0].ID != session.ID {
+			t.Errorf("wrong sessions found: %+v %v", sessions, err)
+		}
+
+		// only the expired sessions are purged
+		if count, err := c.DeleteExpiredSessions(); err != nil || count != 1 {
+			t.Errorf("wrong sessions deleted: %d %v", count, err)
+		}
+		if _, err := c.FindSessionByRefreshHash("expired"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindSessionByRefreshHash() should have returned ErrRecordNotFound: %v", err)
+		}
+		if _, err := c.CreateSession(Session{UserID: user.ID, RefreshHash: "other", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.DeleteSession(user.ID+1, session.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("DeleteSession() should have returned ErrRecordNotFound: %v", err)
+		}
+		if err := c.DeleteSession(user.ID, session.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.FindSession(session.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindSession() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		if err := c.DeleteUserSessions(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.FindSessionByRefreshHash("other"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindSessionByRefreshHash() should have returned ErrRecordNotFound: %v", err)
+		}
+	})
+}
+
+func TestConnection_TwoFactor(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+		user.TOTPEnabled = true
+		if _, err := c.UpdateUser(user); err != nil {
+			t.Fatal(err)
+		}
+
+		// a time step can only be used once, and only after the last one
+		if err := c.UseTOTPStep(user.ID, 42); err != nil {
+			t.Fatal(err)
+		}
+		for _, step := range []int64{42, 41} {
+			if err := c.UseTOTPStep(user.ID, step); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("UseTOTPStep(%d) should have returned ErrRecordNotFound: %v", step, err)
+			}
+		}
+
+		found, err := c.FindUserByID(user.ID)
+		if err != nil || found.TOTPSecret != "JBSWY3DPEHPK3PXP" || !found.TOTPEnabled || found.TOTPLastStep != 42 {
+			t.Errorf("wrong user found: %+v %v", found, err)
+		}
+
+		if err := c.ReplaceRecoveryCodes(user.ID, []string{"a", "b"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.ReplaceRecoveryCodes(user.ID, []string{"c", "d"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the previous codes are replaced
+		if err := c.DeleteRecoveryCode(user.ID, "a"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("DeleteRecoveryCode() should have returned ErrRecordNotFound: %v", err)
+		}
+		if err := c.DeleteRecoveryCode(user.ID+1, "c"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("DeleteRecoveryCode() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		// a code can only be used once
+		if err := c.DeleteRecoveryCode(user.ID, "c"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.DeleteRecoveryCode(user.ID, "c"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("DeleteRecoveryCode() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		if err := c.DeleteRecoveryCodes(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.DeleteRecoveryCode(user.ID, "d"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("DeleteRecoveryCode() should have returned ErrRecordNotFound: %v", err)
+		}
+	})
+}
+
+func TestConnection_DeleteUser(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		alias, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1"}, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateUpdateToken(UpdateToken{AliasID: alias.ID, Hash: "hash-1"}); err != nil {
+			t.Fatal(err)
+		}
+		session, err := c.CreateSession(Session{UserID: user.ID, RefreshHash: "abcd", ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.ReplaceRecoveryCodes(user.ID, []string{"a"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateUserToken(UserToken{UserID: user.ID, Purpose: "verify", Hash: "token-1", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.DeleteUser(user.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.FindUserByID(user.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindUserByID() should have returned ErrRecordNotFound: %v", err)
+		}
+		if _, err := c.FindAlias("foo", "bar.baz"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindAlias() should have returned ErrRecordNotFound: %v", err)
+		}
+		if _, err := c.FindUpdateToken("hash-1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindUpdateToken() should have returned ErrRecordNotFound: %v", err)
+		}
+		if _, err := c.FindSession(session.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindSession() should have returned ErrRecordNotFound: %v", err)
+		}
+		if err := c.DeleteRecoveryCode(user.ID, "a"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("DeleteRecoveryCode() should have returned ErrRecordNotFound: %v", err)
+		}
+		if _, err := c.FindUserToken("verify", "token-1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindUserToken() should have returned ErrRecordNotFound: %v", err)
+		}
+		if err := c.DeleteUser(user.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("DeleteUser() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		// the email of a deleted user can be used again, once
+		recreated, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "other"})
+		if err != nil {
+			t.Fatalf("CreateUser() should have succeeded: %v", err)
+		}
+		if found, err := c.FindUser("lunamicard@gmail.com"); err != nil || found.ID != recreated.ID {
+			t.Errorf("wrong user found: %+v %v", found, err)
+		}
+		if _, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"}); err == nil {
+			t.Error("CreateUser() should have failed")
+		}
+	})
+}
+
+func TestConnection_Aliases(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		alias, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1"}, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateAlias(Alias{Host: "other", Domain: "example.org", IPv6: "2001:4860:4860::8888"}, user.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := c.FindAlias("foo", "bar.baz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.ID != alias.ID || found.UserID != user.ID || found.IPv4 != "1.1.1.1" {
+			t.Errorf("wrong alias found: %+v", found)
+		}
+
+		if found, err := c.FindAliasByID(alias.ID); err != nil || found.Host != "foo" {
+			t.Errorf("wrong alias found: %+v %v", found, err)
+		}
+
+		aliases, err := c.FindUserAliases(user.ID)
+		if err != nil || len(aliases) != 2 {
+			t.Errorf("wrong user aliases: %+v %v", aliases, err)
+		}
+
+		aliases, err = c.FindDomainAliases("bar.baz")
+		if err != nil || len(aliases) != 1 || aliases[0].Host != "foo" {
+			t.Errorf("wrong domain aliases: %+v %v", aliases, err)
+		}
+
+		found.IPv6 = "2001:4860:4860::8844"
+		if _, err := c.UpdateAlias(found); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := c.FindAlias("foo", "bar.baz"); found.IPv4 != "1.1.1.1" || found.IPv6 != "2001:4860:4860::8844" {
+			t.Errorf("alias not updated: %+v", found)
+		}
+
+		if err := c.DeleteAlias("foo", "bar.baz", user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.FindAlias("foo", "bar.baz"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("alias not deleted: %v", err)
+		}
+
+		// the deleted aliases are still known
+		hosts, err := c.FindDomainAliasHosts("bar.baz")
+		if err != nil || len(hosts) != 1 || hosts[0] != "foo" {
+			t.Errorf("wrong domain alias hosts: %v %v", hosts, err)
+		}
+	})
+}
+
+func TestConnection_CreateAlias_Duplicate(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		if _, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1"}, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "8.8.8.8"}, 2); !errors.Is(err, ErrDuplicateAlias) {
+			t.Errorf("CreateAlias() should have returned ErrDuplicateAlias: %v", err)
+		}
+
+		// same host on another domain
+		if _, err := c.CreateAlias(Alias{Host: "foo", Domain: "example.org", IPv4: "8.8.8.8"}, 2); err != nil {
+			t.Error(err)
+		}
+
+		// the deleted aliases are ignored
+		if err := c.DeleteAlias("foo", "bar.baz", 1); err != nil {
+			t.Fatal(err)
+		}
+		alias, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "8.8.8.8"}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alias.ID == 0 || alias.UserID != 2 {
+			t.Errorf("wrong alias created: %+v", alias)
+		}
+
+		if err := c.DeleteAlias("foo", "bar.baz", 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1"}, 1); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestConnection_FindDomainLastUpdate(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		lastUpdate, err := c.FindDomainLastUpdate("bar.baz")
+		if err != nil || !lastUpdate.IsZero() {
+			t.Errorf("wrong last update: %v %v", lastUpdate, err)
+		}
+
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		alias, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1"}, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		created, err := c.FindDomainLastUpdate("bar.baz")
+		if err != nil || created.Unix() != alias.UpdatedAt.Unix() {
+			t.Errorf("wrong last update: %v (expected %v) %v", created, alias.UpdatedAt, err)
+		}
+
+		time.Sleep(1100 * time.Millisecond)
+		if err := c.DeleteAlias("foo", "bar.baz", user.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		deleted, err := c.FindDomainLastUpdate("bar.baz")
+		if err != nil || !deleted.After(created) {
+			t.Errorf("deletion not taken into account: %v %v", deleted, err)
+		}
+	})
+}
+
+func TestConnection_CountDomainAliases(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		for _, alias := range []Alias{
+			{Host: "foo", Domain: "bar.baz"},
+			{Host: "other", Domain: "bar.baz"},
+			{Host: "foo", Domain: "example.org"},
+			{Host: "deleted", Domain: "example.org"},
+		} {
+			if _, err := c.CreateAlias(alias, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.DeleteAlias("deleted", "example.org", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		counts, err := c.CountDomainAliases()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != 2 || counts["bar.baz"] != 2 || counts["example.org"] != 1 {
+			t.Errorf("wrong counts: %+v", counts)
+		}
+	})
+}
+
+func TestConnection_DNSOperations(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		first, err := c.CreateDNSOperation(DNSOperation{Action: "delete", Host: "foo", Domain: "bar.baz", RecordType: "A"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateDNSOperation(DNSOperation{Action: "add", Host: "foo", Domain: "bar.baz", RecordType: "AAAA", Value: "2001:4860:4860::8888"}); err != nil {
+			t.Fatal(err)
+		}
+
+		first.Attempts = 2
+		first.LastError = "provider unavailable"
+		if _, err := c.UpdateDNSOperation(first); err != nil {
+			t.Fatal(err)
+		}
+
+		ops, err := c.FindDNSOperations()
+		if err != nil || len(ops) != 2 || ops[0].ID != first.ID || ops[0].Attempts != 2 || ops[0].LastError != "provider unavailable" {
+			t.Errorf("wrong operations: %+v %v", ops, err)
+		}
+
+		if err := c.DeleteDNSOperation(first.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.DeleteDNSOperations("foo", "bar.baz", "AAAA"); err != nil {
+			t.Fatal(err)
+		}
+
+		if ops, err := c.FindDNSOperations(); err != nil || len(ops) != 0 {
+			t.Errorf("operations not deleted: %+v %v", ops, err)
+		}
+	})
+}
+
+func TestConnection_UpdateTokens(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		token, err := c.CreateUpdateToken(UpdateToken{AliasID: 12, Hash: "hash-1", Prefix: "odt_1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateUpdateToken(UpdateToken{AliasID: 12, Hash: "hash-1", Prefix: "odt_1"}); err == nil {
+			t.Error("CreateUpdateToken() should have failed (duplicate hash)")
+		}
+
+		if found, err := c.FindUpdateToken("hash-1"); err != nil || found.ID != token.ID || found.AliasID != 12 {
+			t.Errorf("wrong token found: %+v %v", found, err)
+		}
+
+		if tokens, err := c.FindAliasUpdateTokens(12); err != nil || len(tokens) != 1 {
+			t.Errorf("wrong tokens found: %+v %v", tokens, err)
+		}
+
+		// token of another alias
+		if err := c.DeleteUpdateToken(13, token.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("DeleteUpdateToken() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		if err := c.DeleteUpdateToken(12, token.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.FindUpdateToken("hash-1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("token not deleted: %v", err)
+		}
+	})
+}
+
+func TestConnection_DeleteAlias_UpdateTokens(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		alias, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1"}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := c.CreateAlias(Alias{Host: "other", Domain: "bar.baz", IPv4: "1.1.1.1"}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, aliasID := range []uint{alias.ID, other.ID} {
+			if _, err := c.CreateUpdateToken(UpdateToken{AliasID: aliasID, Hash: fmt.Sprintf("hash-%d", i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := c.DeleteAlias("foo", "bar.baz", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.FindUpdateToken("hash-0"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("token of the deleted alias not deleted: %v", err)
+		}
+		if _, err := c.FindUpdateToken("hash-1"); err != nil {
+			t.Errorf("token of the other alias should not have been deleted: %v", err)
+		}
+	})
+}
+
+func TestConnection_FindUserByOIDCSubject(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		if _, err := c.CreateUser(User{Email: "local@example.org", Password: "hash"}); err != nil {
+			t.Fatal(err)
+		}
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the users without OpenID Connect identity are never matched
+		if _, err := c.FindUserByOIDCSubject(""); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("FindUserByOIDCSubject() should have returned ErrRecordNotFound: %v", err)
+		}
+
+		user.OIDCSubject = "248289761001"
+		if _, err := c.UpdateUser(user); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := c.FindUserByOIDCSubject("248289761001")
+		if err != nil || found.ID != user.ID {
+			t.Errorf("wrong user found: %+v %v", found, err)
+		}
+	})
+}
@@ -0,0 +1,619 @@
+package database
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ErrNoMigration is returned when there is no migration to revert
+var ErrNoMigration = errors.New("no migration applied")
+
+// ErrSchemaOutdated is returned when some migrations are not applied yet
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
+// SchemaVersion is the mapping of an applied migration
+type SchemaVersion struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName override the table name
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// Migration is a versioned schema change
+// migrations are applied in ascending version order, and reverted the other way around
+type Migration struct {
+	Version uint
+	Name    string
+
+	up   func(tx *gorm.DB) error
+	down func(tx *gorm.DB) error
+}
+
+// MigrationStatus represent the state of a migration
+type MigrationStatus struct {
+	Migration
+
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations are the known migrations, ordered by version
+// never edit an existing migration: add a new one
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// the initial schema is the one previously created by AutoMigrate
+		// it is created only if missing to support existing databases
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userV1{}, &aliasV1{}, &dnsOperationV1{}, &updateTokenV1{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&updateTokenV1{}, &dnsOperationV1{}, &aliasV1{}, &userV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "rename aliases value column to ipv4",
+		up: func(tx *gorm.DB) error {
+			return tx.Migrator().RenameColumn(&aliasV1{}, "value", "ipv4")
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().RenameColumn(&aliasV1{}, "ipv4", "value")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add aliases unique index on host and domain",
+		// the soft-deleted aliases must be ignored by the index
+		up: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() == "mysql" {
+				// MySQL has no partial index: index a generated column which is NULL
+				// once the alias is deleted, since NULL values never conflict
+				if err := tx.Exec("ALTER TABLE aliases ADD COLUMN active TINYINT AS (IF(deleted_at IS NULL, 1, NULL)) VIRTUAL").Error; err != nil {
+					return err
+				}
+				return tx.Exec("CREATE UNIQUE INDEX idx_aliases_host_domain ON aliases (host(191), domain(191), active)").Error
+			}
+
+			return tx.Exec("CREATE UNIQUE INDEX idx_aliases_host_domain ON aliases (host, domain) WHERE deleted_at IS NULL").Error
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&aliasV1{}, "idx_aliases_host_domain"); err != nil {
+				return err
+			}
+			if tx.Dialector.Name() == "mysql" {
+				return tx.Migrator().DropColumn(&aliasV1{}, "active")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add users admin and disabled columns",
+		up: func(tx *gorm.DB) error {
+			for _, column := range []string{"Admin", "Disabled"} {
+				// the columns are kept by down on SQLite
+				if tx.Migrator().HasColumn(&userV4{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&userV4{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		down: func(tx *gorm.DB) error {
+			// SQLite cannot drop a column without rebuilding the table,
+			// which cannot be done within the migration transaction
+			if tx.Dialector.Name() == "sqlite" {
+				return nil
+			}
+			for _, column := range []string{"Disabled", "Admin"} {
+				if err := tx.Migrator().DropColumn(&userV4{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add users verified column and user tokens table",
+		up: func(tx *gorm.DB) error {
+			// the columns are kept by down on SQLite
+			if !tx.Migrator().HasColumn(&userV5{}, "Verified") {
+				if err := tx.Migrator().AddColumn(&userV5{}, "Verified"); err != nil {
+					return err
+				}
+			}
+			// the existing users are trusted
+			if err := tx.Exec("UPDATE users SET verified = ?", true).Error; err != nil {
+				return err
+			}
+			return tx.AutoMigrate(&userTokenV5{})
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&userTokenV5{}); err != nil {
+				return err
+			}
+			// see version 4
+			if tx.Dialector.Name() == "sqlite" {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&userV5{}, "Verified")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add users password changed at column",
+		up: func(tx *gorm.DB) error {
+			// the columns are kept by down on SQLite
+			if tx.Migrator().HasColumn(&userV6{}, "PasswordChangedAt") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&userV6{}, "PasswordChangedAt")
+		},
+		down: func(tx *gorm.DB) error {
+			// see version 4
+			if tx.Dialector.Name() == "sqlite" {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&userV6{}, "PasswordChangedAt")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add sessions table",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&sessionV7{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&sessionV7{})
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add users totp columns and recovery codes table",
+		up: func(tx *gorm.DB) error {
+			for _, column := range []string{"TOTPSecret", "TOTPEnabled", "TOTPLastStep"} {
+				// the columns are kept by down on SQLite
+				if tx.Migrator().HasColumn(&userV8{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&userV8{}, column); err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(&recoveryCodeV8{})
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&recoveryCodeV8{}); err != nil {
+				return err
+			}
+			// see version 4
+			if tx.Dialector.Name() == "sqlite" {
+				return nil
+			}
+			for _, column := range []string{"TOTPLastStep", "TOTPEnabled", "TOTPSecret"} {
+				if err := tx.Migrator().DropColumn(&userV8{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add users oidc subject column",
+		up: func(tx *gorm.DB) error {
+			// the column is kept by down on SQLite
+			if !tx.Migrator().HasColumn(&userV9{}, "OIDCSubject") {
+				if err := tx.Migrator().AddColumn(&userV9{}, "OIDCSubject"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().CreateIndex(&userV9{}, "OIDCSubject")
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&userV9{}, "OIDCSubject"); err != nil {
+				return err
+			}
+			// see version 4
+			if tx.Dialector.Name() == "sqlite" {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&userV9{}, "OIDCSubject")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "restrict users email unique index to the users not deleted",
+		// a deleted user must not prevent re-creating an user with the same email
+		up: func(tx *gorm.DB) error {
+			switch tx.Dialector.Name() {
+			case "sqlite":
+				// SQLite cannot drop the inline unique constraint: rebuild the table
+				// the index replacing it once the migration is reverted is dropped too
+				if err := tx.Exec("DROP INDEX IF EXISTS users_email_key").Error; err != nil {
+					return err
+				}
+				if err := rebuildUsersTableV10(tx); err != nil {
+					return err
+				}
+			case "mysql":
+				if err := tx.Exec("DROP INDEX email ON users").Error; err != nil {
+					return err
+				}
+				// see version 3
+				if err := tx.Exec("ALTER TABLE users ADD COLUMN active TINYINT AS (IF(deleted_at IS NULL, 1, NULL)) VIRTUAL").Error; err != nil {
+					return err
+				}
+				return tx.Exec("CREATE UNIQUE INDEX idx_users_email ON users (email, active)").Error
+			default:
+				if err := tx.Exec("ALTER TABLE users DROP CONSTRAINT users_email_key").Error; err != nil {
+					return err
+				}
+			}
+
+			return tx.Exec("CREATE UNIQUE INDEX idx_users_email ON users (email) WHERE deleted_at IS NULL").Error
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&userV10{}, "idx_users_email"); err != nil {
+				return err
+			}
+
+			switch tx.Dialector.Name() {
+			case "sqlite":
+				// the table is not rebuilt back: use an index instead of the inline constraint
+				return tx.Exec("CREATE UNIQUE INDEX users_email_key ON users (email)").Error
+			case "mysql":
+				if err := tx.Migrator().DropColumn(&userV10{}, "active"); err != nil {
+					return err
+				}
+				return tx.Exec("CREATE UNIQUE INDEX email ON users (email)").Error
+			default:
+				return tx.Exec("ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email)").Error
+			}
+		},
+	},
+}
+
+// rebuildUsersTableV10 re-create the users table without the email unique constraint on SQLite
+func rebuildUsersTableV10(tx *gorm.DB) error {
+	// the references to the users table must not follow the rename
+	if err := tx.Exec("PRAGMA legacy_alter_table = ON").Error; err != nil {
+		return err
+	}
+	defer tx.Exec("PRAGMA legacy_alter_table = OFF")
+
+	columns := "id, created_at, updated_at, deleted_at, email, password, admin, disabled, verified, " +
+		"password_changed_at, totp_secret, totp_enabled, totp_last_step, oidc_subject"
+
+	if err := tx.Exec("ALTER TABLE users RENAME TO users_v9").Error; err != nil {
+		return err
+	}
+
+	// the indexes follow the rename: free their names
+	for _, field := range []string{"DeletedAt", "OIDCSubject"} {
+		if err := tx.Migrator().DropIndex(&userV9{}, field); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Migrator().CreateTable(&userV10{}); err != nil {
+		return err
+	}
+
+	if err := tx.Exec("INSERT INTO users (" + columns + ") SELECT " + columns + " FROM users_v9").Error; err != nil {
+		return err
+	}
+
+	return tx.Exec("DROP TABLE users_v9").Error
+}
+
+// Snapshots of the models used by the migrations
+// they must not follow the models changes
+
+type userV1 struct {
+	gorm.Model
+
+	Email    string `gorm:"unique"`
+	Password string
+
+	Aliases []aliasV1 `gorm:"foreignKey:UserID"`
+}
+
+func (userV1) TableName() string {
+	return "users"
+}
+
+type userV4 struct {
+	gorm.Model
+
+	Email    string `gorm:"unique"`
+	Password string
+	Admin    bool
+	Disabled bool
+}
+
+func (userV4) TableName() string {
+	return "users"
+}
+
+type userV5 struct {
+	gorm.Model
+
+	Email    string `gorm:"unique"`
+	Password string
+	Admin    bool
+	Disabled bool
+	Verified bool
+}
+
+func (userV5) TableName() string {
+	return "users"
+}
+
+type userV6 struct {
+	gorm.Model
+
+	Email             string `gorm:"unique"`
+	Password          string
+	Admin             bool
+	Disabled          bool
+	Verified          bool
+	PasswordChangedAt time.Time
+}
+
+func (userV6) TableName() string {
+	return "users"
+}
+
+type userV8 struct {
+	gorm.Model
+
+	Email             string `gorm:"unique"`
+	Password          string
+	Admin             bool
+	Disabled          bool
+	Verified          bool
+	PasswordChangedAt time.Time
+	TOTPSecret        string `gorm:"column:totp_secret"`
+	TOTPEnabled       bool   `gorm:"column:totp_enabled"`
+	TOTPLastStep      int64  `gorm:"column:totp_last_step"`
+}
+
+func (userV8) TableName() string {
+	return "users"
+}
+
+type userV9 struct {
+	gorm.Model
+
+	Email             string `gorm:"unique"`
+	Password          string
+	Admin             bool
+	Disabled          bool
+	Verified          bool
+	PasswordChangedAt time.Time
+	TOTPSecret        string `gorm:"column:totp_secret"`
+	TOTPEnabled       bool   `gorm:"column:totp_enabled"`
+	TOTPLastStep      int64  `gorm:"column:totp_last_step"`
+	OIDCSubject       string `gorm:"column:oidc_subject;index"`
+}
+
+func (userV9) TableName() string {
+	return "users"
+}
+
+type userV10 struct {
+	gorm.Model
+
+	Email             string
+	Password          string
+	Admin             bool
+	Disabled          bool
+	Verified          bool
+	PasswordChangedAt time.Time
+	TOTPSecret        string `gorm:"column:totp_secret"`
+	TOTPEnabled       bool   `gorm:"column:totp_enabled"`
+	TOTPLastStep      int64  `gorm:"column:totp_last_step"`
+	OIDCSubject       string `gorm:"column:oidc_subject;index"`
+}
+
+func (userV10) TableName() string {
+	return "users"
+}
+
+type userTokenV5 struct {
+	gorm.Model
+
+	UserID    uint
+	Purpose   string
+	Hash      string `gorm:"uniqueIndex:idx_user_tokens_hash"`
+	ExpiresAt time.Time
+}
+
+func (userTokenV5) TableName() string {
+	return "user_tokens"
+}
+
+type sessionV7 struct {
+	gorm.Model
+
+	UserID      uint
+	RefreshHash string `gorm:"uniqueIndex:idx_sessions_refresh_hash"`
+	UserAgent   string
+	IP          string
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+}
+
+func (sessionV7) TableName() string {
+	return "sessions"
+}
+
+type recoveryCodeV8 struct {
+	gorm.Model
+
+	UserID uint `gorm:"index:idx_recovery_codes_user_id"`
+	Hash   string
+}
+
+func (recoveryCodeV8) TableName() string {
+	return "recovery_codes"
+}
+
+type aliasV1 struct {
+	gorm.Model
+
+	Host   string
+	Domain string
+	Value  string
+	IPv6   string `gorm:"column:ipv6"`
+	UserID uint
+}
+
+func (aliasV1) TableName() string {
+	return "aliases"
+}
+
+type updateTokenV1 struct {
+	gorm.Model
+
+	AliasID uint
+	Hash    string `gorm:"uniqueIndex:idx_update_tokens_hash"`
+	Prefix  string
+}
+
+func (updateTokenV1) TableName() string {
+	return "update_tokens"
+}
+
+type dnsOperationV1 struct {
+	gorm.Model
+
+	Action     string
+	Host       string
+	Domain     string
+	RecordType string
+	Value      string
+	Attempts   int
+	LastError  string
+}
+
+func (dnsOperationV1) TableName() string {
+	return "dns_operations"
+}
+
+// MigrationStatus return the status of every known migration
+func (c *connection) MigrationStatus() ([]MigrationStatus, error) {
+	if err := c.connection.AutoMigrate(&SchemaVersion{}); err != nil {
+		return nil, err
+	}
+
+	var versions []SchemaVersion
+	if err := c.connection.Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[uint]SchemaVersion{}
+	for _, version := range versions {
+		applied[version.Version] = version
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range migrations {
+		version, exist := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Migration: migration,
+			Applied:   exist,
+			AppliedAt: version.AppliedAt,
+		})
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp apply the pending migrations and return them
+func (c *connection) MigrateUp() ([]Migration, error) {
+	statuses, err := c.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, status := range statuses {
+		if status.Applied {
+			continue
+		}
+
+		migration := status.Migration
+		if err := c.connection.Transaction(func(tx *gorm.DB) error {
+			if err := migration.up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaVersion{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		}); err != nil {
+			return applied, err
+		}
+
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown revert the latest applied migration and return it
+func (c *connection) MigrateDown() (Migration, error) {
+	statuses, err := c.MigrationStatus()
+	if err != nil {
+		return Migration{}, err
+	}
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if !statuses[i].Applied {
+			continue
+		}
+
+		migration := statuses[i].Migration
+		err := c.connection.Transaction(func(tx *gorm.DB) error {
+			if err := migration.down(tx); err != nil {
+				return err
+			}
+
+			return tx.Delete(&SchemaVersion{}, migration.Version).Error
+		})
+		return migration, err
+	}
+
+	return Migration{}, ErrNoMigration
+}
+
+// PendingMigrations return the migrations not applied yet
+func PendingMigrations(conn Connection) ([]Migration, error) {
+	statuses, err := conn.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConnection_Migrations(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		pending, err := PendingMigrations(c)
+		if err != nil || len(pending) != 0 {
+			t.Errorf("wrong pending migrations: %+v %v", pending, err)
+		}
+
+		// Nothing left to apply
+		if applied, err := c.MigrateUp(); err != nil || len(applied) != 0 {
+			t.Errorf("wrong applied migrations: %+v %v", applied, err)
+		}
+
+		user, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateAlias(Alias{Host: "foo", Domain: "bar.baz", IPv4: "1.1.1.1"}, user.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		// Revert the users changes, the unique index and the column rename
+		for _, version := range []uint{10, 9, 8, 7, 6, 5, 4, 3, 2} {
+			migration, err := c.MigrateDown()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migration.Version != version {
+				t.Errorf("wrong migration reverted: %+v", migration)
+			}
+		}
+		if !c.connection.Migrator().HasColumn(&aliasV1{}, "value") || c.connection.Migrator().HasColumn(&aliasV1{}, "ipv4") {
+			t.Error("column not renamed back")
+		}
+
+		statuses, err := c.MigrationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses) != len(migrations) || !statuses[0].Applied || statuses[1].Applied || statuses[9].Applied {
+			t.Errorf("wrong migrations status: %+v", statuses)
+		}
+
+		pending, err = PendingMigrations(c)
+		if err != nil || len(pending) != 9 || pending[0].Version != 2 {
+			t.Errorf("wrong pending migrations: %+v %v", pending, err)
+		}
+
+		// Data is kept by the rename
+		if applied, err := c.MigrateUp(); err != nil || len(applied) != 9 {
+			t.Fatalf("wrong applied migrations: %+v %v", applied, err)
+		}
+		alias, err := c.FindAlias("foo", "bar.baz")
+		if err != nil || alias.IPv4 != "1.1.1.1" {
+			t.Errorf("alias value lost: %+v %v", alias, err)
+		}
+		if found, err := c.FindUser("lunamicard@gmail.com"); err != nil || found.ID != user.ID || found.Password != "hash" {
+			t.Errorf("user lost: %+v %v", found, err)
+		}
+		if _, err := c.CreateUser(User{Email: "lunamicard@gmail.com", Password: "hash"}); err == nil {
+			t.Error("email should be unique")
+		}
+
+		// Revert everything
+		for range migrations {
+			if _, err := c.MigrateDown(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.connection.Migrator().HasTable(&Alias{}) {
+			t.Error("aliases table not dropped")
+		}
+		if _, err := c.MigrateDown(); !errors.Is(err, ErrNoMigration) {
+			t.Errorf("MigrateDown() should have returned ErrNoMigration: %v", err)
+		}
+	})
+}
+
+func TestConnection_MigrateUp_LegacySchema(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c *connection) {
+		// Database created by AutoMigrate before the versioned migrations
+		if err := c.connection.Migrator().DropTable(models...); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.connection.AutoMigrate(&userV1{}, &aliasV1{}, &dnsOperationV1{}, &updateTokenV1{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.connection.Create(&aliasV1{Host: "foo", Domain: "bar.baz", Value: "1.1.1.1"}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := c.connection.Create(&userV1{Email: "lunamicard@gmail.com", Password: "hash"}).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if applied, err := c.MigrateUp(); err != nil || len(applied) != len(migrations) {
+			t.Fatalf("wrong applied migrations: %+v %v", applied, err)
+		}
+
+		alias, err := c.FindAlias("foo", "bar.baz")
+		if err != nil || alias.IPv4 != "1.1.1.1" {
+			t.Errorf("alias value lost: %+v %v", alias, err)
+		}
+
+		// the existing users are verified
+		user, err := c.FindUser("lunamicard@gmail.com")
+		if err != nil || !user.Verified {
+			t.Errorf("user should be verified: %+v %v", user, err)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package dns
+
+// AuthoritativeProvisionerName is the name of the provisioner used
+// for the domains served by the built-in authoritative Server
+const AuthoritativeProvisionerName = "authoritative"
+
+// authoritativeProvisioner does nothing: the built-in Server
+// answer straight from the database, so changes are applied immediately
+type authoritativeProvisioner struct {
+}
+
+func newAuthoritativeProvisioner(config map[string]string) (Provisioner, error) {
+	// make sure the zone configuration is valid
+	if _, err := NewZone("", config); err != nil {
+		return nil, err
+	}
+
+	return &authoritativeProvisioner{}, nil
+}
+
+func (a *authoritativeProvisioner) AddRecord(_, _, _, _ string) error {
+	return nil
+}
+
+func (a *authoritativeProvisioner) UpdateRecord(_, _, _, _ string) error {
+	return nil
+}
+
+func (a *authoritativeProvisioner) DeleteRecord(_, _, _ string) error {
+	return nil
+}
+
+// Check does nothing: the zone is served by the daemon itself
+func (a *authoritativeProvisioner) Check(_ string) error {
+	return nil
+}
+
+// ListRecords return nothing: the records are never out of sync
+// since they are served straight from the database
+func (a *authoritativeProvisioner) ListRecords(_ string) ([]Record, error) {
+	return nil, nil
+}
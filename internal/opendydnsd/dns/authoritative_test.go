@@ -0,0 +1,24 @@
+package dns
+
+import "testing"
+
+func TestNewAuthoritativeProvisioner(t *testing.T) {
+	if _, err := newAuthoritativeProvisioner(map[string]string{}); err == nil {
+		t.Error("newAuthoritativeProvisioner should have failed")
+	}
+
+	p, err := newAuthoritativeProvisioner(map[string]string{"nameservers": "ns1.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddRecord("foo", "example.org", "A", "8.8.8.8"); err != nil {
+		t.Error(err)
+	}
+	if err := p.UpdateRecord("foo", "example.org", "A", "8.8.8.8"); err != nil {
+		t.Error(err)
+	}
+	if err := p.DeleteRecord("foo", "example.org", "A"); err != nil {
+		t.Error(err)
+	}
+}
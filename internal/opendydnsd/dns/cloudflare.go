@@ -0,0 +1,245 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	cloudflareProvisionerName = "cloudflare"
+	cloudflareDefaultAPIURL   = "https://api.cloudflare.com/client/v4"
+	cloudflareAutoTTL         = 1
+	cloudflarePageSize        = 100
+)
+
+type cloudflareZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+type cloudflareError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// cloudflareResponse is the envelope of every Cloudflare v4 API response
+type cloudflareResponse struct {
+	Success bool              `json:"success"`
+	Errors  []cloudflareError `json:"errors"`
+	Result  json.RawMessage   `json:"result"`
+}
+
+type cloudflareProvisioner struct {
+	client  *resty.Client
+	ttl     int
+	proxied bool
+}
+
+func newCloudflareProvisioner(config map[string]string) (Provisioner, error) {
+	apiToken, err := getConfigOrFail(config, "api-token")
+	if err != nil {
+		return nil, err
+	}
+
+	apiURL := cloudflareDefaultAPIURL
+	if v, exist := config["api-url"]; exist {
+		apiURL = v
+	}
+
+	ttl := cloudflareAutoTTL
+	if v, exist := config["ttl"]; exist {
+		if ttl, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid config `ttl`: %s", err)
+		}
+	}
+
+	proxied := false
+	if v, exist := config["proxied"]; exist {
+		if proxied, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid config `proxied`: %s", err)
+		}
+	}
+
+	client := resty.New()
+	client.SetHostURL(apiURL)
+	client.SetAuthToken(apiToken)
+
+	return &cloudflareProvisioner{
+		client:  client,
+		ttl:     ttl,
+		proxied: proxied,
+	}, nil
+}
+
+func (c *cloudflareProvisioner) AddRecord(host, domain, recordType, value string) error {
+	zoneID, err := c.findZoneID(domain)
+	if err != nil {
+		return err
+	}
+
+	return c.do(http.MethodPost, fmt.Sprintf("/zones/%s/dns_records", zoneID), nil, &cloudflareRecord{
+		Type:    recordType,
+		Name:    getRecordName(host, domain),
+		Content: value,
+		TTL:     c.ttl,
+		Proxied: c.proxied,
+	}, nil)
+}
+
+func (c *cloudflareProvisioner) UpdateRecord(host, domain, recordType, value string) error {
+	zoneID, record, err := c.findRecord(host, domain, recordType)
+	if err != nil {
+		return err
+	}
+
+	// update target
+	record.Content = value
+	record.TTL = c.ttl
+	record.Proxied = c.proxied
+
+	return c.do(http.MethodPut, fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, record.ID), nil, &record, nil)
+}
+
+func (c *cloudflareProvisioner) DeleteRecord(host, domain, recordType string) error {
+	zoneID, record, err := c.findRecord(host, domain, recordType)
+	if errors.Is(err, errRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.do(http.MethodDelete, fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, record.ID), nil, nil, nil)
+}
+
+func (c *cloudflareProvisioner) ListRecords(domain string) ([]Record, error) {
+	zoneID, err := c.findZoneID(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, recordType := range recordTypes {
+		for page := 1; ; page++ {
+			var res []cloudflareRecord
+			query := map[string]string{
+				"type":     recordType,
+				"page":     strconv.Itoa(page),
+				"per_page": strconv.Itoa(cloudflarePageSize),
+			}
+			if err := c.do(http.MethodGet, fmt.Sprintf("/zones/%s/dns_records", zoneID), query, nil, &res); err != nil {
+				return nil, err
+			}
+
+			for _, record := range res {
+				host := strings.TrimSuffix(strings.TrimSuffix(record.Name, domain), ".")
+				records = append(records, Record{Host: host, Type: record.Type, Value: record.Content})
+			}
+
+			if len(res) < cloudflarePageSize {
+				break
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// Check fetch the zone details
+func (c *cloudflareProvisioner) Check(domain string) error {
+	zoneID, err := c.findZoneID(domain)
+	if err != nil {
+		return err
+	}
+
+	return c.do(http.MethodGet, "/zones/"+zoneID, nil, nil, nil)
+}
+
+// findZoneID lookup the zone of given domain
+// the provisioners are built for each operation: the zone ID is not cached
+func (c *cloudflareProvisioner) findZoneID(domain string) (string, error) {
+	var zones []cloudflareZone
+	if err := c.do(http.MethodGet, "/zones", map[string]string{"name": domain}, nil, &zones); err != nil {
+		return "", err
+	}
+
+	if len(zones) != 1 {
+		return "", fmt.Errorf("no zone found for domain %s", domain)
+	}
+
+	return zones[0].ID, nil
+}
+
+func (c *cloudflareProvisioner) findRecord(host, domain, recordType string) (string, cloudflareRecord, error) {
+	zoneID, err := c.findZoneID(domain)
+	if err != nil {
+		return "", cloudflareRecord{}, err
+	}
+
+	var records []cloudflareRecord
+	query := map[string]string{"type": recordType, "name": getRecordName(host, domain)}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/zones/%s/dns_records", zoneID), query, nil, &records); err != nil {
+		return "", cloudflareRecord{}, err
+	}
+
+	if len(records) == 0 {
+		return "", cloudflareRecord{}, errRecordNotFound
+	}
+	if len(records) != 1 {
+		return "", cloudflareRecord{}, fmt.Errorf("more or less than 1 record found")
+	}
+
+	return zoneID, records[0], nil
+}
+
+// do perform given request against the Cloudflare API
+// and decode the response result into result (if not nil)
+func (c *cloudflareProvisioner) do(method, url string, query map[string]string, body, result interface{}) error {
+	var res cloudflareResponse
+
+	req := c.client.R().SetResult(&res).SetError(&res).SetQueryParams(query)
+	if body != nil {
+		req.SetBody(body)
+	}
+
+	r, err := req.Execute(method, url)
+	if err != nil {
+		return err
+	}
+
+	if r.IsError() || !res.Success {
+		var messages []string
+		for _, e := range res.Errors {
+			messages = append(messages, fmt.Sprintf("%d: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare API error (status %d): %s", r.StatusCode(), strings.Join(messages, ", "))
+	}
+
+	if result != nil {
+		return json.Unmarshal(res.Result, result)
+	}
+
+	return nil
+}
+
+func getRecordName(host, domain string) string {
+	if host == "" {
+		return domain
+	}
+
+	return fmt.Sprintf("%s.%s", host, domain)
+}
@@ -0,0 +1,230 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCloudflare is a minimal in-memory stand-in of the Cloudflare v4 API
+type fakeCloudflare struct {
+	lock    sync.Mutex
+	token   string
+	zones   map[string]string // name -> ID
+	records map[string]cloudflareRecord
+	nextID  int
+}
+
+func newFakeCloudflare(token string) *fakeCloudflare {
+	return &fakeCloudflare{
+		token:   token,
+		zones:   map[string]string{"example.org": "zone-1"},
+		records: map[string]cloudflareRecord{},
+	}
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		f.reply(w, http.StatusForbidden, nil, cloudflareError{Code: 9109, Message: "Invalid access token"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "zones" && r.Method == http.MethodGet:
+		var zones []cloudflareZone
+		if id, exist := f.zones[r.URL.Query().Get("name")]; exist {
+			zones = append(zones, cloudflareZone{ID: id, Name: r.URL.Query().Get("name")})
+		}
+		f.reply(w, http.StatusOK, zones)
+	case len(parts) == 2 && parts[0] == "zones" && r.Method == http.MethodGet:
+		for name, id := range f.zones {
+			if id == parts[1] {
+				f.reply(w, http.StatusOK, cloudflareZone{ID: id, Name: name})
+				return
+			}
+		}
+		f.reply(w, http.StatusNotFound, nil, cloudflareError{Code: 1001, Message: "Invalid zone identifier"})
+	case len(parts) == 3 && parts[2] == "dns_records" && r.Method == http.MethodGet:
+		records := []cloudflareRecord{}
+		for _, record := range f.records {
+			name := r.URL.Query().Get("name")
+			if record.Type == r.URL.Query().Get("type") && (name == "" || record.Name == name) {
+				records = append(records, record)
+			}
+		}
+		f.reply(w, http.StatusOK, records)
+	case len(parts) == 3 && parts[2] == "dns_records" && r.Method == http.MethodPost:
+		var record cloudflareRecord
+		_ = json.NewDecoder(r.Body).Decode(&record)
+		f.nextID++
+		record.ID = fmt.Sprintf("record-%d", f.nextID)
+		f.records[record.ID] = record
+		f.reply(w, http.StatusOK, record)
+	case len(parts) == 4 && r.Method == http.MethodPut:
+		if _, exist := f.records[parts[3]]; !exist {
+			f.reply(w, http.StatusNotFound, nil, cloudflareError{Code: 81044, Message: "Record does not exist."})
+			return
+		}
+		var record cloudflareRecord
+		_ = json.NewDecoder(r.Body).Decode(&record)
+		record.ID = parts[3]
+		f.records[record.ID] = record
+		f.reply(w, http.StatusOK, record)
+	case len(parts) == 4 && r.Method == http.MethodDelete:
+		delete(f.records, parts[3])
+		f.reply(w, http.StatusOK, map[string]string{"id": parts[3]})
+	default:
+		f.reply(w, http.StatusNotFound, nil, cloudflareError{Code: 7003, Message: "Could not route"})
+	}
+}
+
+func (f *fakeCloudflare) reply(w http.ResponseWriter, status int, result interface{}, errors ...cloudflareError) {
+	b, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(cloudflareResponse{
+		Success: len(errors) == 0,
+		Errors:  errors,
+		Result:  b,
+	})
+}
+
+func TestNewCloudflareProvisioner(t *testing.T) {
+	if _, err := newCloudflareProvisioner(map[string]string{}); err == nil {
+		t.Error("newCloudflareProvisioner should have failed")
+	}
+
+	if _, err := newCloudflareProvisioner(map[string]string{"api-token": "test", "ttl": "abc"}); err == nil {
+		t.Error("newCloudflareProvisioner should have failed")
+	}
+
+	if _, err := newCloudflareProvisioner(map[string]string{"api-token": "test", "proxied": "abc"}); err == nil {
+		t.Error("newCloudflareProvisioner should have failed")
+	}
+
+	p, err := newCloudflareProvisioner(map[string]string{"api-token": "test", "ttl": "300", "proxied": "true"})
+	if err != nil {
+		t.Fatal("newCloudflareProvisioner has failed")
+	}
+
+	c := p.(*cloudflareProvisioner)
+	if c.ttl != 300 || !c.proxied {
+		t.Error("wrong provisioner configuration")
+	}
+}
+
+func TestCloudflareProvisioner(t *testing.T) {
+	fake := newFakeCloudflare("test-token")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	p, err := newCloudflareProvisioner(map[string]string{
+		"api-token": "test-token",
+		"api-url":   server.URL,
+		"ttl":       "120",
+		"proxied":   "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddRecord("foo", "example.org", "A", "8.8.8.8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRecord("foo", "example.org", "AAAA", "2001:4860:4860::8888"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.records) != 2 {
+		t.Fatal("wrong number of records created")
+	}
+	record := fake.records["record-1"]
+	if record.Name != "foo.example.org" || record.Type != "A" || record.Content != "8.8.8.8" ||
+		record.TTL != 120 || !record.Proxied {
+		t.Errorf("wrong record created: %v", record)
+	}
+
+	if err := p.UpdateRecord("foo", "example.org", "A", "1.1.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.records["record-1"].Content != "1.1.1.1" {
+		t.Error("record not updated")
+	}
+	if fake.records["record-2"].Content != "2001:4860:4860::8888" {
+		t.Error("AAAA record should not have been updated")
+	}
+
+	if err := p.DeleteRecord("foo", "example.org", "AAAA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := fake.records["record-2"]; exist || len(fake.records) != 1 {
+		t.Error("record not deleted")
+	}
+
+	records, err := p.ListRecords("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0] != (Record{Host: "foo", Type: "A", Value: "1.1.1.1"}) {
+		t.Errorf("wrong records listed: %v", records)
+	}
+
+	// non existing record / zone
+	if err := p.UpdateRecord("bar", "example.org", "A", "1.1.1.1"); err == nil {
+		t.Error("UpdateRecord() should have failed")
+	}
+	if err := p.AddRecord("foo", "example.com", "A", "1.1.1.1"); err == nil {
+		t.Error("AddRecord() should have failed")
+	}
+	if err := p.DeleteRecord("bar", "example.org", "A"); err != nil {
+		t.Errorf("DeleteRecord() should have succeeded: %v", err)
+	}
+	if err := p.DeleteRecord("foo", "example.com", "A"); err == nil {
+		t.Error("DeleteRecord() should have failed")
+	}
+
+	if err := p.Check("example.org"); err != nil {
+		t.Error(err)
+	}
+	if err := p.Check("example.com"); err == nil {
+		t.Error("Check() should have failed")
+	}
+
+	// the query parameters are escaped
+	if err := p.Check("example.org&name=example.com"); err == nil {
+		t.Error("Check() should have failed")
+	}
+}
+
+func TestCloudflareProvisioner_InvalidToken(t *testing.T) {
+	server := httptest.NewServer(newFakeCloudflare("test-token"))
+	defer server.Close()
+
+	p, err := newCloudflareProvisioner(map[string]string{"api-token": "wrong", "api-url": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.AddRecord("foo", "example.org", "A", "8.8.8.8")
+	if err == nil || !strings.Contains(err.Error(), "Invalid access token") {
+		t.Errorf("AddRecord() should have failed with API error: %v", err)
+	}
+}
+
+func TestGetRecordName(t *testing.T) {
+	if getRecordName("foo", "example.org") != "foo.example.org" {
+		t.Error()
+	}
+	if getRecordName("", "example.org") != "example.org" {
+		t.Error()
+	}
+}
@@ -1,6 +1,7 @@
 package dns
 
 import (
+	"errors"
 	"fmt"
 	"github.com/ovh/go-ovh/ovh"
 )
@@ -51,10 +52,10 @@ func newOVHProvisioner(config map[string]string) (Provisioner, error) {
 	}, nil
 }
 
-func (o *ovhProvisioner) AddRecord(host, domain, value string) error {
+func (o *ovhProvisioner) AddRecord(host, domain, recordType, value string) error {
 	// add the record
 	if err := o.client.Post(fmt.Sprintf("%s/%s/record", zoneEndpoint, domain), &ovhRecord{
-		FieldType: "A", // TODO AAA if ipv6
+		FieldType: recordType,
 		SubDomain: host,
 		Target:    value,
 	}, nil); err != nil {
@@ -65,8 +66,8 @@ func (o *ovhProvisioner) AddRecord(host, domain, value string) error {
 	return o.refreshZone(domain)
 }
 
-func (o *ovhProvisioner) UpdateRecord(host, domain, value string) error {
-	record, err := o.findRecord(host, domain)
+func (o *ovhProvisioner) UpdateRecord(host, domain, recordType, value string) error {
+	record, err := o.findRecord(host, domain, recordType)
 	if err != nil {
 		return err
 	}
@@ -82,9 +83,12 @@ func (o *ovhProvisioner) UpdateRecord(host, domain, value string) error {
 	return o.refreshZone(domain)
 }
 
-func (o *ovhProvisioner) DeleteRecord(host, domain string) error {
+func (o *ovhProvisioner) DeleteRecord(host, domain, recordType string) error {
 	// find the record to delete
-	record, err := o.findRecord(host, domain)
+	record, err := o.findRecord(host, domain, recordType)
+	if errors.Is(err, errRecordNotFound) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -97,19 +101,53 @@ func (o *ovhProvisioner) DeleteRecord(host, domain string) error {
 	return o.refreshZone(domain)
 }
 
+func (o *ovhProvisioner) ListRecords(domain string) ([]Record, error) {
+	var records []Record
+
+	for _, recordType := range recordTypes {
+		var recordIds []int64
+		url := fmt.Sprintf("%s/%s/record?fieldType=%s", zoneEndpoint, domain, recordType)
+		if err := o.client.Get(url, &recordIds); err != nil {
+			return nil, err
+		}
+
+		for _, id := range recordIds {
+			var record ovhRecord
+			if err := o.client.Get(fmt.Sprintf("%s/%s/record/%d", zoneEndpoint, domain, id), &record); err != nil {
+				return nil, err
+			}
+
+			records = append(records, Record{Host: record.SubDomain, Type: record.FieldType, Value: record.Target})
+		}
+	}
+
+	return records, nil
+}
+
+// Check fetch the zone
+func (o *ovhProvisioner) Check(domain string) error {
+	var zone struct {
+		Name string `json:"name"`
+	}
+	return o.client.Get(fmt.Sprintf("%s/%s", zoneEndpoint, domain), &zone)
+}
+
 func (o *ovhProvisioner) refreshZone(domain string) error {
 	return o.client.Post(fmt.Sprintf("%s/%s/refresh", zoneEndpoint, domain), nil, nil)
 }
 
-func (o *ovhProvisioner) findRecord(host, domain string) (ovhRecord, error) {
+func (o *ovhProvisioner) findRecord(host, domain, recordType string) (ovhRecord, error) {
 	var recordIds []int64
 
 	// Search for the record
-	url := fmt.Sprintf("%s/%s/record?fieldType=A&subDomain=%s", zoneEndpoint, domain, host) // TODO manage Ipv6
+	url := fmt.Sprintf("%s/%s/record?fieldType=%s&subDomain=%s", zoneEndpoint, domain, recordType, host)
 	if err := o.client.Get(url, &recordIds); err != nil {
 		return ovhRecord{}, err
 	}
 
+	if len(recordIds) == 0 {
+		return ovhRecord{}, errRecordNotFound
+	}
 	if len(recordIds) != 1 {
 		return ovhRecord{}, fmt.Errorf("more or less than 1 record found")
 	}
@@ -1,17 +1,41 @@
 package dns
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 //go:generate mockgen -source provisioner.go -destination=../dns_mock/provisioner_mock.go -package=dns_mock
 
 // Provisioner represent a DNS provisioner
 // i.e used to abstract different DNS provisioner API solutions
+// the recordType is either A (IPv4) or AAAA (IPv6).
+// DeleteRecord succeed if the record does not exist: the deletions may be retried
 type Provisioner interface {
-	AddRecord(host, domain, value string) error
-	UpdateRecord(host, domain, value string) error
-	DeleteRecord(host, domain string) error
+	AddRecord(host, domain, recordType, value string) error
+	UpdateRecord(host, domain, recordType, value string) error
+	DeleteRecord(host, domain, recordType string) error
+	// ListRecords return the A / AAAA records currently served for given domain
+	ListRecords(domain string) ([]Record, error)
+	// Check make sure the DNS provider is reachable and manage given domain
+	// this should be lightweight since it is used by the readiness probe
+	Check(domain string) error
 }
 
+// Record is a A / AAAA record as served by the DNS provider
+// the Host is relative to the domain (empty for the domain apex)
+type Record struct {
+	Host  string
+	Type  string
+	Value string
+}
+
+// errRecordNotFound is returned when the record to update or delete does not exist
+var errRecordNotFound = errors.New("record not found")
+
+// recordTypes are the record types managed by the provisioners
+var recordTypes = []string{"A", "AAAA"}
+
 // Provider is the abstraction used to resolve a Provisioner
 // based on his name etc. This ease unit testing
 type Provider interface {
@@ -31,6 +55,12 @@ func (p *provider) GetProvisioner(name string, config map[string]string) (Provis
 	switch name {
 	case ovhProvisionerName:
 		return newOVHProvisioner(config)
+	case cloudflareProvisionerName:
+		return newCloudflareProvisioner(config)
+	case rfc2136ProvisionerName:
+		return newRFC2136Provisioner(config)
+	case AuthoritativeProvisionerName:
+		return newAuthoritativeProvisioner(config)
 	default:
 		return nil, fmt.Errorf("no provisioner named %s found", name)
 	}
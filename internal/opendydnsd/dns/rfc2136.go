@@ -0,0 +1,196 @@
+package dns
+
+import (
+	"fmt"
+	mdns "github.com/miekg/dns"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rfc2136ProvisionerName = "rfc2136"
+	rfc2136DefaultTTL      = 300
+	rfc2136TsigFudge       = 300
+)
+
+var rfc2136Algorithms = map[string]string{
+	"hmac-sha256": mdns.HmacSHA256,
+	"hmac-sha512": mdns.HmacSHA512,
+}
+
+// rfc2136Provisioner send TSIG signed DNS UPDATE messages (RFC 2136)
+// to a primary name server (BIND, Knot, ...)
+type rfc2136Provisioner struct {
+	client    *mdns.Client
+	server    string
+	keyName   string
+	algorithm string
+	ttl       uint32
+}
+
+func newRFC2136Provisioner(config map[string]string) (Provisioner, error) {
+	server, err := getConfigOrFail(config, "server")
+	if err != nil {
+		return nil, err
+	}
+	keyName, err := getConfigOrFail(config, "key-name")
+	if err != nil {
+		return nil, err
+	}
+	keySecret, err := getConfigOrFail(config, "key-secret")
+	if err != nil {
+		return nil, err
+	}
+
+	// Add default DNS port if missing
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	algorithm := mdns.HmacSHA256
+	if v, exist := config["key-algorithm"]; exist {
+		a, exist := rfc2136Algorithms[v]
+		if !exist {
+			return nil, fmt.Errorf("unsupported TSIG algorithm `%s`", v)
+		}
+		algorithm = a
+	}
+
+	ttl := uint32(rfc2136DefaultTTL)
+	if v, exist := config["ttl"]; exist {
+		val, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config `ttl`: %s", err)
+		}
+		ttl = uint32(val)
+	}
+
+	network := "udp"
+	if v, exist := config["net"]; exist {
+		network = v
+	}
+
+	keyName = mdns.Fqdn(keyName)
+
+	return &rfc2136Provisioner{
+		client: &mdns.Client{
+			Net:        network,
+			TsigSecret: map[string]string{keyName: keySecret},
+			Timeout:    10 * time.Second,
+		},
+		server:    server,
+		keyName:   keyName,
+		algorithm: algorithm,
+		ttl:       ttl,
+	}, nil
+}
+
+func (r *rfc2136Provisioner) AddRecord(host, domain, recordType, value string) error {
+	rr, err := r.newRR(host, domain, recordType, value)
+	if err != nil {
+		return err
+	}
+
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(domain))
+	m.Insert([]mdns.RR{rr})
+
+	return r.exchange(m)
+}
+
+func (r *rfc2136Provisioner) UpdateRecord(host, domain, recordType, value string) error {
+	rr, err := r.newRR(host, domain, recordType, value)
+	if err != nil {
+		return err
+	}
+
+	// replace the whole RRset atomically
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(domain))
+	m.RemoveRRset([]mdns.RR{rr})
+	m.Insert([]mdns.RR{rr})
+
+	return r.exchange(m)
+}
+
+func (r *rfc2136Provisioner) DeleteRecord(host, domain, recordType string) error {
+	rrType, exist := mdns.StringToType[recordType]
+	if !exist {
+		return fmt.Errorf("unsupported record type `%s`", recordType)
+	}
+
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(domain))
+	m.RemoveRRset([]mdns.RR{&mdns.ANY{Hdr: mdns.RR_Header{
+		Name:   mdns.Fqdn(getRecordName(host, domain)),
+		Rrtype: rrType,
+		Class:  mdns.ClassINET,
+	}}})
+
+	return r.exchange(m)
+}
+
+// ListRecords fetch the zone content using a TSIG signed zone transfer (AXFR)
+func (r *rfc2136Provisioner) ListRecords(domain string) ([]Record, error) {
+	zone := mdns.Fqdn(domain)
+
+	m := new(mdns.Msg)
+	m.SetAxfr(zone)
+	m.SetTsig(r.keyName, r.algorithm, rfc2136TsigFudge, time.Now().Unix())
+
+	t := &mdns.Transfer{TsigSecret: r.client.TsigSecret}
+	envelopes, err := t.In(m, r.server)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, envelope.Error
+		}
+
+		for _, rr := range envelope.RR {
+			host := strings.TrimSuffix(strings.TrimSuffix(rr.Header().Name, zone), ".")
+
+			switch v := rr.(type) {
+			case *mdns.A:
+				records = append(records, Record{Host: host, Type: "A", Value: v.A.String()})
+			case *mdns.AAAA:
+				records = append(records, Record{Host: host, Type: "AAAA", Value: v.AAAA.String()})
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// Check query (signed) the SOA record of the zone
+func (r *rfc2136Provisioner) Check(domain string) error {
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(domain), mdns.TypeSOA)
+
+	return r.exchange(m)
+}
+
+func (r *rfc2136Provisioner) newRR(host, domain, recordType, value string) (mdns.RR, error) {
+	return mdns.NewRR(fmt.Sprintf("%s %d IN %s %s", mdns.Fqdn(getRecordName(host, domain)), r.ttl, recordType, value))
+}
+
+// exchange sign given message and send it to the primary server
+func (r *rfc2136Provisioner) exchange(m *mdns.Msg) error {
+	m.SetTsig(r.keyName, r.algorithm, rfc2136TsigFudge, time.Now().Unix())
+
+	res, _, err := r.client.Exchange(m, r.server)
+	if err != nil {
+		return err
+	}
+
+	if res.Rcode != mdns.RcodeSuccess {
+		return fmt.Errorf("DNS update refused by %s: %s", r.server, mdns.RcodeToString[res.Rcode])
+	}
+
+	return nil
+}
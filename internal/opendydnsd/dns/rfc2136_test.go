@@ -0,0 +1,238 @@
+package dns
+
+import (
+	mdns "github.com/miekg/dns"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testTsigKey    = "opendydns."
+	testTsigSecret = "c2VjcmV0LWtleS11c2VkLWZvci10ZXN0aW5nLW9ubHk="
+)
+
+// fakePrimary is an in-process DNS server applying TSIG signed updates to an in-memory zone
+type fakePrimary struct {
+	lock    sync.Mutex
+	zone    string
+	records map[string]mdns.RR // "<name> <type>" -> RR
+}
+
+func (f *fakePrimary) ServeDNS(w mdns.ResponseWriter, r *mdns.Msg) {
+	m := new(mdns.Msg)
+	m.SetReply(r)
+
+	switch {
+	case r.IsTsig() == nil || w.TsigStatus() != nil:
+		m.Rcode = mdns.RcodeNotAuth
+	case len(r.Question) != 1 || r.Question[0].Name != f.zone:
+		m.Rcode = mdns.RcodeNotZone
+	case r.Question[0].Qtype == mdns.TypeAXFR:
+		soa, _ := mdns.NewRR(f.zone + " 300 IN SOA ns1.example.org. hostmaster.example.org. 1 3600 600 604800 60")
+		m.Answer = append(m.Answer, soa)
+		f.lock.Lock()
+		for _, rr := range f.records {
+			m.Answer = append(m.Answer, rr)
+		}
+		f.lock.Unlock()
+		m.Answer = append(m.Answer, soa)
+	case r.Opcode == mdns.OpcodeQuery && r.Question[0].Qtype == mdns.TypeSOA:
+		soa, _ := mdns.NewRR(f.zone + " 300 IN SOA ns1.example.org. hostmaster.example.org. 1 3600 600 604800 60")
+		m.Answer = append(m.Answer, soa)
+	case r.Opcode != mdns.OpcodeUpdate:
+		m.Rcode = mdns.RcodeNotImplemented
+	default:
+		f.lock.Lock()
+		for _, rr := range r.Ns {
+			key := rr.Header().Name + " " + mdns.TypeToString[rr.Header().Rrtype]
+			if rr.Header().Class == mdns.ClassANY {
+				delete(f.records, key)
+			} else {
+				f.records[key] = rr
+			}
+		}
+		f.lock.Unlock()
+	}
+
+	if r.IsTsig() != nil && w.TsigStatus() == nil {
+		m.SetTsig(testTsigKey, mdns.HmacSHA256, 300, time.Now().Unix())
+	}
+	_ = w.WriteMsg(m)
+}
+
+func (f *fakePrimary) get(key string) (mdns.RR, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	rr, exist := f.records[key]
+	return rr, exist
+}
+
+func startFakePrimary(t *testing.T) (*fakePrimary, string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// zone transfers are served over TCP, on the same port
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := &fakePrimary{zone: "example.org.", records: map[string]mdns.RR{}}
+
+	var servers []*mdns.Server
+	for _, server := range []*mdns.Server{{PacketConn: pc}, {Listener: l}} {
+		started := make(chan struct{})
+		server.Handler = primary
+		server.TsigSecret = map[string]string{testTsigKey: testTsigSecret}
+		server.NotifyStartedFunc = func() { close(started) }
+		// the default accept func rejects UPDATE messages
+		server.MsgAcceptFunc = func(dh mdns.Header) mdns.MsgAcceptAction {
+			return mdns.MsgAccept
+		}
+
+		go func(server *mdns.Server) {
+			_ = server.ActivateAndServe()
+		}(server)
+		<-started
+
+		servers = append(servers, server)
+	}
+
+	return primary, pc.LocalAddr().String(), func() {
+		for _, server := range servers {
+			_ = server.Shutdown()
+		}
+	}
+}
+
+func TestNewRFC2136Provisioner(t *testing.T) {
+	if _, err := newRFC2136Provisioner(map[string]string{}); err == nil {
+		t.Error("newRFC2136Provisioner should have failed")
+	}
+
+	conf := map[string]string{
+		"server":     "ns1.example.org",
+		"key-name":   "opendydns",
+		"key-secret": testTsigSecret,
+	}
+
+	p, err := newRFC2136Provisioner(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := p.(*rfc2136Provisioner)
+	if r.server != "ns1.example.org:53" || r.keyName != "opendydns." || r.algorithm != mdns.HmacSHA256 || r.ttl != 300 {
+		t.Errorf("wrong default configuration: %+v", r)
+	}
+
+	conf["key-algorithm"] = "hmac-sha512"
+	conf["ttl"] = "60"
+	p, err = newRFC2136Provisioner(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r = p.(*rfc2136Provisioner)
+	if r.algorithm != mdns.HmacSHA512 || r.ttl != 60 {
+		t.Errorf("wrong configuration: %+v", r)
+	}
+
+	conf["key-algorithm"] = "hmac-md5"
+	if _, err := newRFC2136Provisioner(conf); err == nil {
+		t.Error("newRFC2136Provisioner should have failed")
+	}
+}
+
+func TestRFC2136Provisioner(t *testing.T) {
+	primary, addr, shutdown := startFakePrimary(t)
+	defer shutdown()
+
+	p, err := newRFC2136Provisioner(map[string]string{
+		"server":     addr,
+		"key-name":   "opendydns",
+		"key-secret": testTsigSecret,
+		"ttl":        "120",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddRecord("foo", "example.org", "A", "8.8.8.8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRecord("foo", "example.org", "AAAA", "2001:4860:4860::8888"); err != nil {
+		t.Fatal(err)
+	}
+
+	rr, exist := primary.get("foo.example.org. A")
+	if !exist || rr.(*mdns.A).A.String() != "8.8.8.8" || rr.Header().Ttl != 120 {
+		t.Errorf("wrong A record: %v", rr)
+	}
+
+	if err := p.UpdateRecord("foo", "example.org", "A", "1.1.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	if rr, _ := primary.get("foo.example.org. A"); rr.(*mdns.A).A.String() != "1.1.1.1" {
+		t.Errorf("A record not updated: %v", rr)
+	}
+
+	if err := p.DeleteRecord("foo", "example.org", "AAAA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := primary.get("foo.example.org. AAAA"); exist {
+		t.Error("AAAA record not deleted")
+	}
+	if _, exist := primary.get("foo.example.org. A"); !exist {
+		t.Error("A record should not have been deleted")
+	}
+
+	records, err := p.ListRecords("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0] != (Record{Host: "foo", Type: "A", Value: "1.1.1.1"}) {
+		t.Errorf("wrong records listed: %v", records)
+	}
+
+	// not our zone
+	if err := p.AddRecord("foo", "example.com", "A", "8.8.8.8"); err == nil {
+		t.Error("AddRecord() should have failed")
+	}
+
+	if err := p.Check("example.org"); err != nil {
+		t.Error(err)
+	}
+	if err := p.Check("example.com"); err == nil {
+		t.Error("Check() should have failed")
+	}
+}
+
+func TestRFC2136Provisioner_BadKey(t *testing.T) {
+	primary, addr, shutdown := startFakePrimary(t)
+	defer shutdown()
+
+	p, err := newRFC2136Provisioner(map[string]string{
+		"server":     addr,
+		"key-name":   "opendydns",
+		"key-secret": "d3Jvbmctc2VjcmV0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddRecord("foo", "example.org", "A", "8.8.8.8"); err == nil {
+		t.Error("AddRecord() should have failed")
+	}
+	if _, exist := primary.get("foo.example.org. A"); exist {
+		t.Error("record should not have been created")
+	}
+
+	if err := p.Check("example.org"); err == nil {
+		t.Error("Check() should have failed")
+	}
+}
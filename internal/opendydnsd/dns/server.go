@@ -0,0 +1,300 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	mdns "github.com/miekg/dns"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultZoneTTL = 60
+	soaRefresh     = 3600
+	soaRetry       = 600
+	soaExpire      = 604800
+)
+
+// ZoneStore is the source of the records served by the Server
+type ZoneStore interface {
+	FindAlias(host, domain string) (database.Alias, error)
+	FindDomainLastUpdate(domain string) (time.Time, error)
+}
+
+// Zone represent a zone served by the built-in authoritative Server
+type Zone struct {
+	Domain      string   // the aliases domain (i.e without trailing dot)
+	Nameservers []string // FQDN of the zone name servers
+	Mbox        string   // FQDN of the zone administrator mailbox
+	TTL         uint32
+}
+
+// NewZone return the zone for given domain, configured using given provisioner config
+func NewZone(domain string, config map[string]string) (Zone, error) {
+	nameservers, err := getConfigOrFail(config, "nameservers")
+	if err != nil {
+		return Zone{}, err
+	}
+
+	zone := Zone{
+		Domain: domain,
+		Mbox:   mdns.Fqdn("hostmaster." + domain),
+		TTL:    defaultZoneTTL,
+	}
+
+	for _, ns := range strings.Split(nameservers, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			zone.Nameservers = append(zone.Nameservers, mdns.Fqdn(ns))
+		}
+	}
+	if len(zone.Nameservers) == 0 {
+		return Zone{}, fmt.Errorf("missing config `nameservers`")
+	}
+
+	if v, exist := config["mbox"]; exist {
+		zone.Mbox = mdns.Fqdn(v)
+	}
+
+	if v, exist := config["ttl"]; exist {
+		ttl, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return Zone{}, fmt.Errorf("invalid config `ttl`: %s", err)
+		}
+		zone.TTL = uint32(ttl)
+	}
+
+	return zone, nil
+}
+
+// Server is a built-in authoritative DNS server answering
+// A / AAAA / SOA / NS queries straight from the aliases
+type Server struct {
+	addr      string
+	zones     []Zone
+	zonesLock sync.RWMutex
+	store     ZoneStore
+	logger    *zerolog.Logger
+	udp       *mdns.Server
+	tcp       *mdns.Server
+}
+
+// NewServer return a new Server serving given zones on given address (UDP & TCP)
+func NewServer(addr string, zones []Zone, store ZoneStore, logger *zerolog.Logger) *Server {
+	return &Server{
+		addr:   addr,
+		zones:  zones,
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Start the server. This returns once both UDP and TCP listeners are ready
+func (s *Server) Start() error {
+	pc, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		_ = pc.Close()
+		return err
+	}
+
+	s.udp = &mdns.Server{PacketConn: pc, Handler: s}
+	s.tcp = &mdns.Server{Listener: l, Handler: s}
+
+	for _, srv := range []*mdns.Server{s.udp, s.tcp} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+
+		go func(srv *mdns.Server) {
+			if err := srv.ActivateAndServe(); err != nil {
+				s.logger.Err(err).Msg("DNS server stopped.")
+			}
+		}(srv)
+
+		<-started
+	}
+
+	return nil
+}
+
+// Shutdown stop the server
+func (s *Server) Shutdown() error {
+	if s.udp == nil || s.tcp == nil {
+		return nil
+	}
+
+	udpErr := s.udp.Shutdown()
+	if err := s.tcp.Shutdown(); err != nil {
+		return err
+	}
+
+	return udpErr
+}
+
+// ServeDNS implement mdns.Handler
+func (s *Server) ServeDNS(w mdns.ResponseWriter, r *mdns.Msg) {
+	m := new(mdns.Msg)
+	m.SetReply(r)
+
+	if len(r.Question) != 1 {
+		m.Rcode = mdns.RcodeFormatError
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	q := r.Question[0]
+	zone, host, found := s.findZone(q.Name)
+	if !found {
+		m.Rcode = mdns.RcodeRefused
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	m.Authoritative = true
+	if err := s.answer(m, q, zone, host); err != nil {
+		s.logger.Err(err).Str("Name", q.Name).Msg("error while answering DNS query.")
+		m = new(mdns.Msg)
+		m.SetRcode(r, mdns.RcodeServerFailure)
+	}
+
+	_ = w.WriteMsg(m)
+}
+
+func (s *Server) answer(m *mdns.Msg, q mdns.Question, zone Zone, host string) error {
+	name := mdns.Fqdn(zone.Domain)
+
+	// Zone apex
+	if host == "" {
+		switch q.Qtype {
+		case mdns.TypeSOA:
+			soa, err := s.soa(zone)
+			if err != nil {
+				return err
+			}
+			m.Answer = append(m.Answer, soa)
+		case mdns.TypeNS:
+			for _, ns := range zone.Nameservers {
+				m.Answer = append(m.Answer, &mdns.NS{Hdr: header(name, mdns.TypeNS, zone.TTL), Ns: ns})
+			}
+		}
+
+		if len(m.Answer) == 0 {
+			return s.negative(m, zone, mdns.RcodeSuccess)
+		}
+		return nil
+	}
+
+	// aliases are stored as first label / remaining labels
+	domain := zone.Domain
+	if i := strings.Index(host, "."); i != -1 {
+		host, domain = host[:i], host[i+1:]+"."+zone.Domain
+	}
+
+	alias, err := s.store.FindAlias(host, domain)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.negative(m, zone, mdns.RcodeNameError)
+	}
+	if err != nil {
+		return err
+	}
+
+	if alias.IPv4 != "" && (q.Qtype == mdns.TypeA || q.Qtype == mdns.TypeANY) {
+		m.Answer = append(m.Answer, &mdns.A{Hdr: header(q.Name, mdns.TypeA, zone.TTL), A: net.ParseIP(alias.IPv4)})
+	}
+	if alias.IPv6 != "" && (q.Qtype == mdns.TypeAAAA || q.Qtype == mdns.TypeANY) {
+		m.Answer = append(m.Answer, &mdns.AAAA{Hdr: header(q.Name, mdns.TypeAAAA, zone.TTL), AAAA: net.ParseIP(alias.IPv6)})
+	}
+
+	if len(m.Answer) == 0 {
+		return s.negative(m, zone, mdns.RcodeSuccess)
+	}
+
+	return nil
+}
+
+// negative build a negative answer (NXDOMAIN / NODATA) with the zone SOA
+func (s *Server) negative(m *mdns.Msg, zone Zone, rcode int) error {
+	soa, err := s.soa(zone)
+	if err != nil {
+		return err
+	}
+
+	m.Rcode = rcode
+	m.Ns = append(m.Ns, soa)
+	return nil
+}
+
+// soa build the zone SOA record. The serial is derived from
+// the latest alias change, so it bump automatically on each update
+func (s *Server) soa(zone Zone) (*mdns.SOA, error) {
+	lastUpdate, err := s.store.FindDomainLastUpdate(zone.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	serial := uint32(1)
+	if !lastUpdate.IsZero() {
+		serial = uint32(lastUpdate.Unix())
+	}
+
+	return &mdns.SOA{
+		Hdr:     header(mdns.Fqdn(zone.Domain), mdns.TypeSOA, zone.TTL),
+		Ns:      zone.Nameservers[0],
+		Mbox:    zone.Mbox,
+		Serial:  serial,
+		Refresh: soaRefresh,
+		Retry:   soaRetry,
+		Expire:  soaExpire,
+		Minttl:  zone.TTL,
+	}, nil
+}
+
+// SetZones replace the zones served by the server
+func (s *Server) SetZones(zones []Zone) {
+	s.zonesLock.Lock()
+	defer s.zonesLock.Unlock()
+
+	s.zones = zones
+}
+
+// findZone find the most specific zone for given name
+// and return the host part of the name (empty for the zone apex)
+func (s *Server) findZone(name string) (Zone, string, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	s.zonesLock.RLock()
+	defer s.zonesLock.RUnlock()
+
+	var best Zone
+	found := false
+	for _, zone := range s.zones {
+		domain := strings.ToLower(zone.Domain)
+		if name != domain && !strings.HasSuffix(name, "."+domain) {
+			continue
+		}
+
+		if !found || len(domain) > len(best.Domain) {
+			best = zone
+			found = true
+		}
+	}
+
+	if !found {
+		return Zone{}, "", false
+	}
+
+	return best, strings.TrimSuffix(strings.TrimSuffix(name, strings.ToLower(best.Domain)), "."), true
+}
+
+func header(name string, rrType uint16, ttl uint32) mdns.RR_Header {
+	return mdns.RR_Header{Name: name, Rrtype: rrType, Class: mdns.ClassINET, Ttl: ttl}
+}
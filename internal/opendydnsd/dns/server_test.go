@@ -0,0 +1,211 @@
+package dns
+
+import (
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
+	mdns "github.com/miekg/dns"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeZoneStore struct {
+	lock       sync.Mutex
+	aliases    map[string]database.Alias // "<host> <domain>" -> alias
+	lastUpdate time.Time
+	err        error
+}
+
+func (f *fakeZoneStore) FindAlias(host, domain string) (database.Alias, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.err != nil {
+		return database.Alias{}, f.err
+	}
+
+	alias, exist := f.aliases[host+" "+domain]
+	if !exist {
+		return database.Alias{}, gorm.ErrRecordNotFound
+	}
+	return alias, nil
+}
+
+func (f *fakeZoneStore) FindDomainLastUpdate(_ string) (time.Time, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.lastUpdate, nil
+}
+
+func startTestServer(t *testing.T, store ZoneStore) *Server {
+	logger := log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	zones := []Zone{
+		{Domain: "example.org", Nameservers: []string{"ns1.example.org.", "ns2.example.org."}, Mbox: "hostmaster.example.org.", TTL: 60},
+		{Domain: "dyn.example.org", Nameservers: []string{"ns1.example.org."}, Mbox: "hostmaster.example.org.", TTL: 30},
+	}
+
+	s := NewServer("127.0.0.1:0", zones, store, &logger)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func query(t *testing.T, s *Server, network, name string, qtype uint16) *mdns.Msg {
+	addr := s.udp.PacketConn.LocalAddr().String()
+	if network == "tcp" {
+		addr = s.tcp.Listener.Addr().String()
+	}
+
+	m := new(mdns.Msg)
+	m.SetQuestion(name, qtype)
+
+	c := &mdns.Client{Net: network}
+	r, _, err := c.Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestNewZone(t *testing.T) {
+	if _, err := NewZone("example.org", map[string]string{}); err == nil {
+		t.Error("NewZone() should have failed")
+	}
+	if _, err := NewZone("example.org", map[string]string{"nameservers": " , "}); err == nil {
+		t.Error("NewZone() should have failed")
+	}
+	if _, err := NewZone("example.org", map[string]string{"nameservers": "ns1.example.org", "ttl": "abc"}); err == nil {
+		t.Error("NewZone() should have failed")
+	}
+
+	zone, err := NewZone("example.org", map[string]string{"nameservers": "ns1.example.org, ns2.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zone.Nameservers) != 2 || zone.Nameservers[1] != "ns2.example.org." ||
+		zone.Mbox != "hostmaster.example.org." || zone.TTL != defaultZoneTTL {
+		t.Errorf("wrong zone: %+v", zone)
+	}
+
+	zone, err = NewZone("example.org", map[string]string{"nameservers": "ns1.example.org", "mbox": "admin.example.org", "ttl": "30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.Mbox != "admin.example.org." || zone.TTL != 30 {
+		t.Errorf("wrong zone: %+v", zone)
+	}
+}
+
+func TestServer(t *testing.T) {
+	store := &fakeZoneStore{
+		aliases: map[string]database.Alias{
+			"foo example.org":     {Host: "foo", Domain: "example.org", IPv4: "8.8.8.8", IPv6: "2001:4860:4860::8888"},
+			"bar dyn.example.org": {Host: "bar", Domain: "dyn.example.org", IPv4: "1.1.1.1"},
+			"baz sub.example.org": {Host: "baz", Domain: "sub.example.org", IPv4: "9.9.9.9"},
+		},
+		lastUpdate: time.Unix(1600000000, 0),
+	}
+
+	s := startTestServer(t, store)
+	defer s.Shutdown()
+
+	for _, network := range []string{"udp", "tcp"} {
+		r := query(t, s, network, "foo.example.org.", mdns.TypeA)
+		if !r.Authoritative || r.Rcode != mdns.RcodeSuccess || len(r.Answer) != 1 ||
+			r.Answer[0].(*mdns.A).A.String() != "8.8.8.8" || r.Answer[0].Header().Ttl != 60 {
+			t.Errorf("%s: wrong A answer: %v", network, r)
+		}
+	}
+
+	r := query(t, s, "udp", "FOO.example.org.", mdns.TypeAAAA)
+	if len(r.Answer) != 1 || r.Answer[0].(*mdns.AAAA).AAAA.String() != "2001:4860:4860::8888" {
+		t.Errorf("wrong AAAA answer: %v", r)
+	}
+
+	// most specific zone is used
+	r = query(t, s, "udp", "bar.dyn.example.org.", mdns.TypeA)
+	if len(r.Answer) != 1 || r.Answer[0].(*mdns.A).A.String() != "1.1.1.1" || r.Answer[0].Header().Ttl != 30 {
+		t.Errorf("wrong A answer: %v", r)
+	}
+
+	// aliases with multiple labels under the zone
+	r = query(t, s, "udp", "baz.sub.example.org.", mdns.TypeA)
+	if len(r.Answer) != 1 || r.Answer[0].(*mdns.A).A.String() != "9.9.9.9" {
+		t.Errorf("wrong A answer: %v", r)
+	}
+
+	// NODATA
+	r = query(t, s, "udp", "bar.dyn.example.org.", mdns.TypeAAAA)
+	if r.Rcode != mdns.RcodeSuccess || len(r.Answer) != 0 || len(r.Ns) != 1 {
+		t.Errorf("wrong NODATA answer: %v", r)
+	}
+
+	// NXDOMAIN
+	r = query(t, s, "udp", "unknown.example.org.", mdns.TypeA)
+	if r.Rcode != mdns.RcodeNameError || len(r.Ns) != 1 || r.Ns[0].(*mdns.SOA).Serial != 1600000000 {
+		t.Errorf("wrong NXDOMAIN answer: %v", r)
+	}
+
+	// zone apex
+	r = query(t, s, "udp", "example.org.", mdns.TypeSOA)
+	if len(r.Answer) != 1 {
+		t.Fatalf("wrong SOA answer: %v", r)
+	}
+	soa := r.Answer[0].(*mdns.SOA)
+	if soa.Ns != "ns1.example.org." || soa.Mbox != "hostmaster.example.org." || soa.Serial != 1600000000 {
+		t.Errorf("wrong SOA answer: %v", soa)
+	}
+
+	r = query(t, s, "tcp", "example.org.", mdns.TypeNS)
+	if len(r.Answer) != 2 || r.Answer[1].(*mdns.NS).Ns != "ns2.example.org." {
+		t.Errorf("wrong NS answer: %v", r)
+	}
+
+	// not our zone
+	r = query(t, s, "udp", "example.com.", mdns.TypeA)
+	if r.Rcode != mdns.RcodeRefused {
+		t.Errorf("wrong answer for foreign zone: %v", r)
+	}
+}
+
+func TestServer_SerialBump(t *testing.T) {
+	store := &fakeZoneStore{aliases: map[string]database.Alias{}}
+
+	s := startTestServer(t, store)
+	defer s.Shutdown()
+
+	r := query(t, s, "udp", "example.org.", mdns.TypeSOA)
+	if r.Answer[0].(*mdns.SOA).Serial != 1 {
+		t.Errorf("wrong initial serial: %v", r.Answer[0])
+	}
+
+	store.lock.Lock()
+	store.lastUpdate = time.Unix(1600000042, 0)
+	store.lock.Unlock()
+
+	r = query(t, s, "udp", "example.org.", mdns.TypeSOA)
+	if r.Answer[0].(*mdns.SOA).Serial != 1600000042 {
+		t.Errorf("serial not bumped: %v", r.Answer[0])
+	}
+}
+
+func TestServer_StoreError(t *testing.T) {
+	store := &fakeZoneStore{err: fmt.Errorf("database is locked")}
+
+	s := startTestServer(t, store)
+	defer s.Shutdown()
+
+	r := query(t, s, "udp", "foo.example.org.", mdns.TypeA)
+	if r.Rcode != mdns.RcodeServerFailure {
+		t.Errorf("wrong answer: %v", r)
+	}
+}
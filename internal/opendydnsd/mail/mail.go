@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+//go:generate mockgen -source mail.go -destination=../mail_mock/mail_mock.go -package=mail_mock
+
+// Mailer send plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+type smtpMailer struct {
+	conf config.SMTPConfig
+}
+
+// NewMailer return a Mailer sending emails trough given SMTP relay
+func NewMailer(conf config.SMTPConfig) Mailer {
+	return &smtpMailer{conf: conf}
+}
+
+func (sm *smtpMailer) Send(to, subject, body string) error {
+	// prevent headers injection
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	var auth smtp.Auth
+	if sm.conf.Username != "" {
+		host, _, err := net.SplitHostPort(sm.conf.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", sm.conf.Username, sm.conf.Password, host)
+	}
+
+	return smtp.SendMail(sm.conf.Addr, auth, sm.conf.From, []string{to}, newMessage(sm.conf.From, to, subject, body))
+}
+
+// newMessage build the RFC 5322 message
+func newMessage(from, to, subject, body string) []byte {
+	var msg bytes.Buffer
+
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return msg.Bytes()
+}
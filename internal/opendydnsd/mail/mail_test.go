@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"bufio"
+	"encoding/base64"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// smtpStandIn is a minimal SMTP server recording the received messages
+type smtpStandIn struct {
+	listener net.Listener
+	auth     string // decoded AUTH PLAIN credentials
+	from     string
+	to       []string
+	data     string
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &smtpStandIn{listener: l}
+	go s.serve()
+
+	return s
+}
+
+func (s *smtpStandIn) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			_ = tp.PrintfLine("250-localhost")
+			_ = tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			parts := strings.Fields(line)
+			auth, _ := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+			s.auth = string(auth)
+			_ = tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			s.from = line
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.to = append(s.to, line)
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, _ := tp.ReadDotBytes()
+			s.data = string(data)
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	s := newSMTPStandIn(t)
+	defer s.listener.Close()
+
+	m := NewMailer(config.SMTPConfig{
+		Addr:     s.listener.Addr().String(),
+		Username: "opendydns",
+		Password: "secret",
+		From:     "noreply@example.org",
+	})
+
+	if err := m.Send("lunamicard@gmail.com", "Verify your email address", "Hello\nWorld"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.auth != "\x00opendydns\x00secret" {
+		t.Errorf("wrong authentication: %q", s.auth)
+	}
+	if s.from != "MAIL FROM:<noreply@example.org>" || len(s.to) != 1 || s.to[0] != "RCPT TO:<lunamicard@gmail.com>" {
+		t.Errorf("wrong envelope: %s %v", s.from, s.to)
+	}
+
+	headers, err := textproto.NewReader(bufio.NewReader(strings.NewReader(s.data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers.Get("To") != "lunamicard@gmail.com" || headers.Get("Subject") != "Verify your email address" {
+		t.Errorf("wrong headers: %v", headers)
+	}
+	if !strings.HasSuffix(s.data, "\nHello\nWorld\n") {
+		t.Errorf("wrong body: %q", s.data)
+	}
+}
+
+func TestSMTPMailer_Send_InvalidHeader(t *testing.T) {
+	m := NewMailer(config.SMTPConfig{Addr: "127.0.0.1:25", From: "noreply@example.org"})
+
+	if err := m.Send("lunamicard@gmail.com\r\nBcc: other@example.org", "Hello", "World"); err == nil {
+		t.Error("Send() should have failed")
+	}
+}
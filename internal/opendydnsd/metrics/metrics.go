@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "opendydnsd"
+
+// HTTPRequests count the API requests per route
+var HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_requests_total",
+	Help:      "Number of HTTP requests handled per route and status code.",
+}, []string{"method", "route", "status"})
+
+// HTTPRequestDuration observe the API requests latency per route
+var HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "http_request_duration_seconds",
+	Help:      "Latency of the HTTP requests per route.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"method", "route"})
+
+// AuthenticationFailures count the failed authentications
+// the method is either password, totp or update_token
+var AuthenticationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "authentication_failures_total",
+	Help:      "Number of failed authentications per method.",
+}, []string{"method"})
+
+// ThrottledRequests count the requests rejected by the rate limits
+// the limit is either ip, account, alias or lockout
+var ThrottledRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "throttled_requests_total",
+	Help:      "Number of requests rejected by the rate limits per limit.",
+}, []string{"limit"})
+
+// AliasOperations count the successful alias changes
+// the operation is either register, update or delete
+var AliasOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "alias_operations_total",
+	Help:      "Number of aliases registered, updated and deleted.",
+}, []string{"operation"})
+
+// ProvisionerCallDuration observe the DNS provisioners calls latency
+var ProvisionerCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "dns_provisioner_call_duration_seconds",
+	Help:      "Latency of the DNS provisioner calls per provisioner, domain and operation.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"provisioner", "domain", "operation"})
+
+// ProvisionerCallErrors count the failed DNS provisioners calls
+var ProvisionerCallErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "dns_provisioner_call_errors_total",
+	Help:      "Number of failed DNS provisioner calls per provisioner, domain and operation.",
+}, []string{"provisioner", "domain", "operation"})
+
+// AliasesDesc describe the number of aliases per domain
+// the value is computed when collected: see NewAliasesCollector
+var AliasesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "aliases"),
+	"Number of aliases per domain.",
+	[]string{"domain"}, nil,
+)
+
+// aliasesCollector collect the number of aliases per domain
+type aliasesCollector struct {
+	count func() (map[string]int64, error)
+}
+
+// NewAliasesCollector return a collector exposing the number of aliases
+// per domain, as returned by given function
+func NewAliasesCollector(count func() (map[string]int64, error)) prometheus.Collector {
+	return &aliasesCollector{count: count}
+}
+
+func (ac *aliasesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- AliasesDesc
+}
+
+func (ac *aliasesCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := ac.count()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(AliasesDesc, err)
+		return
+	}
+
+	for domain, count := range counts {
+		ch <- prometheus.MustNewConstMetric(AliasesDesc, prometheus.GaugeValue, float64(count), domain)
+	}
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestAliasesCollector(t *testing.T) {
+	collector := NewAliasesCollector(func() (map[string]int64, error) {
+		return map[string]int64{"bar.baz": 2, "example.org": 1}, nil
+	})
+
+	expected := `
+# HELP opendydnsd_aliases Number of aliases per domain.
+# TYPE opendydnsd_aliases gauge
+opendydnsd_aliases{domain="bar.baz"} 2
+opendydnsd_aliases{domain="example.org"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAliasesCollector_Error(t *testing.T) {
+	collector := NewAliasesCollector(func() (map[string]int64, error) {
+		return nil, fmt.Errorf("database is locked")
+	})
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader("")); err == nil {
+		t.Error("CollectAndCompare() should have failed")
+	}
+}
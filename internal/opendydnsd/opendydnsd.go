@@ -1,18 +1,26 @@
 package opendydnsd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/creekorful/open-dydns/internal/common"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/api"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/config"
 	"github.com/creekorful/open-dydns/internal/opendydnsd/daemon"
+	"github.com/creekorful/open-dydns/internal/opendydnsd/database"
 	"github.com/creekorful/open-dydns/proto"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/crypto/ssh/terminal"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 // DaemonApp represent a instance of the Daemon app
 type DaemonApp struct {
 	conf     config.Config
@@ -38,6 +46,10 @@ func (da *DaemonApp) GetApp() *cli.App {
 				Name:  "config",
 				Value: "opendydnsd.toml",
 			},
+			&cli.BoolFlag{
+				Name:  "auto-migrate",
+				Usage: "Apply the pending database migrations before starting",
+			},
 		},
 		Commands: []*cli.Command{
 			{
@@ -45,6 +57,44 @@ func (da *DaemonApp) GetApp() *cli.App {
 				ArgsUsage: "<EMAIL>",
 				Usage:     "Create an user account",
 				Action:    da.createUser,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "admin",
+						Usage: "Allow the user to use the admin endpoints",
+					},
+				},
+			},
+			{
+				Name:   "reconcile",
+				Usage:  "Compare the DNS records with the aliases and repair them",
+				Action: da.reconcile,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Only report the differences",
+					},
+				},
+			},
+			{
+				Name:  "migrate",
+				Usage: "Manage the database schema",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "up",
+						Usage:  "Apply the pending migrations",
+						Action: da.migrateUp,
+					},
+					{
+						Name:   "down",
+						Usage:  "Revert the latest applied migration",
+						Action: da.migrateDown,
+					},
+					{
+						Name:   "status",
+						Usage:  "Display the migrations status",
+						Action: da.migrateStatus,
+					},
+				},
 			},
 		},
 		Action: da.startDaemon,
@@ -90,13 +140,39 @@ func (da *DaemonApp) startDaemon(c *cli.Context) error {
 	// Display version etc...
 	da.logger.Info().Str("Version", c.App.Version).Msg("starting OpenDyDNSD")
 
+	// Apply the pending migrations if asked to
+	if c.Bool("auto-migrate") {
+		if err := da.migrateUp(c); err != nil {
+			return err
+		}
+	}
+
 	// Instantiate the Daemon
 	d, err := daemon.NewDaemon(da.conf, da.logger)
 	if err != nil {
+		if errors.Is(err, database.ErrSchemaOutdated) {
+			da.logger.Error().Msg("please run `opendydnsd migrate up` or use --auto-migrate.")
+		}
 		da.logger.Err(err).Msg("unable to start the daemon.")
 		return err
 	}
 
+	// Release the daemon resources if the startup fails (Shutdown only stop the daemon once)
+	defer func() {
+		ctx, cancel := da.shutdownContext()
+		defer cancel()
+
+		if err := d.Shutdown(ctx); err != nil {
+			da.logger.Err(err).Msg("error while shutting down the daemon.")
+		}
+	}()
+
+	// Start the daemon background services
+	if err := d.Start(); err != nil {
+		da.logger.Err(err).Msg("unable to start the daemon services.")
+		return err
+	}
+
 	// Instantiate the API
 	a, err := api.NewAPI(d, da.conf.APIConfig)
 	if err != nil {
@@ -104,8 +180,97 @@ func (da *DaemonApp) startDaemon(c *cli.Context) error {
 		return err
 	}
 
+	// Start the API in background
+	apiErrs := make(chan error, 1)
+	go func() {
+		apiErrs <- a.Start(da.conf.APIConfig.ListenAddr)
+	}()
 	da.logger.Info().Str("Addr", da.conf.APIConfig.ListenAddr).Msg("OpenDyDNSD API started.")
-	return a.Start(da.conf.APIConfig.ListenAddr)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	// Reload the configuration when the file changes
+	var configChanges <-chan struct{}
+	if watcher, err := newFileWatcher(da.confPath, da.logger); err != nil {
+		da.logger.Warn().Err(err).Msg("unable to watch the configuration file, use SIGHUP to reload it.")
+	} else {
+		defer watcher.Close()
+		configChanges = watcher.Changes()
+	}
+
+	for {
+		select {
+		case <-configChanges:
+			da.reload(d)
+		case err := <-apiErrs:
+			da.logger.Err(err).Msg("API stopped unexpectedly.")
+			if err := da.shutdown(nil, d); err != nil {
+				da.logger.Err(err).Msg("error while shutting down.")
+			}
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				da.reload(d)
+				continue
+			}
+
+			da.logger.Info().Str("Signal", sig.String()).Msg("shutting down OpenDyDNSD.")
+			return da.shutdown(a, d)
+		}
+	}
+}
+
+// shutdown drain the API in-flight requests and stop the daemon
+// within the configured timeout
+func (da *DaemonApp) shutdown(a *api.API, d daemon.Daemon) error {
+	ctx, cancel := da.shutdownContext()
+	defer cancel()
+
+	if a != nil {
+		if err := a.Shutdown(ctx); err != nil {
+			da.logger.Err(err).Msg("error while shutting down the API.")
+		}
+	}
+
+	if err := d.Shutdown(ctx); err != nil {
+		da.logger.Err(err).Msg("error while shutting down the daemon.")
+		return err
+	}
+
+	da.logger.Info().Msg("OpenDyDNSD stopped.")
+
+	return nil
+}
+
+// shutdownContext return the context bounding the shutdown to the configured timeout
+func (da *DaemonApp) shutdownContext() (context.Context, context.CancelFunc) {
+	timeout := da.conf.APIConfig.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// reload load the configuration file again and apply it to the daemon
+// the API and database configurations are not reloaded
+func (da *DaemonApp) reload(d daemon.Daemon) {
+	da.logger.Info().Str("Path", da.confPath).Msg("reloading configuration.")
+
+	conf, err := config.Load(da.confPath)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to reload configuration, keeping the current one.")
+		return
+	}
+
+	if err := d.Reload(conf.DaemonConfig); err != nil {
+		da.logger.Err(err).Msg("unable to reload configuration, keeping the current one.")
+		return
+	}
+
+	da.conf.DaemonConfig = conf.DaemonConfig
 }
 
 func (da *DaemonApp) createUser(c *cli.Context) error {
@@ -120,18 +285,19 @@ func (da *DaemonApp) createUser(c *cli.Context) error {
 	fmt.Printf("Password: ")
 	pass, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
 
-	da.logger.Info().Str("Email", email).Msg("creating user.")
+	da.logger.Info().Str("Email", email).Bool("Admin", c.Bool("admin")).Msg("creating user.")
 
 	d, err := daemon.NewDaemon(da.conf, da.logger)
 	if err != nil {
 		da.logger.Err(err).Msg("unable to start the daemon.")
 		return err
 	}
+	defer d.Shutdown(context.Background())
 
 	if _, err := d.CreateUser(proto.CredentialsDto{
 		Email:    email,
 		Password: string(pass),
-	}); err != nil {
+	}, c.Bool("admin")); err != nil {
 		da.logger.Err(err).Str("Email", email).Msg("unable to create user account.")
 		return err
 	}
@@ -140,3 +306,118 @@ func (da *DaemonApp) createUser(c *cli.Context) error {
 
 	return nil
 }
+
+func (da *DaemonApp) reconcile(c *cli.Context) error {
+	d, err := daemon.NewDaemon(da.conf, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to start the daemon.")
+		return err
+	}
+	defer d.Shutdown(context.Background())
+
+	report, err := d.Reconcile(c.Bool("dry-run"))
+	if err != nil {
+		da.logger.Err(err).Msg("unable to reconcile DNS records.")
+		return err
+	}
+
+	for status, records := range map[string][]proto.RecordDto{
+		"missing":  report.Missing,
+		"stale":    report.Stale,
+		"orphaned": report.Orphaned,
+	} {
+		for _, record := range records {
+			da.logger.Info().
+				Str("Status", status).
+				Str("Name", record.Name).
+				Str("Type", record.Type).
+				Str("Value", record.Value).
+				Str("Expected", record.Expected).
+				Msg("record out of sync.")
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		for _, e := range report.Errors {
+			da.logger.Error().Msg(e)
+		}
+		return fmt.Errorf("reconciliation completed with %d error(s)", len(report.Errors))
+	}
+
+	return nil
+}
+
+func (da *DaemonApp) migrateUp(c *cli.Context) error {
+	conn, err := database.OpenConnection(da.conf.DatabaseConfig, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to connect to the database.")
+		return err
+	}
+	defer conn.Close()
+
+	migrations, err := conn.MigrateUp()
+	for _, migration := range migrations {
+		da.logger.Info().
+			Uint("Version", migration.Version).
+			Str("Name", migration.Name).
+			Msg("migration applied.")
+	}
+	if err != nil {
+		da.logger.Err(err).Msg("unable to apply the migrations.")
+		return err
+	}
+
+	da.logger.Info().Int("Applied", len(migrations)).Msg("database schema is up to date.")
+
+	return nil
+}
+
+func (da *DaemonApp) migrateDown(c *cli.Context) error {
+	conn, err := database.OpenConnection(da.conf.DatabaseConfig, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to connect to the database.")
+		return err
+	}
+	defer conn.Close()
+
+	migration, err := conn.MigrateDown()
+	if err != nil {
+		da.logger.Err(err).Msg("unable to revert the migration.")
+		return err
+	}
+
+	da.logger.Info().
+		Uint("Version", migration.Version).
+		Str("Name", migration.Name).
+		Msg("migration reverted.")
+
+	return nil
+}
+
+func (da *DaemonApp) migrateStatus(c *cli.Context) error {
+	conn, err := database.OpenConnection(da.conf.DatabaseConfig, da.logger)
+	if err != nil {
+		da.logger.Err(err).Msg("unable to connect to the database.")
+		return err
+	}
+	defer conn.Close()
+
+	statuses, err := conn.MigrationStatus()
+	if err != nil {
+		da.logger.Err(err).Msg("unable to fetch the migrations status.")
+		return err
+	}
+
+	for _, status := range statuses {
+		event := da.logger.Info().
+			Uint("Version", status.Version).
+			Str("Name", status.Name).
+			Bool("Applied", status.Applied)
+		if status.Applied {
+			event = event.Time("AppliedAt", status.AppliedAt)
+		}
+		event.Msg("")
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package opendydnsd
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+	"path/filepath"
+	"time"
+)
+
+// watchDelay is the time waited after a change before notifying
+// since editors generally produce several events for a single save
+const watchDelay = 500 * time.Millisecond
+
+// fileWatcher notify the changes made to a file
+type fileWatcher struct {
+	watcher *fsnotify.Watcher
+	changes chan struct{}
+	logger  *zerolog.Logger
+}
+
+// newFileWatcher start watching given file. The parent directory is watched
+// since most editors replace the file instead of writing it
+func newFileWatcher(path string, logger *zerolog.Logger) (*fileWatcher, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	fw := &fileWatcher{
+		watcher: watcher,
+		changes: make(chan struct{}, 1),
+		logger:  logger,
+	}
+	go fw.run(path)
+
+	return fw, nil
+}
+
+// Changes return the channel notified when the file has changed
+func (fw *fileWatcher) Changes() <-chan struct{} {
+	return fw.changes
+}
+
+// Close stop watching the file
+func (fw *fileWatcher) Close() error {
+	return fw.watcher.Close()
+}
+
+func (fw *fileWatcher) run(path string) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-fw.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != path || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(watchDelay, func() {
+				select {
+				case fw.changes <- struct{}{}:
+				default: // a notification is already pending
+				}
+			})
+		case err, ok := <-fw.watcher.Errors:
+			if !ok {
+				return
+			}
+			// the watcher must be drained: the events are blocked otherwise
+			fw.logger.Warn().Err(err).Msg("error while watching the configuration file.")
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package opendydnsd
+
+import (
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opendydnsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opendydnsd.toml")
+	if err := ioutil.WriteFile(path, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zerolog.Nop()
+	fw, err := newFileWatcher(path, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fw.Close()
+
+	// another file of the directory
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.toml"), []byte("b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fw.Changes():
+		t.Error("change of another file notified")
+	case <-time.After(2 * watchDelay):
+	}
+
+	// replaced file
+	tmp := filepath.Join(dir, "opendydnsd.toml.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("c"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fw.Changes():
+	case <-time.After(5 * watchDelay):
+		t.Error("change not notified")
+	}
+}
@@ -1,6 +1,9 @@
 package proto
 
-import "github.com/labstack/echo/v4"
+import (
+	"github.com/labstack/echo/v4"
+	"time"
+)
 
 //go:generate mockgen -source contract.go -destination=../proto_mock/contract_mock.go -package=proto_mock
 
@@ -19,12 +22,121 @@ var ErrInvalidParameters = echo.NewHTTPError(400, "invalid request parameter(s)"
 // ErrDomainNotFound is returned when the alias to register use non supported / not existing domain
 var ErrDomainNotFound = echo.NewHTTPError(404, "requested domain not found")
 
+// ErrInvalidAddress is returned when the alias value is not a valid IPv4 / IPv6 address
+var ErrInvalidAddress = echo.NewHTTPError(400, "invalid IP address")
+
+// ErrAddressNotAllowed is returned when the alias value belongs to an address range rejected by the daemon
+var ErrAddressNotAllowed = echo.NewHTTPError(422, "IP address range not allowed")
+
+// ErrInvalidUpdateToken is returned when the update token is unknown, revoked or bound to another alias
+var ErrInvalidUpdateToken = echo.NewHTTPError(401, "invalid update token")
+
+// ErrUpdateTokenNotFound is returned when the update token to revoke cannot be found
+var ErrUpdateTokenNotFound = echo.NewHTTPError(404, "update token not found")
+
+// ErrUserNotFound is returned when the wanted user cannot be found
+var ErrUserNotFound = echo.NewHTTPError(404, "user not found")
+
+// ErrUserDisabled is returned when the user account has been disabled by an admin
+var ErrUserDisabled = echo.NewHTTPError(403, "user account disabled")
+
+// ErrSignupDisabled is returned when the self-service signup is not enabled
+var ErrSignupDisabled = echo.NewHTTPError(404, "signup is disabled")
+
+// ErrEmailNotVerified is returned when the user has not verified his email address yet
+var ErrEmailNotVerified = echo.NewHTTPError(403, "email address not verified")
+
+// ErrInvalidVerificationToken is returned when the email verification token is unknown or expired
+var ErrInvalidVerificationToken = echo.NewHTTPError(400, "invalid or expired verification token")
+
+// ErrInvalidPassword is returned when the current password of the user does not match
+var ErrInvalidPassword = echo.NewHTTPError(403, "invalid current password")
+
+// ErrPasswordResetDisabled is returned when the password reset by email is not enabled
+var ErrPasswordResetDisabled = echo.NewHTTPError(404, "password reset is disabled")
+
+// ErrInvalidResetToken is returned when the password reset token is unknown, expired or already used
+var ErrInvalidResetToken = echo.NewHTTPError(400, "invalid or expired password reset token")
+
+// ErrInvalidRefreshToken is returned when the refresh token is unknown, expired or already used
+var ErrInvalidRefreshToken = echo.NewHTTPError(401, "invalid or expired refresh token")
+
+// ErrSessionNotFound is returned when the session does not exist
+var ErrSessionNotFound = echo.NewHTTPError(404, "session not found")
+
+// ErrInvalidTwoFactorToken is returned when the two-factor challenge token is unknown, expired or already used
+var ErrInvalidTwoFactorToken = echo.NewHTTPError(401, "invalid or expired two-factor token")
+
+// ErrInvalidTwoFactorCode is returned when the TOTP / recovery code does not match
+var ErrInvalidTwoFactorCode = echo.NewHTTPError(403, "invalid two-factor code")
+
+// ErrTwoFactorRequired is returned when the password alone is not enough to authenticate the user
+var ErrTwoFactorRequired = echo.NewHTTPError(401, "two-factor authentication required")
+
+// ErrTwoFactorEnabled is returned when the two-factor authentication is already enabled
+var ErrTwoFactorEnabled = echo.NewHTTPError(409, "two-factor authentication already enabled")
+
+// ErrTwoFactorNotEnabled is returned when the two-factor authentication is not enabled (or not set up)
+var ErrTwoFactorNotEnabled = echo.NewHTTPError(409, "two-factor authentication not enabled")
+
+// ErrOIDCDisabled is returned when the OpenID Connect login is not enabled
+var ErrOIDCDisabled = echo.NewHTTPError(404, "OpenID Connect login is disabled")
+
+// ErrInvalidIDToken is returned when the OpenID Connect ID token is invalid or expired
+var ErrInvalidIDToken = echo.NewHTTPError(401, "invalid or expired ID token")
+
+// ErrOIDCUserNotAllowed is returned when the OpenID Connect identity is not allowed to login
+// (claims not allowed, unverified email address, account linked to another identity or not provisioned)
+var ErrOIDCUserNotAllowed = echo.NewHTTPError(403, "user not allowed to login using OpenID Connect")
+
+// ErrTooManyRequests is returned when the request is throttled or the account temporarily locked
+// the Retry-After header indicates when the request may be retried
+var ErrTooManyRequests = echo.NewHTTPError(429, "too many requests")
+
+// ErrPasswordManagedExternally is returned when the password cannot be managed by the daemon
+// because the users are authenticated by an external backend (LDAP)
+var ErrPasswordManagedExternally = echo.NewHTTPError(409, "password managed by the authentication backend")
+
+// ErrInvalidSession is returned when the JWT token no longer match the user account
+// (session revoked, user deleted, admin role revoked or password changed)
+var ErrInvalidSession = echo.NewHTTPError(401, "invalid session")
+
+const (
+	// RecordTypeA is the DNS record type used for IPv4 alias values
+	RecordTypeA = "A"
+	// RecordTypeAAAA is the DNS record type used for IPv6 alias values
+	RecordTypeAAAA = "AAAA"
+)
+
 // APIContract defined the API served by the Daemon
 type APIContract interface {
 	// Authenticate user using given credential
-	// this either return the JWT token or an error if something goes wrong
+	// this either return the JWT token (and refresh token) or an error if something goes wrong
+	// if the user enabled the two-factor authentication, only TwoFactorToken is returned: see VerifyTwoFactor
 	// POST /sessions
 	Authenticate(cred CredentialsDto) (TokenDto, error)
+	// VerifyTwoFactor complete the authentication using the TOTP code (or a recovery code)
+	// the two-factor token can be used only once, even if the code is invalid
+	// POST /sessions/2fa
+	VerifyTwoFactor(challenge TwoFactorDto) (TokenDto, error)
+	// GetOIDCConfig return the OpenID Provider and client to use to login
+	// GET /sessions/oidc
+	GetOIDCConfig() (OIDCConfigDto, error)
+	// AuthenticateOIDC authenticate the user using an ID token issued by the OpenID Provider
+	// the user is provisioned on first login if allowed. As for Authenticate,
+	// only a TwoFactorToken is returned if the user enabled the two-factor authentication
+	// POST /sessions/oidc
+	AuthenticateOIDC(token OIDCTokenDto) (TokenDto, error)
+	// RefreshSession issue a new JWT token using the refresh token
+	// the refresh token is rotated: the new one is returned
+	// POST /sessions/refresh
+	RefreshSession(refresh RefreshTokenDto) (TokenDto, error)
+	// GetSessions return the active sessions of the user
+	// GET /sessions
+	GetSessions(token TokenDto) ([]SessionDto, error)
+	// RevokeSession revoke given session of the user (logout)
+	// DELETE /sessions/{id}
+	RevokeSession(token TokenDto, id uint) error
 	// GetAliases return user current aliases
 	// GET /aliases
 	GetAliases(token TokenDto) ([]AliasDto, error)
@@ -32,24 +144,110 @@ type APIContract interface {
 	// POST /aliases
 	RegisterAlias(token TokenDto, alias AliasDto) (AliasDto, error)
 	// UpdateAlias update the user existing alias
+	// if no value is provided the request source address is used
 	// PUT /aliases/{name}
 	UpdateAlias(token TokenDto, alias AliasDto) (AliasDto, error)
 	// DeleteAlias delete the user given alias
 	// DELETE /aliases/{name}
 	DeleteAlias(token TokenDto, name string) error
 
+	// CreateUpdateToken create a new update token for the user given alias
+	// the token value is only returned once
+	// POST /aliases/{name}/tokens
+	CreateUpdateToken(token TokenDto, name string) (UpdateTokenDto, error)
+	// GetUpdateTokens return the update tokens of the user given alias
+	// GET /aliases/{name}/tokens
+	GetUpdateTokens(token TokenDto, name string) ([]UpdateTokenDto, error)
+	// RevokeUpdateToken revoke given update token of the user given alias
+	// DELETE /aliases/{name}/tokens/{id}
+	RevokeUpdateToken(token TokenDto, name string, id uint) error
+	// UpdateAliasWithToken update the alias bound to given update token
+	// if no value is provided the request source address is used
+	// PUT /aliases/{name}
+	UpdateAliasWithToken(updateToken string, alias AliasDto) (AliasDto, error)
+
 	// GetDomains return the list of available / supported domains
 	// for alias creation
 	// GET /domains
 	GetDomains(token TokenDto) ([]DomainDto, error)
+
+	// GetIP return the client IP address as seen by the daemon
+	// GET /ip
+	GetIP() (IPDto, error)
+
+	// SignUp create an account (if enabled) and send the verification email
+	// the response does not tell if the account already exist
+	// POST /users
+	SignUp(cred CredentialsDto) error
+	// VerifyEmail activate the account using the token received by email
+	// GET /users/verify?token={token}
+	VerifyEmail(token string) error
+
+	// ChangePassword change the user password using the current one
+	// the previously issued JWT tokens are invalidated: a new one is returned
+	// PUT /users/me/password
+	ChangePassword(token TokenDto, password ChangePasswordDto) (TokenDto, error)
+	// RequestPasswordReset send a password reset token by email (if enabled)
+	// the response does not tell if the account exist
+	// POST /users/password-reset
+	RequestPasswordReset(request PasswordResetRequestDto) error
+	// CompletePasswordReset replace the user password using the token received by email
+	// the token can be used only once
+	// PUT /users/password-reset
+	CompletePasswordReset(reset PasswordResetDto) error
+
+	// SetupTwoFactor generate a new TOTP secret for the user
+	// the two-factor authentication is only enabled once a code has been confirmed: see EnableTwoFactor
+	// POST /users/me/2fa
+	SetupTwoFactor(token TokenDto) (TwoFactorSetupDto, error)
+	// EnableTwoFactor enable the two-factor authentication using a code generated from the new secret
+	// the recovery codes are only returned once
+	// PUT /users/me/2fa
+	EnableTwoFactor(token TokenDto, code TwoFactorCodeDto) (RecoveryCodesDto, error)
+	// DisableTwoFactor disable the two-factor authentication using a TOTP code (or a recovery code)
+	// POST /users/me/2fa/disable
+	DisableTwoFactor(token TokenDto, code TwoFactorCodeDto) error
+	// RegenerateRecoveryCodes replace the recovery codes of the user using a TOTP code (or a recovery code)
+	// POST /users/me/2fa/recovery-codes
+	RegenerateRecoveryCodes(token TokenDto, code TwoFactorCodeDto) (RecoveryCodesDto, error)
+
+	// GetUsers return the users (admin only)
+	// GET /admin/users
+	GetUsers(token TokenDto) ([]UserDto, error)
+	// CreateUser create a new user (admin only)
+	// POST /admin/users
+	CreateUser(token TokenDto, user CreateUserDto) (UserDto, error)
+	// SetUserDisabled disable or enable given user (admin only)
+	// a disabled user cannot authenticate nor use his update tokens
+	// POST /admin/users/{id}/disable
+	// POST /admin/users/{id}/enable
+	SetUserDisabled(token TokenDto, id uint, disabled bool) error
+	// ResetPassword replace the password of given user (admin only)
+	// PUT /admin/users/{id}/password
+	ResetPassword(token TokenDto, id uint, password PasswordDto) error
+	// DeleteUser delete given user together with his aliases (admin only)
+	// DELETE /admin/users/{id}
+	DeleteUser(token TokenDto, id uint) error
 }
 
-// AliasDto represent a DyDNS alias
+// AliasDto represent a DyDNS alias record
+// the Type (A / AAAA) is determinate by the daemon from the Value
 type AliasDto struct {
 	Domain string `json:"domain"`
+	Type   string `json:"type,omitempty"`
 	Value  string `json:"value"`
 }
 
+// UpdateTokenDto represent a per-alias update token
+// the Token value is only returned upon creation
+type UpdateTokenDto struct {
+	ID        uint      `json:"id"`
+	Alias     string    `json:"alias"`
+	Prefix    string    `json:"prefix"`
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // CredentialsDto represent the credentials
 // when issuing a authentication request
 type CredentialsDto struct {
@@ -59,8 +257,104 @@ type CredentialsDto struct {
 
 // TokenDto represent the object that encapsulate the JWT token
 // when issuing a authentication request
+// the (short-lived) JWT token is renewed using the refresh token
 type TokenDto struct {
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	// TwoFactorToken is set instead of the tokens when the second authentication step is required
+	TwoFactorToken string `json:"two_factor_token,omitempty"`
+}
+
+// TwoFactorDto represent the second authentication step
+// Code is either a TOTP code or a recovery code
+type TwoFactorDto struct {
 	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+// OIDCConfigDto represent the OpenID Connect login settings
+type OIDCConfigDto struct {
+	Issuer   string   `json:"issuer"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+// OIDCTokenDto represent an OpenID Connect ID token
+type OIDCTokenDto struct {
+	IDToken string `json:"id_token"`
+}
+
+// TwoFactorSetupDto represent a new TOTP secret
+// URI is the otpauth:// URI to import in an authenticator app (usually as a QR code)
+type TwoFactorSetupDto struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorCodeDto represent a TOTP code (or a recovery code) confirming a two-factor setting change
+type TwoFactorCodeDto struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesDto represent the single use codes allowing to authenticate without the TOTP device
+type RecoveryCodesDto struct {
+	Codes []string `json:"codes"`
+}
+
+// RefreshTokenDto represent a JWT token renewal request
+type RefreshTokenDto struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SessionDto represent an authenticated session
+// Current is set for the session of the JWT token used for the request
+type SessionDto struct {
+	ID         uint      `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// UserDto represent an user account
+type UserDto struct {
+	ID        uint      `json:"id"`
+	Email     string    `json:"email"`
+	Admin     bool      `json:"admin"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateUserDto represent an user creation request
+type CreateUserDto struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Admin    bool   `json:"admin"`
+}
+
+// PasswordDto represent a password reset request
+type PasswordDto struct {
+	Password string `json:"password"`
+}
+
+// ChangePasswordDto represent a password change request
+type ChangePasswordDto struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// PasswordResetRequestDto represent a request to receive a password reset token
+type PasswordResetRequestDto struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetDto represent a password reset using the token received by email
+type PasswordResetDto struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
 }
 
 // DomainDto represent a domain usable to create alias
@@ -69,10 +363,56 @@ type DomainDto struct {
 	Domain string `json:"domain"`
 }
 
+// IPDto represent the client IP address as seen by the Daemon
+type IPDto struct {
+	IP string `json:"ip"`
+}
+
+// RecordDto represent a DNS record found during a reconciliation
+// Expected is the value held by the alias when it differs from the served one
+type RecordDto struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Value    string `json:"value,omitempty"`
+	Expected string `json:"expected,omitempty"`
+}
+
+// ReconcileReportDto is the result of a DNS / database reconciliation
+type ReconcileReportDto struct {
+	DryRun   bool        `json:"dry_run"`
+	Missing  []RecordDto `json:"missing"`  // alias without DNS record
+	Stale    []RecordDto `json:"stale"`    // DNS record with wrong value
+	Orphaned []RecordDto `json:"orphaned"` // DNS record without alias
+	Repaired int         `json:"repaired"`
+	Errors   []string    `json:"errors,omitempty"`
+}
+
+const (
+	// StatusOK is the status of an healthy dependency
+	StatusOK = "ok"
+	// StatusError is the status of a failing dependency
+	StatusError = "error"
+)
+
+// StatusDto represent the status of a dependency of the daemon
+type StatusDto struct {
+	Status string `json:"status"` // ok or error
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessDto represent the readiness of the daemon dependencies
+// Domains is only set when the DNS provisioners are checked
+type ReadinessDto struct {
+	Ready    bool                 `json:"ready"`
+	Database StatusDto            `json:"database"`
+	Domains  map[string]StatusDto `json:"domains,omitempty"`
+}
+
 // ErrorDto is the generic error response in case of API error
 // TODO make my own error mapper
 type ErrorDto struct {
 	Message string `json:"message"`
+	Code    int    `json:"-"` // HTTP status code, set by the client
 }
 
 func (e ErrorDto) Error() string {
@@ -82,5 +422,7 @@ func (e ErrorDto) Error() string {
 // UserContext represent the JWT token payload
 // and identify the logged in user in secured endpoints
 type UserContext struct {
-	UserID uint
+	UserID    uint
+	Admin     bool
+	SessionID uint // the jti claim
 }